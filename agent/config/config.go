@@ -12,7 +12,10 @@ import (
 
 	"github.com/spf13/pflag"
 
+	"github.com/andydunstall/piko/pkg/compress"
+	"github.com/andydunstall/piko/pkg/limit"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/tlsconfig"
 )
 
 type ListenerProtocol string
@@ -51,6 +54,17 @@ type ListenerConfig struct {
 	// the proxy are logged.
 	AccessLog log.AccessLogConfig `json:"access_log" yaml:"access_log"`
 
+	// Compression configures compressing responses before they are sent
+	// to the Piko server.
+	//
+	// Only applies if the protocol is ListenerProtocolHTTP.
+	Compression compress.Config `json:"compression" yaml:"compression"`
+
+	// Limits configures limits on the requests forwarded to the upstream.
+	//
+	// Only applies if the protocol is ListenerProtocolHTTP.
+	Limits limit.Config `json:"limits" yaml:"limits"`
+
 	// Timeout is the timeout to forward incoming requests to the upstream.
 	Timeout time.Duration `json:"timeout" yaml:"timeout"`
 
@@ -148,6 +162,14 @@ func (c *ListenerConfig) Validate() error {
 		return fmt.Errorf("access log: %w", err)
 	}
 
+	if err := c.Compression.Validate(); err != nil {
+		return fmt.Errorf("compression: %w", err)
+	}
+
+	if err := c.Limits.Validate(); err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+
 	return nil
 }
 
@@ -170,12 +192,17 @@ type TLSConfig struct {
 	//
 	// See https://pkg.go.dev/crypto/tls#Config.
 	InsecureSkipVerify bool `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+
+	tlsconfig.Options `json:",inline" yaml:",inline"`
 }
 
 func (c *TLSConfig) Validate() error {
 	if c.Cert != "" && c.Key == "" {
 		return fmt.Errorf("missing key")
 	}
+	if err := c.Options.Validate(); err != nil {
+		return err
+	}
 
 	_, err := c.Load()
 	return err
@@ -217,10 +244,15 @@ Defaults to using the host root CAs.`,
 Configures the agent to accept any certificate presented by the server and any
 host name in that certificate.`,
 	)
+
+	c.Options.RegisterFlags(fs, prefix)
 }
 
 func (c *TLSConfig) Load() (*tls.Config, error) {
 	tlsConfig := &tls.Config{}
+	if err := c.Options.Apply(tlsConfig); err != nil {
+		return nil, err
+	}
 
 	if c.Cert != "" {
 		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
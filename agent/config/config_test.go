@@ -10,6 +10,7 @@ import (
 
 	pikoconfig "github.com/andydunstall/piko/pkg/config"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/tlsconfig"
 )
 
 // Tests the default configuration is valid.
@@ -92,6 +93,12 @@ connect:
   url: 'http://localhost:8001'
   timeout: 30s
   token: cyz
+  tls:
+    min_version: "1.2"
+    cipher_suites:
+      - TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256
+    curve_preferences:
+      - X25519
 stream:
   max_window_size: 4194304
 server:
@@ -144,6 +151,15 @@ log:
 			URL:     "http://localhost:8001",
 			Timeout: 30 * time.Second,
 			Token:   "cyz",
+			TLS: TLSConfig{
+				Options: tlsconfig.Options{
+					MinVersion: "1.2",
+					CipherSuites: []string{
+						"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+					},
+					CurvePreferences: []string{"X25519"},
+				},
+			},
 		},
 		Stream: StreamConfig{
 			MaxWindowSize: 4 * 1024 * 1024,
@@ -13,6 +13,7 @@ import (
 	"go.uber.org/zap/zapcore"
 
 	"github.com/andydunstall/piko/agent/config"
+	"github.com/andydunstall/piko/pkg/limit"
 	"github.com/andydunstall/piko/pkg/log"
 )
 
@@ -21,6 +22,8 @@ type ReverseProxy struct {
 
 	timeout time.Duration
 
+	limits limit.Config
+
 	logger log.Logger
 }
 
@@ -60,6 +63,7 @@ func NewReverseProxy(conf config.ListenerConfig, logger log.Logger) *ReverseProx
 	rp := &ReverseProxy{
 		proxy:   proxy,
 		timeout: conf.Timeout,
+		limits:  conf.Limits,
 		logger:  logger,
 	}
 	proxy.ErrorHandler = rp.errorHandler
@@ -74,12 +78,27 @@ func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r = r.WithContext(ctx)
 	}
 
+	if err := limit.LimitBody(w, r, p.limits); err != nil {
+		p.logger.Warn(
+			"request body too large",
+			zap.Int64("content-length", r.ContentLength),
+			zap.Int64("max-request-body-size", p.limits.MaxRequestBodySize),
+		)
+		_ = errorResponse(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+
 	p.proxy.ServeHTTP(w, r)
 }
 
 func (p *ReverseProxy) errorHandler(w http.ResponseWriter, _ *http.Request, err error) {
 	p.logger.Warn("proxy request", zap.Error(err))
 
+	if limit.IsBodyTooLarge(err) {
+		_ = errorResponse(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+
 	if errors.Is(err, context.DeadlineExceeded) {
 		_ = errorResponse(w, http.StatusGatewayTimeout, "upstream timeout")
 		return
@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/andydunstall/piko/agent/config"
+	"github.com/andydunstall/piko/pkg/limit"
 	"github.com/andydunstall/piko/pkg/log"
 )
 
@@ -56,6 +57,39 @@ func TestReverseProxy_Forward(t *testing.T) {
 		assert.Equal(t, "bar", buf.String())
 	})
 
+	t.Run("request body too large", func(t *testing.T) {
+		upstream := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				// nolint
+				io.Copy(w, r.Body)
+			},
+		))
+		defer upstream.Close()
+
+		proxy := NewReverseProxy(config.ListenerConfig{
+			EndpointID: "my-endpoint",
+			Addr:       upstream.URL,
+			Limits: limit.Config{
+				MaxRequestBodySize: 3,
+			},
+		}, log.NewNopLogger())
+
+		b := bytes.NewReader([]byte("foobar"))
+		r := httptest.NewRequest(http.MethodPost, "/", b)
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, r)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+		m := errorMessage{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&m))
+		assert.Equal(t, "request body too large", m.Error)
+	})
+
 	t.Run("timeout", func(t *testing.T) {
 		blockCh := make(chan struct{})
 		upstream := httptest.NewServer(http.HandlerFunc(
@@ -51,6 +51,10 @@ func NewServer(
 
 	router.Use(metrics.Handler(conf.EndpointID))
 
+	if conf.Compression.Enabled {
+		router.Use(middleware.NewCompression(conf.Compression))
+	}
+
 	s.router.NoRoute(s.proxyRoute)
 
 	return s
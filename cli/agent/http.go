@@ -9,6 +9,8 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/andydunstall/piko/agent/config"
+	"github.com/andydunstall/piko/pkg/compress"
+	"github.com/andydunstall/piko/pkg/limit"
 	"github.com/andydunstall/piko/pkg/log"
 )
 
@@ -42,6 +44,15 @@ Examples:
 	flags := cmd.Flags()
 	accessLogConfig.RegisterFlags(flags, "")
 
+	compressionConfig := compress.Config{
+		Enabled: false,
+		MinSize: 1024,
+	}
+	compressionConfig.RegisterFlags(flags, "")
+
+	var limitsConfig limit.Config
+	limitsConfig.RegisterFlags(flags, "")
+
 	var timeout time.Duration
 	flags.DurationVar(
 		&timeout,
@@ -87,12 +98,14 @@ Timeout forwarding incoming HTTP requests to the upstream.`,
 		// Discard any listeners in the configuration file and use from command
 		// line.
 		conf.Listeners = []config.ListenerConfig{{
-			EndpointID: args[0],
-			Addr:       args[1],
-			Protocol:   config.ListenerProtocolHTTP,
-			AccessLog:  accessLogConfig,
-			Timeout:    timeout,
-			HTTPClient: httpClientConfig,
+			EndpointID:  args[0],
+			Addr:        args[1],
+			Protocol:    config.ListenerProtocolHTTP,
+			AccessLog:   accessLogConfig,
+			Compression: compressionConfig,
+			Limits:      limitsConfig,
+			Timeout:     timeout,
+			HTTPClient:  httpClientConfig,
 		}}
 
 		var err error
@@ -8,6 +8,7 @@ import (
 	"github.com/andydunstall/piko/cli/forward"
 	"github.com/andydunstall/piko/cli/server"
 	"github.com/andydunstall/piko/cli/test"
+	"github.com/andydunstall/piko/cli/token"
 	"github.com/andydunstall/piko/pkg/build"
 )
 
@@ -64,6 +65,7 @@ to an upstream listener via Piko. Such as to forward port 3000 to endpoint
 	cmd.AddCommand(server.NewCommand())
 	cmd.AddCommand(agent.NewCommand())
 	cmd.AddCommand(forward.NewCommand())
+	cmd.AddCommand(token.NewCommand())
 	cmd.AddCommand(bench.NewCommand())
 	cmd.AddCommand(test.NewCommand())
 
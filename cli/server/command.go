@@ -5,12 +5,15 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 
 	"github.com/andydunstall/piko/cli/server/status"
+	"github.com/andydunstall/piko/cli/server/upstream"
 	pikoconfig "github.com/andydunstall/piko/pkg/config"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/server"
@@ -43,6 +46,12 @@ The server supports both YAML configuration and command line flags. Configure
 a YAML file using '--config.path'. When enabling '--config.expand-env', Piko
 will expand environment variables in the loaded YAML configuration.
 
+The server configuration can be reloaded without restarting by sending the
+server a SIGHUP signal, or using the admin '/reload' endpoint. This re-reads the
+YAML configuration and updates the authentication, tenants, TLS certificates,
+access log and upstream rebalancing configuration. Changes to any other fields
+are rejected.
+
 Examples:
   # Start a Piko server node.
   piko server
@@ -69,7 +78,18 @@ Examples:
 
 	var logger log.Logger
 
+	// flags contains the values of the flags set on the command line, which
+	// are re-applied when reloading the configuration.
+	var flags []flagValue
+
 	cmd.PreRun = func(_ *cobra.Command, _ []string) {
+		// Save the flag values before loading the configuration, since the
+		// flags are bound to the configuration so would otherwise include
+		// changes from the YAML configuration.
+		cmd.Flags().Visit(func(f *pflag.Flag) {
+			flags = append(flags, newFlagValue(f))
+		})
+
 		if err := pikoconfig.Load(conf, loadConf.Path, loadConf.ExpandEnv); err != nil {
 			fmt.Println(err.Error())
 			os.Exit(1)
@@ -97,18 +117,26 @@ Examples:
 	}
 
 	cmd.Run = func(_ *cobra.Command, _ []string) {
-		if err := runServer(conf, logger); err != nil {
+		loadConfig := func() (*config.Config, error) {
+			return reloadConfig(flags, loadConf)
+		}
+		if err := runServer(conf, loadConfig, logger); err != nil {
 			logger.Error("failed to run server", zap.Error(err))
 			os.Exit(1)
 		}
 	}
 
 	cmd.AddCommand(status.NewCommand())
+	cmd.AddCommand(upstream.NewCommand())
 
 	return cmd
 }
 
-func runServer(conf *config.Config, logger log.Logger) error {
+func runServer(
+	conf *config.Config,
+	loadConfig func() (*config.Config, error),
+	logger log.Logger,
+) error {
 	ctx, cancel := signal.NotifyContext(
 		context.Background(), syscall.SIGINT, syscall.SIGTERM,
 	)
@@ -118,6 +146,24 @@ func runServer(conf *config.Config, logger log.Logger) error {
 	if err != nil {
 		return err
 	}
+	server.SetConfigLoader(loadConfig)
+
+	// Reload the configuration on SIGHUP.
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+	go func() {
+		for {
+			select {
+			case <-hupCh:
+				logger.Info("received sighup; reloading config")
+				// Errors are logged by the server.
+				_ = server.ReloadConfig()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
 	if err := server.Start(); err != nil {
 		return err
@@ -129,3 +175,58 @@ func runServer(conf *config.Config, logger log.Logger) error {
 
 	return nil
 }
+
+// flagValue is the value of a flag set on the command line.
+type flagValue struct {
+	name  string
+	value string
+	// slice contains the value of slice flags, which can't be set from
+	// their string representation.
+	slice []string
+}
+
+func newFlagValue(f *pflag.Flag) flagValue {
+	v := flagValue{
+		name:  f.Name,
+		value: f.Value.String(),
+	}
+	if slice, ok := f.Value.(pflag.SliceValue); ok {
+		v.slice = slices.Clone(slice.GetSlice())
+	}
+	return v
+}
+
+// reloadConfig loads the server configuration, using the same flags and YAML
+// configuration file as on startup.
+func reloadConfig(
+	flags []flagValue,
+	loadConf pikoconfig.Config,
+) (*config.Config, error) {
+	conf := config.Default()
+
+	fs := pflag.NewFlagSet("server", pflag.ContinueOnError)
+	conf.RegisterFlags(fs)
+	for _, f := range flags {
+		reloadFlag := fs.Lookup(f.name)
+		if reloadFlag == nil {
+			// Ignore flags that aren't part of the server configuration,
+			// such as '--config.path'.
+			continue
+		}
+
+		if slice, ok := reloadFlag.Value.(pflag.SliceValue); ok {
+			if err := slice.Replace(slices.Clone(f.slice)); err != nil {
+				return nil, fmt.Errorf("flag: %s: %w", f.name, err)
+			}
+			continue
+		}
+		if err := fs.Set(f.name, f.value); err != nil {
+			return nil, fmt.Errorf("flag: %s: %w", f.name, err)
+		}
+	}
+
+	if err := pikoconfig.Load(conf, loadConf.Path, loadConf.ExpandEnv); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
@@ -0,0 +1,91 @@
+package status
+
+import (
+	"fmt"
+	"os"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/spf13/cobra"
+
+	"github.com/andydunstall/piko/server/status/client"
+)
+
+func newBandwidthCommand(c *client.Client) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bandwidth",
+		Short: "inspect bandwidth usage",
+	}
+
+	cmd.AddCommand(newBandwidthEndpointsCommand(c))
+	cmd.AddCommand(newBandwidthTenantsCommand(c))
+
+	return cmd
+}
+
+func newBandwidthEndpointsCommand(c *client.Client) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "endpoints",
+		Short: "inspect the endpoints using the most bandwidth",
+		Long: `Inspect the endpoints using the most bandwidth.
+
+Queries the server for the top talking endpoints connected to the node, sorted
+by their recent bandwidth rate.
+
+Examples:
+  piko server status bandwidth endpoints
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showBandwidthEndpoints(c)
+	}
+
+	return cmd
+}
+
+func showBandwidthEndpoints(c *client.Client) {
+	bandwidth := client.NewBandwidth(c)
+
+	endpoints, err := bandwidth.Endpoints()
+	if err != nil {
+		fmt.Printf("failed to get bandwidth endpoints: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	b, _ := yaml.Marshal(endpoints)
+	fmt.Print(string(b))
+}
+
+func newBandwidthTenantsCommand(c *client.Client) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tenants",
+		Short: "inspect the tenants using the most bandwidth",
+		Long: `Inspect the tenants using the most bandwidth.
+
+Queries the server for the top talking tenants connected to the node, sorted
+by their recent bandwidth rate.
+
+Examples:
+  piko server status bandwidth tenants
+`,
+	}
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showBandwidthTenants(c)
+	}
+
+	return cmd
+}
+
+func showBandwidthTenants(c *client.Client) {
+	bandwidth := client.NewBandwidth(c)
+
+	tenants, err := bandwidth.Tenants()
+	if err != nil {
+		fmt.Printf("failed to get bandwidth tenants: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	b, _ := yaml.Marshal(tenants)
+	fmt.Print(string(b))
+}
@@ -53,6 +53,7 @@ Examples:
 	cmd.AddCommand(newUpstreamCommand(c))
 	cmd.AddCommand(newClusterCommand(c))
 	cmd.AddCommand(newGossipCommand(c))
+	cmd.AddCommand(newBandwidthCommand(c))
 
 	return cmd
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/andydunstall/piko/server/status/client"
+	"github.com/andydunstall/piko/server/upstream"
 )
 
 func newUpstreamCommand(c *client.Client) *cobra.Command {
@@ -17,6 +18,7 @@ func newUpstreamCommand(c *client.Client) *cobra.Command {
 	}
 
 	cmd.AddCommand(newUpstreamEndpointsCommand(c))
+	cmd.AddCommand(newUpstreamConnectionsCommand(c))
 
 	return cmd
 }
@@ -53,3 +55,99 @@ func showUpstreamEndpoints(c *client.Client) {
 	b, _ := yaml.Marshal(endpoints)
 	fmt.Print(string(b))
 }
+
+func newUpstreamConnectionsCommand(c *client.Client) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "connections",
+		Short: "inspect upstream connections",
+		Long: `Inspect upstream connections.
+
+Queries the server for the upstreams connected to the node, including the
+endpoint, client IP, tenant, token subject, agent version, open streams and
+bytes transferred.
+
+Connections are sorted by the time they connected. Use --offset and --limit to
+page through the connections.
+
+Examples:
+  piko server status upstream connections
+
+  # Inspect the connections for endpoint my-endpoint.
+  piko server status upstream connections --endpoint my-endpoint
+
+  # Inspect the second page of connections for tenant my-tenant.
+  piko server status upstream connections --tenant my-tenant --offset 100
+`,
+	}
+
+	var filter upstream.ConnectionFilter
+	cmd.Flags().StringVar(
+		&filter.EndpointID,
+		"endpoint",
+		"",
+		`
+Only include connections for the endpoint.`,
+	)
+	cmd.Flags().StringVar(
+		&filter.TenantID,
+		"tenant",
+		"",
+		`
+Only include connections for the tenant.`,
+	)
+	cmd.Flags().StringVar(
+		&filter.ClientIP,
+		"client-ip",
+		"",
+		`
+Only include connections from the client IP.`,
+	)
+	cmd.Flags().StringVar(
+		&filter.Subject,
+		"subject",
+		"",
+		`
+Only include connections authenticated with the token subject.`,
+	)
+
+	var offset int
+	cmd.Flags().IntVar(
+		&offset,
+		"offset",
+		0,
+		`
+Number of connections to skip.`,
+	)
+	var limit int
+	cmd.Flags().IntVar(
+		&limit,
+		"limit",
+		100,
+		`
+Maximum number of connections to return (up to 1000).`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		showUpstreamConnections(c, filter, offset, limit)
+	}
+
+	return cmd
+}
+
+func showUpstreamConnections(
+	c *client.Client,
+	filter upstream.ConnectionFilter,
+	offset int,
+	limit int,
+) {
+	client := client.NewUpstream(c)
+
+	connections, err := client.Connections(filter, offset, limit)
+	if err != nil {
+		fmt.Printf("failed to get upstream connections: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	b, _ := yaml.Marshal(connections)
+	fmt.Print(string(b))
+}
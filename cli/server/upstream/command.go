@@ -0,0 +1,137 @@
+package upstream
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	yaml "github.com/goccy/go-yaml"
+	"github.com/spf13/cobra"
+
+	"github.com/andydunstall/piko/server/status/client"
+	"github.com/andydunstall/piko/server/status/config"
+	"github.com/andydunstall/piko/server/upstream"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "upstream",
+		Short: "manage connected upstreams",
+		Long: `Manage connected upstreams.
+
+Uses the server admin API to manage the upstreams connected to a node.
+
+Upstreams are only managed on the node they are connected to. Use '--forward'
+to forward the request to another node.
+
+See 'piko server upstream --help' for the available commands.
+
+Examples:
+  # Disconnect all upstreams for endpoint my-endpoint.
+  piko server upstream disconnect --endpoint my-endpoint
+
+  # Disconnect the upstream with connection ID 5f2e1a0c7d9b3e48 on node cv6cdyo.
+  piko server upstream disconnect --id 5f2e1a0c7d9b3e48 --forward cv6cdyo
+`,
+	}
+
+	var conf config.Config
+	conf.RegisterFlags(cmd.PersistentFlags())
+
+	c := client.NewClient(nil)
+
+	cmd.PersistentPreRun = func(_ *cobra.Command, _ []string) {
+		if err := conf.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		url, _ := url.Parse(conf.Server.URL)
+		c.SetURL(url)
+		c.SetForward(conf.Forward)
+	}
+
+	cmd.AddCommand(newDisconnectCommand(c))
+
+	return cmd
+}
+
+func newDisconnectCommand(c *client.Client) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "disconnect",
+		Short: "disconnect upstreams",
+		Long: `Disconnect upstreams.
+
+Disconnects the upstreams with the given connection ID, or all upstreams for
+an endpoint or tenant. If multiple are given, only upstreams matching all of
+them are disconnected. Use 'piko server status upstream connections' to find
+the connection IDs.
+
+By default upstreams are disconnected gracefully, where the server sends a
+GoAway, stops routing new requests to the upstream and waits for open streams
+to complete before closing the session. Use '--force' to close the sessions
+immediately.
+
+Note upstream agents will reconnect after being disconnected. To prevent an
+upstream reconnecting, revoke its token.
+
+Examples:
+  # Disconnect the upstream with connection ID 5f2e1a0c7d9b3e48.
+  piko server upstream disconnect --id 5f2e1a0c7d9b3e48
+
+  # Disconnect all upstreams for tenant my-tenant immediately.
+  piko server upstream disconnect --tenant my-tenant --force
+`,
+	}
+
+	var req upstream.DisconnectRequest
+	cmd.Flags().StringVar(
+		&req.ID,
+		"id",
+		"",
+		`
+Connection ID of the upstream to disconnect.`,
+	)
+	cmd.Flags().StringVar(
+		&req.EndpointID,
+		"endpoint",
+		"",
+		`
+Disconnect the upstreams for the endpoint.`,
+	)
+	cmd.Flags().StringVar(
+		&req.TenantID,
+		"tenant",
+		"",
+		`
+Disconnect the upstreams for the tenant.`,
+	)
+	cmd.Flags().BoolVar(
+		&req.Force,
+		"force",
+		false,
+		`
+Close the sessions immediately rather than waiting for open streams to
+complete.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		if err := req.Validate(); err != nil {
+			fmt.Printf("invalid request: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		client := client.NewUpstream(c)
+
+		resp, err := client.Disconnect(req)
+		if err != nil {
+			fmt.Printf("failed to disconnect upstreams: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		b, _ := yaml.Marshal(resp)
+		fmt.Print(string(b))
+	}
+
+	return cmd
+}
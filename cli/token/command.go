@@ -0,0 +1,36 @@
+package token
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "token [command] (flags)",
+		Short: "manage piko tokens and signed urls",
+		Long: `Manage Piko tokens and signed URLs.
+
+The Piko server can issue short-lived tokens using the admin '/tokens' route,
+when configured with '--admin.token-issuer.*'. Callers can only issue tokens
+with the endpoints, tenant and expiry they are permitted to grant themselves.
+
+Signed URLs grant temporary access to an endpoint without a token, when the
+proxy is configured with '--proxy.signed-urls.secret-key'.
+
+See 'piko token --help' for the available commands.
+
+Examples:
+  # Issue a token that can listen on endpoint 'my-endpoint' for 10 minutes.
+  piko token create --listen my-endpoint --ttl 10m --server.token $TOKEN
+
+  # Share endpoint 'my-endpoint' for one hour using a signed URL.
+  piko token sign-url https://my-endpoint.piko.example.com \
+    --endpoint my-endpoint --secret-key $SECRET_KEY
+`,
+	}
+
+	cmd.AddCommand(newCreateCommand())
+	cmd.AddCommand(newSignURLCommand())
+
+	return cmd
+}
@@ -0,0 +1,213 @@
+package token
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	fspath "path"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andydunstall/piko/server/admin"
+)
+
+type createConfig struct {
+	// ServerURL is the URL of the server admin port.
+	ServerURL string
+
+	// ServerToken is the token to authenticate with the admin port.
+	ServerToken string
+
+	// ServerTenantID is the tenant of the token used to authenticate with
+	// the admin port.
+	ServerTenantID string
+
+	Subject   string
+	Endpoints []string
+	Listen    []string
+	Connect   []string
+	TenantID  string
+	TTL       time.Duration
+}
+
+func (c *createConfig) Validate() error {
+	if c.ServerURL == "" {
+		return fmt.Errorf("missing server url")
+	}
+	if _, err := url.Parse(c.ServerURL); err != nil {
+		return fmt.Errorf("invalid server url: %w", err)
+	}
+	if c.ServerToken == "" {
+		return fmt.Errorf("missing server token")
+	}
+	if c.TTL < 0 {
+		return fmt.Errorf("ttl cannot be negative")
+	}
+	return nil
+}
+
+func newCreateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "create [flags]",
+		Short: "issue a new token",
+		Long: `Issue a new token.
+
+Requests the server to issue a token with the given endpoints, tenant and
+expiry, and writes the token to stdout.
+
+The server must be configured with a token issuer signing key and admin
+authentication. The token used to authenticate with the admin port must be
+permitted to access the requested endpoints, and the issued token can't
+outlive it.
+
+Examples:
+  # Issue a token that can listen on endpoint 'my-endpoint'.
+  piko token create --listen my-endpoint --server.token $TOKEN
+
+  # Issue a token that can connect to any endpoint prefixed 'acme-' for
+  # 5 minutes.
+  piko token create --connect 'acme-*' --ttl 5m --server.token $TOKEN
+`,
+		Args: cobra.NoArgs,
+	}
+
+	var conf createConfig
+
+	cmd.Flags().StringVar(
+		&conf.ServerURL,
+		"server.url",
+		"http://localhost:8002",
+		`
+Piko server URL. This URL should point to the server admin port.`,
+	)
+	cmd.Flags().StringVar(
+		&conf.ServerToken,
+		"server.token",
+		"",
+		`
+Token to authenticate with the server admin port.`,
+	)
+	cmd.Flags().StringVar(
+		&conf.ServerTenantID,
+		"server.tenant-id",
+		"",
+		`
+Tenant ID of the token used to authenticate with the server admin port.`,
+	)
+	cmd.Flags().StringVar(
+		&conf.Subject,
+		"subject",
+		"",
+		`
+Subject ('sub' claim) of the issued token.`,
+	)
+	cmd.Flags().StringSliceVar(
+		&conf.Endpoints,
+		"endpoints",
+		nil,
+		`
+Endpoints the issued token can both listen on and connect to. Endpoints may
+contain a '*' wildcard or '{tenant}' placeholder.`,
+	)
+	cmd.Flags().StringSliceVar(
+		&conf.Listen,
+		"listen",
+		nil,
+		`
+Endpoints the issued token can listen on.`,
+	)
+	cmd.Flags().StringSliceVar(
+		&conf.Connect,
+		"connect",
+		nil,
+		`
+Endpoints the issued token can connect to.`,
+	)
+	cmd.Flags().StringVar(
+		&conf.TenantID,
+		"tenant-id",
+		"",
+		`
+Tenant of the issued token. Defaults to the tenant of the server token.`,
+	)
+	cmd.Flags().DurationVar(
+		&conf.TTL,
+		"ttl",
+		0,
+		`
+Lifetime of the issued token. Defaults to the maximum lifetime configured
+by the server.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, _ []string) {
+		if err := conf.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		resp, err := createToken(&conf)
+		if err != nil {
+			fmt.Printf("failed to create token: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(resp.Token)
+	}
+
+	return cmd
+}
+
+func createToken(conf *createConfig) (*admin.TokenResponse, error) {
+	body, err := json.Marshal(admin.TokenRequest{
+		Subject:   conf.Subject,
+		Endpoints: conf.Endpoints,
+		Listen:    conf.Listen,
+		Connect:   conf.Connect,
+		TenantID:  conf.TenantID,
+		ExpiresIn: int64(conf.TTL.Seconds()),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+
+	u, _ := url.Parse(conf.ServerURL)
+	u.Path = fspath.Join(u.Path, "/tokens")
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+conf.ServerToken)
+	if conf.ServerTenantID != "" {
+		req.Header.Set("x-piko-tenant-id", conf.ServerTenantID)
+	}
+
+	httpClient := &http.Client{
+		Timeout: time.Second * 15,
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errorResp struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&errorResp); err == nil && errorResp.Error != "" {
+			return nil, fmt.Errorf("%d: %s", resp.StatusCode, errorResp.Error)
+		}
+		return nil, fmt.Errorf("request: bad status: %d", resp.StatusCode)
+	}
+
+	var tokenResp admin.TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return &tokenResp, nil
+}
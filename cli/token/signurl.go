@@ -0,0 +1,132 @@
+package token
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andydunstall/piko/pkg/auth"
+)
+
+type signURLConfig struct {
+	SecretKey  string
+	EndpointID string
+	TenantID   string
+	PathPrefix string
+	TTL        time.Duration
+}
+
+func (c *signURLConfig) Validate() error {
+	if c.SecretKey == "" {
+		return fmt.Errorf("missing secret key")
+	}
+	if c.EndpointID == "" {
+		return fmt.Errorf("missing endpoint")
+	}
+	if c.PathPrefix != "" && !strings.HasPrefix(c.PathPrefix, "/") {
+		return fmt.Errorf("path prefix must start with '/'")
+	}
+	if c.TTL <= 0 {
+		return fmt.Errorf("ttl must be positive")
+	}
+	return nil
+}
+
+func newSignURLCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sign-url [url] [flags]",
+		Short: "generate a signed url",
+		Long: `Generate a signed URL.
+
+Signed URLs grant temporary access to an endpoint without a bearer token,
+such as to share an endpoint with an external user. The URL is signed using
+the same secret key as the proxy '--proxy.signed-urls.secret-key'.
+
+The signed URL is generated locally and written to stdout.
+
+Examples:
+  # Share endpoint 'my-endpoint' for one hour.
+  piko token sign-url https://my-endpoint.piko.example.com \
+    --endpoint my-endpoint --secret-key $SECRET_KEY
+
+  # Share only paths prefixed '/docs' for 15 minutes.
+  piko token sign-url https://my-endpoint.piko.example.com/docs \
+    --endpoint my-endpoint --path-prefix /docs --ttl 15m \
+    --secret-key $SECRET_KEY
+`,
+		Args: cobra.ExactArgs(1),
+	}
+
+	var conf signURLConfig
+
+	cmd.Flags().StringVar(
+		&conf.SecretKey,
+		"secret-key",
+		"",
+		`
+HMAC secret key to sign the URL. This must match the proxy
+'--proxy.signed-urls.secret-key'.`,
+	)
+	cmd.Flags().StringVar(
+		&conf.EndpointID,
+		"endpoint",
+		"",
+		`
+Endpoint ID the signed URL grants access to.`,
+	)
+	cmd.Flags().StringVar(
+		&conf.TenantID,
+		"tenant",
+		"",
+		`
+Tenant whose namespace the endpoint is in. If empty, the endpoint is in the
+default tenants namespace.`,
+	)
+	cmd.Flags().StringVar(
+		&conf.PathPrefix,
+		"path-prefix",
+		"",
+		`
+Restricts access to request paths under the given prefix, such as '/docs'.
+The prefix only matches whole path segments. If empty, all paths are
+permitted.`,
+	)
+	cmd.Flags().DurationVar(
+		&conf.TTL,
+		"ttl",
+		time.Hour,
+		`
+Lifetime of the signed URL.`,
+	)
+
+	cmd.Run = func(_ *cobra.Command, args []string) {
+		if err := conf.Validate(); err != nil {
+			fmt.Printf("config: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		u, err := url.Parse(args[0])
+		if err != nil {
+			fmt.Printf("invalid url: %s\n", err.Error())
+			os.Exit(1)
+		}
+
+		signer := auth.NewURLSigner([]byte(conf.SecretKey))
+		if err := signer.SignURL(u, auth.SignedURLGrant{
+			EndpointID: conf.EndpointID,
+			TenantID:   conf.TenantID,
+			Expiry:     time.Now().Add(conf.TTL),
+			PathPrefix: conf.PathPrefix,
+		}); err != nil {
+			fmt.Printf("sign url: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(u.String())
+	}
+
+	return cmd
+}
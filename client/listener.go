@@ -86,7 +86,10 @@ func (l *listener) AcceptWithContext(ctx context.Context) (net.Conn, error) {
 			return nil, ctx.Err()
 		}
 
-		if errors.Is(err, yamux.ErrSessionShutdown) || errors.Is(err, net.ErrClosed) {
+		// Only return ErrClosed if the listener was closed. If the server
+		// closed the connection (such as when rebalancing or disconnecting
+		// the upstream), the error may also be net.ErrClosed so reconnect.
+		if l.closeCtx.Err() != nil || errors.Is(err, yamux.ErrSessionShutdown) {
 			return nil, ErrClosed
 		}
 
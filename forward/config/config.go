@@ -13,6 +13,7 @@ import (
 	"github.com/spf13/pflag"
 
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/tlsconfig"
 )
 
 type PortConfig struct {
@@ -75,12 +76,17 @@ type TLSConfig struct {
 	//
 	// See https://pkg.go.dev/crypto/tls#Config.
 	InsecureSkipVerify bool `json:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+
+	tlsconfig.Options `json:",inline" yaml:",inline"`
 }
 
 func (c *TLSConfig) Validate() error {
 	if c.Cert != "" && c.Key == "" {
 		return fmt.Errorf("missing key")
 	}
+	if err := c.Options.Validate(); err != nil {
+		return err
+	}
 
 	_, err := c.Load()
 	return err
@@ -122,10 +128,15 @@ Defaults to using the host root CAs.`,
 Configures the client to accept any certificate presented by the server and any
 host name in that certificate.`,
 	)
+
+	c.Options.RegisterFlags(fs, prefix)
 }
 
 func (c *TLSConfig) Load() (*tls.Config, error) {
 	tlsConfig := &tls.Config{}
+	if err := c.Options.Apply(tlsConfig); err != nil {
+		return nil, err
+	}
 
 	if c.Cert != "" {
 		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
@@ -42,6 +42,17 @@ func NewNode(opts ...Option) *Node {
 	conf.Upstream.Auth = options.authConfig
 	conf.Admin.Auth = options.authConfig
 
+	if options.acmeConfig.Enabled() {
+		conf.Proxy.ACME = options.acmeConfig
+		if conf.Proxy.ACME.CacheDir == "" {
+			dir, err := os.MkdirTemp("", "piko")
+			if err != nil {
+				panic("create temp: " + err.Error())
+			}
+			conf.Proxy.ACME.CacheDir = dir
+		}
+	}
+
 	// If TLS is enabled, generate a certificate and root CA then write to a
 	// file.
 	var rootCAPool *x509.CertPool
@@ -3,12 +3,14 @@ package cluster
 import (
 	"github.com/andydunstall/piko/pkg/auth"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/config"
 )
 
 type options struct {
 	join       []string
 	authConfig auth.Config
 	tls        bool
+	acmeConfig config.ACMEConfig
 	logger     log.Logger
 }
 
@@ -49,6 +51,20 @@ func WithTLS(tls bool) Option {
 	return tlsOption(tls)
 }
 
+type acmeConfigOption struct {
+	ACMEConfig config.ACMEConfig
+}
+
+func (o acmeConfigOption) apply(opts *options) {
+	opts.acmeConfig = o.ACMEConfig
+}
+
+// WithACMEConfig configures obtaining proxy certificates using ACME. If the
+// cache directory is empty, a temporary directory is used.
+func WithACMEConfig(config config.ACMEConfig) Option {
+	return acmeConfigOption{ACMEConfig: config}
+}
+
 type loggerOption struct {
 	Logger log.Logger
 }
@@ -0,0 +1,157 @@
+package audit
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+type FileConfig struct {
+	// Path is the path of a file to append audit events to as JSON lines.
+	// If empty, events aren't written to a file.
+	Path string `json:"path" yaml:"path"`
+
+	// MaxSize is the maximum size of the file in bytes before it is
+	// rotated. If zero, the file isn't rotated.
+	MaxSize int64 `json:"max_size" yaml:"max_size"`
+
+	// MaxBackups is the maximum number of rotated files to keep. Rotated
+	// files are named '<path>.1' (the most recent) to '<path>.<max_backups>'.
+	MaxBackups int `json:"max_backups" yaml:"max_backups"`
+}
+
+type WebhookConfig struct {
+	// URL is the URL to POST audit events to. If empty, events aren't sent
+	// to a webhook.
+	URL string `json:"url" yaml:"url"`
+
+	// Timeout is the timeout for each webhook request.
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+}
+
+// Config configures recording audit events.
+type Config struct {
+	// Enabled indicates whether to record audit events.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// Interval is the interval to flush audit events to the configured
+	// sinks.
+	Interval time.Duration `json:"interval" yaml:"interval"`
+
+	// File configures writing audit events to a file.
+	File FileConfig `json:"file" yaml:"file"`
+
+	// Webhook configures sending audit events to a HTTP webhook.
+	Webhook WebhookConfig `json:"webhook" yaml:"webhook"`
+
+	// MaxPendingEvents is the maximum number of events to buffer for a sink
+	// that is failing. Once exceeded, the oldest events are dropped.
+	MaxPendingEvents int `json:"max_pending_events" yaml:"max_pending_events"`
+}
+
+func (c *Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	if c.File.Path == "" && c.Webhook.URL == "" {
+		return fmt.Errorf("missing sink; must configure a file or webhook")
+	}
+	if c.File.MaxSize < 0 {
+		return fmt.Errorf("file: max size cannot be negative")
+	}
+	if c.File.MaxBackups < 0 {
+		return fmt.Errorf("file: max backups cannot be negative")
+	}
+	if c.Webhook.URL != "" {
+		u, err := url.Parse(c.Webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("webhook: invalid url: %s", c.Webhook.URL)
+		}
+		if c.Webhook.Timeout <= 0 {
+			return fmt.Errorf("webhook: timeout must be positive")
+		}
+	}
+	if c.MaxPendingEvents < 0 {
+		return fmt.Errorf("max pending events cannot be negative")
+	}
+	return nil
+}
+
+func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(
+		&c.Enabled,
+		"audit.enabled",
+		c.Enabled,
+		`
+Whether to record audit events.
+
+Audit events record upstream listeners registering and disconnecting,
+authentication failures and admin actions, including the client IP, tenant
+and token subject. Events are written as JSON to the configured sinks,
+separately from the access log.
+
+Each event includes the hash of the previous event recorded by the node, so
+modified or deleted events can be detected.`,
+	)
+	fs.DurationVar(
+		&c.Interval,
+		"audit.interval",
+		c.Interval,
+		`
+The interval to flush audit events to the configured sinks.`,
+	)
+	fs.StringVar(
+		&c.File.Path,
+		"audit.file.path",
+		c.File.Path,
+		`
+The path of a file to append audit events to as JSON lines.`,
+	)
+	fs.Int64Var(
+		&c.File.MaxSize,
+		"audit.file.max-size",
+		c.File.MaxSize,
+		`
+The maximum size of the audit file in bytes before it is rotated. Rotated
+files are named '<path>.1' (the most recent) to '<path>.<max-backups>'.
+
+If zero, the file isn't rotated.`,
+	)
+	fs.IntVar(
+		&c.File.MaxBackups,
+		"audit.file.max-backups",
+		c.File.MaxBackups,
+		`
+The maximum number of rotated audit files to keep.`,
+	)
+	fs.StringVar(
+		&c.Webhook.URL,
+		"audit.webhook.url",
+		c.Webhook.URL,
+		`
+A URL to POST audit events to as a JSON array.
+
+If the webhook fails, events are retried on the next flush.`,
+	)
+	fs.DurationVar(
+		&c.Webhook.Timeout,
+		"audit.webhook.timeout",
+		c.Webhook.Timeout,
+		`
+Timeout for each webhook request.`,
+	)
+	fs.IntVar(
+		&c.MaxPendingEvents,
+		"audit.max-pending-events",
+		c.MaxPendingEvents,
+		`
+The maximum number of events to buffer for a sink that is failing. Once
+exceeded, the oldest events are dropped.`,
+	)
+}
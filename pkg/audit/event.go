@@ -0,0 +1,127 @@
+// Package audit records security relevant events, such as upstream listeners
+// registering, authentication failures and admin actions.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Version is the version of the event schema. Fields may be added without
+// changing the version, though existing fields won't be changed or removed.
+const Version = 1
+
+const (
+	// TypeAuthFailed is recorded when a client fails to authenticate.
+	TypeAuthFailed = "auth.failed"
+
+	// TypeUpstreamRegistered is recorded when an upstream listener
+	// registers for an endpoint.
+	TypeUpstreamRegistered = "upstream.registered"
+
+	// TypeUpstreamRejected is recorded when an authenticated upstream
+	// listener is rejected, such as the endpoint isn't permitted.
+	TypeUpstreamRejected = "upstream.rejected"
+
+	// TypeUpstreamDisconnected is recorded when a registered upstream
+	// listener disconnects.
+	TypeUpstreamDisconnected = "upstream.disconnected"
+
+	// TypeAdminRequest is recorded for admin actions, which includes
+	// requests that modify state and requests forwarded to other nodes.
+	TypeAdminRequest = "admin.request"
+)
+
+// Event is an audit event.
+type Event struct {
+	// Version is the version of the event schema.
+	Version int `json:"version"`
+
+	// Seq is the sequence number of the event recorded by the node, starting
+	// at 1 when the node starts.
+	Seq uint64 `json:"seq"`
+
+	// Time is the time the event was recorded.
+	Time time.Time `json:"time"`
+
+	// NodeID is the ID of the node that recorded the event.
+	NodeID string `json:"node_id"`
+
+	// Type is the event type, such as 'upstream.registered'.
+	Type string `json:"type"`
+
+	// Listener is the listener the event occurred on, either 'proxy',
+	// 'upstream' or 'admin'.
+	Listener string `json:"listener"`
+
+	// ClientIP is the IP address of the client.
+	ClientIP string `json:"client_ip,omitempty"`
+
+	// TenantID is the ID of the client tenant.
+	TenantID string `json:"tenant_id,omitempty"`
+
+	// Subject is the token subject ('sub' claim) of the client.
+	Subject string `json:"subject,omitempty"`
+
+	// TokenID is the token ID ('jti' claim) of the client.
+	TokenID string `json:"token_id,omitempty"`
+
+	// EndpointID is the ID of the endpoint.
+	EndpointID string `json:"endpoint_id,omitempty"`
+
+	// Method is the HTTP request method.
+	Method string `json:"method,omitempty"`
+
+	// Path is the HTTP request path.
+	Path string `json:"path,omitempty"`
+
+	// Status is the HTTP response status code.
+	Status int `json:"status,omitempty"`
+
+	// Forward is the ID of the node an admin request was forwarded to.
+	Forward string `json:"forward,omitempty"`
+
+	// Reason describes why the client was rejected.
+	Reason string `json:"reason,omitempty"`
+
+	// PrevHash is the hash of the previous event recorded by the node, or
+	// empty for the first event.
+	PrevHash string `json:"prev_hash"`
+
+	// Hash is the hex encoded SHA-256 hash of the event, excluding the hash
+	// itself. As the hash includes the previous events hash, modifying or
+	// removing an event breaks the chain.
+	Hash string `json:"hash"`
+}
+
+// VerifyChain verifies the hashes of events recorded by a node, where the
+// events must be in the order they were recorded.
+func VerifyChain(events []Event) error {
+	for i, event := range events {
+		if i > 0 && event.PrevHash != events[i-1].Hash {
+			return fmt.Errorf("event %d: previous hash mismatch", event.Seq)
+		}
+		hash, err := event.hash()
+		if err != nil {
+			return fmt.Errorf("event %d: %w", event.Seq, err)
+		}
+		if hash != event.Hash {
+			return fmt.Errorf("event %d: hash mismatch", event.Seq)
+		}
+	}
+	return nil
+}
+
+// hash returns the hash of the event, excluding the Hash field.
+func (e Event) hash() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("encode: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,190 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/piko/pkg/log"
+)
+
+// Logger records audit events and periodically flushes them to the
+// configured sinks.
+//
+// Events are chained by hash, so modifying or removing an event can be
+// detected using VerifyChain.
+type Logger struct {
+	// listener is the listener events are recorded for.
+	listener string
+
+	state *state
+}
+
+// state is shared by all loggers created with WithListener.
+type state struct {
+	nodeID string
+
+	seq      uint64
+	prevHash string
+
+	sinks []*sinkQueue
+
+	// mu protects the sequence number, previous hash and pending events.
+	mu sync.Mutex
+
+	// flushMu ensures only a single flush runs at a time.
+	flushMu sync.Mutex
+
+	config Config
+
+	metrics *Metrics
+
+	logger log.Logger
+}
+
+// sinkQueue contains the events that are pending for a sink. Each sink has
+// its own queue so a failing sink doesn't cause events to be written to other
+// sinks multiple times.
+type sinkQueue struct {
+	sink    Sink
+	pending []Event
+}
+
+func NewLogger(
+	nodeID string,
+	conf Config,
+	sinks []Sink,
+	logger log.Logger,
+) *Logger {
+	s := &state{
+		nodeID:  nodeID,
+		config:  conf,
+		metrics: NewMetrics(),
+		logger:  logger.WithSubsystem("audit"),
+	}
+	for _, sink := range sinks {
+		s.sinks = append(s.sinks, &sinkQueue{sink: sink})
+	}
+	return &Logger{
+		state: s,
+	}
+}
+
+// WithListener returns a logger that records events for the given listener.
+func (l *Logger) WithListener(listener string) *Logger {
+	return &Logger{
+		listener: listener,
+		state:    l.state,
+	}
+}
+
+// Record records the event. The version, sequence number, time, node ID,
+// listener and hashes are set by the logger.
+func (l *Logger) Record(event Event) {
+	s := l.state
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	event.Version = Version
+	event.Seq = s.seq
+	event.Time = time.Now().UTC()
+	event.NodeID = s.nodeID
+	event.Listener = l.listener
+	event.PrevHash = s.prevHash
+
+	hash, err := event.hash()
+	if err != nil {
+		// Will not happen as the event only contains encodable types.
+		panic("audit: " + err.Error())
+	}
+	event.Hash = hash
+	s.prevHash = hash
+
+	for _, queue := range s.sinks {
+		queue.pending = append(queue.pending, event)
+		if s.config.MaxPendingEvents > 0 && len(queue.pending) > s.config.MaxPendingEvents {
+			dropped := len(queue.pending) - s.config.MaxPendingEvents
+			queue.pending = queue.pending[dropped:]
+
+			s.metrics.EventsDroppedTotal.WithLabelValues(queue.sink.Name()).Add(float64(dropped))
+		}
+	}
+}
+
+// Run flushes events to the sinks every interval until the context is
+// cancelled, then flushes the remaining events.
+func (l *Logger) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.state.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.Flush(ctx)
+		case <-ctx.Done():
+			// Flush the final events with a new context as ctx is
+			// cancelled.
+			flushCtx, cancel := context.WithTimeout(
+				context.Background(), l.state.config.Webhook.Timeout,
+			)
+			l.Flush(flushCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+// Flush writes the pending events to the sinks.
+func (l *Logger) Flush(ctx context.Context) {
+	s := l.state
+
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	for _, queue := range s.sinks {
+		s.mu.Lock()
+		pending := queue.pending
+		s.mu.Unlock()
+
+		if len(pending) == 0 {
+			continue
+		}
+
+		if err := queue.sink.Write(ctx, pending); err != nil {
+			s.metrics.WriteErrorsTotal.WithLabelValues(queue.sink.Name()).Inc()
+			s.logger.Warn(
+				"failed to write audit events",
+				zap.String("sink", queue.sink.Name()),
+				zap.Int("pending", len(pending)),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		s.metrics.EventsTotal.WithLabelValues(queue.sink.Name()).Add(float64(len(pending)))
+
+		s.mu.Lock()
+		queue.pending = removeWritten(queue.pending, pending)
+		s.mu.Unlock()
+	}
+}
+
+func (l *Logger) Metrics() *Metrics {
+	return l.state.metrics
+}
+
+// removeWritten removes the written events from the pending events. Events
+// may have been recorded, or the oldest events dropped, while writing.
+func removeWritten(pending []Event, written []Event) []Event {
+	last := written[len(written)-1].Seq
+	for i, event := range pending {
+		if event.Seq > last {
+			return pending[i:]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/pkg/log"
+)
+
+type fakeSink struct {
+	events [][]Event
+	err    error
+}
+
+func (s *fakeSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeSink) Write(_ context.Context, events []Event) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.events = append(s.events, append([]Event(nil), events...))
+	return nil
+}
+
+func TestLogger_Record(t *testing.T) {
+	sink := &fakeSink{}
+	logger := NewLogger("node-1", Config{
+		Interval: time.Minute,
+	}, []Sink{sink}, log.NewNopLogger())
+
+	logger.WithListener("upstream").Record(Event{
+		Type:       TypeUpstreamRegistered,
+		ClientIP:   "10.26.104.56",
+		TenantID:   "tenant-1",
+		Subject:    "my-service",
+		EndpointID: "endpoint-1",
+	})
+	logger.WithListener("proxy").Record(Event{
+		Type:     TypeAuthFailed,
+		ClientIP: "10.26.104.57",
+		Reason:   "invalid token",
+	})
+
+	logger.Flush(context.Background())
+
+	require.Len(t, sink.events, 1)
+	events := sink.events[0]
+	require.Len(t, events, 2)
+
+	assert.Equal(t, Version, events[0].Version)
+	assert.Equal(t, uint64(1), events[0].Seq)
+	assert.Equal(t, "node-1", events[0].NodeID)
+	assert.Equal(t, "upstream", events[0].Listener)
+	assert.Equal(t, TypeUpstreamRegistered, events[0].Type)
+	assert.Equal(t, "my-service", events[0].Subject)
+	assert.Equal(t, "", events[0].PrevHash)
+
+	assert.Equal(t, uint64(2), events[1].Seq)
+	assert.Equal(t, "proxy", events[1].Listener)
+	assert.Equal(t, events[0].Hash, events[1].PrevHash)
+
+	assert.NoError(t, VerifyChain(events))
+
+	// Flushing again doesn't rewrite the events.
+	logger.Flush(context.Background())
+	assert.Len(t, sink.events, 1)
+}
+
+func TestLogger_SinkFailure(t *testing.T) {
+	failing := &fakeSink{err: errors.New("unavailable")}
+	healthy := &fakeSink{}
+	logger := NewLogger("node-1", Config{
+		Interval:         time.Minute,
+		MaxPendingEvents: 2,
+	}, []Sink{failing, healthy}, log.NewNopLogger())
+
+	for _, endpointID := range []string{"endpoint-1", "endpoint-2", "endpoint-3"} {
+		logger.Record(Event{
+			Type:       TypeUpstreamRegistered,
+			EndpointID: endpointID,
+		})
+		logger.Flush(context.Background())
+	}
+
+	// The healthy sink isn't affected by the failing sink.
+	assert.Len(t, healthy.events, 3)
+
+	// Once the failing sink recovers, the pending events are retried,
+	// excluding the oldest which were dropped.
+	failing.err = nil
+	logger.Flush(context.Background())
+	require.Len(t, failing.events, 1)
+	require.Len(t, failing.events[0], 2)
+	assert.Equal(t, "endpoint-2", failing.events[0][0].EndpointID)
+	assert.Equal(t, "endpoint-3", failing.events[0][1].EndpointID)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(
+		logger.Metrics().EventsDroppedTotal.WithLabelValues("fake"),
+	))
+}
+
+func TestVerifyChain(t *testing.T) {
+	sink := &fakeSink{}
+	logger := NewLogger("node-1", Config{
+		Interval: time.Minute,
+	}, []Sink{sink}, log.NewNopLogger())
+
+	for _, endpointID := range []string{"endpoint-1", "endpoint-2", "endpoint-3"} {
+		logger.Record(Event{
+			Type:       TypeUpstreamRegistered,
+			EndpointID: endpointID,
+		})
+	}
+	logger.Flush(context.Background())
+	require.Len(t, sink.events, 1)
+	events := sink.events[0]
+
+	assert.NoError(t, VerifyChain(events))
+
+	t.Run("encoded", func(t *testing.T) {
+		b, err := json.Marshal(events)
+		require.NoError(t, err)
+		var decoded []Event
+		require.NoError(t, json.Unmarshal(b, &decoded))
+		assert.NoError(t, VerifyChain(decoded))
+	})
+
+	t.Run("modified", func(t *testing.T) {
+		modified := append([]Event(nil), events...)
+		modified[1].EndpointID = "endpoint-4"
+		assert.EqualError(t, VerifyChain(modified), "event 2: hash mismatch")
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		removed := []Event{events[0], events[2]}
+		assert.EqualError(
+			t, VerifyChain(removed), "event 3: previous hash mismatch",
+		)
+	})
+}
@@ -0,0 +1,57 @@
+package audit
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type Metrics struct {
+	// EventsTotal is the number of events written to each sink. Labelled
+	// by sink name.
+	EventsTotal *prometheus.CounterVec
+
+	// WriteErrorsTotal is the number of failed writes to each sink.
+	// Labelled by sink name.
+	WriteErrorsTotal *prometheus.CounterVec
+
+	// EventsDroppedTotal is the number of events dropped as a sink exceeded
+	// the maximum number of pending events. Labelled by sink name.
+	EventsDroppedTotal *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		EventsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "audit",
+				Name:      "events_total",
+				Help:      "Number of audit events written to each sink",
+			},
+			[]string{"sink"},
+		),
+		WriteErrorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "audit",
+				Name:      "write_errors_total",
+				Help:      "Number of failed writes to each sink",
+			},
+			[]string{"sink"},
+		),
+		EventsDroppedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "audit",
+				Name:      "events_dropped_total",
+				Help:      "Number of audit events dropped due to a failing sink",
+			},
+			[]string{"sink"},
+		),
+	}
+}
+
+func (m *Metrics) Register(registry *prometheus.Registry) {
+	registry.MustRegister(
+		m.EventsTotal,
+		m.WriteErrorsTotal,
+		m.EventsDroppedTotal,
+	)
+}
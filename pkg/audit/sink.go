@@ -0,0 +1,166 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Sink receives audit events.
+type Sink interface {
+	// Name returns the name of the sink used for logging and metrics.
+	Name() string
+
+	// Write writes the events to the sink. If an error is returned, the
+	// events will be retried.
+	Write(ctx context.Context, events []Event) error
+}
+
+// FileSink appends events to a file as JSON lines, and rotates the file once
+// it exceeds the configured maximum size.
+type FileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+}
+
+func NewFileSink(conf FileConfig) *FileSink {
+	return &FileSink{
+		path:       conf.Path,
+		maxSize:    conf.MaxSize,
+		maxBackups: conf.MaxBackups,
+	}
+}
+
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+func (s *FileSink) Write(_ context.Context, events []Event) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+	}
+
+	if err := s.rotate(int64(buf.Len())); err != nil {
+		return fmt.Errorf("rotate: %w", err)
+	}
+
+	// Open the file on each write to support rotating the file externally.
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+	return nil
+}
+
+// rotate rotates the file if writing n bytes would exceed the maximum size.
+func (s *FileSink) rotate(n int64) error {
+	if s.maxSize == 0 {
+		return nil
+	}
+
+	info, err := os.Stat(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Always write to an empty file, even if the events exceed the maximum
+	// size.
+	if info.Size() == 0 || info.Size()+n <= s.maxSize {
+		return nil
+	}
+
+	if s.maxBackups == 0 {
+		return os.Remove(s.path)
+	}
+
+	// Shift the existing backups, discarding the oldest.
+	for i := s.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(s.backupPath(i), s.backupPath(i+1))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(s.path, s.backupPath(1))
+}
+
+func (s *FileSink) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
+
+// WebhookSink sends events to a HTTP webhook as a JSON array.
+type WebhookSink struct {
+	url string
+
+	httpClient *http.Client
+}
+
+func NewWebhookSink(url string, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{
+		url: url,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSink) Write(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, s.url, bytes.NewReader(body),
+	)
+	if err != nil {
+		return fmt.Errorf("request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request: %w", err)
+	}
+	defer resp.Body.Close()
+	// Drain the body to reuse the connection.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("request: bad status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+var _ Sink = &FileSink{}
+var _ Sink = &WebhookSink{}
@@ -0,0 +1,127 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSink(t *testing.T) {
+	t.Run("append", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		sink := NewFileSink(FileConfig{Path: path})
+
+		require.NoError(t, sink.Write(context.Background(), []Event{
+			{Seq: 1, EndpointID: "endpoint-1"},
+			{Seq: 2, EndpointID: "endpoint-2"},
+		}))
+		// Writes append to the existing file.
+		require.NoError(t, sink.Write(context.Background(), []Event{
+			{Seq: 3, EndpointID: "endpoint-3"},
+		}))
+
+		assert.Equal(t, []uint64{1, 2, 3}, readSeqs(t, path))
+	})
+
+	t.Run("rotate", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		// Rotate after each write.
+		sink := NewFileSink(FileConfig{
+			Path:       path,
+			MaxSize:    1,
+			MaxBackups: 2,
+		})
+
+		for seq := uint64(1); seq <= 4; seq++ {
+			require.NoError(t, sink.Write(context.Background(), []Event{
+				{Seq: seq},
+			}))
+		}
+
+		assert.Equal(t, []uint64{4}, readSeqs(t, path))
+		assert.Equal(t, []uint64{3}, readSeqs(t, path+".1"))
+		assert.Equal(t, []uint64{2}, readSeqs(t, path+".2"))
+		// The oldest backup is discarded.
+		_, err := os.Stat(path + ".3")
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("rotate no backups", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		sink := NewFileSink(FileConfig{
+			Path:    path,
+			MaxSize: 1,
+		})
+
+		for seq := uint64(1); seq <= 2; seq++ {
+			require.NoError(t, sink.Write(context.Background(), []Event{
+				{Seq: seq},
+			}))
+		}
+
+		assert.Equal(t, []uint64{2}, readSeqs(t, path))
+		_, err := os.Stat(path + ".1")
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestWebhookSink(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var received []Event
+		server := httptest.NewServer(http.HandlerFunc(
+			func(_ http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+			},
+		))
+		defer server.Close()
+
+		sink := NewWebhookSink(server.URL, time.Second)
+		require.NoError(t, sink.Write(context.Background(), []Event{
+			{Seq: 1, Type: TypeAuthFailed, Reason: "invalid token"},
+		}))
+
+		assert.Equal(t, []Event{
+			{Seq: 1, Type: TypeAuthFailed, Reason: "invalid token"},
+		}, received)
+	})
+
+	t.Run("bad status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+		))
+		defer server.Close()
+
+		sink := NewWebhookSink(server.URL, time.Second)
+		assert.Error(t, sink.Write(context.Background(), []Event{
+			{Seq: 1},
+		}))
+	})
+}
+
+func readSeqs(t *testing.T, path string) []uint64 {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var seqs []uint64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		seqs = append(seqs, event.Seq)
+	}
+	return seqs
+}
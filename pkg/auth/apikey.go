@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+
+	"github.com/andydunstall/piko/pkg/log"
+)
+
+const (
+	// defaultAPIKeysReloadInterval is the interval to check the API keys
+	// file for changes if no interval is configured.
+	defaultAPIKeysReloadInterval = time.Second * 5
+)
+
+// APIKeysConfig configures verifying opaque API keys.
+type APIKeysConfig struct {
+	// File is the path of a YAML file containing the hashed API keys.
+	File string `json:"file" yaml:"file"`
+
+	// ReloadInterval is the interval to check the API keys file for
+	// changes.
+	ReloadInterval time.Duration `json:"reload_interval" yaml:"reload_interval"`
+}
+
+func (c *APIKeysConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	prefix += "api-keys."
+
+	fs.StringVar(
+		&c.File,
+		prefix+"file",
+		c.File,
+		`
+Path of a YAML file containing hashed API keys.
+
+API keys are opaque tokens that can be used instead of JWTs. The file contains
+a list of keys, where each key has the hex encoded SHA-256 hash of the key,
+and optionally the permitted endpoints, listen and connect endpoints, tenant
+ID and expiry. Such as:
+
+  keys:
+    - hash: 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08
+      tenant_id: my-tenant
+      endpoints:
+        - my-endpoint
+      expiry: 2030-01-01T00:00:00Z
+
+The file is reloaded when it changes or when the server receives SIGHUP.`,
+	)
+	fs.DurationVar(
+		&c.ReloadInterval,
+		prefix+"reload-interval",
+		c.ReloadInterval,
+		`
+Interval to check the API keys file for changes. Defaults to 5s.`,
+	)
+}
+
+// APIKey is an entry in the API keys file.
+type APIKey struct {
+	// Hash is the hex encoded SHA-256 hash of the API key.
+	Hash string `json:"hash" yaml:"hash"`
+
+	// TenantID is the ID of the tenant the key belongs to. If set, the key
+	// can only be used by that tenant.
+	TenantID string `json:"tenant_id" yaml:"tenant_id"`
+
+	// Endpoints contains the endpoints the key can both listen on and
+	// connect to.
+	Endpoints []string `json:"endpoints" yaml:"endpoints"`
+
+	// Listen contains the endpoints the key can listen on.
+	Listen []string `json:"listen" yaml:"listen"`
+
+	// Connect contains the endpoints the key can connect to.
+	Connect []string `json:"connect" yaml:"connect"`
+
+	// Expiry is the time the key expires, or zero if the key doesn't
+	// expire.
+	Expiry time.Time `json:"expiry" yaml:"expiry"`
+}
+
+type apiKeysFile struct {
+	Keys []APIKey `json:"keys" yaml:"keys"`
+}
+
+// HashAPIKey returns the hex encoded SHA-256 hash of the given API key, as
+// expected in the API keys file.
+func HashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// APIKeyVerifier verifies opaque API keys loaded from a file of hashed keys.
+type APIKeyVerifier struct {
+	path string
+
+	// keys contains the loaded API keys indexed by hash.
+	keys atomic.Pointer[map[string]APIKey]
+
+	// modTime and size are used to detect when the file changes.
+	modTime time.Time
+	size    int64
+	// mu protects modTime and size, and ensures a single reload runs at
+	// a time.
+	mu sync.Mutex
+
+	logger log.Logger
+}
+
+// NewAPIKeyVerifier creates a verifier for the API keys in the file at the
+// given path. Returns an error if the file can't be loaded.
+func NewAPIKeyVerifier(path string, logger log.Logger) (*APIKeyVerifier, error) {
+	v := &APIKeyVerifier{
+		path:   path,
+		logger: logger.WithSubsystem("auth.apikeys"),
+	}
+	if err := v.Reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *APIKeyVerifier) Verify(token string) (*Token, error) {
+	key, ok := (*v.keys.Load())[HashAPIKey(token)]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	if !key.Expiry.IsZero() && time.Now().After(key.Expiry) {
+		return nil, ErrExpiredToken
+	}
+	return &Token{
+		Expiry:           key.Expiry,
+		Endpoints:        key.Endpoints,
+		ListenEndpoints:  key.Listen,
+		ConnectEndpoints: key.Connect,
+		TenantID:         key.TenantID,
+	}, nil
+}
+
+// Reload loads the API keys file. If the file is invalid, the existing keys
+// are kept.
+func (v *APIKeyVerifier) Reload() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	info, err := os.Stat(v.path)
+	if err != nil {
+		return fmt.Errorf("api keys: %w", err)
+	}
+	b, err := os.ReadFile(v.path)
+	if err != nil {
+		return fmt.Errorf("api keys: %w", err)
+	}
+
+	var f apiKeysFile
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("api keys: parse: %w", err)
+	}
+
+	keys := make(map[string]APIKey, len(f.Keys))
+	for i, key := range f.Keys {
+		hash := strings.ToLower(key.Hash)
+		if len(hash) != sha256.Size*2 {
+			return fmt.Errorf("api keys: key %d: invalid hash", i)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return fmt.Errorf("api keys: key %d: invalid hash", i)
+		}
+		keys[hash] = key
+	}
+
+	v.keys.Store(&keys)
+	v.modTime = info.ModTime()
+	v.size = info.Size()
+
+	return nil
+}
+
+// Watch reloads the API keys file when it changes or the process receives
+// SIGHUP, until the context is cancelled.
+func (v *APIKeyVerifier) Watch(ctx context.Context, interval time.Duration) {
+	if interval == 0 {
+		interval = defaultAPIKeysReloadInterval
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !v.changed() {
+				continue
+			}
+		case <-sighup:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := v.Reload(); err != nil {
+			v.logger.Warn("failed to reload api keys", zap.Error(err))
+			continue
+		}
+		v.logger.Info(
+			"reloaded api keys",
+			zap.Int("keys", len(*v.keys.Load())),
+		)
+	}
+}
+
+// changed returns whether the API keys file has changed since it was last
+// loaded.
+func (v *APIKeyVerifier) changed() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	info, err := os.Stat(v.path)
+	if err != nil {
+		return false
+	}
+	return !info.ModTime().Equal(v.modTime) || info.Size() != v.size
+}
+
+var _ Verifier = &APIKeyVerifier{}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/pkg/log"
+)
+
+func writeAPIKeys(t *testing.T, path string, keys string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(keys), 0o600))
+}
+
+func TestAPIKeyVerifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	writeAPIKeys(t, path, fmt.Sprintf(`
+keys:
+  - hash: %s
+    tenant_id: my-tenant
+    endpoints:
+      - my-endpoint
+    listen:
+      - listen-endpoint
+    connect:
+      - "connect-*"
+  - hash: %s
+    expiry: 2020-01-01T00:00:00Z
+`, HashAPIKey("key-1"), HashAPIKey("expired-key")))
+
+	verifier, err := NewAPIKeyVerifier(path, log.NewNopLogger())
+	require.NoError(t, err)
+
+	t.Run("ok", func(t *testing.T) {
+		token, err := verifier.Verify("key-1")
+		require.NoError(t, err)
+
+		assert.Equal(t, "my-tenant", token.TenantID)
+		assert.Equal(t, []string{"my-endpoint"}, token.Endpoints)
+		assert.Equal(t, []string{"listen-endpoint"}, token.ListenEndpoints)
+		assert.Equal(t, []string{"connect-*"}, token.ConnectEndpoints)
+		assert.True(t, token.Expiry.IsZero())
+
+		assert.True(t, token.ListenPermitted("listen-endpoint"))
+		assert.True(t, token.ConnectPermitted("connect-foo"))
+		assert.False(t, token.ListenPermitted("connect-foo"))
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		_, err := verifier.Verify("unknown")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		_, err := verifier.Verify("expired-key")
+		assert.ErrorIs(t, err, ErrExpiredToken)
+	})
+
+	t.Run("invalid hash", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "keys.yaml")
+		writeAPIKeys(t, path, `
+keys:
+  - hash: not-a-hash
+`)
+		_, err := NewAPIKeyVerifier(path, log.NewNopLogger())
+		assert.Error(t, err)
+	})
+}
+
+func TestAPIKeyVerifier_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	writeAPIKeys(t, path, fmt.Sprintf(`
+keys:
+  - hash: %s
+`, HashAPIKey("key-1")))
+
+	verifier, err := NewAPIKeyVerifier(path, log.NewNopLogger())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go verifier.Watch(ctx, time.Millisecond*10)
+
+	_, err = verifier.Verify("key-1")
+	require.NoError(t, err)
+
+	writeAPIKeys(t, path, fmt.Sprintf(`
+keys:
+  - hash: %s
+  - hash: %s
+`, HashAPIKey("key-2"), HashAPIKey("key-3")))
+
+	assert.Eventually(t, func() bool {
+		_, err := verifier.Verify("key-2")
+		return err == nil
+	}, time.Second, time.Millisecond*10)
+
+	_, err = verifier.Verify("key-1")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// An invalid file keeps the existing keys.
+	writeAPIKeys(t, path, "keys: [")
+	assert.Error(t, verifier.Reload())
+	_, err = verifier.Verify("key-2")
+	assert.NoError(t, err)
+}
+
+func TestChainVerifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	writeAPIKeys(t, path, fmt.Sprintf(`
+keys:
+  - hash: %s
+`, HashAPIKey("my-key")))
+	apiKeyVerifier, err := NewAPIKeyVerifier(path, log.NewNopLogger())
+	require.NoError(t, err)
+
+	secretKey := generateTestHSKey(t)
+	jwtVerifier := NewJWTVerifier(&LoadedConfig{
+		HMACSecretKey: secretKey,
+	})
+
+	verifier := NewChainVerifier(apiKeyVerifier, jwtVerifier)
+
+	_, err = verifier.Verify("my-key")
+	assert.NoError(t, err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, JWTClaims{
+		Piko: PikoClaims{
+			Endpoints: []string{"my-endpoint"},
+		},
+	})
+	tokenString, err := token.SignedString([]byte(secretKey))
+	require.NoError(t, err)
+
+	parsedToken, err := verifier.Verify(tokenString)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"my-endpoint"}, parsedToken.Endpoints)
+
+	expiredToken := jwt.NewWithClaims(jwt.SigningMethodHS256, JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour)),
+		},
+	})
+	expiredTokenString, err := expiredToken.SignedString([]byte(secretKey))
+	require.NoError(t, err)
+
+	_, err = verifier.Verify(expiredTokenString)
+	assert.ErrorIs(t, err, ErrExpiredToken)
+
+	_, err = verifier.Verify("unknown")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
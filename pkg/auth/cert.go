@@ -0,0 +1,242 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// commonNamePlaceholder is replaced by the certificates common name in the
+// endpoints of a certificate rule.
+const commonNamePlaceholder = "{cn}"
+
+// patternMetacharacters contains the characters with a special meaning in
+// endpoint patterns, being the '*' wildcard and the '{tenant}' placeholder.
+const patternMetacharacters = "*{}"
+
+// CertRule maps client certificates to permissions.
+//
+// A rule matches a certificate if all configured identity patterns match.
+// Patterns may contain a '*' wildcard, which matches any sequence of
+// characters.
+type CertRule struct {
+	// CommonName is a pattern matching the certificate subject common name.
+	CommonName string `json:"common_name" yaml:"common_name"`
+
+	// DNSName is a pattern matching any of the certificate DNS SANs.
+	DNSName string `json:"dns_name" yaml:"dns_name"`
+
+	// URI is a pattern matching any of the certificate URI SANs, such as a
+	// SPIFFE ID 'spiffe://example.com/agent/*'.
+	URI string `json:"uri" yaml:"uri"`
+
+	// TenantID is the ID of the tenant matching certificates belong to.
+	TenantID string `json:"tenant_id" yaml:"tenant_id"`
+
+	// Endpoints contains the endpoints matching certificates can both listen
+	// on and connect to.
+	//
+	// Endpoints may include a '{cn}' placeholder which is replaced by the
+	// certificate common name. Certificates whose common name contains '*',
+	// '{' or '}' never match a rule using the placeholder.
+	Endpoints []string `json:"endpoints" yaml:"endpoints"`
+
+	// Listen contains the endpoints matching certificates can listen on.
+	Listen []string `json:"listen" yaml:"listen"`
+
+	// Connect contains the endpoints matching certificates can connect to.
+	Connect []string `json:"connect" yaml:"connect"`
+}
+
+// CertConfig configures authenticating clients using their verified TLS
+// client certificate.
+//
+// Requires the server to be configured with TLS client CAs. Note requests
+// forwarded between Piko nodes are authenticated using the forwarding nodes
+// client certificate, so when running a cluster a rule must also match the
+// node certificates.
+type CertConfig struct {
+	// Rules contains the rules to map client certificates to permissions.
+	// The first matching rule is used. If no rule matches, the client is
+	// rejected.
+	Rules []CertRule `json:"rules" yaml:"rules"`
+}
+
+func (c *CertConfig) Enabled() bool {
+	return len(c.Rules) > 0
+}
+
+func (c *CertConfig) Validate() error {
+	for i, rule := range c.Rules {
+		if rule.CommonName == "" && rule.DNSName == "" && rule.URI == "" {
+			return fmt.Errorf(
+				"rule %d: missing common name, dns name or uri", i,
+			)
+		}
+	}
+	return nil
+}
+
+// CertificateVerifier verifies TLS client certificates.
+type CertificateVerifier interface {
+	// VerifyCertificate returns the token for a client certificate that
+	// has already been verified by the TLS handshake.
+	VerifyCertificate(cert *x509.Certificate) (*Token, error)
+}
+
+type certRule struct {
+	commonName *regexp.Regexp
+	dnsName    *regexp.Regexp
+	uri        *regexp.Regexp
+
+	rule CertRule
+}
+
+// CertVerifier maps verified TLS client certificates to tokens.
+//
+// The token subject is the certificate common name, or the serial number if
+// the certificate has no common name, and the token ID is the serial number.
+// So certificates can be revoked by either.
+type CertVerifier struct {
+	rules []certRule
+}
+
+func NewCertVerifier(conf *CertConfig) *CertVerifier {
+	v := &CertVerifier{}
+	for _, rule := range conf.Rules {
+		r := certRule{rule: rule}
+		if rule.CommonName != "" {
+			r.commonName = globRegexp(rule.CommonName)
+		}
+		if rule.DNSName != "" {
+			r.dnsName = globRegexp(rule.DNSName)
+		}
+		if rule.URI != "" {
+			r.uri = globRegexp(rule.URI)
+		}
+		v.rules = append(v.rules, r)
+	}
+	return v
+}
+
+// Verify doesn't accept any bearer tokens, as only client certificates are
+// supported.
+func (v *CertVerifier) Verify(_ string) (*Token, error) {
+	return nil, ErrInvalidToken
+}
+
+func (v *CertVerifier) VerifyCertificate(cert *x509.Certificate) (*Token, error) {
+	for _, r := range v.rules {
+		if !r.match(cert) {
+			continue
+		}
+
+		commonName := cert.Subject.CommonName
+		if r.usesCommonName() {
+			if commonName == "" {
+				// Skip the rule rather than granting access to a partial
+				// set of endpoints.
+				continue
+			}
+			if strings.ContainsAny(commonName, patternMetacharacters) {
+				// Skip the rule rather than inserting the common name into
+				// the endpoint patterns, which would grant a common name
+				// such as '*' access to all endpoints.
+				continue
+			}
+		}
+		return &Token{
+			Expiry:           cert.NotAfter,
+			ID:               certificateSerial(cert),
+			Subject:          certificateSubject(cert),
+			Endpoints:        replaceCommonName(r.rule.Endpoints, commonName),
+			ListenEndpoints:  replaceCommonName(r.rule.Listen, commonName),
+			ConnectEndpoints: replaceCommonName(r.rule.Connect, commonName),
+			TenantID:         r.rule.TenantID,
+		}, nil
+	}
+	return nil, ErrInvalidToken
+}
+
+func (r *certRule) match(cert *x509.Certificate) bool {
+	if r.commonName != nil && !r.commonName.MatchString(cert.Subject.CommonName) {
+		return false
+	}
+	if r.dnsName != nil {
+		matched := false
+		for _, name := range cert.DNSNames {
+			if r.dnsName.MatchString(name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if r.uri != nil {
+		matched := false
+		for _, uri := range cert.URIs {
+			if r.uri.MatchString(uri.String()) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// usesCommonName returns whether any of the rules endpoints contain the
+// '{cn}' placeholder.
+func (r *certRule) usesCommonName() bool {
+	for _, endpoints := range [][]string{
+		r.rule.Endpoints, r.rule.Listen, r.rule.Connect,
+	} {
+		for _, endpoint := range endpoints {
+			if strings.Contains(endpoint, commonNamePlaceholder) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// certificateSerial returns the certificates hex encoded serial number, which
+// is used as the token ID so a certificate can be revoked by its serial.
+func certificateSerial(cert *x509.Certificate) string {
+	if cert.SerialNumber == nil {
+		return ""
+	}
+	return cert.SerialNumber.Text(16)
+}
+
+// certificateSubject returns the certificates common name, or its serial
+// number if it has no common name, so the certificate can be revoked and
+// audited by subject.
+func certificateSubject(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return certificateSerial(cert)
+}
+
+func replaceCommonName(endpoints []string, commonName string) []string {
+	if len(endpoints) == 0 {
+		return nil
+	}
+	replaced := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		replaced = append(
+			replaced,
+			strings.ReplaceAll(endpoint, commonNamePlaceholder, commonName),
+		)
+	}
+	return replaced
+}
+
+var _ Verifier = &CertVerifier{}
+var _ CertificateVerifier = &CertVerifier{}
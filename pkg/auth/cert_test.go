@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertVerifier(t *testing.T) {
+	verifier := NewCertVerifier(&CertConfig{
+		Rules: []CertRule{
+			{
+				URI:      "spiffe://example.com/agent/*",
+				TenantID: "my-tenant",
+				Listen:   []string{"{tenant}-*"},
+			},
+			{
+				DNSName: "*.internal.example.com",
+				Connect: []string{"internal-*"},
+			},
+			{
+				CommonName: "agent-*",
+				Endpoints:  []string{"{cn}"},
+			},
+		},
+	})
+
+	t.Run("uri", func(t *testing.T) {
+		spiffeID, _ := url.Parse("spiffe://example.com/agent/1")
+		expiry := time.Now().Add(time.Hour)
+		token, err := verifier.VerifyCertificate(&x509.Certificate{
+			URIs:     []*url.URL{spiffeID},
+			NotAfter: expiry,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "my-tenant", token.TenantID)
+		assert.Equal(t, []string{"{tenant}-*"}, token.ListenEndpoints)
+		assert.Equal(t, expiry, token.Expiry)
+
+		assert.True(t, token.ListenPermitted("my-tenant-api"))
+		assert.False(t, token.ConnectPermitted("my-tenant-api"))
+	})
+
+	t.Run("dns", func(t *testing.T) {
+		token, err := verifier.VerifyCertificate(&x509.Certificate{
+			SerialNumber: big.NewInt(0xab12),
+			DNSNames:     []string{"foo.example.com", "db.internal.example.com"},
+		})
+		require.NoError(t, err)
+
+		// Without a common name the subject is the serial number.
+		assert.Equal(t, "ab12", token.ID)
+		assert.Equal(t, "ab12", token.Subject)
+
+		assert.Equal(t, []string{"internal-*"}, token.ConnectEndpoints)
+	})
+
+	t.Run("common name", func(t *testing.T) {
+		token, err := verifier.VerifyCertificate(&x509.Certificate{
+			Subject: pkix.Name{CommonName: "agent-1"},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "agent-1", token.Subject)
+		assert.Equal(t, []string{"agent-1"}, token.Endpoints)
+		assert.True(t, token.ListenPermitted("agent-1"))
+		assert.False(t, token.ListenPermitted("agent-2"))
+	})
+
+	t.Run("common name pattern", func(t *testing.T) {
+		for _, commonName := range []string{"agent-*", "agent-{tenant}"} {
+			_, err := verifier.VerifyCertificate(&x509.Certificate{
+				Subject: pkix.Name{CommonName: commonName},
+			})
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		}
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		spiffeID, _ := url.Parse("spiffe://other.com/agent/1")
+		_, err := verifier.VerifyCertificate(&x509.Certificate{
+			Subject:  pkix.Name{CommonName: "other"},
+			DNSNames: []string{"db.example.com"},
+			URIs:     []*url.URL{spiffeID},
+		})
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("bearer token", func(t *testing.T) {
+		_, err := verifier.Verify("123")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
+
+func TestCertConfig_Validate(t *testing.T) {
+	conf := CertConfig{
+		Rules: []CertRule{
+			{
+				Endpoints: []string{"my-endpoint"},
+			},
+		},
+	}
+	assert.Error(t, conf.Validate())
+}
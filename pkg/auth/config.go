@@ -5,6 +5,8 @@ import (
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/spf13/pflag"
@@ -44,6 +46,28 @@ type Config struct {
 	//
 	// If provided, it will take precedence over the other keys.
 	JWKS JWKSConfig `json:"jwks" yaml:"jwks"`
+
+	// OIDC configures verifying JWTs using an OIDC issuers discovery
+	// document.
+	//
+	// If provided, it will take precedence over the other keys.
+	OIDC OIDCConfig `json:"oidc" yaml:"oidc"`
+
+	// Algorithms contains the permitted JWT signing algorithms, such as
+	// 'RS256'. If empty, all algorithms supported by the configured keys are
+	// permitted.
+	Algorithms []string `json:"algorithms" yaml:"algorithms"`
+
+	// Leeway is the allowed clock skew when validating the JWT 'exp', 'nbf'
+	// and 'iat' claims.
+	Leeway time.Duration `json:"leeway" yaml:"leeway"`
+
+	// Cert configures authenticating clients using their verified TLS
+	// client certificate, instead of a bearer token.
+	Cert CertConfig `json:"cert" yaml:"cert"`
+
+	// APIKeys configures verifying opaque API keys in addition to JWTs.
+	APIKeys APIKeysConfig `json:"api_keys" yaml:"api_keys"`
 }
 
 // LoadedConfig is the same as Config except it parses the RSA, ECDSA keys and JWKS.
@@ -55,13 +79,16 @@ type LoadedConfig struct {
 	Issuer                    string
 	DisableDisconnectOnExpiry bool
 	JWKS                      *LoadedJWKS
+	Algorithms                []string
+	Leeway                    time.Duration
 }
 
 // Enabled returns whether authentication is enabled.
 //
-// It is enabled when at least one verification key is configured.
+// It is enabled when at least one verification key, an API keys file or
+// certificate rules are configured.
 func (c *Config) Enabled() bool {
-	return c.HMACSecretKey != "" || c.RSAPublicKey != "" || c.ECDSAPublicKey != "" || c.JWKS.Endpoint != ""
+	return c.HMACSecretKey != "" || c.RSAPublicKey != "" || c.ECDSAPublicKey != "" || c.JWKS.Endpoint != "" || c.OIDC.Issuer != "" || c.APIKeys.File != "" || c.Cert.Enabled()
 }
 
 func (c *Config) Load(ctx context.Context) (*LoadedConfig, error) {
@@ -70,6 +97,17 @@ func (c *Config) Load(ctx context.Context) (*LoadedConfig, error) {
 		Audience:                  c.Audience,
 		Issuer:                    c.Issuer,
 		DisableDisconnectOnExpiry: c.DisableDisconnectOnExpiry,
+		Algorithms:                c.Algorithms,
+		Leeway:                    c.Leeway,
+	}
+
+	for _, alg := range c.Algorithms {
+		if alg == "none" || jwt.GetSigningMethod(alg) == nil {
+			return nil, fmt.Errorf("unsupported algorithm: %s", alg)
+		}
+	}
+	if c.Leeway < 0 {
+		return nil, fmt.Errorf("leeway cannot be negative")
 	}
 
 	if c.RSAPublicKey != "" {
@@ -106,9 +144,54 @@ func (c *Config) Load(ctx context.Context) (*LoadedConfig, error) {
 		}
 	}
 
+	if c.OIDC.Issuer != "" {
+		// Avoid accidental misconfiguration by not allowing OIDC to be set
+		// together with other verification keys.
+		if c.HMACSecretKey != "" || c.RSAPublicKey != "" || c.ECDSAPublicKey != "" || c.JWKS.Endpoint != "" {
+			return nil, fmt.Errorf("no other verification key can be set when OIDC.Issuer is set")
+		}
+		if c.Issuer != "" && c.Issuer != c.OIDC.Issuer {
+			return nil, fmt.Errorf("issuer must match OIDC.Issuer")
+		}
+
+		loadedJWKS, issuer, err := c.OIDC.Load(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("load OIDC configuration: %w", err)
+		}
+		config.JWKS = loadedJWKS
+		config.Issuer = issuer
+	}
+
+	if config.JWKS == nil {
+		// Each algorithm must be verifiable with one of the configured
+		// keys.
+		methods := config.keyMethods()
+		for _, alg := range c.Algorithms {
+			if !slices.Contains(methods, alg) {
+				return nil, fmt.Errorf("algorithm %s has no matching verification key", alg)
+			}
+		}
+	}
+
 	return &config, nil
 }
 
+// keyMethods returns the JWT methods supported by the configured HMAC, RSA
+// and ECDSA keys.
+func (c *LoadedConfig) keyMethods() []string {
+	var methods []string
+	if len(c.HMACSecretKey) > 0 {
+		methods = append(methods, hmacMethods...)
+	}
+	if c.RSAPublicKey != nil {
+		methods = append(methods, rsaMethods...)
+	}
+	if c.ECDSAPublicKey != nil {
+		methods = append(methods, ecdsaMethods...)
+	}
+	return methods
+}
+
 func (c *Config) RegisterFlags(fs *pflag.FlagSet, prefix string) {
 	prefix += ".auth."
 
@@ -163,5 +246,25 @@ Disables disconnecting the client when their token expires.
 Piko still verifies the token expiry when the client first connects.`,
 	)
 
+	fs.StringSliceVar(
+		&c.Algorithms,
+		prefix+"algorithms",
+		c.Algorithms,
+		`
+Permitted JWT signing algorithms, such as 'RS256'.
+
+If not given, all algorithms supported by the configured verification keys
+are permitted.`,
+	)
+	fs.DurationVar(
+		&c.Leeway,
+		prefix+"leeway",
+		c.Leeway,
+		`
+Allowed clock skew when validating the JWT 'exp', 'nbf' and 'iat' claims.`,
+	)
+
 	c.JWKS.RegisterFlags(fs, prefix)
+	c.OIDC.RegisterFlags(fs, prefix)
+	c.APIKeys.RegisterFlags(fs, prefix)
 }
@@ -71,4 +71,14 @@ RIQzNasYSoRQHQ/6S6Ps8tpMcT+KvIIC8W/e9k0W7Cm72M1P9jU7SLf/vg==
 
 		assert.NotNil(t, loaded.JWKS.KeyFunc)
 	})
+
+	t.Run("algorithm without key", func(t *testing.T) {
+		config := Config{
+			HMACSecretKey: "my-secret-key",
+			Algorithms:    []string{"HS256", "RS256"},
+		}
+
+		_, err := config.Load(t.Context())
+		assert.ErrorContains(t, err, "algorithm RS256 has no matching verification key")
+	})
 }
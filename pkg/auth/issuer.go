@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/spf13/pflag"
+)
+
+// IssuerConfig configures signing Piko JWTs issued by the server.
+//
+// The signing key is configured separately from the verification keys, so
+// the verification keys must also be configured to accept issued tokens.
+type IssuerConfig struct {
+	// HMACSecretKey is the secret key to sign tokens using HS256.
+	HMACSecretKey string `json:"hmac_secret_key" yaml:"hmac_secret_key"`
+
+	// RSAPrivateKey is the PEM encoded private key to sign tokens using
+	// RS256.
+	RSAPrivateKey string `json:"rsa_private_key" yaml:"rsa_private_key"`
+
+	// ECDSAPrivateKey is the PEM encoded private key to sign tokens using
+	// ECDSA, where the algorithm depends on the key curve.
+	ECDSAPrivateKey string `json:"ecdsa_private_key" yaml:"ecdsa_private_key"`
+
+	// Issuer is the 'iss' claim of issued tokens, or empty to omit.
+	Issuer string `json:"issuer" yaml:"issuer"`
+
+	// Audience is the 'aud' claim of issued tokens, or empty to omit.
+	Audience string `json:"audience" yaml:"audience"`
+
+	// MaxTTL is the maximum lifetime of issued tokens.
+	MaxTTL time.Duration `json:"max_ttl" yaml:"max_ttl"`
+}
+
+// Enabled returns whether a signing key is configured.
+func (c *IssuerConfig) Enabled() bool {
+	return c.HMACSecretKey != "" || c.RSAPrivateKey != "" || c.ECDSAPrivateKey != ""
+}
+
+func (c *IssuerConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	keys := 0
+	for _, key := range []string{c.HMACSecretKey, c.RSAPrivateKey, c.ECDSAPrivateKey} {
+		if key != "" {
+			keys++
+		}
+	}
+	if keys > 1 {
+		return fmt.Errorf("only one signing key can be set")
+	}
+	if c.MaxTTL <= 0 {
+		return fmt.Errorf("max ttl must be positive")
+	}
+	return nil
+}
+
+// Load parses the signing key and returns an issuer.
+func (c *IssuerConfig) Load() (*Issuer, error) {
+	issuer := &Issuer{
+		issuer:   c.Issuer,
+		audience: c.Audience,
+		maxTTL:   c.MaxTTL,
+	}
+
+	switch {
+	case c.HMACSecretKey != "":
+		issuer.method = jwt.SigningMethodHS256
+		issuer.key = []byte(c.HMACSecretKey)
+	case c.RSAPrivateKey != "":
+		key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(c.RSAPrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("parse rsa private key: %w", err)
+		}
+		issuer.method = jwt.SigningMethodRS256
+		issuer.key = key
+	case c.ECDSAPrivateKey != "":
+		key, err := jwt.ParseECPrivateKeyFromPEM([]byte(c.ECDSAPrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("parse ecdsa private key: %w", err)
+		}
+		method, err := ecdsaSigningMethod(key)
+		if err != nil {
+			return nil, err
+		}
+		issuer.method = method
+		issuer.key = key
+	default:
+		return nil, fmt.Errorf("missing signing key")
+	}
+
+	return issuer, nil
+}
+
+func (c *IssuerConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	prefix += ".token-issuer."
+
+	fs.StringVar(
+		&c.HMACSecretKey,
+		prefix+"hmac-secret-key",
+		c.HMACSecretKey,
+		`
+Secret key to sign issued tokens using HS256.
+
+Piko can issue short-lived tokens using the admin '/tokens' route. The
+signing key is configured separately from the verification keys, so the
+proxy and upstream verification keys must also accept issued tokens.`,
+	)
+	fs.StringVar(
+		&c.RSAPrivateKey,
+		prefix+"rsa-private-key",
+		c.RSAPrivateKey,
+		`
+PEM encoded private key to sign issued tokens using RS256.`,
+	)
+	fs.StringVar(
+		&c.ECDSAPrivateKey,
+		prefix+"ecdsa-private-key",
+		c.ECDSAPrivateKey,
+		`
+PEM encoded private key to sign issued tokens using ECDSA. The algorithm
+depends on the key curve, such as ES256 for a P-256 key.`,
+	)
+	fs.StringVar(
+		&c.Issuer,
+		prefix+"issuer",
+		c.Issuer,
+		`
+'iss' claim of issued tokens. If empty the claim is omitted.`,
+	)
+	fs.StringVar(
+		&c.Audience,
+		prefix+"audience",
+		c.Audience,
+		`
+'aud' claim of issued tokens. If empty the claim is omitted.`,
+	)
+	fs.DurationVar(
+		&c.MaxTTL,
+		prefix+"max-ttl",
+		c.MaxTTL,
+		`
+Maximum lifetime of issued tokens.`,
+	)
+}
+
+// Issuer signs Piko JWTs.
+type Issuer struct {
+	method jwt.SigningMethod
+	key    any
+
+	issuer   string
+	audience string
+	maxTTL   time.Duration
+}
+
+// MaxTTL returns the maximum lifetime of issued tokens.
+func (i *Issuer) MaxTTL() time.Duration {
+	return i.maxTTL
+}
+
+// Issue signs a token containing the given claims that expires at the given
+// time.
+//
+// The token is given a random 'jti' claim so it can be revoked.
+func (i *Issuer) Issue(
+	subject string,
+	claims PikoClaims,
+	expiry time.Time,
+) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("token id: %w", err)
+	}
+
+	now := time.Now()
+	jwtClaims := JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(id),
+			Subject:   subject,
+			Issuer:    i.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiry),
+		},
+		Piko: claims,
+	}
+	if i.audience != "" {
+		jwtClaims.Audience = jwt.ClaimStrings{i.audience}
+	}
+
+	token, err := jwt.NewWithClaims(i.method, jwtClaims).SignedString(i.key)
+	if err != nil {
+		return "", fmt.Errorf("sign: %w", err)
+	}
+	return token, nil
+}
+
+// CheckGrant returns an error if the token isn't permitted to issue a token
+// with the given claims and expiry.
+//
+// A token can only grant access to endpoints it can access itself, within
+// its own tenant, and can't issue tokens that outlive it.
+func (t *Token) CheckGrant(claims PikoClaims, expiry time.Time) error {
+	if t.TenantID != "" && claims.TenantID != t.TenantID {
+		return fmt.Errorf("tenant not permitted: %s", claims.TenantID)
+	}
+	if !t.Expiry.IsZero() && expiry.After(t.Expiry) {
+		return fmt.Errorf("expiry exceeds token expiry")
+	}
+
+	if t.unscoped() {
+		return nil
+	}
+	if len(claims.Endpoints) == 0 && len(claims.Listen) == 0 && len(claims.Connect) == 0 {
+		return fmt.Errorf("endpoints required")
+	}
+
+	// Requested patterns are matched as endpoint IDs against the tokens
+	// patterns. Since a requested '*' wildcard can only be matched by a '*'
+	// wildcard in the tokens pattern, a match means the requested pattern is
+	// a subset of the tokens pattern.
+	for _, pattern := range slices.Concat(claims.Endpoints, claims.Listen) {
+		pattern = strings.ReplaceAll(pattern, tenantPlaceholder, claims.TenantID)
+		if !t.ListenPermitted(pattern) {
+			return fmt.Errorf("listen endpoint not permitted: %s", pattern)
+		}
+	}
+	for _, pattern := range slices.Concat(claims.Endpoints, claims.Connect) {
+		pattern = strings.ReplaceAll(pattern, tenantPlaceholder, claims.TenantID)
+		if !t.ConnectPermitted(pattern) {
+			return fmt.Errorf("connect endpoint not permitted: %s", pattern)
+		}
+	}
+	return nil
+}
+
+func ecdsaSigningMethod(key *ecdsa.PrivateKey) (jwt.SigningMethod, error) {
+	switch key.Curve.Params().BitSize {
+	case 256:
+		return jwt.SigningMethodES256, nil
+	case 384:
+		return jwt.SigningMethodES384, nil
+	case 521:
+		return jwt.SigningMethodES512, nil
+	default:
+		return nil, fmt.Errorf("unsupported ecdsa curve: %s", key.Curve.Params().Name)
+	}
+}
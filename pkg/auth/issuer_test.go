@@ -0,0 +1,144 @@
+package auth
+
+import (
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssuer_Issue(t *testing.T) {
+	t.Run("hmac", func(t *testing.T) {
+		conf := IssuerConfig{
+			HMACSecretKey: "my-secret",
+			Issuer:        "my-issuer",
+			Audience:      "my-audience",
+			MaxTTL:        time.Hour,
+		}
+		require.NoError(t, conf.Validate())
+		issuer, err := conf.Load()
+		require.NoError(t, err)
+
+		expiry := time.Now().Add(time.Minute)
+		tokenString, err := issuer.Issue("my-subject", PikoClaims{
+			Endpoints: []string{"my-endpoint"},
+			Listen:    []string{"listen-endpoint"},
+			TenantID:  "my-tenant",
+		}, expiry)
+		require.NoError(t, err)
+
+		verifier := NewJWTVerifier(&LoadedConfig{
+			HMACSecretKey: []byte("my-secret"),
+			Issuer:        "my-issuer",
+			Audience:      "my-audience",
+		})
+		token, err := verifier.Verify(tokenString)
+		require.NoError(t, err)
+
+		assert.Equal(t, "my-subject", token.Subject)
+		assert.NotEmpty(t, token.ID)
+		assert.Equal(t, []string{"my-endpoint"}, token.Endpoints)
+		assert.Equal(t, []string{"listen-endpoint"}, token.ListenEndpoints)
+		assert.Equal(t, "my-tenant", token.TenantID)
+		assert.Equal(t, expiry.Unix(), token.Expiry.Unix())
+	})
+
+	t.Run("ecdsa", func(t *testing.T) {
+		privateKey, publicKey := generateTestECDSAKeys(elliptic.P384(), t)
+		der, err := x509.MarshalECPrivateKey(privateKey)
+		require.NoError(t, err)
+
+		conf := IssuerConfig{
+			ECDSAPrivateKey: string(pem.EncodeToMemory(&pem.Block{
+				Type: "EC PRIVATE KEY", Bytes: der,
+			})),
+			MaxTTL: time.Hour,
+		}
+		issuer, err := conf.Load()
+		require.NoError(t, err)
+
+		tokenString, err := issuer.Issue(
+			"", PikoClaims{}, time.Now().Add(time.Minute),
+		)
+		require.NoError(t, err)
+
+		verifier := NewJWTVerifier(&LoadedConfig{
+			ECDSAPublicKey: publicKey,
+			Algorithms:     []string{"ES384"},
+		})
+		_, err = verifier.Verify(tokenString)
+		require.NoError(t, err)
+	})
+
+	t.Run("multiple keys", func(t *testing.T) {
+		conf := IssuerConfig{
+			HMACSecretKey: "my-secret",
+			RSAPrivateKey: "my-key",
+			MaxTTL:        time.Hour,
+		}
+		assert.Error(t, conf.Validate())
+	})
+}
+
+func TestToken_CheckGrant(t *testing.T) {
+	expiry := time.Now().Add(time.Hour)
+
+	t.Run("unscoped", func(t *testing.T) {
+		token := &Token{}
+		assert.NoError(t, token.CheckGrant(PikoClaims{}, expiry))
+		assert.NoError(t, token.CheckGrant(PikoClaims{
+			Endpoints: []string{"*"},
+			TenantID:  "my-tenant",
+		}, expiry))
+	})
+
+	t.Run("endpoints", func(t *testing.T) {
+		token := &Token{
+			Endpoints:        []string{"acme-*"},
+			ConnectEndpoints: []string{"public"},
+		}
+
+		assert.NoError(t, token.CheckGrant(PikoClaims{
+			Endpoints: []string{"acme-1", "acme-prod-*"},
+			Connect:   []string{"public"},
+		}, expiry))
+
+		// Can't grant an unscoped token.
+		assert.Error(t, token.CheckGrant(PikoClaims{}, expiry))
+		// Can't widen a wildcard.
+		assert.Error(t, token.CheckGrant(PikoClaims{
+			Endpoints: []string{"acme*"},
+		}, expiry))
+		// Can't grant listen on a connect only endpoint.
+		assert.Error(t, token.CheckGrant(PikoClaims{
+			Listen: []string{"public"},
+		}, expiry))
+	})
+
+	t.Run("tenant", func(t *testing.T) {
+		token := &Token{
+			TenantID:  "my-tenant",
+			Endpoints: []string{"{tenant}-*"},
+		}
+
+		assert.NoError(t, token.CheckGrant(PikoClaims{
+			Endpoints: []string{"{tenant}-1"},
+			TenantID:  "my-tenant",
+		}, expiry))
+		assert.Error(t, token.CheckGrant(PikoClaims{
+			Endpoints: []string{"{tenant}-1"},
+			TenantID:  "other-tenant",
+		}, expiry))
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		token := &Token{Expiry: expiry}
+
+		assert.NoError(t, token.CheckGrant(PikoClaims{}, expiry))
+		assert.Error(t, token.CheckGrant(PikoClaims{}, expiry.Add(time.Second)))
+	})
+}
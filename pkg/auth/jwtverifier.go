@@ -5,13 +5,38 @@ import (
 	"crypto/rsa"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/andydunstall/piko/pkg/limit"
 )
 
 type PikoClaims struct {
+	// Endpoints contains the endpoints the client can both listen on and
+	// connect to.
+	//
+	// Each endpoint may be a pattern containing a '*' wildcard or a
+	// '{tenant}' placeholder, such as 'acme-*' or '{tenant}-*'.
 	Endpoints []string `json:"endpoints"`
+
+	// Listen contains the endpoints the client can listen on.
+	Listen []string `json:"listen"`
+
+	// Connect contains the endpoints the client can connect to.
+	Connect []string `json:"connect"`
+
+	// TenantID is the ID of the tenant the client belongs to.
+	//
+	// If the client doesn't specify a tenant with the 'x-piko-tenant-id'
+	// header, the tenant is selected from this claim. The token must still
+	// be signed by the tenants key.
+	TenantID string `json:"tenant_id"`
+
+	// Limits lowers the configured request limits for the endpoint when
+	// included in an upstream token. It can't raise the configured limits.
+	Limits limit.Config `json:"limits"`
 }
 
 type JWTClaims struct {
@@ -19,6 +44,12 @@ type JWTClaims struct {
 	Piko PikoClaims `json:"piko"`
 }
 
+var (
+	hmacMethods  = []string{"HS256", "HS384", "HS512"}
+	rsaMethods   = []string{"RS256", "RS384", "RS512"}
+	ecdsaMethods = []string{"ES256", "ES384", "ES512"}
+)
+
 // JWTVerifier verifies client JWT tokens.
 type JWTVerifier struct {
 	hmacSecretKey  []byte
@@ -28,6 +59,7 @@ type JWTVerifier struct {
 
 	audience string
 	issuer   string
+	leeway   time.Duration
 
 	disableDisconnectOnExpiry bool
 
@@ -40,23 +72,37 @@ func NewJWTVerifier(conf *LoadedConfig) *JWTVerifier {
 	v := &JWTVerifier{
 		audience:                  conf.Audience,
 		issuer:                    conf.Issuer,
+		leeway:                    conf.Leeway,
 		disableDisconnectOnExpiry: conf.DisableDisconnectOnExpiry,
 	}
 
 	if len(conf.HMACSecretKey) > 0 {
 		v.hmacSecretKey = conf.HMACSecretKey
-		v.methods = append(v.methods, []string{"HS256", "HS384", "HS512"}...)
 	}
 	if conf.RSAPublicKey != nil {
 		v.rsaPublicKey = conf.RSAPublicKey
-		v.methods = append(v.methods, []string{"RS256", "RS384", "RS512"}...)
 	}
 	if conf.ECDSAPublicKey != nil {
 		v.ecdsaPublicKey = conf.ECDSAPublicKey
-		v.methods = append(v.methods, []string{"ES256", "ES384", "ES512"}...)
 	}
+	v.methods = conf.keyMethods()
+
 	if conf.JWKS != nil {
 		v.keyFunc = conf.JWKS.KeyFunc
+		// The JWKS key function checks the algorithm matches the key, so
+		// the configured algorithms can be used as is.
+		v.methods = conf.Algorithms
+	} else if len(conf.Algorithms) > 0 {
+		// Narrow the methods supported by the configured keys to the
+		// configured algorithms. Algorithms without a matching key are
+		// never permitted.
+		var methods []string
+		for _, alg := range conf.Algorithms {
+			if slices.Contains(v.methods, alg) {
+				methods = append(methods, alg)
+			}
+		}
+		v.methods = methods
 	}
 
 	return v
@@ -65,6 +111,12 @@ func NewJWTVerifier(conf *LoadedConfig) *JWTVerifier {
 func (v *JWTVerifier) Verify(tokenString string) (*Token, error) {
 	claims := &JWTClaims{}
 
+	if v.keyFunc == nil && len(v.methods) == 0 {
+		// No configured key supports any permitted algorithm. Note
+		// jwt.WithValidMethods with no methods would permit any method.
+		return nil, ErrInvalidToken
+	}
+
 	opts := []jwt.ParserOption{
 		jwt.WithValidMethods(v.methods),
 	}
@@ -74,6 +126,9 @@ func (v *JWTVerifier) Verify(tokenString string) (*Token, error) {
 	if v.issuer != "" {
 		opts = append(opts, jwt.WithIssuer(v.issuer))
 	}
+	if v.leeway != 0 {
+		opts = append(opts, jwt.WithLeeway(v.leeway))
+	}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		claims,
@@ -83,24 +138,16 @@ func (v *JWTVerifier) Verify(tokenString string) (*Token, error) {
 				return v.keyFunc(token)
 			}
 
-			switch token.Method.Alg() {
-			case "HS256":
-				fallthrough
-			case "HS384":
-				fallthrough
-			case "HS512":
+			// Never return a nil key, since an algorithm whose key isn't
+			// configured must be rejected rather than verified against an
+			// empty key.
+			alg := token.Method.Alg()
+			switch {
+			case slices.Contains(hmacMethods, alg) && v.hmacSecretKey != nil:
 				return v.hmacSecretKey, nil
-			case "RS256":
-				fallthrough
-			case "RS384":
-				fallthrough
-			case "RS512":
+			case slices.Contains(rsaMethods, alg) && v.rsaPublicKey != nil:
 				return v.rsaPublicKey, nil
-			case "ES256":
-				fallthrough
-			case "ES384":
-				fallthrough
-			case "ES512":
+			case slices.Contains(ecdsaMethods, alg) && v.ecdsaPublicKey != nil:
 				return v.ecdsaPublicKey, nil
 			default:
 				return nil, fmt.Errorf("unsupported algorithm: %s", token.Method.Alg())
@@ -125,9 +172,24 @@ func (v *JWTVerifier) Verify(tokenString string) (*Token, error) {
 	if claims.ExpiresAt != nil && !v.disableDisconnectOnExpiry {
 		expiry = claims.ExpiresAt.Time
 	}
+
+	// The token has already been verified, so parse the raw claims without
+	// verifying again.
+	rawClaims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tokenString, rawClaims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
 	return &Token{
-		Expiry:    expiry,
-		Endpoints: claims.Piko.Endpoints,
+		Expiry:           expiry,
+		ID:               claims.ID,
+		Subject:          claims.Subject,
+		Endpoints:        claims.Piko.Endpoints,
+		ListenEndpoints:  claims.Piko.Listen,
+		ConnectEndpoints: claims.Piko.Connect,
+		Limits:           claims.Piko.Limits,
+		TenantID:         claims.Piko.TenantID,
+		Claims:           rawClaims,
 	}, nil
 }
 
@@ -4,8 +4,10 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"testing"
@@ -16,6 +18,8 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/pkg/limit"
 )
 
 func TestJWTVerifier_HS(t *testing.T) {
@@ -24,9 +28,16 @@ func TestJWTVerifier_HS(t *testing.T) {
 	endpointClaims := JWTClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			ID:        "my-id",
+			Subject:   "my-subject",
 		},
 		Piko: PikoClaims{
 			Endpoints: []string{"my-endpoint"},
+			Listen:    []string{"listen-endpoint"},
+			Connect:   []string{"connect-endpoint"},
+			Limits: limit.Config{
+				MaxRequestBodySize: 1024,
+			},
 		},
 	}
 
@@ -49,7 +60,12 @@ func TestJWTVerifier_HS(t *testing.T) {
 				assert.NoError(t, err)
 
 				assert.Equal(t, []string{"my-endpoint"}, parsedToken.Endpoints)
+				assert.Equal(t, []string{"listen-endpoint"}, parsedToken.ListenEndpoints)
+				assert.Equal(t, []string{"connect-endpoint"}, parsedToken.ConnectEndpoints)
 				assert.Equal(t, endpointClaims.ExpiresAt.Unix(), parsedToken.Expiry.Unix())
+				assert.Equal(t, int64(1024), parsedToken.Limits.MaxRequestBodySize)
+				assert.Equal(t, "my-id", parsedToken.ID)
+				assert.Equal(t, "my-subject", parsedToken.Subject)
 			})
 		}
 	})
@@ -117,6 +133,62 @@ func TestJWTVerifier_RS(t *testing.T) {
 	})
 }
 
+func TestJWTVerifier_Algorithms(t *testing.T) {
+	endpointClaims := JWTClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Piko: PikoClaims{
+			Endpoints: []string{"*"},
+		},
+	}
+
+	privateKey, publicKey := generateTestRSAKeys(t)
+
+	t.Run("restricted", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS384, endpointClaims)
+		tokenString, err := token.SignedString(privateKey)
+		assert.NoError(t, err)
+
+		verifier := NewJWTVerifier(&LoadedConfig{
+			RSAPublicKey: publicKey,
+			Algorithms:   []string{"RS256"},
+		})
+		_, err = verifier.Verify(tokenString)
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	// Tests a HMAC token signed with an empty key is rejected by a verifier
+	// with only an RSA key, even when HMAC algorithms are permitted.
+	t.Run("hmac token with rsa key", func(t *testing.T) {
+		tokenString := signEmptyHMAC(t, endpointClaims)
+
+		verifier := NewJWTVerifier(&LoadedConfig{
+			RSAPublicKey: publicKey,
+			Algorithms:   []string{"RS256", "HS256"},
+		})
+		_, err := verifier.Verify(tokenString)
+		assert.Equal(t, ErrInvalidToken, err)
+
+		verifier = NewJWTVerifier(&LoadedConfig{
+			RSAPublicKey: publicKey,
+		})
+		_, err = verifier.Verify(tokenString)
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("no matching key", func(t *testing.T) {
+		tokenString := signEmptyHMAC(t, endpointClaims)
+
+		verifier := NewJWTVerifier(&LoadedConfig{
+			RSAPublicKey: publicKey,
+			Algorithms:   []string{"HS256"},
+		})
+		_, err := verifier.Verify(tokenString)
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+}
+
 func TestJWTVerifier_EC(t *testing.T) {
 	endpointClaims := JWTClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
@@ -350,6 +422,16 @@ func TestJWTVerifier_DisableDisconnectOnExpiry(t *testing.T) {
 	assert.True(t, parsedToken.Expiry.IsZero())
 }
 
+// signEmptyHMAC signs the claims with HS256 and an empty key.
+func signEmptyHMAC(t *testing.T, claims jwt.Claims) string {
+	signingString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SigningString()
+	require.NoError(t, err)
+
+	mac := hmac.New(sha256.New, nil)
+	mac.Write([]byte(signingString))
+	return signingString + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
 func generateTestHSKey(t *testing.T) []byte {
 	b := make([]byte, 10)
 	_, err := rand.Read(b)
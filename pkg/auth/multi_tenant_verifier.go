@@ -1,34 +1,110 @@
 package auth
 
-type MultiTenantVerifier struct {
+import (
+	"crypto/x509"
+	"sync/atomic"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type multiTenantOptions struct {
+	revocations *RevocationList
+}
+
+type revocationsOption struct {
+	Revocations *RevocationList
+}
+
+func (o revocationsOption) apply(opts *multiTenantOptions) {
+	opts.revocations = o.Revocations
+}
+
+// WithRevocations configures a list of revoked tokens. Revoked tokens are
+// rejected with ErrRevokedToken. Defaults to no revocations.
+func WithRevocations(revocations *RevocationList) MultiTenantOption {
+	return revocationsOption{Revocations: revocations}
+}
+
+type MultiTenantOption interface {
+	apply(*multiTenantOptions)
+}
+
+// verifiers contains the verifiers for the default tenant and each
+// configured tenant.
+type verifiers struct {
 	defaultVerifier Verifier
 	tenantVerifiers map[string]Verifier
 }
 
+type MultiTenantVerifier struct {
+	// verifiers contains the current verifiers, which may be replaced when
+	// the configuration is reloaded.
+	verifiers atomic.Pointer[verifiers]
+
+	revocations *RevocationList
+}
+
 func NewMultiTenantVerifier(
 	defaultVerifier Verifier,
 	tenantVerifiers map[string]Verifier,
+	opts ...MultiTenantOption,
 ) *MultiTenantVerifier {
-	return &MultiTenantVerifier{
+	options := multiTenantOptions{}
+	for _, o := range opts {
+		o.apply(&options)
+	}
+
+	v := &MultiTenantVerifier{
+		revocations: options.revocations,
+	}
+	v.Update(defaultVerifier, tenantVerifiers)
+	return v
+}
+
+// Update replaces the verifiers for the default tenant and each tenant, such
+// as when rotating keys. Requests already verified aren't affected.
+func (v *MultiTenantVerifier) Update(
+	defaultVerifier Verifier,
+	tenantVerifiers map[string]Verifier,
+) {
+	v.verifiers.Store(&verifiers{
 		defaultVerifier: defaultVerifier,
 		tenantVerifiers: tenantVerifiers,
-	}
+	})
 }
 
+// Verify verifies the token for the given tenant.
+//
+// If the tenant ID is empty and tenants are configured, the tenant is
+// selected using the tokens 'tenant_id' claim.
 func (v *MultiTenantVerifier) Verify(token string, tenantID string) (*Token, error) {
+	verifiers := v.verifiers.Load()
+
+	if tenantID == "" && len(verifiers.tenantVerifiers) != 0 {
+		tenantID = tenantFromClaims(token)
+	}
+
 	if tenantID == "" {
-		if len(v.tenantVerifiers) != 0 {
+		if len(verifiers.tenantVerifiers) != 0 {
 			// If tenants are configured, the default tenant is disabled.
 			return nil, ErrUnknownTenant
 		}
-		return v.defaultVerifier.Verify(token)
+		t, err := verifiers.defaultVerifier.Verify(token)
+		if err != nil {
+			return nil, err
+		}
+		if v.revoked(t) {
+			return nil, ErrRevokedToken
+		}
+		t.compile()
+		return t, nil
 	}
 
-	if v.tenantVerifiers == nil {
+	if verifiers.tenantVerifiers == nil {
 		return nil, ErrUnknownTenant
 	}
 
-	verifier, ok := v.tenantVerifiers[tenantID]
+	verifier, ok := verifiers.tenantVerifiers[tenantID]
 	if !ok {
 		return nil, ErrUnknownTenant
 	}
@@ -37,6 +113,109 @@ func (v *MultiTenantVerifier) Verify(token string, tenantID string) (*Token, err
 	if err != nil {
 		return nil, err
 	}
+	// Tokens that belong to a tenant, such as API keys, can only be used by
+	// that tenant.
+	if t.TenantID != "" && t.TenantID != tenantID {
+		return nil, ErrInvalidToken
+	}
 	t.TenantID = tenantID
+	// Revocations are scoped to the tenant, so must check once the tenant
+	// ID is known.
+	if v.revoked(t) {
+		return nil, ErrRevokedToken
+	}
+	// Compile the endpoint patterns once the tenant ID is known.
+	t.compile()
+	return t, nil
+}
+
+// VerifyCertificate returns the token for a verified TLS client certificate.
+//
+// Returns ErrInvalidToken if the tenants verifier doesn't support client
+// certificates or no rule matches the certificate.
+func (v *MultiTenantVerifier) VerifyCertificate(
+	cert *x509.Certificate,
+	tenantID string,
+) (*Token, error) {
+	verifiers := v.verifiers.Load()
+
+	var verifier Verifier
+	if tenantID == "" {
+		if len(verifiers.tenantVerifiers) != 0 {
+			// If tenants are configured, the default tenant is disabled.
+			return nil, ErrUnknownTenant
+		}
+		verifier = verifiers.defaultVerifier
+	} else {
+		var ok bool
+		verifier, ok = verifiers.tenantVerifiers[tenantID]
+		if !ok {
+			return nil, ErrUnknownTenant
+		}
+	}
+
+	certVerifier, ok := verifier.(CertificateVerifier)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	t, err := certVerifier.VerifyCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+	if tenantID != "" {
+		if t.TenantID != "" && t.TenantID != tenantID {
+			return nil, ErrInvalidToken
+		}
+		t.TenantID = tenantID
+	}
+	if v.revoked(t) {
+		return nil, ErrRevokedToken
+	}
+	t.compile()
 	return t, nil
 }
+
+// CertificatesEnabled returns whether any of the verifiers support TLS
+// client certificates.
+func (v *MultiTenantVerifier) CertificatesEnabled() bool {
+	verifiers := v.verifiers.Load()
+
+	if _, ok := verifiers.defaultVerifier.(CertificateVerifier); ok {
+		return true
+	}
+	for _, verifier := range verifiers.tenantVerifiers {
+		if _, ok := verifier.(CertificateVerifier); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// NotifyRevoked calls f when the token is revoked, such as to close sessions
+// authenticated with the token.
+//
+// Returns a function to stop watching the token. If revocations aren't
+// configured, f is never called.
+func (v *MultiTenantVerifier) NotifyRevoked(token *Token, f func()) func() {
+	if v.revocations == nil {
+		return func() {}
+	}
+	return v.revocations.Notify(token, f)
+}
+
+// tenantFromClaims returns the tenant ID claim from an unverified JWT, or an
+// empty string if the token isn't a JWT or has no tenant.
+//
+// The claim is only used to select the tenants verifier, which then verifies
+// the token, so it's safe to parse without verifying.
+func tenantFromClaims(token string) string {
+	var claims JWTClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err != nil {
+		return ""
+	}
+	return claims.Piko.TenantID
+}
+
+func (v *MultiTenantVerifier) revoked(token *Token) bool {
+	return v.revocations != nil && v.revocations.Revoked(token)
+}
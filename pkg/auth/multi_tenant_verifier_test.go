@@ -1,6 +1,8 @@
 package auth
 
 import (
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"testing"
 	"time"
 
@@ -52,6 +54,140 @@ func TestMultiTenantVerifier(t *testing.T) {
 		assert.Equal(t, parsedToken.TenantID, "tenant-1")
 	})
 
+	t.Run("tenant endpoint pattern", func(t *testing.T) {
+		claims := endpointClaims
+		claims.Piko = PikoClaims{
+			Endpoints: []string{"{tenant}-*"},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte(tenant1SecretKey))
+		assert.NoError(t, err)
+
+		parsedToken, err := verifier.Verify(tokenString, "tenant-1")
+		assert.NoError(t, err)
+
+		assert.True(t, parsedToken.ConnectPermitted("tenant-1-api"))
+		assert.False(t, parsedToken.ConnectPermitted("tenant-2-api"))
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		revocations := NewRevocationList()
+		verifier := NewMultiTenantVerifier(
+			verifierFunc(func(string) (*Token, error) {
+				return &Token{ID: "my-id"}, nil
+			}),
+			nil,
+			WithRevocations(revocations),
+		)
+
+		token, err := verifier.Verify("123", "")
+		assert.NoError(t, err)
+
+		var notified bool
+		verifier.NotifyRevoked(token, func() {
+			notified = true
+		})
+
+		revocations.Revoke(Revocation{
+			Type:   RevocationTypeID,
+			Value:  "my-id",
+			Expiry: time.Now().Add(time.Hour),
+		})
+		assert.True(t, notified)
+
+		_, err = verifier.Verify("123", "")
+		assert.Equal(t, ErrRevokedToken, err)
+	})
+
+	t.Run("revoked certificate", func(t *testing.T) {
+		revocations := NewRevocationList()
+		verifier := NewMultiTenantVerifier(
+			NewCertVerifier(&CertConfig{
+				Rules: []CertRule{
+					{CommonName: "agent-*"},
+				},
+			}),
+			nil,
+			WithRevocations(revocations),
+		)
+
+		cert := &x509.Certificate{
+			Subject: pkix.Name{CommonName: "agent-1"},
+		}
+		token, err := verifier.VerifyCertificate(cert, "")
+		assert.NoError(t, err)
+		assert.Equal(t, "agent-1", token.Subject)
+
+		revocations.Revoke(Revocation{
+			Type:   RevocationTypeSubject,
+			Value:  "agent-1",
+			Expiry: time.Now().Add(time.Hour),
+		})
+
+		_, err = verifier.VerifyCertificate(cert, "")
+		assert.Equal(t, ErrRevokedToken, err)
+	})
+
+	t.Run("revoked tenant", func(t *testing.T) {
+		revocations := NewRevocationList()
+		tenantVerifier := verifierFunc(func(string) (*Token, error) {
+			return &Token{ID: "my-id"}, nil
+		})
+		verifier := NewMultiTenantVerifier(nil, map[string]Verifier{
+			"tenant-1": tenantVerifier,
+			"tenant-2": tenantVerifier,
+		}, WithRevocations(revocations))
+
+		revocations.Revoke(Revocation{
+			TenantID: "tenant-1",
+			Type:     RevocationTypeID,
+			Value:    "my-id",
+			Expiry:   time.Now().Add(time.Hour),
+		})
+
+		_, err := verifier.Verify("123", "tenant-1")
+		assert.Equal(t, ErrRevokedToken, err)
+
+		// Other tenants tokens with the same ID aren't revoked.
+		_, err = verifier.Verify("123", "tenant-2")
+		assert.NoError(t, err)
+	})
+
+	t.Run("tenant claim", func(t *testing.T) {
+		claims := endpointClaims
+		claims.Piko = PikoClaims{
+			TenantID: "tenant-2",
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		tokenString, err := token.SignedString([]byte(tenant2SecretKey))
+		assert.NoError(t, err)
+
+		parsedToken, err := verifier.Verify(tokenString, "")
+		assert.NoError(t, err)
+		assert.Equal(t, "tenant-2", parsedToken.TenantID)
+
+		// The token must be signed by the claimed tenants key.
+		tokenString, err = token.SignedString([]byte(tenant1SecretKey))
+		assert.NoError(t, err)
+		_, err = verifier.Verify(tokenString, "")
+		assert.Equal(t, ErrInvalidToken, err)
+
+		// The claim must match the tenant header.
+		_, err = verifier.Verify(tokenString, "tenant-1")
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	// Tests a token that belongs to tenant 2 being used by tenant 1.
+	t.Run("token tenant mismatch", func(t *testing.T) {
+		verifier := NewMultiTenantVerifier(nil, map[string]Verifier{
+			"tenant-1": verifierFunc(func(string) (*Token, error) {
+				return &Token{TenantID: "tenant-2"}, nil
+			}),
+		})
+		_, err := verifier.Verify("123", "tenant-1")
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
 	// Tests tenant 1 using tenants 2 key.
 	t.Run("incorrect key", func(t *testing.T) {
 		token := jwt.NewWithClaims(jwt.SigningMethodHS256, endpointClaims)
@@ -70,4 +206,41 @@ func TestMultiTenantVerifier(t *testing.T) {
 		_, err = verifier.Verify(tokenString, "unknown")
 		assert.Equal(t, ErrUnknownTenant, err)
 	})
+
+	t.Run("update", func(t *testing.T) {
+		verifier := NewMultiTenantVerifier(nil, tenantVerifiers)
+
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, endpointClaims)
+		tokenString, err := token.SignedString([]byte(tenant1SecretKey))
+		assert.NoError(t, err)
+
+		_, err = verifier.Verify(tokenString, "tenant-1")
+		assert.NoError(t, err)
+
+		// Rotate tenant 1's key.
+		rotatedSecretKey := generateTestHSKey(t)
+		verifier.Update(nil, map[string]Verifier{
+			"tenant-1": NewJWTVerifier(&LoadedConfig{
+				HMACSecretKey: rotatedSecretKey,
+			}),
+		})
+
+		_, err = verifier.Verify(tokenString, "tenant-1")
+		assert.Equal(t, ErrInvalidToken, err)
+
+		tokenString, err = token.SignedString([]byte(rotatedSecretKey))
+		assert.NoError(t, err)
+		_, err = verifier.Verify(tokenString, "tenant-1")
+		assert.NoError(t, err)
+
+		// Tenant 2 was removed.
+		_, err = verifier.Verify(tokenString, "tenant-2")
+		assert.Equal(t, ErrUnknownTenant, err)
+	})
+}
+
+type verifierFunc func(token string) (*Token, error)
+
+func (f verifierFunc) Verify(token string) (*Token, error) {
+	return f(token)
 }
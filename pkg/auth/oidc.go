@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	// defaultOIDCTimeout is the timeout for loading the OIDC discovery
+	// document if no timeout is configured.
+	defaultOIDCTimeout = time.Second * 10
+)
+
+type OIDCConfig struct {
+	// Issuer is the OIDC issuer URL.
+	//
+	// The JWKS is loaded from the 'jwks_uri' in the issuers discovery
+	// document, and JWTs must have an 'iss' claim matching the issuer.
+	Issuer string `json:"issuer" yaml:"issuer"`
+
+	// How long to cache the JWKS for before reloading.
+	CacheTTL time.Duration `json:"cache_ttl" yaml:"cache_ttl"`
+
+	// Timeout for loading the discovery document and JWKS.
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+}
+
+// oidcDiscovery contains the fields used from an OIDC discovery document.
+type oidcDiscovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// Load fetches the issuers discovery document and loads the JWKS.
+//
+// Returns the loaded JWKS and the issuer to validate.
+func (c *OIDCConfig) Load(ctx context.Context) (*LoadedJWKS, string, error) {
+	discovery, err := c.discover(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("discovery: %w", err)
+	}
+
+	jwksConfig := JWKSConfig{
+		Endpoint: discovery.JWKSURI,
+		CacheTTL: c.CacheTTL,
+		Timeout:  c.Timeout,
+	}
+	loadedJWKS, err := jwksConfig.loadRemote(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return loadedJWKS, discovery.Issuer, nil
+}
+
+func (c *OIDCConfig) discover(ctx context.Context) (*oidcDiscovery, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultOIDCTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	discoveryURL := strings.TrimSuffix(c.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request: bad status: %d", resp.StatusCode)
+	}
+
+	var discovery oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+
+	// The discovery document issuer must exactly match the configured
+	// issuer.
+	if discovery.Issuer != c.Issuer {
+		return nil, fmt.Errorf(
+			"issuer mismatch: %s != %s", discovery.Issuer, c.Issuer,
+		)
+	}
+	if discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("missing jwks_uri")
+	}
+	u, err := url.Parse(discovery.JWKSURI)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid jwks_uri: %s", discovery.JWKSURI)
+	}
+
+	return &discovery, nil
+}
+
+func (c *OIDCConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	prefix += "oidc."
+
+	fs.StringVar(
+		&c.Issuer,
+		prefix+"issuer",
+		c.Issuer,
+		`
+OIDC issuer URL to verify JWTs.
+
+Piko loads the issuers discovery document from
+'<issuer>/.well-known/openid-configuration', then verifies JWTs using the
+JWKS from the documents 'jwks_uri'. JWTs must have an 'iss' claim matching
+the issuer.`,
+	)
+	fs.DurationVar(
+		&c.CacheTTL,
+		prefix+"cache-ttl",
+		c.CacheTTL,
+		`
+Frequency to refresh the JWK Set from the issuer.`,
+	)
+	fs.DurationVar(
+		&c.Timeout,
+		prefix+"timeout",
+		c.Timeout,
+		`
+Timeout for loading the discovery document and JWK Set.`,
+	)
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// oidcServer is a local OIDC provider serving a discovery document and
+// JWKS.
+type oidcServer struct {
+	*httptest.Server
+
+	privateKey *rsa.PrivateKey
+	kid        string
+	// issuer is the issuer returned in the discovery document. Defaults to
+	// the server URL.
+	issuer string
+}
+
+func newOIDCServer(t *testing.T) *oidcServer {
+	privateKey, _ := generateTestRSAKeys(t)
+
+	jwk := jose.JSONWebKey{
+		Key:       privateKey,
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}
+	thumb, err := jwk.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	jwk.KeyID = base64.RawURLEncoding.EncodeToString(thumb)
+
+	jwks, err := json.Marshal(jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{jwk.Public()},
+	})
+	require.NoError(t, err)
+
+	s := &oidcServer{
+		privateKey: privateKey,
+		kid:        jwk.KeyID,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(
+		"/.well-known/openid-configuration",
+		func(w http.ResponseWriter, _ *http.Request) {
+			issuer := s.issuer
+			if issuer == "" {
+				issuer = s.URL
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"issuer":   issuer,
+				"jwks_uri": s.URL + "/jwks.json",
+			})
+		},
+	)
+	mux.HandleFunc("/jwks.json", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(jwks)
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *oidcServer) Sign(t *testing.T, claims JWTClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.kid
+	tokenString, err := token.SignedString(s.privateKey)
+	require.NoError(t, err)
+	return tokenString
+}
+
+func TestOIDC(t *testing.T) {
+	server := newOIDCServer(t)
+
+	t.Run("ok", func(t *testing.T) {
+		conf := Config{
+			OIDC: OIDCConfig{
+				Issuer:   server.URL,
+				CacheTTL: time.Minute,
+			},
+		}
+		assert.True(t, conf.Enabled())
+
+		loaded, err := conf.Load(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, server.URL, loaded.Issuer)
+
+		verifier := NewJWTVerifier(loaded)
+
+		tokenString := server.Sign(t, JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    server.URL,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			},
+			Piko: PikoClaims{
+				Endpoints: []string{"my-endpoint"},
+			},
+		})
+		token, err := verifier.Verify(tokenString)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"my-endpoint"}, token.Endpoints)
+
+		// Tokens from a different issuer are rejected.
+		tokenString = server.Sign(t, JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer: "https://other.example.com",
+			},
+		})
+		_, err = verifier.Verify(tokenString)
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("issuer mismatch", func(t *testing.T) {
+		server := newOIDCServer(t)
+		server.issuer = "https://other.example.com"
+
+		conf := Config{
+			OIDC: OIDCConfig{
+				Issuer: server.URL,
+			},
+		}
+		_, err := conf.Load(t.Context())
+		assert.ErrorContains(t, err, "issuer mismatch")
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		conf := Config{
+			OIDC: OIDCConfig{
+				Issuer: server.URL + "/unknown",
+			},
+		}
+		_, err := conf.Load(t.Context())
+		assert.ErrorContains(t, err, "bad status: 404")
+	})
+
+	t.Run("other keys", func(t *testing.T) {
+		conf := Config{
+			HMACSecretKey: "my-secret-key",
+			OIDC: OIDCConfig{
+				Issuer: server.URL,
+			},
+		}
+		_, err := conf.Load(t.Context())
+		assert.Error(t, err)
+	})
+}
+
+func TestConfig_AlgorithmsAndLeeway(t *testing.T) {
+	secretKey := generateTestHSKey(t)
+
+	t.Run("algorithms", func(t *testing.T) {
+		conf := Config{
+			HMACSecretKey: string(secretKey),
+			Algorithms:    []string{"HS512"},
+		}
+		loaded, err := conf.Load(t.Context())
+		require.NoError(t, err)
+		verifier := NewJWTVerifier(loaded)
+
+		token := jwt.NewWithClaims(jwt.SigningMethodHS512, JWTClaims{})
+		tokenString, err := token.SignedString(secretKey)
+		require.NoError(t, err)
+		_, err = verifier.Verify(tokenString)
+		assert.NoError(t, err)
+
+		token = jwt.NewWithClaims(jwt.SigningMethodHS256, JWTClaims{})
+		tokenString, err = token.SignedString(secretKey)
+		require.NoError(t, err)
+		_, err = verifier.Verify(tokenString)
+		assert.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("unsupported algorithm", func(t *testing.T) {
+		conf := Config{
+			HMACSecretKey: string(secretKey),
+			Algorithms:    []string{"none"},
+		}
+		_, err := conf.Load(t.Context())
+		assert.ErrorContains(t, err, "unsupported algorithm: none")
+	})
+
+	t.Run("leeway", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, JWTClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Second * 5)),
+			},
+		})
+		tokenString, err := token.SignedString(secretKey)
+		require.NoError(t, err)
+
+		conf := Config{
+			HMACSecretKey: string(secretKey),
+		}
+		loaded, err := conf.Load(t.Context())
+		require.NoError(t, err)
+		_, err = NewJWTVerifier(loaded).Verify(tokenString)
+		assert.Equal(t, ErrExpiredToken, err)
+
+		conf.Leeway = time.Minute
+		loaded, err = conf.Load(t.Context())
+		require.NoError(t, err)
+		_, err = NewJWTVerifier(loaded).Verify(tokenString)
+		assert.NoError(t, err)
+	})
+}
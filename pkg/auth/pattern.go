@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"regexp"
+	"strings"
+)
+
+// tenantPlaceholder is replaced by the tokens tenant ID in endpoint
+// patterns.
+const tenantPlaceholder = "{tenant}"
+
+// endpointMatcher matches endpoint IDs against a set of endpoint patterns.
+//
+// Patterns may contain a '*' wildcard, which matches any sequence of
+// characters, and a '{tenant}' placeholder, which is replaced by the tokens
+// tenant ID. Patterns containing '{tenant}' never match if the token doesn't
+// have a tenant.
+type endpointMatcher struct {
+	exact    map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+func newEndpointMatcher(patterns []string, tenantID string) *endpointMatcher {
+	m := &endpointMatcher{
+		exact: make(map[string]struct{}),
+	}
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, tenantPlaceholder) {
+			if tenantID == "" {
+				continue
+			}
+			pattern = strings.ReplaceAll(pattern, tenantPlaceholder, tenantID)
+		}
+
+		if !strings.Contains(pattern, "*") {
+			m.exact[pattern] = struct{}{}
+			continue
+		}
+
+		m.patterns = append(m.patterns, globRegexp(pattern))
+	}
+	return m
+}
+
+func (m *endpointMatcher) Match(endpointID string) bool {
+	if _, ok := m.exact[endpointID]; ok {
+		return true
+	}
+	for _, pattern := range m.patterns {
+		if pattern.MatchString(endpointID) {
+			return true
+		}
+	}
+	return false
+}
+
+// globRegexp compiles a pattern containing '*' wildcards, which match any
+// sequence of characters, to a regular expression matching the whole
+// string.
+func globRegexp(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// RevocationTypeID revokes tokens by their 'jti' claim.
+	RevocationTypeID = "jti"
+	// RevocationTypeSubject revokes tokens by their 'sub' claim.
+	RevocationTypeSubject = "sub"
+)
+
+// Revocation revokes all tokens with the given ID or subject.
+//
+// Revocations only apply to tokens for the given tenant, since token IDs and
+// subjects are only unique within a tenant.
+type Revocation struct {
+	// TenantID is the tenant of the revoked tokens, or empty for the default
+	// tenant.
+	TenantID string `json:"tenant_id,omitempty"`
+
+	// Type is the claim to match, either 'jti' or 'sub'.
+	Type string `json:"type"`
+
+	// Value is the token ID or subject to revoke.
+	Value string `json:"value"`
+
+	// Expiry is the time the revocation expires. This should match the
+	// expiry of the revoked tokens, after which the tokens are rejected
+	// anyway.
+	Expiry time.Time `json:"expiry"`
+}
+
+func (r *Revocation) Validate() error {
+	if r.Type != RevocationTypeID && r.Type != RevocationTypeSubject {
+		return fmt.Errorf("unsupported type: %s", r.Type)
+	}
+	if r.Value == "" {
+		return fmt.Errorf("missing value")
+	}
+	if r.Expiry.IsZero() {
+		return fmt.Errorf("missing expiry")
+	}
+	return nil
+}
+
+func (r *Revocation) Expired(now time.Time) bool {
+	return !r.Expiry.After(now)
+}
+
+type revocationKey struct {
+	TenantID string
+	Type     string
+	Value    string
+}
+
+type revocationWatcher struct {
+	token *Token
+	f     func()
+}
+
+// RevocationList is a denylist of revoked token IDs and subjects.
+//
+// Sessions authenticated with a token can register to be notified when the
+// token is revoked, so they can be closed.
+type RevocationList struct {
+	revocations map[revocationKey]time.Time
+
+	watchers map[*revocationWatcher]struct{}
+
+	onRevoke []func(r Revocation)
+	onExpire []func(r Revocation)
+
+	// mu protects the above fields.
+	mu sync.Mutex
+}
+
+func NewRevocationList() *RevocationList {
+	return &RevocationList{
+		revocations: make(map[revocationKey]time.Time),
+		watchers:    make(map[*revocationWatcher]struct{}),
+	}
+}
+
+// Revoke adds the revocation to the list and notifies any sessions
+// authenticated with a matching token.
+//
+// Returns false if the revocation was already known or has expired.
+func (l *RevocationList) Revoke(r Revocation) bool {
+	if r.Expired(time.Now()) {
+		return false
+	}
+
+	key := revocationKey{TenantID: r.TenantID, Type: r.Type, Value: r.Value}
+
+	l.mu.Lock()
+
+	if expiry, ok := l.revocations[key]; ok && !expiry.Before(r.Expiry) {
+		l.mu.Unlock()
+		return false
+	}
+	l.revocations[key] = r.Expiry
+
+	var notify []func()
+	for w := range l.watchers {
+		if r.Matches(w.token) {
+			notify = append(notify, w.f)
+			delete(l.watchers, w)
+		}
+	}
+	onRevoke := l.onRevoke
+
+	l.mu.Unlock()
+
+	for _, f := range notify {
+		f()
+	}
+	for _, f := range onRevoke {
+		f(r)
+	}
+
+	return true
+}
+
+// Revoked returns whether the token has been revoked.
+func (l *RevocationList) Revoked(token *Token) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.revokedLocked(token, time.Now())
+}
+
+// Notify calls f when the token is revoked. If the token is already revoked,
+// f is called immediately.
+//
+// Returns a function to stop watching the token.
+func (l *RevocationList) Notify(token *Token, f func()) func() {
+	l.mu.Lock()
+
+	if l.revokedLocked(token, time.Now()) {
+		l.mu.Unlock()
+		f()
+		return func() {}
+	}
+
+	w := &revocationWatcher{token: token, f: f}
+	l.watchers[w] = struct{}{}
+
+	l.mu.Unlock()
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		delete(l.watchers, w)
+	}
+}
+
+// List returns the active revocations, ordered by tenant, type and value.
+func (l *RevocationList) List() []Revocation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	revocations := make([]Revocation, 0, len(l.revocations))
+	for key, expiry := range l.revocations {
+		r := Revocation{
+			TenantID: key.TenantID,
+			Type:     key.Type,
+			Value:    key.Value,
+			Expiry:   expiry,
+		}
+		if r.Expired(now) {
+			continue
+		}
+		revocations = append(revocations, r)
+	}
+	sort.Slice(revocations, func(i, j int) bool {
+		if revocations[i].TenantID != revocations[j].TenantID {
+			return revocations[i].TenantID < revocations[j].TenantID
+		}
+		if revocations[i].Type != revocations[j].Type {
+			return revocations[i].Type < revocations[j].Type
+		}
+		return revocations[i].Value < revocations[j].Value
+	})
+	return revocations
+}
+
+// OnRevoke registers a callback that is called whenever a new revocation is
+// added.
+func (l *RevocationList) OnRevoke(f func(r Revocation)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.onRevoke = append(l.onRevoke, f)
+}
+
+// OnExpire registers a callback that is called whenever a revocation expires
+// and is removed from the list.
+func (l *RevocationList) OnExpire(f func(r Revocation)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.onExpire = append(l.onExpire, f)
+}
+
+// Prune removes revocations that have expired at the given time.
+func (l *RevocationList) Prune(now time.Time) {
+	l.mu.Lock()
+
+	var expired []Revocation
+	for key, expiry := range l.revocations {
+		r := Revocation{
+			TenantID: key.TenantID,
+			Type:     key.Type,
+			Value:    key.Value,
+			Expiry:   expiry,
+		}
+		if r.Expired(now) {
+			expired = append(expired, r)
+			delete(l.revocations, key)
+		}
+	}
+	onExpire := l.onExpire
+
+	l.mu.Unlock()
+
+	for _, r := range expired {
+		for _, f := range onExpire {
+			f(r)
+		}
+	}
+}
+
+// Run prunes expired revocations at the given interval until the context is
+// cancelled.
+func (l *RevocationList) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.Prune(time.Now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Matches returns whether the revocation applies to the given token.
+func (r *Revocation) Matches(token *Token) bool {
+	if token.TenantID != r.TenantID {
+		return false
+	}
+	switch r.Type {
+	case RevocationTypeID:
+		return token.ID != "" && token.ID == r.Value
+	case RevocationTypeSubject:
+		return token.Subject != "" && token.Subject == r.Value
+	default:
+		return false
+	}
+}
+
+func (l *RevocationList) revokedLocked(token *Token, now time.Time) bool {
+	if token.ID != "" {
+		expiry, ok := l.revocations[revocationKey{
+			TenantID: token.TenantID, Type: RevocationTypeID, Value: token.ID,
+		}]
+		if ok && expiry.After(now) {
+			return true
+		}
+	}
+	if token.Subject != "" {
+		expiry, ok := l.revocations[revocationKey{
+			TenantID: token.TenantID,
+			Type:     RevocationTypeSubject,
+			Value:    token.Subject,
+		}]
+		if ok && expiry.After(now) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevocationList(t *testing.T) {
+	t.Run("revoke id", func(t *testing.T) {
+		list := NewRevocationList()
+
+		token := &Token{ID: "my-id", Subject: "my-subject"}
+		assert.False(t, list.Revoked(token))
+
+		assert.True(t, list.Revoke(Revocation{
+			Type:   RevocationTypeID,
+			Value:  "my-id",
+			Expiry: time.Now().Add(time.Hour),
+		}))
+		assert.True(t, list.Revoked(token))
+		assert.False(t, list.Revoked(&Token{ID: "other-id"}))
+		assert.False(t, list.Revoked(&Token{}))
+
+		// Revoking again is ignored.
+		assert.False(t, list.Revoke(Revocation{
+			Type:   RevocationTypeID,
+			Value:  "my-id",
+			Expiry: time.Now().Add(time.Minute),
+		}))
+	})
+
+	t.Run("revoke subject", func(t *testing.T) {
+		list := NewRevocationList()
+
+		assert.True(t, list.Revoke(Revocation{
+			Type:   RevocationTypeSubject,
+			Value:  "my-subject",
+			Expiry: time.Now().Add(time.Hour),
+		}))
+		assert.True(t, list.Revoked(&Token{ID: "1", Subject: "my-subject"}))
+		assert.True(t, list.Revoked(&Token{ID: "2", Subject: "my-subject"}))
+		// The subject isn't matched against the token ID.
+		assert.False(t, list.Revoked(&Token{ID: "my-subject"}))
+	})
+
+	t.Run("revoke tenant", func(t *testing.T) {
+		list := NewRevocationList()
+
+		assert.True(t, list.Revoke(Revocation{
+			TenantID: "my-tenant",
+			Type:     RevocationTypeID,
+			Value:    "my-id",
+			Expiry:   time.Now().Add(time.Hour),
+		}))
+		assert.True(t, list.Revoked(&Token{TenantID: "my-tenant", ID: "my-id"}))
+		// Revocations only apply to tokens for the same tenant.
+		assert.False(t, list.Revoked(&Token{ID: "my-id"}))
+		assert.False(t, list.Revoked(&Token{TenantID: "other-tenant", ID: "my-id"}))
+	})
+
+	t.Run("revoke expired", func(t *testing.T) {
+		list := NewRevocationList()
+
+		assert.False(t, list.Revoke(Revocation{
+			Type:   RevocationTypeID,
+			Value:  "my-id",
+			Expiry: time.Now().Add(-time.Minute),
+		}))
+		assert.False(t, list.Revoked(&Token{ID: "my-id"}))
+	})
+
+	t.Run("notify", func(t *testing.T) {
+		list := NewRevocationList()
+
+		var notified []string
+		list.Notify(&Token{ID: "1"}, func() {
+			notified = append(notified, "1")
+		})
+		stop := list.Notify(&Token{ID: "2"}, func() {
+			notified = append(notified, "2")
+		})
+		stop()
+		list.Notify(&Token{ID: "3"}, func() {
+			notified = append(notified, "3")
+		})
+
+		for _, id := range []string{"1", "2"} {
+			list.Revoke(Revocation{
+				Type:   RevocationTypeID,
+				Value:  id,
+				Expiry: time.Now().Add(time.Hour),
+			})
+		}
+		assert.Equal(t, []string{"1"}, notified)
+
+		// Tokens that are already revoked are notified immediately.
+		list.Notify(&Token{ID: "2"}, func() {
+			notified = append(notified, "2")
+		})
+		assert.Equal(t, []string{"1", "2"}, notified)
+	})
+
+	t.Run("prune", func(t *testing.T) {
+		list := NewRevocationList()
+
+		var revoked, expired []Revocation
+		list.OnRevoke(func(r Revocation) {
+			revoked = append(revoked, r)
+		})
+		list.OnExpire(func(r Revocation) {
+			expired = append(expired, r)
+		})
+
+		expiry := time.Now().Add(time.Hour)
+		r1 := Revocation{Type: RevocationTypeID, Value: "1", Expiry: expiry}
+		r2 := Revocation{
+			Type:   RevocationTypeSubject,
+			Value:  "2",
+			Expiry: expiry.Add(time.Hour),
+		}
+		list.Revoke(r1)
+		list.Revoke(r2)
+		assert.Equal(t, []Revocation{r1, r2}, revoked)
+		assert.Equal(t, []Revocation{r1, r2}, list.List())
+
+		list.Prune(expiry)
+		assert.Equal(t, []Revocation{r1}, expired)
+		assert.Equal(t, []Revocation{r2}, list.List())
+	})
+}
+
+func TestRevocation_Validate(t *testing.T) {
+	expiry := time.Now().Add(time.Hour)
+
+	r := Revocation{Type: RevocationTypeID, Value: "my-id", Expiry: expiry}
+	assert.NoError(t, r.Validate())
+
+	r = Revocation{Type: "foo", Value: "my-id", Expiry: expiry}
+	assert.ErrorContains(t, r.Validate(), "unsupported type")
+
+	r = Revocation{Type: RevocationTypeSubject, Expiry: expiry}
+	assert.ErrorContains(t, r.Validate(), "missing value")
+
+	r = Revocation{Type: RevocationTypeSubject, Value: "my-subject"}
+	assert.ErrorContains(t, r.Validate(), "missing expiry")
+}
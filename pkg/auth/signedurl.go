@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SignedURLEndpointParam  = "piko_endpoint"
+	SignedURLExpiresParam   = "piko_expires"
+	SignedURLPathParam      = "piko_path"
+	SignedURLTenantParam    = "piko_tenant"
+	SignedURLSignatureParam = "piko_signature"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpiredSignature = errors.New("expired signature")
+	ErrInvalidEndpoint  = errors.New("invalid endpoint")
+)
+
+// SignedURLGrant grants temporary access to an endpoint using HMAC signed
+// query parameters, as an alternative to a bearer token.
+type SignedURLGrant struct {
+	// EndpointID is the ID of the granted endpoint. This must be an exact
+	// endpoint ID rather than an endpoint pattern.
+	EndpointID string
+
+	// TenantID is the tenant whose namespace the endpoint is in, or empty
+	// for the default tenant.
+	TenantID string
+
+	Expiry time.Time
+
+	// PathPrefix restricts access to request paths under the given prefix,
+	// or is empty if all paths are permitted.
+	//
+	// The prefix only matches whole path segments, so '/docs' permits
+	// '/docs' and '/docs/index.html' but not '/docs-admin'.
+	PathPrefix string
+}
+
+// Token returns a token permitted to connect to the granted endpoint.
+func (g *SignedURLGrant) Token() *Token {
+	return &Token{
+		Expiry:           g.Expiry,
+		ConnectEndpoints: []string{g.EndpointID},
+		TenantID:         g.TenantID,
+	}
+}
+
+// PathPermitted returns whether the grant permits accessing the given
+// decoded request path.
+//
+// Paths containing '.' or '..' segments are never permitted when the grant
+// has a path prefix, as the upstream may resolve them to a path outside the
+// prefix.
+func (g *SignedURLGrant) PathPermitted(p string) bool {
+	if g.PathPrefix == "" {
+		return true
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	p = path.Clean("/" + p)
+	prefix := strings.TrimSuffix(path.Clean("/"+g.PathPrefix), "/")
+	return p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// URLSigner signs and verifies signed URL grants.
+type URLSigner struct {
+	key []byte
+}
+
+func NewURLSigner(key []byte) *URLSigner {
+	return &URLSigner{
+		key: key,
+	}
+}
+
+// Sign returns the query parameters containing the signed grant.
+//
+// Returns ErrInvalidEndpoint if the grant endpoint ID isn't a valid endpoint
+// ID, such as if it contains a pattern.
+func (s *URLSigner) Sign(grant SignedURLGrant) (url.Values, error) {
+	if !validGrantEndpointID(grant.EndpointID) {
+		return nil, ErrInvalidEndpoint
+	}
+
+	expires := strconv.FormatInt(grant.Expiry.Unix(), 10)
+
+	query := url.Values{}
+	query.Set(SignedURLEndpointParam, grant.EndpointID)
+	query.Set(SignedURLExpiresParam, expires)
+	if grant.PathPrefix != "" {
+		query.Set(SignedURLPathParam, grant.PathPrefix)
+	}
+	if grant.TenantID != "" {
+		query.Set(SignedURLTenantParam, grant.TenantID)
+	}
+	query.Set(
+		SignedURLSignatureParam,
+		s.signature(grant.EndpointID, grant.TenantID, expires, grant.PathPrefix),
+	)
+	return query, nil
+}
+
+// SignURL adds the signed grant to the query parameters of the given URL.
+func (s *URLSigner) SignURL(u *url.URL, grant SignedURLGrant) error {
+	signed, err := s.Sign(grant)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	for k, v := range signed {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	return nil
+}
+
+// Verify verifies the signed grant in the given query parameters.
+//
+// Returns ErrInvalidSignature if the query doesn't contain a valid grant, or
+// ErrExpiredSignature if the grant has expired.
+func (s *URLSigner) Verify(query url.Values) (*SignedURLGrant, error) {
+	endpointID := query.Get(SignedURLEndpointParam)
+	expires := query.Get(SignedURLExpiresParam)
+	pathPrefix := query.Get(SignedURLPathParam)
+	tenantID := query.Get(SignedURLTenantParam)
+	signature := query.Get(SignedURLSignatureParam)
+	if endpointID == "" || expires == "" || signature == "" {
+		return nil, ErrInvalidSignature
+	}
+	// The endpoint is used as a connect pattern, so grants for patterns are
+	// rejected even if signed, since they would grant access to every
+	// matching endpoint.
+	if !validGrantEndpointID(endpointID) {
+		return nil, ErrInvalidSignature
+	}
+
+	expected := s.signature(endpointID, tenantID, expires, pathPrefix)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, ErrInvalidSignature
+	}
+
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	expiry := time.Unix(expiresUnix, 0)
+	if !time.Now().Before(expiry) {
+		return nil, ErrExpiredSignature
+	}
+
+	return &SignedURLGrant{
+		EndpointID: endpointID,
+		TenantID:   tenantID,
+		Expiry:     expiry,
+		PathPrefix: pathPrefix,
+	}, nil
+}
+
+// IsSignedURLParam returns whether the given query parameter is part of a
+// signed grant.
+func IsSignedURLParam(key string) bool {
+	switch key {
+	case SignedURLEndpointParam, SignedURLExpiresParam, SignedURLPathParam,
+		SignedURLTenantParam, SignedURLSignatureParam:
+		return true
+	default:
+		return false
+	}
+}
+
+// validGrantEndpointID returns whether the endpoint ID can be granted. Since
+// the granted endpoint is matched as a connect pattern, it must not contain
+// the '*' wildcard or '{tenant}' placeholder, nor the tenant namespace
+// separator '/'.
+func validGrantEndpointID(endpointID string) bool {
+	return endpointID != "" && !strings.ContainsAny(endpointID, "*{}/")
+}
+
+func (s *URLSigner) signature(endpointID, tenantID, expires, pathPrefix string) string {
+	// Encode the fields as a JSON array so the message is unambiguous,
+	// including a version to support changing the format.
+	message, _ := json.Marshal([]string{"v2", endpointID, tenantID, expires, pathPrefix})
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(message)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
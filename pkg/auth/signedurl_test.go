@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLSigner(t *testing.T) {
+	signer := NewURLSigner([]byte("my-secret"))
+
+	t.Run("verify ok", func(t *testing.T) {
+		expiry := time.Now().Add(time.Hour)
+		u, _ := url.Parse("https://my-endpoint.example.com/docs?foo=bar")
+		require.NoError(t, signer.SignURL(u, SignedURLGrant{
+			EndpointID: "my-endpoint",
+			Expiry:     expiry,
+			PathPrefix: "/docs",
+		}))
+		assert.Equal(t, "bar", u.Query().Get("foo"))
+
+		grant, err := signer.Verify(u.Query())
+		require.NoError(t, err)
+		assert.Equal(t, "my-endpoint", grant.EndpointID)
+		assert.Equal(t, expiry.Unix(), grant.Expiry.Unix())
+		assert.True(t, grant.PathPermitted("/docs/index.html"))
+		assert.False(t, grant.PathPermitted("/admin"))
+
+		token := grant.Token()
+		assert.True(t, token.ConnectPermitted("my-endpoint"))
+		assert.False(t, token.ConnectPermitted("other-endpoint"))
+		assert.False(t, token.ListenPermitted("my-endpoint"))
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		query, err := signer.Sign(SignedURLGrant{
+			EndpointID: "my-endpoint",
+			Expiry:     time.Now().Add(time.Hour),
+			PathPrefix: "/docs",
+		})
+		require.NoError(t, err)
+
+		for _, param := range []string{
+			SignedURLEndpointParam, SignedURLExpiresParam, SignedURLPathParam,
+			SignedURLTenantParam,
+		} {
+			tampered := url.Values{}
+			for k, v := range query {
+				tampered[k] = v
+			}
+			tampered.Set(param, "1")
+
+			_, err := signer.Verify(tampered)
+			assert.ErrorIs(t, err, ErrInvalidSignature, param)
+		}
+
+		// Removing the path prefix is also rejected.
+		tampered := url.Values{}
+		for k, v := range query {
+			tampered[k] = v
+		}
+		tampered.Del(SignedURLPathParam)
+		_, err = signer.Verify(tampered)
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	t.Run("tenant", func(t *testing.T) {
+		query, err := signer.Sign(SignedURLGrant{
+			EndpointID: "my-endpoint",
+			TenantID:   "my-tenant",
+			Expiry:     time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err)
+
+		grant, err := signer.Verify(query)
+		require.NoError(t, err)
+		assert.Equal(t, "my-tenant", grant.TenantID)
+		assert.Equal(t, "my-tenant", grant.Token().TenantID)
+
+		// Removing the tenant is rejected.
+		query.Del(SignedURLTenantParam)
+		_, err = signer.Verify(query)
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		query, err := NewURLSigner([]byte("other-secret")).Sign(SignedURLGrant{
+			EndpointID: "my-endpoint",
+			Expiry:     time.Now().Add(time.Hour),
+		})
+		require.NoError(t, err)
+		_, err = signer.Verify(query)
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		query, err := signer.Sign(SignedURLGrant{
+			EndpointID: "my-endpoint",
+			Expiry:     time.Now().Add(-time.Second),
+		})
+		require.NoError(t, err)
+		_, err = signer.Verify(query)
+		assert.ErrorIs(t, err, ErrExpiredSignature)
+	})
+
+	// Tests the endpoint can't be a pattern, which would grant access to
+	// every matching endpoint.
+	t.Run("endpoint pattern", func(t *testing.T) {
+		for _, endpointID := range []string{
+			"*", "acme-*", "{tenant}-endpoint", "my-tenant/my-endpoint",
+		} {
+			_, err := signer.Sign(SignedURLGrant{
+				EndpointID: endpointID,
+				Expiry:     time.Now().Add(time.Hour),
+			})
+			assert.ErrorIs(t, err, ErrInvalidEndpoint, endpointID)
+
+			// Grants with a pattern are rejected even if signed.
+			expires := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+			query := url.Values{}
+			query.Set(SignedURLEndpointParam, endpointID)
+			query.Set(SignedURLExpiresParam, expires)
+			query.Set(
+				SignedURLSignatureParam,
+				signer.signature(endpointID, "", expires, ""),
+			)
+			_, err = signer.Verify(query)
+			assert.ErrorIs(t, err, ErrInvalidSignature, endpointID)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := signer.Verify(url.Values{})
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+}
+
+func TestSignedURLGrant_PathPermitted(t *testing.T) {
+	grant := SignedURLGrant{PathPrefix: "/reports"}
+	for _, path := range []string{
+		"/reports",
+		"/reports/",
+		"/reports/2024/q1.pdf",
+		"/reports//q1.pdf",
+	} {
+		assert.True(t, grant.PathPermitted(path), path)
+	}
+	for _, path := range []string{
+		"/",
+		"/report",
+		"/reports-admin",
+		"/reportsx/q1.pdf",
+		"/reports/../secrets",
+		"/reports/./q1.pdf",
+		"/reports/..",
+		"/secrets",
+	} {
+		assert.False(t, grant.PathPermitted(path), path)
+	}
+
+	// A trailing slash in the prefix is ignored.
+	grant = SignedURLGrant{PathPrefix: "/reports/"}
+	assert.True(t, grant.PathPermitted("/reports"))
+	assert.True(t, grant.PathPermitted("/reports/q1.pdf"))
+	assert.False(t, grant.PathPermitted("/reports-admin"))
+
+	grant = SignedURLGrant{PathPrefix: "/"}
+	assert.True(t, grant.PathPermitted("/reports"))
+	assert.False(t, grant.PathPermitted("/reports/../secrets"))
+
+	// No prefix permits all paths.
+	grant = SignedURLGrant{}
+	assert.True(t, grant.PathPermitted("/reports-admin"))
+}
@@ -1,15 +1,22 @@
 package auth
 
 import (
+	"context"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"slices"
 	"time"
+
+	"github.com/andydunstall/piko/pkg/limit"
+	"github.com/andydunstall/piko/pkg/log"
 )
 
 var (
 	ErrInvalidToken  = errors.New("invalid token")
 	ErrExpiredToken  = errors.New("expired token")
 	ErrUnknownTenant = errors.New("unknown tenant")
+	ErrRevokedToken  = errors.New("revoked token")
 )
 
 // Token represents an authenticated Piko token.
@@ -18,27 +25,192 @@ type Token struct {
 	// expiry.
 	Expiry time.Time
 
-	// Endpoints contains the list of endpoint IDs the connection is permitted
-	// to access (either connect to or listen on). If empty then all endpoints
-	// are allowed.
+	// ID is the token ID ('jti' claim), or empty if the token has no ID.
+	ID string
+
+	// Subject is the token subject ('sub' claim), or empty if the token has
+	// no subject.
+	Subject string
+
+	// Endpoints contains the list of endpoint patterns the connection is
+	// permitted to access (either connect to or listen on).
+	//
+	// Patterns may contain a '*' wildcard, which matches any sequence of
+	// characters, and a '{tenant}' placeholder, which is replaced by the
+	// tokens tenant ID.
 	Endpoints []string
 
+	// ListenEndpoints contains the list of endpoint patterns the connection
+	// is permitted to listen on, in addition to Endpoints.
+	ListenEndpoints []string
+
+	// ConnectEndpoints contains the list of endpoint patterns the connection
+	// is permitted to connect to, in addition to Endpoints.
+	ConnectEndpoints []string
+
 	// TenantID is the ID of the client tenant.
 	TenantID string
+
+	// Limits contains request limits that lower the configured limits for
+	// the endpoint. A token limit can never raise a configured limit. Only
+	// applies to upstream tokens.
+	Limits limit.Config
+
+	// Claims contains the raw verified token claims, or nil if the token
+	// isn't a JWT.
+	Claims map[string]any
+
+	// listenMatcher and connectMatcher contain the compiled endpoint
+	// patterns. If nil, the patterns are compiled on each check.
+	listenMatcher  *endpointMatcher
+	connectMatcher *endpointMatcher
 }
 
-// EndpointPermitted returns whether the token it permitted to access the
+// ListenPermitted returns whether the token is permitted to register an
+// upstream listener for the endpoint with the given ID.
+//
+// If the token doesn't include any endpoints, it can access all endpoints.
+func (t *Token) ListenPermitted(endpointID string) bool {
+	if t.unscoped() {
+		return true
+	}
+	m := t.listenMatcher
+	if m == nil {
+		m = newEndpointMatcher(
+			slices.Concat(t.Endpoints, t.ListenEndpoints), t.TenantID,
+		)
+	}
+	return m.Match(endpointID)
+}
+
+// ConnectPermitted returns whether the token is permitted to connect to the
 // endpoint with the given ID.
 //
 // If the token doesn't include any endpoints, it can access all endpoints.
-func (t *Token) EndpointPermitted(endpointID string) bool {
-	if len(t.Endpoints) == 0 {
+func (t *Token) ConnectPermitted(endpointID string) bool {
+	if t.unscoped() {
 		return true
 	}
-	return slices.Contains(t.Endpoints, endpointID)
+	m := t.connectMatcher
+	if m == nil {
+		m = newEndpointMatcher(
+			slices.Concat(t.Endpoints, t.ConnectEndpoints), t.TenantID,
+		)
+	}
+	return m.Match(endpointID)
+}
+
+// compile compiles the tokens endpoint patterns so they aren't compiled on
+// each check. This must be called once the tokens tenant ID is known, and
+// before the token is shared between goroutines.
+func (t *Token) compile() {
+	t.listenMatcher = newEndpointMatcher(
+		slices.Concat(t.Endpoints, t.ListenEndpoints), t.TenantID,
+	)
+	t.connectMatcher = newEndpointMatcher(
+		slices.Concat(t.Endpoints, t.ConnectEndpoints), t.TenantID,
+	)
+}
+
+// unscoped returns whether the token doesn't include any endpoints, meaning
+// it can access all endpoints.
+//
+// Note if the token only includes connect endpoints, it can't listen on any
+// endpoint (and vice versa).
+func (t *Token) unscoped() bool {
+	return len(t.Endpoints) == 0 &&
+		len(t.ListenEndpoints) == 0 &&
+		len(t.ConnectEndpoints) == 0
 }
 
 // Verifier verifies client tokens.
 type Verifier interface {
 	Verify(token string) (*Token, error)
 }
+
+// ChainVerifier verifies tokens using each of a list of verifiers in turn,
+// such as to accept both API keys and JWTs.
+type ChainVerifier struct {
+	verifiers []Verifier
+}
+
+func NewChainVerifier(verifiers ...Verifier) *ChainVerifier {
+	return &ChainVerifier{
+		verifiers: verifiers,
+	}
+}
+
+// Verify returns the token from the first verifier that accepts the token.
+//
+// If no verifier accepts the token, returns ErrExpiredToken if any
+// verifier found the token expired, otherwise ErrInvalidToken.
+func (v *ChainVerifier) Verify(token string) (*Token, error) {
+	verifyErr := ErrInvalidToken
+	for _, verifier := range v.verifiers {
+		t, err := verifier.Verify(token)
+		if err == nil {
+			return t, nil
+		}
+		if errors.Is(err, ErrExpiredToken) {
+			verifyErr = err
+		}
+	}
+	return nil, verifyErr
+}
+
+// NewVerifier creates a verifier from the given configuration.
+//
+// If an API keys file is configured, API keys are accepted in addition to
+// JWTs, and the file is watched for changes until the context is cancelled.
+// If certificate rules are configured, the returned verifier also implements
+// CertificateVerifier.
+func NewVerifier(ctx context.Context, conf *Config, logger log.Logger) (Verifier, error) {
+	if err := conf.Cert.Validate(); err != nil {
+		return nil, fmt.Errorf("cert: %w", err)
+	}
+
+	loadedConf, err := conf.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jwtVerifier := NewJWTVerifier(loadedConf)
+	if conf.APIKeys.File == "" && !conf.Cert.Enabled() {
+		return jwtVerifier, nil
+	}
+
+	var verifiers []Verifier
+	if conf.APIKeys.File != "" {
+		apiKeyVerifier, err := NewAPIKeyVerifier(conf.APIKeys.File, logger)
+		if err != nil {
+			return nil, err
+		}
+		go apiKeyVerifier.Watch(ctx, conf.APIKeys.ReloadInterval)
+
+		// Check API keys first as they only require a hash lookup.
+		verifiers = append(verifiers, apiKeyVerifier)
+	}
+	verifiers = append(verifiers, jwtVerifier)
+	if conf.Cert.Enabled() {
+		verifiers = append(verifiers, NewCertVerifier(&conf.Cert))
+	}
+	return NewChainVerifier(verifiers...), nil
+}
+
+// VerifyCertificate returns the token from the first verifier that accepts
+// the client certificate.
+func (v *ChainVerifier) VerifyCertificate(cert *x509.Certificate) (*Token, error) {
+	for _, verifier := range v.verifiers {
+		certVerifier, ok := verifier.(CertificateVerifier)
+		if !ok {
+			continue
+		}
+		t, err := certVerifier.VerifyCertificate(cert)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return nil, ErrInvalidToken
+}
+
+var _ Verifier = &ChainVerifier{}
+var _ CertificateVerifier = &ChainVerifier{}
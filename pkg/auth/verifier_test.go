@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToken_Permitted(t *testing.T) {
+	t.Run("unscoped", func(t *testing.T) {
+		token := &Token{}
+		assert.True(t, token.ListenPermitted("my-endpoint"))
+		assert.True(t, token.ConnectPermitted("my-endpoint"))
+	})
+
+	t.Run("endpoints", func(t *testing.T) {
+		token := &Token{
+			Endpoints: []string{"my-endpoint"},
+		}
+		assert.True(t, token.ListenPermitted("my-endpoint"))
+		assert.True(t, token.ConnectPermitted("my-endpoint"))
+		assert.False(t, token.ListenPermitted("other-endpoint"))
+		assert.False(t, token.ConnectPermitted("other-endpoint"))
+	})
+
+	t.Run("listen", func(t *testing.T) {
+		token := &Token{
+			ListenEndpoints: []string{"my-endpoint"},
+		}
+		assert.True(t, token.ListenPermitted("my-endpoint"))
+		assert.False(t, token.ConnectPermitted("my-endpoint"))
+		assert.False(t, token.ListenPermitted("other-endpoint"))
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		token := &Token{
+			Endpoints:        []string{"shared-endpoint"},
+			ConnectEndpoints: []string{"my-endpoint"},
+		}
+		assert.True(t, token.ConnectPermitted("my-endpoint"))
+		assert.False(t, token.ListenPermitted("my-endpoint"))
+		assert.True(t, token.ListenPermitted("shared-endpoint"))
+		assert.True(t, token.ConnectPermitted("shared-endpoint"))
+	})
+}
+
+func TestToken_Patterns(t *testing.T) {
+	t.Run("wildcard", func(t *testing.T) {
+		token := &Token{
+			Endpoints: []string{"acme-*", "*.internal", "a*b*c"},
+		}
+		token.compile()
+
+		assert.True(t, token.ConnectPermitted("acme-"))
+		assert.True(t, token.ConnectPermitted("acme-api"))
+		assert.True(t, token.ListenPermitted("db.internal"))
+		assert.True(t, token.ConnectPermitted("axxbyyc"))
+		assert.False(t, token.ConnectPermitted("other-acme-api"))
+		assert.False(t, token.ConnectPermitted("db.internal.example"))
+		// Special characters other than '*' are matched literally.
+		assert.False(t, token.ConnectPermitted("dbxinternal"))
+	})
+
+	t.Run("tenant", func(t *testing.T) {
+		token := &Token{
+			TenantID:         "acme",
+			ConnectEndpoints: []string{"{tenant}-*", "shared-{tenant}"},
+		}
+		token.compile()
+
+		assert.True(t, token.ConnectPermitted("acme-api"))
+		assert.True(t, token.ConnectPermitted("shared-acme"))
+		assert.False(t, token.ConnectPermitted("other-api"))
+		assert.False(t, token.ConnectPermitted("{tenant}-api"))
+		assert.False(t, token.ListenPermitted("acme-api"))
+	})
+
+	t.Run("tenant missing", func(t *testing.T) {
+		token := &Token{
+			Endpoints: []string{"{tenant}-*"},
+		}
+		token.compile()
+
+		assert.False(t, token.ConnectPermitted("-api"))
+		assert.False(t, token.ConnectPermitted("{tenant}-api"))
+	})
+
+	t.Run("not compiled", func(t *testing.T) {
+		token := &Token{
+			TenantID:  "acme",
+			Endpoints: []string{"{tenant}-*"},
+		}
+		assert.True(t, token.ConnectPermitted("acme-api"))
+		assert.False(t, token.ConnectPermitted("other-api"))
+	})
+}
@@ -0,0 +1,140 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoder is a compressing writer that can be reused with a new underlying
+// writer.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	AlgorithmGzip: {
+		New: func() any {
+			return gzip.NewWriter(io.Discard)
+		},
+	},
+	AlgorithmBrotli: {
+		New: func() any {
+			// Use a moderate quality as compression is on the request path.
+			return brotli.NewWriterLevel(io.Discard, 5)
+		},
+	},
+	AlgorithmZstd: {
+		New: func() any {
+			enc, err := zstd.NewWriter(
+				io.Discard,
+				zstd.WithEncoderLevel(zstd.SpeedDefault),
+				zstd.WithEncoderConcurrency(1),
+			)
+			if err != nil {
+				// Only fails with invalid options.
+				panic("zstd writer: " + err.Error())
+			}
+			return enc
+		},
+	},
+}
+
+func acquireEncoder(algorithm string, w io.Writer) encoder {
+	enc := encoderPools[algorithm].Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+func releaseEncoder(algorithm string, enc encoder) {
+	// Detach the underlying writer so it can be garbage collected.
+	enc.Reset(io.Discard)
+	encoderPools[algorithm].Put(enc)
+}
+
+// Negotiate selects the compression algorithm to use given the request
+// 'Accept-Encoding' header and the supported algorithms in order of
+// preference.
+//
+// The client preference (q-value) takes precedence, then the order of the
+// supported algorithms. Returns an empty string if no algorithm is acceptable.
+func Negotiate(acceptEncoding string, algorithms []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	accepted := make(map[string]float64)
+	for _, entry := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.TrimSpace(name) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				parsed = 0
+			}
+			q = parsed
+		}
+		accepted[coding] = q
+	}
+
+	var selected string
+	var selectedQ float64
+	for _, algorithm := range algorithms {
+		q, ok := accepted[algorithm]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if !ok || q <= 0 {
+			continue
+		}
+		// Only replace if strictly preferred by the client, so ties are
+		// broken by the server order.
+		if q > selectedQ {
+			selected = algorithm
+			selectedQ = q
+		}
+	}
+	return selected
+}
+
+// Compressible returns whether the given response content type matches one
+// of the given types.
+//
+// Types may include a wildcard subtype, such as 'text/*'.
+func Compressible(contentType string, types []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if mediaType == t {
+			return true
+		}
+	}
+	return false
+}
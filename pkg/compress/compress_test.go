@@ -0,0 +1,209 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		algorithms     []string
+		expected       string
+	}{
+		{"", DefaultAlgorithms, ""},
+		{"gzip", DefaultAlgorithms, "gzip"},
+		{"gzip, br", DefaultAlgorithms, "br"},
+		{"gzip, br, zstd", DefaultAlgorithms, "zstd"},
+		{"gzip, br, zstd", []string{"gzip", "br"}, "gzip"},
+		// Client preference takes precedence.
+		{"gzip;q=1.0, br;q=0.5", DefaultAlgorithms, "gzip"},
+		{"gzip;q=0, br;q=0", DefaultAlgorithms, ""},
+		{"*", DefaultAlgorithms, "zstd"},
+		{"*;q=0.1, gzip", DefaultAlgorithms, "gzip"},
+		{"identity", DefaultAlgorithms, ""},
+		{"deflate", DefaultAlgorithms, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Negotiate(tt.acceptEncoding, tt.algorithms))
+		})
+	}
+}
+
+func TestConfig_Negotiate(t *testing.T) {
+	// Defaults to all supported algorithms.
+	conf := Config{}
+	assert.Equal(t, "zstd", conf.Negotiate("gzip, br, zstd"))
+
+	conf = Config{Algorithms: []string{AlgorithmGzip}}
+	assert.Equal(t, "gzip", conf.Negotiate("gzip, br, zstd"))
+	assert.Equal(t, "", conf.Negotiate("br"))
+}
+
+func TestCompressible(t *testing.T) {
+	assert.True(t, Compressible("text/html; charset=utf-8", DefaultContentTypes))
+	assert.True(t, Compressible("application/json", DefaultContentTypes))
+	assert.True(t, Compressible("Text/Plain", DefaultContentTypes))
+	assert.False(t, Compressible("image/png", DefaultContentTypes))
+	assert.False(t, Compressible("", DefaultContentTypes))
+	assert.True(t, Compressible("image/png", []string{"image/*"}))
+}
+
+func decode(t *testing.T, algorithm string, b []byte) string {
+	var r io.Reader
+	switch algorithm {
+	case AlgorithmGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(b))
+		require.NoError(t, err)
+		r = gr
+	case AlgorithmBrotli:
+		r = brotli.NewReader(bytes.NewReader(b))
+	case AlgorithmZstd:
+		zr, err := zstd.NewReader(bytes.NewReader(b))
+		require.NoError(t, err)
+		defer zr.Close()
+		r = zr
+	}
+	decoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(decoded)
+}
+
+func TestResponseWriter(t *testing.T) {
+	body := strings.Repeat("piko", 1024)
+
+	for _, algorithm := range DefaultAlgorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			w := NewResponseWriter(rec, algorithm, &Config{MinSize: 128})
+
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Header().Set("ETag", `"abc"`)
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write([]byte(body))
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			resp := rec.Result()
+			assert.Equal(t, algorithm, resp.Header.Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+			assert.Equal(t, `W/"abc"`, resp.Header.Get("ETag"))
+			assert.Equal(t, "", resp.Header.Get("Content-Length"))
+			assert.Equal(t, body, decode(t, algorithm, rec.Body.Bytes()))
+		})
+	}
+
+	t.Run("unknown length", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := NewResponseWriter(rec, AlgorithmGzip, &Config{MinSize: 128})
+
+		w.Header().Set("Content-Type", "text/plain")
+		// Write in small chunks so the writer must buffer until it reaches
+		// the minimum size.
+		for i := 0; i != len(body); i += 64 {
+			_, err := w.Write([]byte(body[i : i+64]))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, "gzip", rec.Result().Header.Get("Content-Encoding"))
+		assert.Equal(t, body, decode(t, AlgorithmGzip, rec.Body.Bytes()))
+	})
+
+	t.Run("below min size", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := NewResponseWriter(rec, AlgorithmGzip, &Config{MinSize: 128})
+
+		w.Header().Set("Content-Type", "text/plain")
+		_, err := w.Write([]byte("foo"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, "", rec.Result().Header.Get("Content-Encoding"))
+		assert.Equal(t, "foo", rec.Body.String())
+	})
+
+	t.Run("already encoded", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := NewResponseWriter(rec, AlgorithmGzip, &Config{})
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "br")
+		_, err := w.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, "br", rec.Result().Header.Get("Content-Encoding"))
+		assert.Equal(t, body, rec.Body.String())
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := NewResponseWriter(rec, AlgorithmGzip, &Config{})
+
+		w.Header().Set("Content-Type", "image/png")
+		_, err := w.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, "", rec.Result().Header.Get("Content-Encoding"))
+		assert.Equal(t, body, rec.Body.String())
+	})
+
+	t.Run("event stream", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := NewResponseWriter(rec, AlgorithmGzip, &Config{})
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, err := w.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, "", rec.Result().Header.Get("Content-Encoding"))
+		assert.Equal(t, body, rec.Body.String())
+	})
+
+	t.Run("flush before min size", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := NewResponseWriter(rec, AlgorithmGzip, &Config{MinSize: 128})
+
+		w.Header().Set("Content-Type", "text/plain")
+		// Flushing before any data is written is ignored.
+		w.Flush()
+		_, err := w.Write([]byte("foo"))
+		require.NoError(t, err)
+		// Flushing with buffered data means the response is streaming.
+		w.Flush()
+		_, err = w.Write([]byte(body))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, "", rec.Result().Header.Get("Content-Encoding"))
+		assert.Equal(t, "foo"+body, rec.Body.String())
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := NewResponseWriter(rec, AlgorithmGzip, &Config{})
+
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusNoContent)
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, http.StatusNoContent, rec.Result().StatusCode)
+		assert.Equal(t, "", rec.Result().Header.Get("Content-Encoding"))
+	})
+}
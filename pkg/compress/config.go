@@ -0,0 +1,146 @@
+package compress
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	AlgorithmGzip   = "gzip"
+	AlgorithmBrotli = "br"
+	AlgorithmZstd   = "zstd"
+)
+
+var (
+	// DefaultAlgorithms contains the supported algorithms in the default
+	// order of preference.
+	DefaultAlgorithms = []string{AlgorithmZstd, AlgorithmBrotli, AlgorithmGzip}
+
+	// DefaultContentTypes contains the response content types that are
+	// compressed by default.
+	DefaultContentTypes = []string{
+		"text/*",
+		"application/json",
+		"application/javascript",
+		"application/xml",
+		"application/xhtml+xml",
+		"application/wasm",
+		"image/svg+xml",
+	}
+)
+
+// Config configures response compression.
+type Config struct {
+	// Enabled indicates whether to compress responses.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// Algorithms contains the supported compression algorithms in order of
+	// preference. Supports 'zstd', 'br' and 'gzip'.
+	//
+	// The algorithm is selected based on the request 'Accept-Encoding'
+	// header, where the client preference (q-value) takes precedence over
+	// this order.
+	//
+	// Defaults to all supported algorithms.
+	Algorithms []string `json:"algorithms" yaml:"algorithms"`
+
+	// ContentTypes contains the response content types to compress. Entries
+	// may include a wildcard subtype, such as 'text/*'.
+	//
+	// Defaults to common text based content types.
+	ContentTypes []string `json:"content_types" yaml:"content_types"`
+
+	// MinSize is the minimum response size in bytes to compress. Smaller
+	// responses are sent uncompressed.
+	MinSize int `json:"min_size" yaml:"min_size"`
+}
+
+func (c *Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	for _, algorithm := range c.Algorithms {
+		if !supportedAlgorithm(algorithm) {
+			return fmt.Errorf("unsupported algorithm: %s", algorithm)
+		}
+	}
+	if c.MinSize < 0 {
+		return fmt.Errorf("min size cannot be negative")
+	}
+	return nil
+}
+
+func (c *Config) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	if len(prefix) > 0 {
+		prefix = prefix + ".compression."
+	} else {
+		prefix = "compression."
+	}
+	fs.BoolVar(
+		&c.Enabled,
+		prefix+"enabled",
+		c.Enabled,
+		`
+Whether to compress responses based on the request 'Accept-Encoding' header.
+
+Upgraded and streaming responses are never compressed.`,
+	)
+	fs.StringSliceVar(
+		&c.Algorithms,
+		prefix+"algorithms",
+		c.Algorithms,
+		`
+The supported compression algorithms in order of preference.
+
+Supports 'zstd', 'br' and 'gzip'. Defaults to all supported algorithms.`,
+	)
+	fs.StringSliceVar(
+		&c.ContentTypes,
+		prefix+"content-types",
+		c.ContentTypes,
+		`
+The response content types to compress, which may include a wildcard subtype
+such as 'text/*'.
+
+Defaults to common text based content types.`,
+	)
+	fs.IntVar(
+		&c.MinSize,
+		prefix+"min-size",
+		c.MinSize,
+		`
+The minimum response size in bytes to compress.`,
+	)
+}
+
+// Negotiate selects the compression algorithm to use given the request
+// 'Accept-Encoding' header and the configured algorithms. Returns an empty
+// string if no algorithm is acceptable.
+func (c *Config) Negotiate(acceptEncoding string) string {
+	return Negotiate(acceptEncoding, c.algorithms())
+}
+
+func (c *Config) algorithms() []string {
+	if len(c.Algorithms) == 0 {
+		return DefaultAlgorithms
+	}
+	return c.Algorithms
+}
+
+func (c *Config) contentTypes() []string {
+	if len(c.ContentTypes) == 0 {
+		return DefaultContentTypes
+	}
+	return c.ContentTypes
+}
+
+func supportedAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case AlgorithmGzip, AlgorithmBrotli, AlgorithmZstd:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,244 @@
+package compress
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type writerState int
+
+const (
+	// stateHeaderPending means the handler hasn't written the header yet.
+	stateHeaderPending writerState = iota
+	// stateBuffering means the response is eligible for compression but
+	// the size is unknown, so the body is buffered until it reaches the
+	// minimum size.
+	stateBuffering
+	// stateCompressing means the response is being compressed.
+	stateCompressing
+	// statePassthrough means the response is written uncompressed.
+	statePassthrough
+)
+
+// ResponseWriter wraps a http.ResponseWriter to compress the response body
+// when the response is eligible for compression.
+//
+// Whether to compress is decided when the header is written, based on the
+// response status, content type, content encoding and size. If the size is
+// unknown, the body is buffered until it reaches the minimum size. If the
+// handler flushes before then, the response is considered streaming and
+// written uncompressed.
+//
+// Close must be called once the handler returns.
+type ResponseWriter struct {
+	http.ResponseWriter
+
+	algorithm    string
+	contentTypes []string
+	minSize      int
+
+	state      writerState
+	statusCode int
+	buf        []byte
+	enc        encoder
+}
+
+// NewResponseWriter returns a writer that compresses the response with the
+// given algorithm.
+func NewResponseWriter(
+	w http.ResponseWriter,
+	algorithm string,
+	conf *Config,
+) *ResponseWriter {
+	return &ResponseWriter{
+		ResponseWriter: w,
+		algorithm:      algorithm,
+		contentTypes:   conf.contentTypes(),
+		minSize:        conf.MinSize,
+	}
+}
+
+func (w *ResponseWriter) WriteHeader(statusCode int) {
+	if w.state != stateHeaderPending {
+		return
+	}
+
+	// Informational responses are forwarded as is, except for 101 which is
+	// the final response and upgrades the connection.
+	if statusCode >= 100 && statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	w.statusCode = statusCode
+
+	if !w.eligible() {
+		w.state = statePassthrough
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+
+	// If the content length is known, we can decide immediately.
+	if contentLength := w.Header().Get("Content-Length"); contentLength != "" {
+		n, err := strconv.Atoi(contentLength)
+		if err != nil || n < w.minSize {
+			w.state = statePassthrough
+			w.ResponseWriter.WriteHeader(statusCode)
+			return
+		}
+		w.startCompressing()
+		return
+	}
+
+	w.state = stateBuffering
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	if w.state == stateHeaderPending {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	switch w.state {
+	case stateBuffering:
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.minSize {
+			return len(b), nil
+		}
+
+		w.startCompressing()
+		buf := w.buf
+		w.buf = nil
+		if _, err := w.enc.Write(buf); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	case stateCompressing:
+		return w.enc.Write(b)
+	default:
+		return w.ResponseWriter.Write(b)
+	}
+}
+
+// Flush flushes any buffered data to the client.
+//
+// If the response is still being buffered, the response is considered
+// streaming so is written uncompressed. Flushing before any of the body has
+// been written is ignored, since the header is only sent once we've decided
+// whether to compress.
+func (w *ResponseWriter) Flush() {
+	switch w.state {
+	case stateHeaderPending:
+		return
+	case stateBuffering:
+		if len(w.buf) == 0 {
+			return
+		}
+		_ = w.passthroughBuffered()
+	case stateCompressing:
+		_ = w.enc.Flush()
+	}
+
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Close completes the response, writing any buffered data.
+func (w *ResponseWriter) Close() error {
+	switch w.state {
+	case stateBuffering:
+		// The response never reached the minimum size.
+		return w.passthroughBuffered()
+	case stateCompressing:
+		err := w.enc.Close()
+		releaseEncoder(w.algorithm, w.enc)
+		w.enc = nil
+		w.state = statePassthrough
+		return err
+	}
+	return nil
+}
+
+// Written returns whether the handler has written the response header.
+func (w *ResponseWriter) Written() bool {
+	return w.state != stateHeaderPending
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *ResponseWriter) eligible() bool {
+	switch {
+	case w.statusCode < 200,
+		w.statusCode == http.StatusNoContent,
+		w.statusCode == http.StatusPartialContent,
+		w.statusCode == http.StatusNotModified:
+		return false
+	}
+
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		// Already encoded by the upstream.
+		return false
+	}
+	for _, v := range h.Values("Cache-Control") {
+		if strings.Contains(strings.ToLower(v), "no-transform") {
+			return false
+		}
+	}
+
+	contentType := h.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if mediaType == "text/event-stream" {
+		// Streaming responses are never compressed.
+		return false
+	}
+	return Compressible(contentType, w.contentTypes)
+}
+
+func (w *ResponseWriter) startCompressing() {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	h.Set("Content-Encoding", w.algorithm)
+	addVary(h, "Accept-Encoding")
+	// The compressed representation differs from the original so a strong
+	// validator no longer applies.
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	w.enc = acquireEncoder(w.algorithm, w.ResponseWriter)
+	w.state = stateCompressing
+}
+
+func (w *ResponseWriter) passthroughBuffered() error {
+	w.state = statePassthrough
+	w.ResponseWriter.WriteHeader(w.statusCode)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func addVary(h http.Header, value string) {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
@@ -0,0 +1,99 @@
+package limit
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+)
+
+// Config configures limits on the requests forwarded to an upstream.
+type Config struct {
+	// MaxRequestBodySize is the maximum size of a request body in bytes.
+	// Requests whose body exceeds the limit are rejected with
+	// '413 Content Too Large'.
+	//
+	// If zero, the request body size is unlimited.
+	MaxRequestBodySize int64 `json:"max_request_body_size" yaml:"max_request_body_size"`
+
+	// MaxUploadRate is the maximum rate a request body is forwarded in
+	// bytes per second.
+	//
+	// If zero, the upload rate is unlimited.
+	MaxUploadRate int64 `json:"max_upload_rate" yaml:"max_upload_rate"`
+}
+
+// Enabled returns whether any limits are configured.
+func (c *Config) Enabled() bool {
+	return c.MaxRequestBodySize > 0 || c.MaxUploadRate > 0
+}
+
+// Override returns the configuration with any non-zero limits in the
+// override replacing the configured limits.
+func (c Config) Override(override Config) Config {
+	if override.MaxRequestBodySize != 0 {
+		c.MaxRequestBodySize = override.MaxRequestBodySize
+	}
+	if override.MaxUploadRate != 0 {
+		c.MaxUploadRate = override.MaxUploadRate
+	}
+	return c
+}
+
+// Restrict returns the configuration with any non-zero limits in the
+// restriction replacing the configured limits only when they are lower, so
+// the restriction can never raise a limit.
+//
+// A zero limit is unlimited, so a non-zero limit always restricts it.
+func (c Config) Restrict(restriction Config) Config {
+	c.MaxRequestBodySize = minLimit(c.MaxRequestBodySize, restriction.MaxRequestBodySize)
+	c.MaxUploadRate = minLimit(c.MaxUploadRate, restriction.MaxUploadRate)
+	return c
+}
+
+func (c *Config) Validate() error {
+	if c.MaxRequestBodySize < 0 {
+		return fmt.Errorf("max request body size cannot be negative")
+	}
+	if c.MaxUploadRate < 0 {
+		return fmt.Errorf("max upload rate cannot be negative")
+	}
+	return nil
+}
+
+func (c *Config) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	if len(prefix) > 0 {
+		prefix = prefix + ".limits."
+	} else {
+		prefix = "limits."
+	}
+	fs.Int64Var(
+		&c.MaxRequestBodySize,
+		prefix+"max-request-body-size",
+		c.MaxRequestBodySize,
+		`
+The maximum size of a request body in bytes. Requests whose body exceeds the
+limit are rejected with '413 Content Too Large'.
+
+Set to 0 for no limit.`,
+	)
+	fs.Int64Var(
+		&c.MaxUploadRate,
+		prefix+"max-upload-rate",
+		c.MaxUploadRate,
+		`
+The maximum rate to forward a request body in bytes per second.
+
+Set to 0 for no limit.`,
+	)
+}
+
+// minLimit returns the lowest of the two limits, where zero is unlimited.
+func minLimit(a, b int64) int64 {
+	if a == 0 {
+		return b
+	}
+	if b == 0 {
+		return a
+	}
+	return min(a, b)
+}
@@ -0,0 +1,87 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// maxBurst is the maximum number of bytes read from a rate limited body at
+// once.
+const maxBurst = 64 << 10
+
+var (
+	// ErrBodyTooLarge indicates the request body exceeds the maximum size.
+	ErrBodyTooLarge = errors.New("request body too large")
+)
+
+// LimitBody applies the configured limits to the request body.
+//
+// If the request 'Content-Length' already exceeds the maximum body size,
+// returns ErrBodyTooLarge. Otherwise reading a body that exceeds the maximum
+// size returns an error where IsBodyTooLarge is true.
+func LimitBody(w http.ResponseWriter, r *http.Request, conf Config) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	if conf.MaxRequestBodySize > 0 {
+		if r.ContentLength > conf.MaxRequestBodySize {
+			return ErrBodyTooLarge
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, conf.MaxRequestBodySize)
+	}
+	if conf.MaxUploadRate > 0 {
+		r.Body = newRateReader(r.Context(), r.Body, conf.MaxUploadRate)
+	}
+	return nil
+}
+
+// IsBodyTooLarge returns whether the error indicates the request body
+// exceeds the maximum size.
+func IsBodyTooLarge(err error) bool {
+	if errors.Is(err, ErrBodyTooLarge) {
+		return true
+	}
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// rateReader limits the rate the underlying reader can be read.
+type rateReader struct {
+	io.ReadCloser
+
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func newRateReader(
+	ctx context.Context,
+	r io.ReadCloser,
+	bytesPerSecond int64,
+) *rateReader {
+	// Allow bursts of up to a second of data, though cap the burst to avoid
+	// large reads.
+	burst := int(min(bytesPerSecond, maxBurst))
+	return &rateReader{
+		ReadCloser: r,
+		ctx:        ctx,
+		limiter:    rate.NewLimiter(rate.Limit(bytesPerSecond), burst),
+	}
+}
+
+func (r *rateReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,100 @@
+package limit
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitBody(t *testing.T) {
+	t.Run("content length exceeds limit", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("foobar"))
+		w := httptest.NewRecorder()
+
+		err := LimitBody(w, r, Config{MaxRequestBodySize: 3})
+		assert.ErrorIs(t, err, ErrBodyTooLarge)
+		assert.True(t, IsBodyTooLarge(err))
+	})
+
+	t.Run("body exceeds limit", func(t *testing.T) {
+		// Unknown content length.
+		r := httptest.NewRequest(
+			http.MethodPost, "/", io.MultiReader(strings.NewReader("foobar")),
+		)
+		r.ContentLength = -1
+		w := httptest.NewRecorder()
+
+		require.NoError(t, LimitBody(w, r, Config{MaxRequestBodySize: 3}))
+
+		_, err := io.ReadAll(r.Body)
+		assert.True(t, IsBodyTooLarge(err))
+	})
+
+	t.Run("body within limit", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("foobar"))
+		w := httptest.NewRecorder()
+
+		require.NoError(t, LimitBody(w, r, Config{MaxRequestBodySize: 6}))
+
+		b, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "foobar", string(b))
+	})
+
+	t.Run("upload rate", func(t *testing.T) {
+		body := bytes.Repeat([]byte("a"), 12000)
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+
+		require.NoError(t, LimitBody(w, r, Config{MaxUploadRate: 10000}))
+
+		// The burst allows reading up to a second of data immediately, so
+		// the remaining 2000 bytes should take at least 200ms.
+		start := time.Now()
+		b, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, body, b)
+		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*150)
+	})
+}
+
+func TestConfig_Override(t *testing.T) {
+	conf := Config{
+		MaxRequestBodySize: 100,
+		MaxUploadRate:      200,
+	}
+	assert.Equal(t, Config{
+		MaxRequestBodySize: 300,
+		MaxUploadRate:      200,
+	}, conf.Override(Config{MaxRequestBodySize: 300}))
+	assert.Equal(t, conf, conf.Override(Config{}))
+}
+
+func TestConfig_Restrict(t *testing.T) {
+	conf := Config{
+		MaxRequestBodySize: 100,
+		MaxUploadRate:      200,
+	}
+	// Lower limits restrict the configured limits.
+	assert.Equal(t, Config{
+		MaxRequestBodySize: 50,
+		MaxUploadRate:      200,
+	}, conf.Restrict(Config{MaxRequestBodySize: 50}))
+	// Higher limits are ignored.
+	assert.Equal(t, conf, conf.Restrict(Config{
+		MaxRequestBodySize: 300,
+		MaxUploadRate:      400,
+	}))
+	assert.Equal(t, conf, conf.Restrict(Config{}))
+	// A limit restricts an unlimited configuration.
+	assert.Equal(t, Config{
+		MaxRequestBodySize: 300,
+	}, Config{}.Restrict(Config{MaxRequestBodySize: 300}))
+}
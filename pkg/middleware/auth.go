@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"context"
+	"crypto/x509"
 	"errors"
 	"net/http"
 	"strings"
@@ -8,45 +10,81 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 
+	"github.com/andydunstall/piko/pkg/audit"
 	"github.com/andydunstall/piko/pkg/auth"
 	"github.com/andydunstall/piko/pkg/log"
 )
 
 const (
 	TokenContextKey = "_piko_token"
+
+	// CredentialHeaderContextKey is the name of the header containing the
+	// verified bearer token, or unset if the request wasn't authenticated
+	// with a bearer token.
+	CredentialHeaderContextKey = "_piko_credential_header"
 )
 
 // Auth is middleware to verify token requests.
 type Auth struct {
 	verifier *auth.MultiTenantVerifier
-	logger   log.Logger
+
+	// auditLogger records authentication failures, or is nil if audit
+	// logging is disabled.
+	auditLogger *audit.Logger
+
+	logger log.Logger
 }
 
-func NewAuth(verifier *auth.MultiTenantVerifier, logger log.Logger) *Auth {
+func NewAuth(
+	verifier *auth.MultiTenantVerifier,
+	auditLogger *audit.Logger,
+	logger log.Logger,
+) *Auth {
 	return &Auth{
-		verifier: verifier,
-		logger:   logger,
+		verifier:    verifier,
+		auditLogger: auditLogger,
+		logger:      logger,
 	}
 }
 
 // Verify verifies the request endpoint token and adds to the context.
 //
 // If the token is invalid, returns 401 to the client.
+//
+// If the request doesn't include an authorization header, but the client
+// presented a verified TLS client certificate, the token is derived from the
+// certificate.
+//
+// If the token is revoked while the request is in progress, the request
+// context is cancelled with cause auth.ErrRevokedToken.
+//
+// If the request was already authenticated by earlier middleware, such as
+// using a signed URL, Verify does nothing.
 func (m *Auth) Verify(c *gin.Context) {
-	tokenString, ok := m.parseToken(c)
-	if !ok {
+	if _, ok := c.Get(TokenContextKey); ok {
 		return
 	}
 
 	tenantID := m.parseTenant(c)
 
-	token, err := m.verifier.Verify(tokenString, tenantID)
+	var token *auth.Token
+	var err error
+	if cert, ok := m.parseCertificate(c); ok {
+		token, err = m.verifier.VerifyCertificate(cert, tenantID)
+	} else {
+		tokenString, ok := m.parseToken(c)
+		if !ok {
+			return
+		}
+		token, err = m.verifier.Verify(tokenString, tenantID)
+	}
 	if err != nil {
 		if errors.Is(err, auth.ErrInvalidToken) {
 			m.logger.Warn(
 				"auth invalid token",
 				zap.Error(err),
 			)
+			m.recordFailure(c, "invalid token")
 			c.AbortWithStatusJSON(
 				http.StatusUnauthorized,
 				gin.H{"error": "invalid token"},
@@ -58,17 +96,31 @@ func (m *Auth) Verify(c *gin.Context) {
 				"auth expired token",
 				zap.Error(err),
 			)
+			m.recordFailure(c, "expired token")
 			c.AbortWithStatusJSON(
 				http.StatusUnauthorized,
 				gin.H{"error": "expired token"},
 			)
 			return
 		}
+		if errors.Is(err, auth.ErrRevokedToken) {
+			m.logger.Warn(
+				"auth revoked token",
+				zap.Error(err),
+			)
+			m.recordFailure(c, "revoked token")
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				gin.H{"error": "revoked token"},
+			)
+			return
+		}
 		if errors.Is(err, auth.ErrUnknownTenant) {
 			m.logger.Warn(
 				"auth unknwon tenant",
 				zap.Error(err),
 			)
+			m.recordFailure(c, "unknown tenant")
 			c.AbortWithStatusJSON(
 				http.StatusUnauthorized,
 				gin.H{"error": "unknown tenant"},
@@ -80,10 +132,21 @@ func (m *Auth) Verify(c *gin.Context) {
 			"unknown verification error",
 			zap.Error(err),
 		)
+		m.recordFailure(c, "verification error")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	// Cancel the request if the token is revoked, such as to close
+	// long-lived upstream and TCP connections.
+	ctx, cancel := context.WithCancelCause(c.Request.Context())
+	defer cancel(nil)
+	stop := m.verifier.NotifyRevoked(token, func() {
+		cancel(auth.ErrRevokedToken)
+	})
+	defer stop()
+	c.Request = c.Request.WithContext(ctx)
+
 	c.Set(TokenContextKey, token)
 	c.Next()
 }
@@ -92,12 +155,15 @@ func (m *Auth) parseToken(c *gin.Context) (string, bool) {
 	// Support both x-piko-authorization and authorization, where
 	// x-piko-authorization takes precedence. x-piko-authorization can be used
 	// to avoid conflicts with the upstream authorization header.
-	authorization := c.Request.Header.Get("x-piko-authorization")
+	header := "x-piko-authorization"
+	authorization := c.Request.Header.Get(header)
 	if authorization == "" {
-		authorization = c.Request.Header.Get("Authorization")
+		header = "Authorization"
+		authorization = c.Request.Header.Get(header)
 	}
 	if authorization == "" {
 		m.logger.Warn("missing authorization header")
+		m.recordFailure(c, "missing authorization")
 		c.AbortWithStatusJSON(
 			http.StatusUnauthorized,
 			gin.H{"error": "missing authorization"},
@@ -107,6 +173,7 @@ func (m *Auth) parseToken(c *gin.Context) (string, bool) {
 	authType, tokenString, ok := strings.Cut(authorization, " ")
 	if !ok {
 		m.logger.Warn("invalid authorization header")
+		m.recordFailure(c, "invalid authorization")
 		c.AbortWithStatusJSON(
 			http.StatusUnauthorized,
 			gin.H{"error": "invalid authorization"},
@@ -118,6 +185,7 @@ func (m *Auth) parseToken(c *gin.Context) (string, bool) {
 			"unsupported auth type",
 			zap.String("auth-type", authType),
 		)
+		m.recordFailure(c, "unsupported auth type")
 		c.AbortWithStatusJSON(
 			http.StatusUnauthorized,
 			gin.H{"error": "unsupported auth type"},
@@ -125,9 +193,46 @@ func (m *Auth) parseToken(c *gin.Context) (string, bool) {
 		return "", false
 	}
 
+	c.Set(CredentialHeaderContextKey, header)
 	return tokenString, true
 }
 
+// parseCertificate returns the verified TLS client certificate if the
+// request should be authenticated using the certificate rather than a bearer
+// token.
+func (m *Auth) parseCertificate(c *gin.Context) (*x509.Certificate, bool) {
+	if c.Request.Header.Get("x-piko-authorization") != "" ||
+		c.Request.Header.Get("Authorization") != "" {
+		return nil, false
+	}
+	// VerifiedChains is only set when the certificate was verified against
+	// the configured client CAs.
+	tlsState := c.Request.TLS
+	if tlsState == nil || len(tlsState.VerifiedChains) == 0 || len(tlsState.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	if !m.verifier.CertificatesEnabled() {
+		return nil, false
+	}
+	return tlsState.VerifiedChains[0][0], true
+}
+
+// recordFailure records an audit event for a request that failed to
+// authenticate.
+func (m *Auth) recordFailure(c *gin.Context, reason string) {
+	if m.auditLogger == nil {
+		return
+	}
+	m.auditLogger.Record(audit.Event{
+		Type:     audit.TypeAuthFailed,
+		ClientIP: c.ClientIP(),
+		TenantID: m.parseTenant(c),
+		Method:   c.Request.Method,
+		Path:     c.Request.URL.Path,
+		Reason:   reason,
+	})
+}
+
 func (m *Auth) parseTenant(c *gin.Context) string {
 	return c.Request.Header.Get("x-piko-tenant-id")
 }
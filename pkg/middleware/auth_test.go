@@ -1,6 +1,10 @@
 package middleware
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -11,6 +15,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/andydunstall/piko/pkg/audit"
 	"github.com/andydunstall/piko/pkg/auth"
 	"github.com/andydunstall/piko/pkg/log"
 )
@@ -25,6 +30,19 @@ func (v *fakeVerifier) Verify(token string) (*auth.Token, error) {
 
 var _ auth.Verifier = &fakeVerifier{}
 
+type fakeAuditSink struct {
+	events []audit.Event
+}
+
+func (s *fakeAuditSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeAuditSink) Write(_ context.Context, events []audit.Event) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
 type errorMessage struct {
 	Error string `json:"error"`
 }
@@ -40,7 +58,7 @@ func TestAuth(t *testing.T) {
 				}, nil
 			},
 		}, nil)
-		m := NewAuth(verifier, log.NewNopLogger())
+		m := NewAuth(verifier, nil, log.NewNopLogger())
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -68,7 +86,7 @@ func TestAuth(t *testing.T) {
 				}, nil
 			},
 		}, nil)
-		m := NewAuth(verifier, log.NewNopLogger())
+		m := NewAuth(verifier, nil, log.NewNopLogger())
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -106,7 +124,7 @@ func TestAuth(t *testing.T) {
 				},
 			},
 		})
-		m := NewAuth(verifier, log.NewNopLogger())
+		m := NewAuth(verifier, nil, log.NewNopLogger())
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -125,6 +143,77 @@ func TestAuth(t *testing.T) {
 		assert.Equal(t, []string{"e1", "e2", "e3"}, token.(*auth.Token).Endpoints)
 	})
 
+	t.Run("client certificate", func(t *testing.T) {
+		verifier := auth.NewMultiTenantVerifier(auth.NewChainVerifier(
+			&fakeVerifier{
+				handler: func(_ string) (*auth.Token, error) {
+					t.Error("unexpected bearer token verification")
+					return nil, auth.ErrInvalidToken
+				},
+			},
+			auth.NewCertVerifier(&auth.CertConfig{
+				Rules: []auth.CertRule{
+					{
+						CommonName: "agent-*",
+						Endpoints:  []string{"{cn}"},
+					},
+				},
+			}),
+		), nil)
+		m := NewAuth(verifier, nil, log.NewNopLogger())
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		c.Request.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{
+				{
+					{
+						Subject: pkix.Name{CommonName: "agent-1"},
+					},
+				},
+			},
+		}
+
+		m.Verify(c)
+
+		resp := w.Result()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		token, ok := c.Get(TokenContextKey)
+		assert.True(t, ok)
+		assert.Equal(t, []string{"agent-1"}, token.(*auth.Token).Endpoints)
+	})
+
+	t.Run("client certificate not matched", func(t *testing.T) {
+		verifier := auth.NewMultiTenantVerifier(auth.NewCertVerifier(&auth.CertConfig{
+			Rules: []auth.CertRule{
+				{
+					CommonName: "agent-*",
+				},
+			},
+		}), nil)
+		m := NewAuth(verifier, nil, log.NewNopLogger())
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		c.Request.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{
+				{
+					{
+						Subject: pkix.Name{CommonName: "unknown"},
+					},
+				},
+			},
+		}
+
+		m.Verify(c)
+
+		resp := w.Result()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
 	t.Run("invalid token", func(t *testing.T) {
 		verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
 			handler: func(token string) (*auth.Token, error) {
@@ -132,7 +221,7 @@ func TestAuth(t *testing.T) {
 				return &auth.Token{}, fmt.Errorf("foo: %w", auth.ErrInvalidToken)
 			},
 		}, nil)
-		m := NewAuth(verifier, log.NewNopLogger())
+		m := NewAuth(verifier, nil, log.NewNopLogger())
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -156,7 +245,7 @@ func TestAuth(t *testing.T) {
 				return &auth.Token{}, fmt.Errorf("foo: %w", auth.ErrExpiredToken)
 			},
 		}, nil)
-		m := NewAuth(verifier, log.NewNopLogger())
+		m := NewAuth(verifier, nil, log.NewNopLogger())
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -173,6 +262,69 @@ func TestAuth(t *testing.T) {
 		assert.Equal(t, "expired token", errMessage.Error)
 	})
 
+	t.Run("revoked token", func(t *testing.T) {
+		revocations := auth.NewRevocationList()
+		revocations.Revoke(auth.Revocation{
+			Type:   auth.RevocationTypeSubject,
+			Value:  "my-subject",
+			Expiry: time.Now().Add(time.Hour),
+		})
+		verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+			handler: func(_ string) (*auth.Token, error) {
+				return &auth.Token{Subject: "my-subject"}, nil
+			},
+		}, nil, auth.WithRevocations(revocations))
+		m := NewAuth(verifier, nil, log.NewNopLogger())
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		c.Request.Header.Add("Authorization", "Bearer 123")
+
+		m.Verify(c)
+
+		resp := w.Result()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+		var errMessage errorMessage
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&errMessage))
+		assert.Equal(t, "revoked token", errMessage.Error)
+	})
+
+	t.Run("revoked during request", func(t *testing.T) {
+		revocations := auth.NewRevocationList()
+		verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+			handler: func(_ string) (*auth.Token, error) {
+				return &auth.Token{ID: "my-id"}, nil
+			},
+		}, nil, auth.WithRevocations(revocations))
+		m := NewAuth(verifier, nil, log.NewNopLogger())
+
+		w := httptest.NewRecorder()
+		c, router := gin.CreateTestContext(w)
+		router.Use(m.Verify)
+		router.GET("/foo", func(c *gin.Context) {
+			revocations.Revoke(auth.Revocation{
+				Type:   auth.RevocationTypeID,
+				Value:  "my-id",
+				Expiry: time.Now().Add(time.Hour),
+			})
+
+			// The request context should be cancelled.
+			<-c.Request.Context().Done()
+			assert.Equal(
+				t, auth.ErrRevokedToken, context.Cause(c.Request.Context()),
+			)
+			c.Status(http.StatusOK)
+		})
+		c.Request = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		c.Request.Header.Add("Authorization", "Bearer 123")
+
+		router.HandleContext(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
 	t.Run("unknown tenant", func(t *testing.T) {
 		verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
 			handler: func(token string) (*auth.Token, error) {
@@ -180,7 +332,7 @@ func TestAuth(t *testing.T) {
 				return &auth.Token{}, fmt.Errorf("foo: %w", auth.ErrExpiredToken)
 			},
 		}, nil)
-		m := NewAuth(verifier, log.NewNopLogger())
+		m := NewAuth(verifier, nil, log.NewNopLogger())
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -205,7 +357,7 @@ func TestAuth(t *testing.T) {
 				return &auth.Token{}, fmt.Errorf("unknown")
 			},
 		}, nil)
-		m := NewAuth(verifier, log.NewNopLogger())
+		m := NewAuth(verifier, nil, log.NewNopLogger())
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -219,7 +371,7 @@ func TestAuth(t *testing.T) {
 	})
 
 	t.Run("unsupported auth type", func(t *testing.T) {
-		m := NewAuth(nil, log.NewNopLogger())
+		m := NewAuth(nil, nil, log.NewNopLogger())
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -236,8 +388,38 @@ func TestAuth(t *testing.T) {
 		assert.Equal(t, "unsupported auth type", errMessage.Error)
 	})
 
+	t.Run("audit failure", func(t *testing.T) {
+		// Tenant 1 isn't configured.
+		verifier := auth.NewMultiTenantVerifier(nil, nil)
+		sink := &fakeAuditSink{}
+		auditLogger := audit.NewLogger(
+			"node-1", audit.Config{}, []audit.Sink{sink}, log.NewNopLogger(),
+		)
+		m := NewAuth(verifier, auditLogger.WithListener("proxy"), log.NewNopLogger())
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest("GET", "http://example.com/foo", nil)
+		c.Request.RemoteAddr = "10.26.104.56:5000"
+		c.Request.Header.Add("Authorization", "Bearer 123")
+		c.Request.Header.Add("x-piko-tenant-id", "tenant-1")
+
+		m.Verify(c)
+		assert.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+
+		auditLogger.Flush(context.Background())
+		assert.Len(t, sink.events, 1)
+		event := sink.events[0]
+		assert.Equal(t, audit.TypeAuthFailed, event.Type)
+		assert.Equal(t, "proxy", event.Listener)
+		assert.Equal(t, "10.26.104.56", event.ClientIP)
+		assert.Equal(t, "tenant-1", event.TenantID)
+		assert.Equal(t, "/foo", event.Path)
+		assert.Equal(t, "unknown tenant", event.Reason)
+	})
+
 	t.Run("missing authorization header", func(t *testing.T) {
-		m := NewAuth(nil, log.NewNopLogger())
+		m := NewAuth(nil, nil, log.NewNopLogger())
 
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/andydunstall/piko/pkg/compress"
+)
+
+type compressWriter struct {
+	gin.ResponseWriter
+
+	w *compress.ResponseWriter
+}
+
+func (w *compressWriter) WriteHeader(statusCode int) {
+	w.w.WriteHeader(statusCode)
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	if !w.w.Written() {
+		w.w.WriteHeader(w.ResponseWriter.Status())
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	return w.w.Write(b)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return io.WriteString(w.w, s)
+}
+
+func (w *compressWriter) Written() bool {
+	return w.w.Written()
+}
+
+func (w *compressWriter) Flush() {
+	w.w.Flush()
+}
+
+// NewCompression creates middleware to compress responses based on the
+// request 'Accept-Encoding' header.
+//
+// Upgraded and streaming responses are never compressed.
+func NewCompression(conf compress.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead || c.Request.Header.Get("Upgrade") != "" {
+			c.Next()
+			return
+		}
+
+		algorithm := conf.Negotiate(c.Request.Header.Get("Accept-Encoding"))
+		if algorithm == "" {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{
+			ResponseWriter: c.Writer,
+			w:              compress.NewResponseWriter(c.Writer, algorithm, &conf),
+		}
+		c.Writer = w
+		defer func() {
+			_ = w.w.Close()
+			c.Writer = w.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/textproto"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -73,63 +74,100 @@ func (l *logHeaderFilter) Filter(h http.Header) http.Header {
 	return h
 }
 
-// NewLogger creates logging middleware for the access log.
-func NewLogger(config log.AccessLogConfig, logger log.Logger) gin.HandlerFunc {
-	logger = logger.WithSubsystem(logger.Subsystem() + ".access")
-
-	requestHeaderFilter := newLogHeaderFilter(
-		config.RequestHeaders.AllowList,
-		config.RequestHeaders.BlockList,
-	)
-	responseHeaderFilter := newLogHeaderFilter(
-		config.ResponseHeaders.AllowList,
-		config.ResponseHeaders.BlockList,
-	)
+// accessLogState contains the parsed access log configuration.
+type accessLogState struct {
+	disable              bool
+	level                zapcore.Level
+	requestHeaderFilter  logHeaderFilter
+	responseHeaderFilter logHeaderFilter
+}
 
+func newAccessLogState(config log.AccessLogConfig) *accessLogState {
 	level, err := log.ZapLevelFromString(config.Level)
 	if err != nil {
 		// Validated on boot so must not happen.
 		panic("invalid log level")
 	}
 
-	return func(c *gin.Context) {
-		s := time.Now()
+	return &accessLogState{
+		disable: config.Disable,
+		level:   level,
+		requestHeaderFilter: newLogHeaderFilter(
+			config.RequestHeaders.AllowList,
+			config.RequestHeaders.BlockList,
+		),
+		responseHeaderFilter: newLogHeaderFilter(
+			config.ResponseHeaders.AllowList,
+			config.ResponseHeaders.BlockList,
+		),
+	}
+}
 
-		c.Next()
+// AccessLogger is logging middleware for the access log, whose
+// configuration can be updated without restarting.
+type AccessLogger struct {
+	state atomic.Pointer[accessLogState]
 
-		if config.Disable {
-			// Access log disabled.
-			return
-		}
+	logger log.Logger
+}
 
-		// Ignore internal endpoints.
-		if strings.HasPrefix(c.Request.URL.Path, "/_piko") {
-			return
-		}
+func NewAccessLogger(config log.AccessLogConfig, logger log.Logger) *AccessLogger {
+	l := &AccessLogger{
+		logger: logger.WithSubsystem(logger.Subsystem() + ".access"),
+	}
+	l.Update(config)
+	return l
+}
 
-		// Note filter will modify the request/response headers, though
-		// they have already been written so it doesn't matter.
-		requestHeaders := requestHeaderFilter.Filter(c.Request.Header)
-		responseHeaders := responseHeaderFilter.Filter(c.Writer.Header())
-
-		req := &loggedRequest{
-			Proto:           c.Request.Proto,
-			Method:          c.Request.Method,
-			Host:            c.Request.Host,
-			Path:            c.Request.URL.Path,
-			RequestHeaders:  requestHeaders,
-			ResponseHeaders: responseHeaders,
-			Status:          c.Writer.Status(),
-			Duration:        time.Since(s).String(),
-		}
+// Update replaces the access log configuration.
+func (l *AccessLogger) Update(config log.AccessLogConfig) {
+	l.state.Store(newAccessLogState(config))
+}
 
-		recordLevel := level
-		// If the response is a server error, increase the log level to a
-		// minimum of 'warn'.
-		if c.Writer.Status() >= http.StatusInternalServerError && recordLevel < zapcore.WarnLevel {
-			recordLevel = zapcore.WarnLevel
-		}
+// Handler logs the request once handled.
+func (l *AccessLogger) Handler(c *gin.Context) {
+	s := time.Now()
+
+	c.Next()
+
+	state := l.state.Load()
+	if state.disable {
+		// Access log disabled.
+		return
+	}
 
-		logger.Log(recordLevel, "request", zap.Any("request", req))
+	// Ignore internal endpoints.
+	if strings.HasPrefix(c.Request.URL.Path, "/_piko") {
+		return
 	}
+
+	// Note filter will modify the request/response headers, though
+	// they have already been written so it doesn't matter.
+	requestHeaders := state.requestHeaderFilter.Filter(c.Request.Header)
+	responseHeaders := state.responseHeaderFilter.Filter(c.Writer.Header())
+
+	req := &loggedRequest{
+		Proto:           c.Request.Proto,
+		Method:          c.Request.Method,
+		Host:            c.Request.Host,
+		Path:            c.Request.URL.Path,
+		RequestHeaders:  requestHeaders,
+		ResponseHeaders: responseHeaders,
+		Status:          c.Writer.Status(),
+		Duration:        time.Since(s).String(),
+	}
+
+	recordLevel := state.level
+	// If the response is a server error, increase the log level to a
+	// minimum of 'warn'.
+	if c.Writer.Status() >= http.StatusInternalServerError && recordLevel < zapcore.WarnLevel {
+		recordLevel = zapcore.WarnLevel
+	}
+
+	l.logger.Log(recordLevel, "request", zap.Any("request", req))
+}
+
+// NewLogger creates logging middleware for the access log.
+func NewLogger(config log.AccessLogConfig, logger log.Logger) gin.HandlerFunc {
+	return NewAccessLogger(config, logger).Handler
 }
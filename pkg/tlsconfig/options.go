@@ -0,0 +1,156 @@
+// Package tlsconfig contains TLS options shared by the server, agent and
+// forward configurations.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+var (
+	versions = map[string]uint16{
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	curves = map[string]tls.CurveID{
+		"X25519":         tls.X25519,
+		"X25519MLKEM768": tls.X25519MLKEM768,
+		"P256":           tls.CurveP256,
+		"P384":           tls.CurveP384,
+		"P521":           tls.CurveP521,
+	}
+)
+
+// Options configures the permitted TLS versions and algorithms.
+type Options struct {
+	// MinVersion is the minimum TLS version, either '1.2' or '1.3'.
+	//
+	// Defaults to TLS 1.2.
+	MinVersion string `json:"min_version" yaml:"min_version"`
+
+	// CipherSuites contains the permitted TLS 1.2 cipher suites, such as
+	// 'TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256'.
+	//
+	// Defaults to the Go defaults. TLS 1.3 cipher suites aren't
+	// configurable.
+	CipherSuites []string `json:"cipher_suites" yaml:"cipher_suites"`
+
+	// CurvePreferences contains the permitted key exchange mechanisms in
+	// order of preference, such as 'X25519' or 'P256'.
+	//
+	// Defaults to the Go defaults.
+	CurvePreferences []string `json:"curve_preferences" yaml:"curve_preferences"`
+}
+
+func (o *Options) Validate() error {
+	if o.MinVersion != "" {
+		if _, ok := versions[o.MinVersion]; !ok {
+			return fmt.Errorf("unsupported min version: %s", o.MinVersion)
+		}
+	}
+	if len(o.CipherSuites) > 0 && o.MinVersion == "1.3" {
+		return fmt.Errorf("cipher suites only apply to tls 1.2")
+	}
+	for _, name := range o.CipherSuites {
+		if _, err := cipherSuite(name); err != nil {
+			return err
+		}
+	}
+	for _, name := range o.CurvePreferences {
+		if _, ok := curves[name]; !ok {
+			return fmt.Errorf("unsupported curve: %s", name)
+		}
+	}
+	return nil
+}
+
+func (o *Options) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	fs.StringVar(
+		&o.MinVersion,
+		prefix+"min-version",
+		o.MinVersion,
+		`
+Minimum TLS version, either '1.2' or '1.3'.
+
+Defaults to TLS 1.2.`,
+	)
+	fs.StringSliceVar(
+		&o.CipherSuites,
+		prefix+"cipher-suites",
+		o.CipherSuites,
+		`
+Permitted TLS 1.2 cipher suites, such as
+'TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256'. Insecure cipher suites aren't
+supported.
+
+TLS 1.3 cipher suites aren't configurable.
+
+Defaults to the Go defaults.`,
+	)
+	fs.StringSliceVar(
+		&o.CurvePreferences,
+		prefix+"curve-preferences",
+		o.CurvePreferences,
+		`
+Permitted key exchange mechanisms in order of preference. Supports 'X25519',
+'X25519MLKEM768', 'P256', 'P384' and 'P521'.
+
+Defaults to the Go defaults.`,
+	)
+}
+
+// Apply applies the options to the TLS configuration.
+func (o *Options) Apply(tlsConfig *tls.Config) error {
+	tlsConfig.MinVersion = tls.VersionTLS12
+	if o.MinVersion != "" {
+		version, ok := versions[o.MinVersion]
+		if !ok {
+			return fmt.Errorf("unsupported min version: %s", o.MinVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	tlsConfig.CipherSuites = nil
+	for _, name := range o.CipherSuites {
+		id, err := cipherSuite(name)
+		if err != nil {
+			return err
+		}
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, id)
+	}
+
+	tlsConfig.CurvePreferences = nil
+	for _, name := range o.CurvePreferences {
+		curve, ok := curves[name]
+		if !ok {
+			return fmt.Errorf("unsupported curve: %s", name)
+		}
+		tlsConfig.CurvePreferences = append(tlsConfig.CurvePreferences, curve)
+	}
+
+	return nil
+}
+
+// cipherSuite returns the ID of the TLS 1.2 cipher suite with the given name.
+func cipherSuite(name string) (uint16, error) {
+	for _, suite := range tls.CipherSuites() {
+		if !strings.EqualFold(suite.Name, name) {
+			continue
+		}
+		if !slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			return 0, fmt.Errorf("cipher suite not configurable: %s", name)
+		}
+		return suite.ID, nil
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if strings.EqualFold(suite.Name, name) {
+			return 0, fmt.Errorf("insecure cipher suite: %s", name)
+		}
+	}
+	return 0, fmt.Errorf("unsupported cipher suite: %s", name)
+}
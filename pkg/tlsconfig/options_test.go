@@ -0,0 +1,103 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions_Apply(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		var opts Options
+		require.NoError(t, opts.Validate())
+
+		var tlsConfig tls.Config
+		require.NoError(t, opts.Apply(&tlsConfig))
+		assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+		assert.Nil(t, tlsConfig.CipherSuites)
+		assert.Nil(t, tlsConfig.CurvePreferences)
+	})
+
+	t.Run("options", func(t *testing.T) {
+		opts := Options{
+			MinVersion: "1.2",
+			CipherSuites: []string{
+				"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+				"tls_ecdhe_rsa_with_aes_256_gcm_sha384",
+			},
+			CurvePreferences: []string{"X25519", "P384"},
+		}
+		require.NoError(t, opts.Validate())
+
+		var tlsConfig tls.Config
+		require.NoError(t, opts.Apply(&tlsConfig))
+		assert.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MinVersion)
+		assert.Equal(t, []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		}, tlsConfig.CipherSuites)
+		assert.Equal(t, []tls.CurveID{
+			tls.X25519, tls.CurveP384,
+		}, tlsConfig.CurvePreferences)
+	})
+
+	t.Run("tls 1.3", func(t *testing.T) {
+		opts := Options{
+			MinVersion: "1.3",
+		}
+		require.NoError(t, opts.Validate())
+
+		var tlsConfig tls.Config
+		require.NoError(t, opts.Apply(&tlsConfig))
+		assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+	})
+}
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		err  string
+	}{
+		{
+			name: "unsupported version",
+			opts: Options{MinVersion: "1.1"},
+			err:  "unsupported min version: 1.1",
+		},
+		{
+			name: "tls 1.3 cipher suites",
+			opts: Options{
+				MinVersion:   "1.3",
+				CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+			},
+			err: "cipher suites only apply to tls 1.2",
+		},
+		{
+			name: "tls 1.3 cipher suite",
+			opts: Options{CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+			err:  "cipher suite not configurable: TLS_AES_128_GCM_SHA256",
+		},
+		{
+			name: "insecure cipher suite",
+			opts: Options{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			err:  "insecure cipher suite: TLS_RSA_WITH_RC4_128_SHA",
+		},
+		{
+			name: "unknown cipher suite",
+			opts: Options{CipherSuites: []string{"unknown"}},
+			err:  "unsupported cipher suite: unknown",
+		},
+		{
+			name: "unknown curve",
+			opts: Options{CurvePreferences: []string{"P224"}},
+			err:  "unsupported curve: P224",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.EqualError(t, tt.opts.Validate(), tt.err)
+		})
+	}
+}
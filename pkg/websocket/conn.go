@@ -14,6 +14,8 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"github.com/andydunstall/piko/pkg/build"
 )
 
 // retryableStatusCodes contains a set of HTTP status codes that should be
@@ -140,6 +142,9 @@ func Dial(ctx context.Context, u string, opts ...DialOption) (*Conn, error) {
 	}
 
 	header := make(http.Header)
+	// Include the Piko version so the server can identify the agent
+	// version.
+	header.Set("User-Agent", "piko/"+build.Version)
 	if options.token != "" {
 		header.Set("Authorization", "Bearer "+options.token)
 	}
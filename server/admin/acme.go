@@ -0,0 +1,48 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/andydunstall/piko/server/certs"
+)
+
+// AddACMECache registers a route for other nodes in the cluster to fetch
+// entries from the local ACME cache, such as certificates and challenge
+// responses.
+//
+// Since the cache contains private keys, requests must include the cluster
+// key rather than using admin authentication.
+func (s *Server) AddACMECache(
+	get func(ctx context.Context, key string) ([]byte, error),
+	clusterKey string,
+) {
+	s.router.GET("/acme/cache/:key", func(c *gin.Context) {
+		if subtle.ConstantTimeCompare(
+			[]byte(c.Request.Header.Get(certs.ClusterKeyHeader)),
+			[]byte(clusterKey),
+		) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid cluster key"})
+			return
+		}
+
+		data, err := get(c.Request.Context(), c.Param("key"))
+		if errors.Is(err, autocert.ErrCacheMiss) {
+			c.Status(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			s.logger.Warn("failed to get acme cache entry", zap.Error(err))
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Data(http.StatusOK, "application/octet-stream", data)
+	})
+	s.clusterRoutes = append(s.clusterRoutes, "/acme/cache/")
+}
@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AddReload registers a route to reload the server configuration using the
+// given function.
+func (s *Server) AddReload(reload func() error) {
+	s.router.POST("/reload", func(c *gin.Context) {
+		if err := reload(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+}
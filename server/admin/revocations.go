@@ -0,0 +1,60 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/piko/pkg/auth"
+)
+
+// AddRevocations registers routes to list and add token revocations.
+//
+// Revocations added to one node are propagated to the rest of the cluster.
+func (s *Server) AddRevocations(revocations *auth.RevocationList) {
+	s.revocations = revocations
+
+	group := s.router.Group("/revocations")
+	group.GET("", s.listRevocationsRoute)
+	group.POST("", s.revokeRoute)
+}
+
+// listRevocationsRoute returns the active token revocations.
+func (s *Server) listRevocationsRoute(c *gin.Context) {
+	c.JSON(http.StatusOK, s.revocations.List())
+}
+
+// revokeRoute revokes all tokens with the requested 'jti' or 'sub' for the
+// requested tenant, or the default tenant if no tenant is given.
+//
+// The revocation should expire at the same time as the revoked tokens.
+// Sessions authenticated with a revoked token are disconnected.
+func (s *Server) revokeRoute(c *gin.Context) {
+	var r auth.Revocation
+	if err := c.BindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	if err := r.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if r.Expired(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expired"})
+		return
+	}
+
+	if s.revocations.Revoke(r) {
+		s.logger.Info(
+			"token revoked",
+			zap.String("tenant-id", r.TenantID),
+			zap.String("type", r.Type),
+			zap.String("value", r.Value),
+			zap.Time("expiry", r.Expiry),
+		)
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
@@ -15,11 +16,13 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/andydunstall/piko/pkg/audit"
 	"github.com/andydunstall/piko/pkg/auth"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/pkg/middleware"
 	"github.com/andydunstall/piko/server/cluster"
 	"github.com/andydunstall/piko/server/status"
+	"github.com/andydunstall/piko/server/upstream"
 )
 
 // Server is the admin HTTP server, which exposes endpoints for metrics, health
@@ -33,10 +36,35 @@ type Server struct {
 
 	proxy *ReverseProxy
 
+	// revocations contains the revoked tokens, or nil if the revocations API
+	// isn't enabled.
+	revocations *auth.RevocationList
+
+	// issuer signs issued tokens, or nil if the token issuance API isn't
+	// enabled.
+	issuer *auth.Issuer
+
+	// upstreams manages the upstreams connected to the local node, or nil if
+	// the upstream API isn't enabled.
+	upstreams *upstream.LoadBalancedManager
+
 	httpServer *http.Server
 
 	router *gin.Engine
 
+	// tenantRoutes contains the route prefixes clients belonging to a tenant
+	// can access.
+	tenantRoutes []string
+
+	// clusterRoutes contains the route prefixes used by other nodes in the
+	// cluster. These routes authenticate requests themselves, so skip admin
+	// authentication.
+	clusterRoutes []string
+
+	// auditLogger records admin actions, or is nil if audit logging is
+	// disabled.
+	auditLogger *audit.Logger
+
 	logger log.Logger
 }
 
@@ -45,6 +73,7 @@ func NewServer(
 	registry *prometheus.Registry,
 	verifier *auth.MultiTenantVerifier,
 	tlsConfig *tls.Config,
+	auditLogger *audit.Logger,
 	logger log.Logger,
 ) *Server {
 	logger = logger.WithSubsystem("admin")
@@ -67,9 +96,18 @@ func NewServer(
 	// Recover from panics.
 	router.Use(gin.CustomRecoveryWithWriter(nil, server.panicRoute))
 
+	if auditLogger != nil {
+		auditLogger = auditLogger.WithListener("admin")
+		server.auditLogger = auditLogger
+		// Add before authentication and forwarding so the audit log
+		// includes rejected and forwarded requests.
+		router.Use(server.auditInterceptor)
+	}
+
 	if verifier != nil {
-		authMiddleware := middleware.NewAuth(verifier, logger)
-		router.Use(authMiddleware.Verify)
+		authMiddleware := middleware.NewAuth(verifier, auditLogger, logger)
+		router.Use(server.clusterInterceptor(authMiddleware.Verify))
+		router.Use(server.tenantInterceptor)
 	}
 
 	if clusterState != nil {
@@ -111,6 +149,13 @@ func (s *Server) AddStatus(route string, handler status.Handler) {
 	handler.Register(group)
 }
 
+// AddTenantStatus adds a status handler that clients belonging to a tenant
+// can access. The handler must only return the status of the clients tenant.
+func (s *Server) AddTenantStatus(route string, handler status.Handler) {
+	s.AddStatus(route, handler)
+	s.tenantRoutes = append(s.tenantRoutes, "/status"+route+"/")
+}
+
 func (s *Server) SetReady(ready bool) {
 	s.ready.Store(ready)
 }
@@ -151,6 +196,93 @@ func (s *Server) readyRoute(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+// tenantInterceptor restricts clients belonging to a tenant to the health
+// routes and tenant status routes.
+func (s *Server) tenantInterceptor(c *gin.Context) {
+	tenantID := status.TenantID(c)
+	if tenantID == "" {
+		c.Next()
+		return
+	}
+
+	path := c.FullPath()
+	if path == "/health" || path == "/ready" {
+		c.Next()
+		return
+	}
+	for _, prefix := range s.tenantRoutes {
+		if strings.HasPrefix(path, prefix) {
+			c.Next()
+			return
+		}
+	}
+
+	s.logger.Warn(
+		"route not permitted for tenant",
+		zap.String("tenant-id", tenantID),
+		zap.String("path", c.Request.URL.Path),
+	)
+	c.AbortWithStatusJSON(
+		http.StatusForbidden,
+		gin.H{"error": "route not permitted"},
+	)
+}
+
+// clusterInterceptor skips the given auth handler for cluster routes.
+func (s *Server) clusterInterceptor(auth gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.FullPath()
+		for _, prefix := range s.clusterRoutes {
+			if strings.HasPrefix(path, prefix) {
+				c.Next()
+				return
+			}
+		}
+		auth(c)
+	}
+}
+
+// auditInterceptor records admin actions to the audit log, which includes
+// requests that may modify state and requests forwarded to other nodes.
+func (s *Server) auditInterceptor(c *gin.Context) {
+	path := c.FullPath()
+	for _, prefix := range s.clusterRoutes {
+		if strings.HasPrefix(path, prefix) {
+			c.Next()
+			return
+		}
+	}
+
+	forward := c.Query("forward")
+	if s.clusterState != nil && forward == s.clusterState.LocalID() {
+		forward = ""
+	}
+	method := c.Request.Method
+	readOnly := method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+	if readOnly && forward == "" {
+		c.Next()
+		return
+	}
+
+	c.Next()
+
+	event := audit.Event{
+		Type:     audit.TypeAdminRequest,
+		ClientIP: c.ClientIP(),
+		Method:   method,
+		Path:     c.Request.URL.Path,
+		Status:   c.Writer.Status(),
+		Forward:  forward,
+	}
+	if token, ok := c.Get(middleware.TokenContextKey); ok {
+		adminToken := token.(*auth.Token)
+		event.TenantID = adminToken.TenantID
+		event.Subject = adminToken.Subject
+		event.TokenID = adminToken.ID
+	}
+	s.auditLogger.Record(event)
+}
+
 // forwardInterceptor intercepts all admin requests. If the request has a
 // 'forward' query, the request is forwarded to the node with the requested ID.
 func (s *Server) forwardInterceptor(c *gin.Context) {
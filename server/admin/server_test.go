@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"testing"
@@ -14,12 +16,15 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme/autocert"
 
+	"github.com/andydunstall/piko/pkg/audit"
 	"github.com/andydunstall/piko/pkg/auth"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/pkg/testutil"
 	"github.com/andydunstall/piko/server/cluster"
 	"github.com/andydunstall/piko/server/status"
+	"github.com/andydunstall/piko/server/upstream"
 )
 
 type fakeStatus struct {
@@ -45,6 +50,19 @@ func (v *fakeVerifier) Verify(token string) (*auth.Token, error) {
 
 var _ auth.Verifier = &fakeVerifier{}
 
+type fakeAuditSink struct {
+	events []audit.Event
+}
+
+func (s *fakeAuditSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeAuditSink) Write(_ context.Context, events []audit.Event) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
 func TestServer_AdminRoutes(t *testing.T) {
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
@@ -54,6 +72,7 @@ func TestServer_AdminRoutes(t *testing.T) {
 		prometheus.NewRegistry(),
 		nil,
 		nil,
+		nil,
 		log.NewNopLogger(),
 	)
 	go func() {
@@ -122,6 +141,7 @@ func TestServer_StatusRoutes(t *testing.T) {
 		prometheus.NewRegistry(),
 		nil,
 		nil,
+		nil,
 		log.NewNopLogger(),
 	)
 	s.AddStatus("/mystatus", &fakeStatus{})
@@ -155,8 +175,428 @@ func TestServer_StatusRoutes(t *testing.T) {
 	})
 }
 
+func TestServer_Revocations(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	revocations := auth.NewRevocationList()
+
+	s := NewServer(
+		nil,
+		prometheus.NewRegistry(),
+		nil,
+		nil,
+		nil,
+		log.NewNopLogger(),
+	)
+	s.AddRevocations(revocations)
+
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	url := fmt.Sprintf("http://%s/revocations", ln.Addr().String())
+
+	t.Run("revoke ok", func(t *testing.T) {
+		r := auth.Revocation{
+			Type:   auth.RevocationTypeID,
+			Value:  "my-id",
+			Expiry: time.Now().Add(time.Hour).UTC().Truncate(time.Second),
+		}
+		body, err := json.Marshal(r)
+		require.NoError(t, err)
+
+		resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		assert.True(t, revocations.Revoked(&auth.Token{ID: "my-id"}))
+
+		listResp, err := http.Get(url)
+		require.NoError(t, err)
+		defer listResp.Body.Close()
+
+		var listed []auth.Revocation
+		require.NoError(t, json.NewDecoder(listResp.Body).Decode(&listed))
+		require.Len(t, listed, 1)
+		assert.Equal(t, r.Value, listed[0].Value)
+		assert.True(t, r.Expiry.Equal(listed[0].Expiry))
+	})
+
+	t.Run("revoke invalid", func(t *testing.T) {
+		for _, body := range []string{
+			`{"type": "foo", "value": "my-id", "expiry": "2100-01-01T00:00:00Z"}`,
+			`{"type": "jti", "value": "my-id"}`,
+			`{"type": "jti", "value": "my-id", "expiry": "2000-01-01T00:00:00Z"}`,
+			`{`,
+		} {
+			resp, err := http.Post(
+				url, "application/json", bytes.NewReader([]byte(body)),
+			)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+}
+
+func TestServer_Upstreams(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	state := cluster.NewState(&cluster.Node{ID: "local"}, log.NewNopLogger())
+	upstreams := upstream.NewLoadBalancedManager(state, nil)
+
+	s := NewServer(
+		nil,
+		prometheus.NewRegistry(),
+		nil,
+		nil,
+		nil,
+		log.NewNopLogger(),
+	)
+	s.AddUpstreams(upstreams)
+
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	url := fmt.Sprintf("http://%s/upstream/disconnect", ln.Addr().String())
+
+	t.Run("disconnect ok", func(t *testing.T) {
+		resp, err := http.Post(
+			url,
+			"application/json",
+			bytes.NewReader([]byte(`{"endpoint_id": "my-endpoint", "force": true}`)),
+		)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var disconnectResp upstream.DisconnectResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&disconnectResp))
+		assert.NotNil(t, disconnectResp.Disconnected)
+		assert.Empty(t, disconnectResp.Disconnected)
+	})
+
+	t.Run("disconnect invalid", func(t *testing.T) {
+		for _, body := range []string{
+			`{}`,
+			`{"force": true}`,
+			`{`,
+		} {
+			resp, err := http.Post(
+				url, "application/json", bytes.NewReader([]byte(body)),
+			)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		}
+	})
+}
+
 // TestServer_Forward tests forwarding an admin request to another node
 // in the cluster.
+func TestServer_Reload(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	var reloadErr error
+	var reloads int
+
+	s := NewServer(
+		nil,
+		prometheus.NewRegistry(),
+		nil,
+		nil,
+		nil,
+		log.NewNopLogger(),
+	)
+	s.AddReload(func() error {
+		reloads++
+		return reloadErr
+	})
+
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	url := fmt.Sprintf("http://%s/reload", ln.Addr().String())
+
+	t.Run("ok", func(t *testing.T) {
+		resp, err := http.Post(url, "application/json", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 1, reloads)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		reloadErr = fmt.Errorf("invalid config")
+
+		resp, err := http.Post(url, "application/json", nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		var body struct {
+			Error string `json:"error"`
+		}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.Equal(t, "invalid config", body.Error)
+	})
+}
+
+func TestServer_Audit(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+		handler: func(token string) (*auth.Token, error) {
+			if token != "123" {
+				return nil, auth.ErrInvalidToken
+			}
+			return &auth.Token{
+				Expiry:  time.Now().Add(time.Hour),
+				Subject: "operator",
+			}, nil
+		},
+	}, nil)
+
+	sink := &fakeAuditSink{}
+	auditLogger := audit.NewLogger(
+		"node-1", audit.Config{}, []audit.Sink{sink}, log.NewNopLogger(),
+	)
+
+	s := NewServer(
+		nil,
+		prometheus.NewRegistry(),
+		verifier,
+		nil,
+		auditLogger,
+		log.NewNopLogger(),
+	)
+	s.AddReload(func() error {
+		return nil
+	})
+
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	request := func(method string, path string, token string) {
+		url := fmt.Sprintf("http://%s%s", ln.Addr().String(), path)
+		req, _ := http.NewRequest(method, url, nil)
+		req.Header.Add("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// Read only requests aren't recorded.
+	request(http.MethodGet, "/health", "123")
+	request(http.MethodPost, "/reload", "123")
+	request(http.MethodPost, "/reload", "456")
+
+	auditLogger.Flush(context.Background())
+
+	require.Len(t, sink.events, 3)
+
+	assert.Equal(t, audit.TypeAdminRequest, sink.events[0].Type)
+	assert.Equal(t, "admin", sink.events[0].Listener)
+	assert.Equal(t, http.MethodPost, sink.events[0].Method)
+	assert.Equal(t, "/reload", sink.events[0].Path)
+	assert.Equal(t, http.StatusOK, sink.events[0].Status)
+	assert.Equal(t, "operator", sink.events[0].Subject)
+
+	// The rejected request records both the auth failure and the
+	// request.
+	assert.Equal(t, audit.TypeAuthFailed, sink.events[1].Type)
+	assert.Equal(t, "invalid token", sink.events[1].Reason)
+	assert.Equal(t, audit.TypeAdminRequest, sink.events[2].Type)
+	assert.Equal(t, http.StatusUnauthorized, sink.events[2].Status)
+	assert.Equal(t, "", sink.events[2].Subject)
+}
+
+func TestServer_ACMECache(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Enable admin authentication to verify cluster routes skip it.
+	verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+		handler: func(string) (*auth.Token, error) {
+			return nil, auth.ErrInvalidToken
+		},
+	}, nil)
+
+	s := NewServer(
+		nil,
+		prometheus.NewRegistry(),
+		verifier,
+		nil,
+		nil,
+		log.NewNopLogger(),
+	)
+	s.AddACMECache(func(_ context.Context, key string) ([]byte, error) {
+		if key != "my-key" {
+			return nil, autocert.ErrCacheMiss
+		}
+		return []byte("my-value"), nil
+	}, "cluster-key")
+
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	get := func(key string, clusterKey string) *http.Response {
+		url := fmt.Sprintf("http://%s/acme/cache/%s", ln.Addr().String(), key)
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Set("x-piko-cluster-key", clusterKey)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("ok", func(t *testing.T) {
+		resp := get("my-key", "cluster-key")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("my-value"), b)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		resp := get("unknown", "cluster-key")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("invalid cluster key", func(t *testing.T) {
+		resp := get("my-key", "invalid")
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("other routes require auth", func(t *testing.T) {
+		url := fmt.Sprintf("http://%s/health", ln.Addr().String())
+		resp, err := http.Get(url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestServer_Tokens(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	verifier := auth.NewMultiTenantVerifier(nil, map[string]auth.Verifier{
+		"tenant-1": &fakeVerifier{
+			handler: func(token string) (*auth.Token, error) {
+				assert.Equal(t, "123", token)
+				return &auth.Token{
+					Expiry:    time.Now().Add(time.Hour),
+					Endpoints: []string{"{tenant}-*"},
+				}, nil
+			},
+		},
+	})
+
+	issuerConf := auth.IssuerConfig{
+		HMACSecretKey: "my-secret",
+		MaxTTL:        time.Minute * 10,
+	}
+	issuer, err := issuerConf.Load()
+	require.NoError(t, err)
+
+	s := NewServer(
+		nil,
+		prometheus.NewRegistry(),
+		verifier,
+		nil,
+		nil,
+		log.NewNopLogger(),
+	)
+	s.AddTokens(issuer)
+
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	issue := func(body string) *http.Response {
+		url := fmt.Sprintf("http://%s/tokens", ln.Addr().String())
+		req, _ := http.NewRequest(
+			http.MethodPost, url, bytes.NewReader([]byte(body)),
+		)
+		req.Header.Add("Authorization", "Bearer 123")
+		req.Header.Add("x-piko-tenant-id", "tenant-1")
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("issue ok", func(t *testing.T) {
+		resp := issue(`{"subject": "my-subject", "listen": ["tenant-1-a"], "expires_in": 60}`)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var tokenResp TokenResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&tokenResp))
+
+		jwtVerifier := auth.NewJWTVerifier(&auth.LoadedConfig{
+			HMACSecretKey: []byte("my-secret"),
+		})
+		token, err := jwtVerifier.Verify(tokenResp.Token)
+		require.NoError(t, err)
+		assert.Equal(t, "my-subject", token.Subject)
+		assert.Equal(t, "tenant-1", token.TenantID)
+		assert.Equal(t, []string{"tenant-1-a"}, token.ListenEndpoints)
+		assert.Equal(t, tokenResp.ExpiresAt.Unix(), token.Expiry.Unix())
+	})
+
+	t.Run("issue not permitted", func(t *testing.T) {
+		for _, body := range []string{
+			// Endpoint outside the callers tenant.
+			`{"endpoints": ["tenant-2-a"]}`,
+			// Different tenant.
+			`{"endpoints": ["tenant-1-a"], "tenant_id": "tenant-2"}`,
+			// Unscoped token.
+			`{}`,
+		} {
+			resp := issue(body)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode, body)
+		}
+	})
+
+	t.Run("issue invalid", func(t *testing.T) {
+		for _, body := range []string{
+			// Exceeds the max TTL.
+			`{"endpoints": ["tenant-1-a"], "expires_in": 3600}`,
+			`{"endpoints": ["tenant-1-a"], "expires_in": -1}`,
+			// Overflows when converted to a duration.
+			`{"endpoints": ["tenant-1-a"], "expires_in": 9223372036854775807}`,
+			`{"endpoints": ["tenant-1-a"], "expires_in": 18446744074}`,
+			`{`,
+		} {
+			resp := issue(body)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+		}
+	})
+}
+
 func TestServer_Forward(t *testing.T) {
 	ln1, err := net.Listen("tcp", "127.0.0.1:0")
 	require.NoError(t, err)
@@ -171,6 +611,7 @@ func TestServer_Forward(t *testing.T) {
 		prometheus.NewRegistry(),
 		nil,
 		nil,
+		nil,
 		log.NewNopLogger(),
 	)
 	// Note only node 1 registers the status route.
@@ -198,6 +639,7 @@ func TestServer_Forward(t *testing.T) {
 		prometheus.NewRegistry(),
 		nil,
 		nil,
+		nil,
 		log.NewNopLogger(),
 	)
 
@@ -249,6 +691,7 @@ func TestServer_Authentication(t *testing.T) {
 			prometheus.NewRegistry(),
 			verifier,
 			nil,
+			nil,
 			log.NewNopLogger(),
 		)
 		go func() {
@@ -284,6 +727,7 @@ func TestServer_Authentication(t *testing.T) {
 			prometheus.NewRegistry(),
 			verifier,
 			nil,
+			nil,
 			log.NewNopLogger(),
 		)
 		go func() {
@@ -304,6 +748,63 @@ func TestServer_Authentication(t *testing.T) {
 	})
 }
 
+// TestServer_Tenants tests clients belonging to a tenant can only access
+// tenant status routes.
+func TestServer_Tenants(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	verifier := auth.NewMultiTenantVerifier(nil, map[string]auth.Verifier{
+		"tenant-1": &fakeVerifier{
+			handler: func(token string) (*auth.Token, error) {
+				assert.Equal(t, "123", token)
+				return &auth.Token{
+					Expiry: time.Now().Add(time.Hour),
+				}, nil
+			},
+		},
+	})
+
+	s := NewServer(
+		nil,
+		prometheus.NewRegistry(),
+		verifier,
+		nil,
+		nil,
+		log.NewNopLogger(),
+	)
+	s.AddStatus("/mystatus", &fakeStatus{})
+	s.AddTenantStatus("/mytenantstatus", &fakeStatus{})
+
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	for _, tt := range []struct {
+		path       string
+		statusCode int
+	}{
+		{"/health", http.StatusOK},
+		{"/status/mytenantstatus/foo", http.StatusOK},
+		{"/status/mystatus/foo", http.StatusForbidden},
+		{"/metrics", http.StatusForbidden},
+		{"/debug/pprof/", http.StatusForbidden},
+	} {
+		url := fmt.Sprintf("http://%s%s", ln.Addr().String(), tt.path)
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Add("Authorization", "Bearer 123")
+		req.Header.Add("x-piko-tenant-id", "tenant-1")
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, tt.statusCode, resp.StatusCode, tt.path)
+	}
+}
+
 func TestServer_TLS(t *testing.T) {
 	rootCAPool, cert, err := testutil.LocalTLSServerCert()
 	require.NoError(t, err)
@@ -319,6 +820,7 @@ func TestServer_TLS(t *testing.T) {
 		prometheus.NewRegistry(),
 		nil,
 		tlsConfig,
+		nil,
 		log.NewNopLogger(),
 	)
 	go func() {
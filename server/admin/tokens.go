@@ -0,0 +1,135 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/piko/pkg/auth"
+	"github.com/andydunstall/piko/pkg/middleware"
+)
+
+// TokenRequest is a request to issue a Piko token.
+type TokenRequest struct {
+	// Subject is the 'sub' claim of the issued token.
+	Subject string `json:"subject"`
+
+	// Endpoints contains the endpoints the token can both listen on and
+	// connect to.
+	Endpoints []string `json:"endpoints"`
+
+	// Listen contains the endpoints the token can listen on.
+	Listen []string `json:"listen"`
+
+	// Connect contains the endpoints the token can connect to.
+	Connect []string `json:"connect"`
+
+	// TenantID is the tenant of the issued token. Defaults to the tenant of
+	// the caller.
+	TenantID string `json:"tenant_id"`
+
+	// ExpiresIn is the lifetime of the issued token in seconds. Defaults to
+	// the maximum configured lifetime, or the expiry of the callers token if
+	// sooner.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+// TokenResponse contains an issued token.
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AddTokens registers a route to issue Piko tokens.
+//
+// Callers can only issue tokens with the endpoints, tenant and expiry they
+// are permitted to grant themselves, so tenants can also issue tokens within
+// their own tenant.
+func (s *Server) AddTokens(issuer *auth.Issuer) {
+	s.issuer = issuer
+
+	s.router.POST("/tokens", s.issueTokenRoute)
+	s.tenantRoutes = append(s.tenantRoutes, "/tokens")
+}
+
+// issueTokenRoute issues a token with the requested claims.
+func (s *Server) issueTokenRoute(c *gin.Context) {
+	// Issuing tokens requires the caller to authenticate, since otherwise
+	// any client could issue tokens.
+	t, ok := c.Get(middleware.TokenContextKey)
+	if !ok {
+		c.JSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "authentication required"},
+		)
+		return
+	}
+	token := t.(*auth.Token)
+
+	var r TokenRequest
+	if err := c.BindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	ttl := s.issuer.MaxTTL()
+	if r.ExpiresIn < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid expires in"})
+		return
+	}
+	// Compare in seconds before converting to a duration, since a large
+	// expires in would overflow the duration.
+	if float64(r.ExpiresIn) > s.issuer.MaxTTL().Seconds() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires in exceeds max ttl"})
+		return
+	}
+	if r.ExpiresIn > 0 {
+		ttl = time.Duration(r.ExpiresIn) * time.Second
+	}
+	expiry := time.Now().Add(ttl)
+	if r.ExpiresIn == 0 && !token.Expiry.IsZero() && token.Expiry.Before(expiry) {
+		// If no lifetime is requested, default to the callers own expiry
+		// if it expires before the maximum lifetime.
+		expiry = token.Expiry
+	}
+
+	if r.TenantID == "" {
+		r.TenantID = token.TenantID
+	}
+	claims := auth.PikoClaims{
+		Endpoints: r.Endpoints,
+		Listen:    r.Listen,
+		Connect:   r.Connect,
+		TenantID:  r.TenantID,
+	}
+	if err := token.CheckGrant(claims, expiry); err != nil {
+		s.logger.Warn(
+			"token grant not permitted",
+			zap.String("tenant-id", token.TenantID),
+			zap.Error(err),
+		)
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	issued, err := s.issuer.Issue(r.Subject, claims, expiry)
+	if err != nil {
+		s.logger.Error("failed to issue token", zap.Error(err))
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Info(
+		"token issued",
+		zap.String("subject", r.Subject),
+		zap.String("tenant-id", r.TenantID),
+		zap.Time("expiry", expiry),
+	)
+
+	c.JSON(http.StatusOK, TokenResponse{
+		Token:     issued,
+		ExpiresAt: expiry,
+	})
+}
@@ -0,0 +1,55 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/piko/server/upstream"
+)
+
+// AddUpstreams registers a route to disconnect upstreams connected to the
+// local node.
+//
+// To disconnect upstreams connected to another node, the request must be
+// forwarded to that node.
+func (s *Server) AddUpstreams(upstreams *upstream.LoadBalancedManager) {
+	s.upstreams = upstreams
+
+	group := s.router.Group("/upstream")
+	group.POST("/disconnect", s.disconnectUpstreamsRoute)
+}
+
+// disconnectUpstreamsRoute disconnects the upstreams with the requested
+// connection ID, endpoint ID or tenant ID.
+//
+// By default upstreams are disconnected gracefully with a GoAway, waiting for
+// open streams to complete. If 'force' is set the sessions are closed
+// immediately.
+func (s *Server) disconnectUpstreamsRoute(c *gin.Context) {
+	var r upstream.DisconnectRequest
+	if err := c.BindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+	if err := r.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	disconnected := s.upstreams.Disconnect(r.Filter(), r.Force)
+	for _, conn := range disconnected {
+		s.logger.Info(
+			"disconnecting upstream",
+			zap.String("connection-id", conn.ID),
+			zap.String("endpoint-id", conn.EndpointID),
+			zap.String("tenant-id", conn.TenantID),
+			zap.Bool("force", r.Force),
+		)
+	}
+
+	c.JSON(http.StatusOK, upstream.DisconnectResponse{
+		Disconnected: disconnected,
+	})
+}
@@ -0,0 +1,213 @@
+package authz
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/config"
+)
+
+const (
+	// ActionListen is the action of an upstream listening on an endpoint.
+	ActionListen = "listen"
+	// ActionConnect is the action of a client connecting to an endpoint.
+	ActionConnect = "connect"
+)
+
+// maxCacheEntries is the maximum number of cached decisions.
+const maxCacheEntries = 10000
+
+var (
+	ErrUnavailable = errors.New("authorization unavailable")
+)
+
+// Request is the request sent to the authorization webhook.
+type Request struct {
+	// Action is either 'listen' or 'connect'.
+	Action string `json:"action"`
+
+	EndpointID string `json:"endpoint_id"`
+
+	// TenantID is the ID of the tenant whose namespace the endpoint is in,
+	// or empty for the default tenant.
+	TenantID string `json:"tenant_id,omitempty"`
+
+	// ClientIP is the IP address of the client or upstream.
+	ClientIP string `json:"client_ip"`
+
+	// Claims contains the claims of the verified token, or nil if the
+	// request wasn't authenticated with a JWT.
+	Claims map[string]any `json:"claims,omitempty"`
+}
+
+// Decision is the response from the authorization webhook.
+type Decision struct {
+	Allow bool `json:"allow"`
+
+	// Reason is an optional reason for the decision, which is returned to
+	// the client when the request is denied.
+	Reason string `json:"reason,omitempty"`
+
+	// Headers contains optional headers to add to requests proxied to the
+	// upstream. Only applies to the 'connect' action.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type cacheEntry struct {
+	decision *Decision
+	expiry   time.Time
+}
+
+// Authorizer decides whether requests are permitted using an external
+// authorization webhook.
+//
+// Decisions are cached for the configured TTL, keyed by the full request.
+// Failed webhook requests aren't cached.
+type Authorizer struct {
+	url      string
+	cacheTTL time.Duration
+	failOpen bool
+
+	httpClient *http.Client
+
+	cache map[string]cacheEntry
+	mu    sync.Mutex
+
+	now func() time.Time
+
+	logger log.Logger
+}
+
+func NewAuthorizer(conf config.AuthzConfig, logger log.Logger) *Authorizer {
+	return &Authorizer{
+		url:      conf.URL,
+		cacheTTL: conf.CacheTTL,
+		failOpen: conf.FailOpen,
+		httpClient: &http.Client{
+			Timeout: conf.Timeout,
+		},
+		cache:  make(map[string]cacheEntry),
+		now:    time.Now,
+		logger: logger.WithSubsystem("authz"),
+	}
+}
+
+// Authorize returns the decision for the given request.
+//
+// If the webhook fails, Authorize allows the request when configured to fail
+// open, otherwise returns ErrUnavailable.
+func (a *Authorizer) Authorize(ctx context.Context, req Request) (*Decision, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	key := hex.EncodeToString(sum[:])
+
+	if decision, ok := a.cached(key); ok {
+		return decision, nil
+	}
+
+	decision, err := a.request(ctx, body)
+	if err != nil {
+		a.logger.Warn(
+			"authorization request failed",
+			zap.String("action", req.Action),
+			zap.String("endpoint-id", req.EndpointID),
+			zap.Bool("fail-open", a.failOpen),
+			zap.Error(err),
+		)
+		if a.failOpen {
+			return &Decision{Allow: true}, nil
+		}
+		return nil, ErrUnavailable
+	}
+
+	a.store(key, decision)
+	return decision, nil
+}
+
+func (a *Authorizer) request(ctx context.Context, body []byte) (*Decision, error) {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, a.url, bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Drain the body to reuse the connection.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("request: bad status: %d", resp.StatusCode)
+	}
+
+	var decision Decision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return &decision, nil
+}
+
+func (a *Authorizer) cached(key string) (*Decision, bool) {
+	if a.cacheTTL == 0 {
+		return nil, false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entry, ok := a.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if !a.now().Before(entry.expiry) {
+		delete(a.cache, key)
+		return nil, false
+	}
+	return entry.decision, true
+}
+
+func (a *Authorizer) store(key string, decision *Decision) {
+	if a.cacheTTL == 0 {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	if len(a.cache) >= maxCacheEntries {
+		for k, entry := range a.cache {
+			if !now.Before(entry.expiry) {
+				delete(a.cache, k)
+			}
+		}
+	}
+	if len(a.cache) >= maxCacheEntries {
+		// If the cache is still full, drop all entries to bound memory.
+		a.cache = make(map[string]cacheEntry)
+	}
+	a.cache[key] = cacheEntry{
+		decision: decision,
+		expiry:   now.Add(a.cacheTTL),
+	}
+}
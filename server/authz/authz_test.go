@@ -0,0 +1,167 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/config"
+)
+
+type stubServer struct {
+	*httptest.Server
+
+	requests atomic.Int64
+}
+
+func newStubServer(t *testing.T, handler func(req Request) (int, Decision)) *stubServer {
+	s := &stubServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+
+		var req Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		status, decision := handler(req)
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(decision)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestAuthorizer_Authorize(t *testing.T) {
+	t.Run("allow", func(t *testing.T) {
+		stub := newStubServer(t, func(req Request) (int, Decision) {
+			assert.Equal(t, ActionConnect, req.Action)
+			assert.Equal(t, "my-endpoint", req.EndpointID)
+			assert.Equal(t, "my-tenant", req.TenantID)
+			assert.Equal(t, "1.2.3.4", req.ClientIP)
+			assert.Equal(t, "my-sub", req.Claims["sub"])
+
+			return http.StatusOK, Decision{
+				Allow:   true,
+				Headers: map[string]string{"x-user": "my-user"},
+			}
+		})
+
+		authorizer := NewAuthorizer(config.AuthzConfig{
+			URL:     stub.URL,
+			Timeout: time.Second,
+		}, log.NewNopLogger())
+
+		decision, err := authorizer.Authorize(context.Background(), Request{
+			Action:     ActionConnect,
+			EndpointID: "my-endpoint",
+			TenantID:   "my-tenant",
+			ClientIP:   "1.2.3.4",
+			Claims:     map[string]any{"sub": "my-sub"},
+		})
+		require.NoError(t, err)
+		assert.True(t, decision.Allow)
+		assert.Equal(t, map[string]string{"x-user": "my-user"}, decision.Headers)
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		stub := newStubServer(t, func(_ Request) (int, Decision) {
+			return http.StatusOK, Decision{Allow: false, Reason: "banned"}
+		})
+
+		authorizer := NewAuthorizer(config.AuthzConfig{
+			URL:     stub.URL,
+			Timeout: time.Second,
+		}, log.NewNopLogger())
+
+		decision, err := authorizer.Authorize(context.Background(), Request{
+			Action:     ActionListen,
+			EndpointID: "my-endpoint",
+		})
+		require.NoError(t, err)
+		assert.False(t, decision.Allow)
+		assert.Equal(t, "banned", decision.Reason)
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		stub := newStubServer(t, func(_ Request) (int, Decision) {
+			return http.StatusOK, Decision{Allow: true}
+		})
+
+		authorizer := NewAuthorizer(config.AuthzConfig{
+			URL:      stub.URL,
+			Timeout:  time.Second,
+			CacheTTL: time.Minute,
+		}, log.NewNopLogger())
+		now := time.Now()
+		authorizer.now = func() time.Time {
+			return now
+		}
+
+		req := Request{Action: ActionConnect, EndpointID: "my-endpoint"}
+		for i := 0; i != 3; i++ {
+			decision, err := authorizer.Authorize(context.Background(), req)
+			require.NoError(t, err)
+			assert.True(t, decision.Allow)
+		}
+		assert.Equal(t, int64(1), stub.requests.Load())
+
+		// Different requests aren't cached.
+		_, err := authorizer.Authorize(context.Background(), Request{
+			Action: ActionListen, EndpointID: "my-endpoint",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), stub.requests.Load())
+
+		// Expired decisions are requested again.
+		now = now.Add(time.Minute)
+		_, err = authorizer.Authorize(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), stub.requests.Load())
+	})
+
+	t.Run("fail closed", func(t *testing.T) {
+		stub := newStubServer(t, func(_ Request) (int, Decision) {
+			return http.StatusInternalServerError, Decision{}
+		})
+
+		authorizer := NewAuthorizer(config.AuthzConfig{
+			URL:      stub.URL,
+			Timeout:  time.Second,
+			CacheTTL: time.Minute,
+		}, log.NewNopLogger())
+
+		req := Request{Action: ActionConnect, EndpointID: "my-endpoint"}
+		_, err := authorizer.Authorize(context.Background(), req)
+		assert.ErrorIs(t, err, ErrUnavailable)
+
+		// Failures aren't cached.
+		_, err = authorizer.Authorize(context.Background(), req)
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.Equal(t, int64(2), stub.requests.Load())
+	})
+
+	t.Run("fail open", func(t *testing.T) {
+		stub := newStubServer(t, func(_ Request) (int, Decision) {
+			return http.StatusInternalServerError, Decision{}
+		})
+
+		authorizer := NewAuthorizer(config.AuthzConfig{
+			URL:      stub.URL,
+			Timeout:  time.Second,
+			FailOpen: true,
+		}, log.NewNopLogger())
+
+		decision, err := authorizer.Authorize(context.Background(), Request{
+			Action: ActionConnect, EndpointID: "my-endpoint",
+		})
+		require.NoError(t, err)
+		assert.True(t, decision.Allow)
+	})
+}
@@ -0,0 +1,108 @@
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+type reader struct {
+	r         io.Reader
+	stream    *Stream
+	direction string
+	ctx       context.Context
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if burst := r.stream.Burst(); burst > 0 && len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.r.Read(p)
+	if recordErr := r.stream.Record(r.ctx, r.direction, n); recordErr != nil {
+		return n, recordErr
+	}
+	return n, err
+}
+
+type readCloser struct {
+	reader
+	io.Closer
+}
+
+// Reader returns a reader that accounts and limits the bytes read from r.
+func (s *Stream) Reader(
+	ctx context.Context,
+	r io.Reader,
+	direction string,
+) io.Reader {
+	return &reader{
+		r:         r,
+		stream:    s,
+		direction: direction,
+		ctx:       ctx,
+	}
+}
+
+// ReadCloser returns a reader that accounts and limits the bytes read from
+// rc.
+func (s *Stream) ReadCloser(
+	ctx context.Context,
+	rc io.ReadCloser,
+	direction string,
+) io.ReadCloser {
+	return &readCloser{
+		reader: reader{
+			r:         rc,
+			stream:    s,
+			direction: direction,
+			ctx:       ctx,
+		},
+		Closer: rc,
+	}
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+
+	stream *Stream
+	ctx    context.Context
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if burst := w.stream.Burst(); burst > 0 && len(chunk) > burst {
+			chunk = chunk[:burst]
+		}
+		if err := w.stream.Record(w.ctx, DirectionOut, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Unwrap returns the underlying response writer, which is used by
+// http.ResponseController to flush and hijack.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// ResponseWriter returns a response writer that accounts and limits the
+// response body bytes written to w.
+func (s *Stream) ResponseWriter(
+	ctx context.Context,
+	w http.ResponseWriter,
+) http.ResponseWriter {
+	return &responseWriter{
+		ResponseWriter: w,
+		stream:         s,
+		ctx:            ctx,
+	}
+}
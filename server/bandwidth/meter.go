@@ -0,0 +1,456 @@
+package bandwidth
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+)
+
+const (
+	// DirectionIn is traffic from the client to the upstream.
+	DirectionIn = "in"
+	// DirectionOut is traffic from the upstream to the client.
+	DirectionOut = "out"
+)
+
+const (
+	// otherLabel is the metrics label used once the maximum number of
+	// labels is exceeded.
+	otherLabel = "other"
+
+	// rateWindow is the window to calculate the bandwidth rate over.
+	rateWindow = time.Second * 10
+
+	// maxBurst is the maximum number of bytes read or written at once when
+	// bandwidth is limited.
+	maxBurst = 64 << 10
+
+	// idleTimeout is the duration an endpoint or tenant with no open
+	// streams is kept before it is removed.
+	idleTimeout = time.Minute * 10
+)
+
+// Usage contains the bandwidth used by an endpoint or tenant.
+type Usage struct {
+	ID string `json:"id"`
+
+	// BytesIn is the number of bytes sent from clients to the upstream.
+	BytesIn int64 `json:"bytes_in"`
+
+	// BytesOut is the number of bytes sent from the upstream to clients.
+	BytesOut int64 `json:"bytes_out"`
+
+	// Rate is the recent bandwidth in bytes per second in both directions.
+	Rate float64 `json:"rate"`
+}
+
+// Recorder is notified of bytes accounted by a Meter.
+type Recorder interface {
+	RecordBytes(endpointID string, tenantID string, direction string, n int)
+}
+
+// Meter accounts and limits the bandwidth used by endpoints and tenants.
+//
+// Each endpoint and tenant has a token bucket shared by all connections to
+// the endpoint or tenant.
+//
+// Endpoints and tenants are removed once idle, so their usage is reset if
+// they become active again.
+type Meter struct {
+	endpoints map[string]*usage
+	tenants   map[string]*usage
+
+	// mu protects the above fields, and the open streams of each usage.
+	mu sync.Mutex
+
+	config config.BandwidthConfig
+
+	recorders []Recorder
+
+	metrics *Metrics
+}
+
+func NewMeter(conf config.BandwidthConfig, recorders ...Recorder) *Meter {
+	return &Meter{
+		endpoints: make(map[string]*usage),
+		tenants:   make(map[string]*usage),
+		config:    conf,
+		recorders: recorders,
+		metrics:   NewMetrics(),
+	}
+}
+
+// Stream returns a stream to account and limit the bandwidth of a
+// connection or request to the given endpoint. If tenant ID is empty, only
+// the endpoint is accounted.
+//
+// The stream must be closed once the connection or request completes.
+func (m *Meter) Stream(endpointID string, tenantID string) *Stream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := &Stream{
+		meter:      m,
+		endpointID: endpointID,
+		tenantID:   tenantID,
+		recorders:  m.recorders,
+		throttled:  m.metrics.ThrottledSecondsTotal,
+	}
+
+	// Endpoints are namespaced by tenant, so different tenants using the
+	// same endpoint ID are accounted separately.
+	endpointKey := cluster.EndpointKey(tenantID, endpointID)
+	endpoint, ok := m.endpoints[endpointKey]
+	if !ok {
+		label := endpointID
+		if len(m.endpoints) >= m.config.MaxMetricsLabels {
+			label = otherLabel
+		}
+		endpoint = newUsage(
+			endpointKey,
+			tenantID,
+			m.config.Endpoint(endpointID),
+			m.metrics.EndpointBytesTotal.MustCurryWith(prometheus.Labels{
+				"endpoint_id": label,
+			}),
+		)
+		m.endpoints[endpointKey] = endpoint
+	}
+	s.usages = append(s.usages, endpoint)
+
+	if tenantID != "" {
+		tenant, ok := m.tenants[tenantID]
+		if !ok {
+			label := tenantID
+			if len(m.tenants) >= m.config.MaxMetricsLabels {
+				label = otherLabel
+			}
+			tenant = newUsage(
+				tenantID,
+				tenantID,
+				m.config.Tenant(tenantID),
+				m.metrics.TenantBytesTotal.MustCurryWith(prometheus.Labels{
+					"tenant_id": label,
+				}),
+			)
+			m.tenants[tenantID] = tenant
+		}
+		s.usages = append(s.usages, tenant)
+	}
+
+	for _, u := range s.usages {
+		u.streams++
+	}
+
+	return s
+}
+
+// Prune removes endpoints and tenants with no open streams that have been
+// idle for the idle timeout at the given time.
+func (m *Meter) Prune(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, u := range m.endpoints {
+		if u.idle(now) {
+			delete(m.endpoints, key)
+		}
+	}
+	for key, u := range m.tenants {
+		if u.idle(now) {
+			delete(m.tenants, key)
+		}
+	}
+}
+
+// Run prunes idle endpoints and tenants at the given interval until the
+// context is cancelled.
+func (m *Meter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.Prune(time.Now())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Meter) closeStream(s *Stream) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, u := range s.usages {
+		u.streams--
+		u.lastUsed = now
+	}
+}
+
+// TopEndpoints returns up to n endpoints using the most bandwidth.
+//
+// If byTotal is true, endpoints are sorted by the total number of bytes,
+// otherwise by the recent rate.
+//
+// If tenantID isn't empty, only endpoints belonging to the tenant are
+// returned. Otherwise the returned IDs are namespaced by tenant, as returned
+// by cluster.EndpointKey.
+func (m *Meter) TopEndpoints(tenantID string, n int, byTotal bool) []Usage {
+	m.mu.Lock()
+	usages := make([]*usage, 0, len(m.endpoints))
+	for _, u := range m.endpoints {
+		if tenantID == "" || u.tenantID == tenantID {
+			usages = append(usages, u)
+		}
+	}
+	m.mu.Unlock()
+
+	endpoints := top(usages, n, byTotal)
+	if tenantID != "" {
+		// Tenants only see the endpoint IDs in their own namespace.
+		for i := range endpoints {
+			_, endpoints[i].ID = cluster.ParseEndpointKey(endpoints[i].ID)
+		}
+	}
+	return endpoints
+}
+
+// TopTenants returns up to n tenants using the most bandwidth.
+//
+// If byTotal is true, tenants are sorted by the total number of bytes,
+// otherwise by the recent rate.
+//
+// If tenantID isn't empty, only that tenant is returned.
+func (m *Meter) TopTenants(tenantID string, n int, byTotal bool) []Usage {
+	m.mu.Lock()
+	usages := make([]*usage, 0, len(m.tenants))
+	for _, u := range m.tenants {
+		if tenantID == "" || u.tenantID == tenantID {
+			usages = append(usages, u)
+		}
+	}
+	m.mu.Unlock()
+
+	return top(usages, n, byTotal)
+}
+
+func (m *Meter) Metrics() *Metrics {
+	return m.metrics
+}
+
+func top(usages []*usage, n int, byTotal bool) []Usage {
+	now := time.Now()
+	snapshots := make([]Usage, 0, len(usages))
+	for _, u := range usages {
+		snapshots = append(snapshots, u.Snapshot(now))
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		totalI := snapshots[i].BytesIn + snapshots[i].BytesOut
+		totalJ := snapshots[j].BytesIn + snapshots[j].BytesOut
+		if byTotal || snapshots[i].Rate == snapshots[j].Rate {
+			if totalI == totalJ {
+				return snapshots[i].ID < snapshots[j].ID
+			}
+			return totalI > totalJ
+		}
+		return snapshots[i].Rate > snapshots[j].Rate
+	})
+
+	if n > 0 && len(snapshots) > n {
+		snapshots = snapshots[:n]
+	}
+	return snapshots
+}
+
+// usage tracks the bandwidth of an endpoint or tenant.
+type usage struct {
+	id string
+
+	// tenantID is the ID of the tenant the usage belongs to. Endpoints
+	// belong to the tenant whose namespace they are in.
+	tenantID string
+
+	// limiter limits the bandwidth, or is nil if unlimited.
+	limiter *rate.Limiter
+
+	bytesIn  prometheus.Counter
+	bytesOut prometheus.Counter
+
+	// streams is the number of open streams, protected by the meters
+	// mutex.
+	streams int
+	// lastUsed is the time the last stream was closed, protected by the
+	// meters mutex.
+	lastUsed time.Time
+
+	mu sync.Mutex
+
+	totalIn  int64
+	totalOut int64
+
+	// windowStart is the start of the current rate window.
+	windowStart time.Time
+	// windowBytes is the number of bytes in the current rate window.
+	windowBytes int64
+	// lastRate is the rate over the last complete window.
+	lastRate float64
+}
+
+func newUsage(
+	id string,
+	tenantID string,
+	bytesPerSecond int64,
+	bytes *prometheus.CounterVec,
+) *usage {
+	u := &usage{
+		id:          id,
+		tenantID:    tenantID,
+		bytesIn:     bytes.WithLabelValues(DirectionIn),
+		bytesOut:    bytes.WithLabelValues(DirectionOut),
+		lastUsed:    time.Now(),
+		windowStart: time.Now(),
+	}
+	if bytesPerSecond > 0 {
+		// Allow bursts of up to a second of data, though cap the burst to
+		// avoid large reads and writes.
+		burst := int(min(bytesPerSecond, maxBurst))
+		u.limiter = rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+	}
+	return u
+}
+
+func (u *usage) Add(direction string, n int, now time.Time) {
+	if direction == DirectionIn {
+		u.bytesIn.Add(float64(n))
+	} else {
+		u.bytesOut.Add(float64(n))
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if direction == DirectionIn {
+		u.totalIn += int64(n)
+	} else {
+		u.totalOut += int64(n)
+	}
+
+	u.rotate(now)
+	u.windowBytes += int64(n)
+}
+
+func (u *usage) Snapshot(now time.Time) Usage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.rotate(now)
+	return Usage{
+		ID:       u.id,
+		BytesIn:  u.totalIn,
+		BytesOut: u.totalOut,
+		Rate:     u.lastRate,
+	}
+}
+
+// idle returns whether the usage has no open streams and hasn't been used
+// for the idle timeout. The meters mutex must be held.
+func (u *usage) idle(now time.Time) bool {
+	return u.streams == 0 && now.Sub(u.lastUsed) >= idleTimeout
+}
+
+// rotate starts a new rate window if the current window has completed.
+func (u *usage) rotate(now time.Time) {
+	elapsed := now.Sub(u.windowStart)
+	if elapsed < rateWindow {
+		return
+	}
+	if elapsed >= rateWindow*2 {
+		// There has been a complete window with no traffic.
+		u.lastRate = 0
+	} else {
+		u.lastRate = float64(u.windowBytes) / elapsed.Seconds()
+	}
+	u.windowStart = now
+	u.windowBytes = 0
+}
+
+// Stream accounts and limits the bandwidth of a connection or request.
+type Stream struct {
+	meter *Meter
+
+	endpointID string
+	tenantID   string
+
+	usages []*usage
+
+	recorders []Recorder
+
+	throttled prometheus.Counter
+
+	closeOnce sync.Once
+}
+
+// Close closes the stream, so the endpoint and tenant can be removed once
+// idle.
+func (s *Stream) Close() {
+	s.closeOnce.Do(func() {
+		s.meter.closeStream(s)
+	})
+}
+
+// Burst returns the maximum number of bytes to read or write at once, or
+// zero if bandwidth is unlimited.
+func (s *Stream) Burst() int {
+	burst := 0
+	for _, u := range s.usages {
+		if u.limiter == nil {
+			continue
+		}
+		if burst == 0 || u.limiter.Burst() < burst {
+			burst = u.limiter.Burst()
+		}
+	}
+	return burst
+}
+
+// Record accounts for n bytes in the given direction, and blocks until the
+// bandwidth limits permit the bytes.
+//
+// n must not exceed Burst.
+func (s *Stream) Record(ctx context.Context, direction string, n int) error {
+	if n <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	for _, u := range s.usages {
+		u.Add(direction, n, now)
+	}
+	for _, r := range s.recorders {
+		r.RecordBytes(s.endpointID, s.tenantID, direction, n)
+	}
+
+	for _, u := range s.usages {
+		if u.limiter == nil {
+			continue
+		}
+		if err := u.limiter.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	if waited := time.Since(now); waited > time.Millisecond {
+		s.throttled.Add(waited.Seconds())
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/server/config"
+)
+
+func TestMeter_Accounting(t *testing.T) {
+	meter := NewMeter(config.BandwidthConfig{
+		MaxMetricsLabels: 2,
+	})
+
+	s1 := meter.Stream("endpoint-1", "tenant-1")
+	n, err := io.Copy(io.Discard, s1.Reader(
+		context.Background(), bytes.NewReader(make([]byte, 100)), DirectionIn,
+	))
+	require.NoError(t, err)
+	assert.Equal(t, int64(100), n)
+
+	w := httptest.NewRecorder()
+	_, err = s1.ResponseWriter(context.Background(), w).Write(make([]byte, 50))
+	require.NoError(t, err)
+	assert.Equal(t, 50, w.Body.Len())
+
+	s2 := meter.Stream("endpoint-2", "tenant-1")
+	_, err = io.Copy(io.Discard, s2.Reader(
+		context.Background(), bytes.NewReader(make([]byte, 500)), DirectionOut,
+	))
+	require.NoError(t, err)
+
+	// Streams without a tenant are only accounted to the endpoint.
+	s3 := meter.Stream("endpoint-3", "")
+	_, err = io.Copy(io.Discard, s3.Reader(
+		context.Background(), bytes.NewReader(make([]byte, 10)), DirectionOut,
+	))
+	require.NoError(t, err)
+
+	// Endpoints are namespaced by tenant.
+	assert.Equal(t, []Usage{
+		{ID: "tenant-1/endpoint-2", BytesOut: 500},
+		{ID: "tenant-1/endpoint-1", BytesIn: 100, BytesOut: 50},
+		{ID: "endpoint-3", BytesOut: 10},
+	}, meter.TopEndpoints("", 0, true))
+	assert.Equal(t, []Usage{
+		{ID: "tenant-1/endpoint-2", BytesOut: 500},
+	}, meter.TopEndpoints("", 1, true))
+	assert.Equal(t, []Usage{
+		{ID: "tenant-1", BytesIn: 100, BytesOut: 550},
+	}, meter.TopTenants("", 0, true))
+
+	// Filter by tenant.
+	assert.Equal(t, []Usage{
+		{ID: "endpoint-2", BytesOut: 500},
+		{ID: "endpoint-1", BytesIn: 100, BytesOut: 50},
+	}, meter.TopEndpoints("tenant-1", 0, true))
+	assert.Empty(t, meter.TopEndpoints("tenant-2", 0, true))
+	assert.Empty(t, meter.TopTenants("tenant-2", 0, true))
+
+	metrics := meter.Metrics()
+	assert.Equal(t, 100.0, testutil.ToFloat64(
+		metrics.EndpointBytesTotal.WithLabelValues("endpoint-1", DirectionIn),
+	))
+	assert.Equal(t, 550.0, testutil.ToFloat64(
+		metrics.TenantBytesTotal.WithLabelValues("tenant-1", DirectionOut),
+	))
+	// The third endpoint exceeds the label limit.
+	assert.Equal(t, 10.0, testutil.ToFloat64(
+		metrics.EndpointBytesTotal.WithLabelValues("other", DirectionOut),
+	))
+}
+
+func TestMeter_Limit(t *testing.T) {
+	t.Run("endpoint", func(t *testing.T) {
+		meter := NewMeter(config.BandwidthConfig{
+			EndpointRate: 10000,
+			Endpoints: []config.BandwidthLimitConfig{
+				{
+					// Unlimited.
+					ID:   "unlimited-endpoint",
+					Rate: 0,
+				},
+			},
+			MaxMetricsLabels: 10,
+		})
+
+		// The limit is shared by both streams to the endpoint, so after
+		// the one second burst, the remaining 2000 bytes take at least
+		// 200ms.
+		start := time.Now()
+		for i := 0; i != 2; i++ {
+			s := meter.Stream("my-endpoint", "")
+			_, err := io.Copy(io.Discard, s.Reader(
+				context.Background(), bytes.NewReader(make([]byte, 6000)), DirectionIn,
+			))
+			require.NoError(t, err)
+		}
+		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*150)
+
+		start = time.Now()
+		s := meter.Stream("unlimited-endpoint", "")
+		_, err := io.Copy(io.Discard, s.Reader(
+			context.Background(), bytes.NewReader(make([]byte, 1<<20)), DirectionIn,
+		))
+		require.NoError(t, err)
+		assert.Less(t, time.Since(start), time.Millisecond*100)
+	})
+
+	t.Run("tenant", func(t *testing.T) {
+		meter := NewMeter(config.BandwidthConfig{
+			TenantRate:       10000,
+			MaxMetricsLabels: 10,
+		})
+
+		// Writes to different endpoints share the tenant limit.
+		start := time.Now()
+		for _, endpointID := range []string{"endpoint-1", "endpoint-2"} {
+			s := meter.Stream(endpointID, "my-tenant")
+			w := httptest.NewRecorder()
+			_, err := s.ResponseWriter(context.Background(), w).Write(make([]byte, 6000))
+			require.NoError(t, err)
+			assert.Equal(t, 6000, w.Body.Len())
+		}
+		assert.GreaterOrEqual(t, time.Since(start), time.Millisecond*150)
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		meter := NewMeter(config.BandwidthConfig{
+			EndpointRate:     1000,
+			MaxMetricsLabels: 10,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		s := meter.Stream("my-endpoint", "")
+		_, err := io.Copy(io.Discard, s.Reader(
+			ctx, bytes.NewReader(make([]byte, 5000)), DirectionIn,
+		))
+		assert.Error(t, err)
+	})
+}
+
+func TestUsage_Rate(t *testing.T) {
+	meter := NewMeter(config.BandwidthConfig{MaxMetricsLabels: 10})
+	meter.Stream("my-endpoint", "")
+
+	u := meter.endpoints["my-endpoint"]
+	start := u.windowStart
+
+	u.Add(DirectionIn, 1000, start.Add(time.Second))
+	u.Add(DirectionOut, 1000, start.Add(time.Second*5))
+	// No complete window yet.
+	assert.Equal(t, 0.0, u.Snapshot(start.Add(time.Second*9)).Rate)
+
+	// 2000 bytes over the 10 second window.
+	assert.Equal(t, 200.0, u.Snapshot(start.Add(time.Second*10)).Rate)
+
+	// After a window with no traffic the rate drops to zero.
+	assert.Equal(t, 0.0, u.Snapshot(start.Add(time.Second*30)).Rate)
+}
+
+func TestMeter_Prune(t *testing.T) {
+	meter := NewMeter(config.BandwidthConfig{})
+
+	s1 := meter.Stream("endpoint-1", "tenant-1")
+	s2 := meter.Stream("endpoint-2", "tenant-1")
+	s2.Close()
+
+	// Streams are only pruned once idle.
+	meter.Prune(time.Now())
+	assert.Len(t, meter.TopEndpoints("", 0, true), 2)
+
+	// Endpoint 2 has no open streams so is removed once idle, though the
+	// tenant has an open stream.
+	meter.Prune(time.Now().Add(idleTimeout))
+	assert.Equal(t, []Usage{
+		{ID: "tenant-1/endpoint-1"},
+	}, meter.TopEndpoints("", 0, true))
+	assert.Len(t, meter.TopTenants("", 0, true), 1)
+
+	s1.Close()
+	// Closing again is ignored.
+	s1.Close()
+
+	meter.Prune(time.Now().Add(idleTimeout))
+	assert.Empty(t, meter.TopEndpoints("", 0, true))
+	assert.Empty(t, meter.TopTenants("", 0, true))
+}
@@ -0,0 +1,56 @@
+package bandwidth
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type Metrics struct {
+	// EndpointBytesTotal is the number of bytes proxied for each endpoint.
+	// Labelled by endpoint ID and direction ('in' or 'out').
+	EndpointBytesTotal *prometheus.CounterVec
+
+	// TenantBytesTotal is the number of bytes proxied for each tenant.
+	// Labelled by tenant ID and direction ('in' or 'out').
+	TenantBytesTotal *prometheus.CounterVec
+
+	// ThrottledSecondsTotal is the total time connections have waited due
+	// to bandwidth limits.
+	ThrottledSecondsTotal prometheus.Counter
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		EndpointBytesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "bandwidth",
+				Name:      "endpoint_bytes_total",
+				Help:      "Number of bytes proxied for each endpoint",
+			},
+			[]string{"endpoint_id", "direction"},
+		),
+		TenantBytesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "bandwidth",
+				Name:      "tenant_bytes_total",
+				Help:      "Number of bytes proxied for each tenant",
+			},
+			[]string{"tenant_id", "direction"},
+		),
+		ThrottledSecondsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "bandwidth",
+				Name:      "throttled_seconds_total",
+				Help:      "Total time connections have waited due to bandwidth limits",
+			},
+		),
+	}
+}
+
+func (m *Metrics) Register(registry *prometheus.Registry) {
+	registry.MustRegister(
+		m.EndpointBytesTotal,
+		m.TenantBytesTotal,
+		m.ThrottledSecondsTotal,
+	)
+}
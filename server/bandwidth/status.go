@@ -0,0 +1,72 @@
+package bandwidth
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/andydunstall/piko/server/status"
+)
+
+const defaultTopLimit = 10
+
+type Status struct {
+	meter *Meter
+}
+
+func NewStatus(meter *Meter) *Status {
+	return &Status{
+		meter: meter,
+	}
+}
+
+func (s *Status) Register(group *gin.RouterGroup) {
+	group.GET("/endpoints", s.topEndpointsRoute)
+	group.GET("/tenants", s.topTenantsRoute)
+}
+
+// topEndpointsRoute returns the endpoints using the most bandwidth.
+//
+// Supports the 'limit' query to set the number of endpoints to return, and
+// 'sort=total' to sort by the total number of bytes rather than the recent
+// rate.
+//
+// Tenants can only view their own endpoints.
+func (s *Status) topEndpointsRoute(c *gin.Context) {
+	limit, ok := topLimit(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, s.meter.TopEndpoints(
+		status.TenantID(c), limit, c.Query("sort") == "total",
+	))
+}
+
+// topTenantsRoute returns the tenants using the most bandwidth.
+//
+// Supports the same queries as topEndpointsRoute.
+func (s *Status) topTenantsRoute(c *gin.Context) {
+	limit, ok := topLimit(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, s.meter.TopTenants(
+		status.TenantID(c), limit, c.Query("sort") == "total",
+	))
+}
+
+func topLimit(c *gin.Context) (int, bool) {
+	limitQuery := c.Query("limit")
+	if limitQuery == "" {
+		return defaultTopLimit, true
+	}
+	limit, err := strconv.Atoi(limitQuery)
+	if err != nil || limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return 0, false
+	}
+	return limit, true
+}
+
+var _ status.Handler = &Status{}
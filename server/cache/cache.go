@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+)
+
+// keySeparator separates the components of a cache key. Since it can't be
+// included in a request URI, a prefix of '<endpoint><sep><path>' only matches
+// keys for that endpoint.
+const keySeparator = "\x00"
+
+// Cache is a shared HTTP response cache following RFC 9111.
+//
+// Responses are keyed by endpoint key and request URI, where the endpoint key
+// namespaces the endpoint ID by tenant (see cluster.EndpointKey). If a response includes
+// a 'Vary' header, a marker entry is stored under the primary key listing the
+// header names, and each variant is stored under a secondary key including
+// the request header values.
+type Cache struct {
+	store Store
+
+	config config.CacheConfig
+
+	metrics *Metrics
+
+	logger log.Logger
+}
+
+func NewCache(conf config.CacheConfig, logger log.Logger) (*Cache, error) {
+	logger = logger.WithSubsystem("proxy.cache")
+
+	var store Store
+	switch conf.Backend {
+	case config.CacheBackendDisk:
+		diskStore, err := NewDiskStore(conf.Dir, conf.MaxSize, logger)
+		if err != nil {
+			return nil, fmt.Errorf("disk store: %w", err)
+		}
+		store = diskStore
+	default:
+		store = NewMemoryStore(conf.MaxSize)
+	}
+
+	return NewCacheWithStore(store, conf, logger), nil
+}
+
+func NewCacheWithStore(store Store, conf config.CacheConfig, logger log.Logger) *Cache {
+	c := &Cache{
+		store:   store,
+		config:  conf,
+		metrics: NewMetrics(),
+		logger:  logger,
+	}
+	c.updateMetrics()
+	return c
+}
+
+// Enabled returns whether caching is enabled for the endpoint with the given
+// ID.
+func (c *Cache) Enabled(endpointID string) bool {
+	return !c.config.Endpoint(endpointID).Disable
+}
+
+// Lookup returns the stored response matching the request, or false if there
+// is no stored response.
+//
+// The returned entry may be stale, so the caller must check whether it is
+// fresh before using it.
+func (c *Cache) Lookup(endpointKey string, r *http.Request) (*Entry, bool) {
+	key := primaryKey(endpointKey, r)
+	entry, ok := c.store.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if len(entry.Vary) == 0 {
+		return entry, true
+	}
+	return c.store.Get(variantKey(key, entry.Vary, r))
+}
+
+// Storable returns whether the response may be stored, and if so the
+// maximum size of the body to store.
+func (c *Cache) Storable(
+	endpointKey string,
+	r *http.Request,
+	resp *http.Response,
+) (int64, bool) {
+	endpointConf := c.endpointConfig(endpointKey)
+	if endpointConf.Disable {
+		return 0, false
+	}
+	if !storable(r, resp, endpointConf.DefaultTTL) {
+		return 0, false
+	}
+	if resp.ContentLength > endpointConf.MaxObjectSize {
+		return 0, false
+	}
+	return endpointConf.MaxObjectSize, true
+}
+
+// Store stores the response to the given request.
+//
+// The caller must have checked the response is storable.
+func (c *Cache) Store(
+	endpointKey string,
+	r *http.Request,
+	statusCode int,
+	header http.Header,
+	body []byte,
+	requestTime time.Time,
+	responseTime time.Time,
+) *Entry {
+	entry := &Entry{
+		StatusCode:   statusCode,
+		Header:       header,
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		DefaultTTL:   c.endpointConfig(endpointKey).DefaultTTL,
+	}
+
+	key := primaryKey(endpointKey, r)
+	vary := varyFields(header)
+	if len(vary) == 0 {
+		entry.Key = key
+		c.store.Set(entry)
+	} else {
+		c.store.Set(&Entry{
+			Key:  key,
+			Vary: vary,
+		})
+		entry.Key = variantKey(key, vary, r)
+		c.store.Set(entry)
+	}
+
+	c.updateMetrics()
+	return entry
+}
+
+// Revalidated updates the stored response after the upstream responds with
+// 304 (Not Modified) to a conditional request (RFC 9111 section 4.3.4).
+//
+// Returns the updated entry.
+func (c *Cache) Revalidated(
+	endpointKey string,
+	r *http.Request,
+	entry *Entry,
+	resp *http.Response,
+	requestTime time.Time,
+	responseTime time.Time,
+) *Entry {
+	header := entry.Header.Clone()
+	for name, values := range resp.Header {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Content-Range", "Transfer-Encoding":
+			// Describe the 304 response itself rather than the stored
+			// response.
+			continue
+		}
+		header[name] = values
+	}
+	return c.Store(
+		endpointKey, r, entry.StatusCode, header, entry.Body, requestTime, responseTime,
+	)
+}
+
+// Invalidate removes the stored responses for the request target URI if the
+// request used an unsafe method, such as POST, and the response status code
+// was non-error (RFC 9111 section 4.4).
+func (c *Cache) Invalidate(endpointKey string, r *http.Request, statusCode int) {
+	if !unsafeMethod(r.Method) || statusCode < 200 || statusCode >= 400 {
+		return
+	}
+
+	key := primaryKey(endpointKey, r)
+	c.store.Delete(key)
+	c.store.DeletePrefix(key + keySeparator)
+	c.updateMetrics()
+}
+
+// Purge removes the stored responses for the endpoint with the given key whose
+// path has the given prefix. If the endpoint key is empty, responses for all
+// endpoints are removed.
+//
+// Returns the number of removed entries.
+func (c *Cache) Purge(endpointKey string, pathPrefix string) int {
+	prefix := ""
+	if endpointKey != "" {
+		prefix = endpointKey + keySeparator + pathPrefix
+	}
+	purged := c.store.DeletePrefix(prefix)
+	c.updateMetrics()
+
+	c.logger.Info(
+		"purged cache",
+		zap.String("endpoint-key", endpointKey),
+		zap.String("path-prefix", pathPrefix),
+		zap.Int("purged", purged),
+	)
+	return purged
+}
+
+// Size returns the number of stored entries and their size in bytes.
+func (c *Cache) Size() (int, int64) {
+	return c.store.Len(), c.store.Size()
+}
+
+func (c *Cache) Metrics() *Metrics {
+	return c.metrics
+}
+
+// Observe records the result of a request to the cache.
+func (c *Cache) Observe(result string) {
+	c.metrics.RequestsTotal.With(prometheus.Labels{
+		"result": result,
+	}).Inc()
+}
+
+func (c *Cache) updateMetrics() {
+	c.metrics.Entries.Set(float64(c.store.Len()))
+	c.metrics.SizeBytes.Set(float64(c.store.Size()))
+}
+
+// endpointConfig returns the cache configuration for the endpoint with the
+// given key. Endpoints are configured by ID, regardless of tenant.
+func (c *Cache) endpointConfig(endpointKey string) config.EndpointCacheConfig {
+	_, endpointID := cluster.ParseEndpointKey(endpointKey)
+	return c.config.Endpoint(endpointID)
+}
+
+func primaryKey(endpointKey string, r *http.Request) string {
+	return endpointKey + keySeparator + r.URL.RequestURI()
+}
+
+func variantKey(primaryKey string, vary []string, r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(primaryKey)
+	b.WriteString(keySeparator)
+	for _, field := range vary {
+		b.WriteString(field)
+		b.WriteString("=")
+		for i, value := range r.Header.Values(field) {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(strings.TrimSpace(value))
+		}
+		b.WriteString(keySeparator)
+	}
+	return b.String()
+}
@@ -0,0 +1,360 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/config"
+)
+
+func defaultConfig() config.CacheConfig {
+	return config.CacheConfig{
+		Enabled:       true,
+		Backend:       config.CacheBackendMemory,
+		MaxSize:       1 << 20,
+		MaxObjectSize: 1 << 10,
+	}
+}
+
+func newResponse(statusCode int, header http.Header) *http.Response {
+	return &http.Response{
+		StatusCode:    statusCode,
+		Header:        header,
+		ContentLength: -1,
+	}
+}
+
+func TestCache_Storable(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		reqCC    string
+		auth     bool
+		header   http.Header
+		storable bool
+	}{
+		{
+			name:     "max-age",
+			method:   http.MethodGet,
+			header:   http.Header{"Cache-Control": []string{"max-age=60"}},
+			storable: true,
+		},
+		{
+			name:     "validator",
+			method:   http.MethodGet,
+			header:   http.Header{"Etag": []string{`"v1"`}},
+			storable: true,
+		},
+		{
+			name:     "no freshness",
+			method:   http.MethodGet,
+			header:   http.Header{},
+			storable: false,
+		},
+		{
+			name:     "post",
+			method:   http.MethodPost,
+			header:   http.Header{"Cache-Control": []string{"max-age=60"}},
+			storable: false,
+		},
+		{
+			name:     "response no-store",
+			method:   http.MethodGet,
+			header:   http.Header{"Cache-Control": []string{"max-age=60, no-store"}},
+			storable: false,
+		},
+		{
+			name:     "request no-store",
+			method:   http.MethodGet,
+			reqCC:    "no-store",
+			header:   http.Header{"Cache-Control": []string{"max-age=60"}},
+			storable: false,
+		},
+		{
+			name:     "private",
+			method:   http.MethodGet,
+			header:   http.Header{"Cache-Control": []string{"private, max-age=60"}},
+			storable: false,
+		},
+		{
+			name:     "authorization",
+			method:   http.MethodGet,
+			auth:     true,
+			header:   http.Header{"Cache-Control": []string{"max-age=60"}},
+			storable: false,
+		},
+		{
+			name:     "authorization public",
+			method:   http.MethodGet,
+			auth:     true,
+			header:   http.Header{"Cache-Control": []string{"public, max-age=60"}},
+			storable: true,
+		},
+		{
+			name:   "vary all",
+			method: http.MethodGet,
+			header: http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Vary":          []string{"*"},
+			},
+			storable: false,
+		},
+		{
+			name:   "set cookie",
+			method: http.MethodGet,
+			header: http.Header{
+				"Cache-Control": []string{"max-age=60"},
+				"Set-Cookie":    []string{"a=b"},
+			},
+			storable: false,
+		},
+	}
+
+	c := NewCacheWithStore(
+		NewMemoryStore(1<<20), defaultConfig(), log.NewNopLogger(),
+	)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/foo", nil)
+			if tt.reqCC != "" {
+				r.Header.Set("Cache-Control", tt.reqCC)
+			}
+			if tt.auth {
+				r.Header.Set("Authorization", "Bearer abc")
+			}
+
+			_, ok := c.Storable("my-endpoint", r, newResponse(http.StatusOK, tt.header))
+			assert.Equal(t, tt.storable, ok)
+		})
+	}
+}
+
+func TestCache_Lookup(t *testing.T) {
+	t.Run("fresh", func(t *testing.T) {
+		c := NewCacheWithStore(
+			NewMemoryStore(1<<20), defaultConfig(), log.NewNopLogger(),
+		)
+
+		now := time.Now()
+		r := httptest.NewRequest(http.MethodGet, "/foo?a=b", nil)
+		c.Store("my-endpoint", r, http.StatusOK, http.Header{
+			"Cache-Control": []string{"max-age=60"},
+		}, []byte("foo"), now, now)
+
+		entry, ok := c.Lookup("my-endpoint", r)
+		require.True(t, ok)
+		assert.Equal(t, []byte("foo"), entry.Body)
+		assert.True(t, entry.Fresh(r, now.Add(time.Second*30)))
+		assert.False(t, entry.Fresh(r, now.Add(time.Second*90)))
+
+		// Different query.
+		_, ok = c.Lookup("my-endpoint", httptest.NewRequest(http.MethodGet, "/foo", nil))
+		assert.False(t, ok)
+		// Different endpoint.
+		_, ok = c.Lookup("other-endpoint", r)
+		assert.False(t, ok)
+	})
+
+	t.Run("request cache control", func(t *testing.T) {
+		c := NewCacheWithStore(
+			NewMemoryStore(1<<20), defaultConfig(), log.NewNopLogger(),
+		)
+
+		now := time.Now()
+		r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		c.Store("my-endpoint", r, http.StatusOK, http.Header{
+			"Cache-Control": []string{"max-age=60"},
+		}, []byte("foo"), now, now)
+
+		entry, ok := c.Lookup("my-endpoint", r)
+		require.True(t, ok)
+
+		noCache := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		noCache.Header.Set("Cache-Control", "no-cache")
+		assert.False(t, entry.Fresh(noCache, now))
+
+		maxAge := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		maxAge.Header.Set("Cache-Control", "max-age=10")
+		assert.False(t, entry.Fresh(maxAge, now.Add(time.Second*20)))
+
+		maxStale := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		maxStale.Header.Set("Cache-Control", "max-stale=60")
+		assert.True(t, entry.Fresh(maxStale, now.Add(time.Second*90)))
+		assert.False(t, entry.Fresh(maxStale, now.Add(time.Second*150)))
+	})
+
+	t.Run("vary", func(t *testing.T) {
+		c := NewCacheWithStore(
+			NewMemoryStore(1<<20), defaultConfig(), log.NewNopLogger(),
+		)
+
+		now := time.Now()
+		header := http.Header{
+			"Cache-Control": []string{"max-age=60"},
+			"Vary":          []string{"accept-language"},
+		}
+
+		en := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		en.Header.Set("Accept-Language", "en")
+		c.Store("my-endpoint", en, http.StatusOK, header, []byte("hello"), now, now)
+
+		fr := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		fr.Header.Set("Accept-Language", "fr")
+		c.Store("my-endpoint", fr, http.StatusOK, header, []byte("bonjour"), now, now)
+
+		entry, ok := c.Lookup("my-endpoint", en)
+		require.True(t, ok)
+		assert.Equal(t, []byte("hello"), entry.Body)
+
+		entry, ok = c.Lookup("my-endpoint", fr)
+		require.True(t, ok)
+		assert.Equal(t, []byte("bonjour"), entry.Body)
+
+		de := httptest.NewRequest(http.MethodGet, "/foo", nil)
+		de.Header.Set("Accept-Language", "de")
+		_, ok = c.Lookup("my-endpoint", de)
+		assert.False(t, ok)
+	})
+}
+
+func TestCache_Revalidated(t *testing.T) {
+	c := NewCacheWithStore(
+		NewMemoryStore(1<<20), defaultConfig(), log.NewNopLogger(),
+	)
+
+	now := time.Now()
+	r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	entry := c.Store("my-endpoint", r, http.StatusOK, http.Header{
+		"Cache-Control": []string{"max-age=0"},
+		"Etag":          []string{`"v1"`},
+		"Content-Type":  []string{"text/plain"},
+	}, []byte("foo"), now, now)
+	assert.False(t, entry.Fresh(r, now))
+
+	updated := c.Revalidated("my-endpoint", r, entry, newResponse(
+		http.StatusNotModified,
+		http.Header{
+			"Cache-Control":  []string{"max-age=60"},
+			"Content-Length": []string{"0"},
+		},
+	), now, now)
+	assert.Equal(t, http.StatusOK, updated.StatusCode)
+	assert.Equal(t, []byte("foo"), updated.Body)
+	assert.Equal(t, "text/plain", updated.Header.Get("Content-Type"))
+	assert.Equal(t, "", updated.Header.Get("Content-Length"))
+	assert.True(t, updated.Fresh(r, now))
+
+	conditional := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	conditional.Header.Set("If-None-Match", `W/"v1"`)
+	assert.True(t, updated.NotModified(conditional))
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	c := NewCacheWithStore(
+		NewMemoryStore(1<<20), defaultConfig(), log.NewNopLogger(),
+	)
+
+	now := time.Now()
+	r := httptest.NewRequest(http.MethodGet, "/foo", nil)
+	c.Store("my-endpoint", r, http.StatusOK, http.Header{
+		"Cache-Control": []string{"max-age=60"},
+	}, []byte("foo"), now, now)
+
+	// Failed unsafe requests don't invalidate.
+	post := httptest.NewRequest(http.MethodPost, "/foo", nil)
+	c.Invalidate("my-endpoint", post, http.StatusInternalServerError)
+	_, ok := c.Lookup("my-endpoint", r)
+	assert.True(t, ok)
+
+	c.Invalidate("my-endpoint", post, http.StatusOK)
+	_, ok = c.Lookup("my-endpoint", r)
+	assert.False(t, ok)
+}
+
+func TestCache_Purge(t *testing.T) {
+	c := NewCacheWithStore(
+		NewMemoryStore(1<<20), defaultConfig(), log.NewNopLogger(),
+	)
+
+	now := time.Now()
+	header := http.Header{"Cache-Control": []string{"max-age=60"}}
+	for _, path := range []string{"/a/1", "/a/2", "/b/1"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		c.Store("endpoint-1", r, http.StatusOK, header, []byte("foo"), now, now)
+		c.Store("endpoint-2", r, http.StatusOK, header, []byte("foo"), now, now)
+	}
+
+	assert.Equal(t, 2, c.Purge("endpoint-1", "/a/"))
+	entries, _ := c.Size()
+	assert.Equal(t, 4, entries)
+
+	assert.Equal(t, 3, c.Purge("endpoint-2", ""))
+	assert.Equal(t, 1, c.Purge("", ""))
+
+	entries, size := c.Size()
+	assert.Equal(t, 0, entries)
+	assert.Equal(t, int64(0), size)
+}
+
+func TestMemoryStore_Evict(t *testing.T) {
+	store := NewMemoryStore(100)
+
+	store.Set(&Entry{Key: "a", Body: make([]byte, 40)})
+	store.Set(&Entry{Key: "b", Body: make([]byte, 40)})
+	// Use 'a' so 'b' is the least recently used.
+	_, ok := store.Get("a")
+	assert.True(t, ok)
+
+	store.Set(&Entry{Key: "c", Body: make([]byte, 40)})
+
+	_, ok = store.Get("a")
+	assert.True(t, ok)
+	_, ok = store.Get("b")
+	assert.False(t, ok)
+	_, ok = store.Get("c")
+	assert.True(t, ok)
+	assert.Equal(t, 2, store.Len())
+}
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewDiskStore(dir, 1<<20, log.NewNopLogger())
+	require.NoError(t, err)
+
+	now := time.Now().Truncate(time.Second)
+	store.Set(&Entry{
+		Key:          "my-endpoint\x00/foo",
+		StatusCode:   http.StatusOK,
+		Header:       http.Header{"Cache-Control": []string{"max-age=60"}},
+		Body:         []byte("foo"),
+		RequestTime:  now,
+		ResponseTime: now,
+	})
+	store.Set(&Entry{
+		Key:  "my-endpoint\x00/bar",
+		Body: []byte("bar"),
+	})
+	assert.Equal(t, 1, store.DeletePrefix("my-endpoint\x00/bar"))
+
+	// Reopen the store to check entries persist.
+	store, err = NewDiskStore(dir, 1<<20, log.NewNopLogger())
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.Len())
+
+	entry, ok := store.Get("my-endpoint\x00/foo")
+	require.True(t, ok)
+	assert.Equal(t, http.StatusOK, entry.StatusCode)
+	assert.Equal(t, []byte("foo"), entry.Body)
+	assert.Equal(t, "max-age=60", entry.Header.Get("Cache-Control"))
+	assert.True(t, entry.ResponseTime.Equal(now))
+
+	_, ok = store.Get("my-endpoint\x00/bar")
+	assert.False(t, ok)
+}
@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/piko/pkg/log"
+)
+
+const diskEntryExt = ".entry"
+
+// DiskStore is an LRU store that persists entries to disk.
+//
+// Entries are stored one per file in the configured directory. The index of
+// entries is kept in memory and rebuilt from the directory on boot, so the
+// cache survives restarts.
+type DiskStore struct {
+	dir string
+
+	lru *lru
+
+	mu sync.Mutex
+
+	logger log.Logger
+}
+
+func NewDiskStore(dir string, maxSize int64, logger log.Logger) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create dir: %s: %w", dir, err)
+	}
+
+	s := &DiskStore{
+		dir:    dir,
+		logger: logger,
+	}
+	s.lru = newLRU(maxSize, s.onEvict)
+
+	if err := s.load(); err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+	return s, nil
+}
+
+func (s *DiskStore) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.lru.Get(key); !ok {
+		return nil, false
+	}
+
+	entry, err := readEntry(s.path(key))
+	if err != nil {
+		s.logger.Warn(
+			"failed to read cache entry",
+			zap.String("key", key),
+			zap.Error(err),
+		)
+		s.lru.Remove(key)
+		return nil, false
+	}
+	return entry, true
+}
+
+func (s *DiskStore) Set(entry *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Remove any existing entry first, as evicting deletes the entry file.
+	s.lru.Remove(entry.Key)
+
+	size, err := writeEntry(s.dir, s.path(entry.Key), entry)
+	if err != nil {
+		s.logger.Warn(
+			"failed to write cache entry",
+			zap.String("key", entry.Key),
+			zap.Error(err),
+		)
+		return
+	}
+
+	s.lru.Add(&lruItem{
+		key:  entry.Key,
+		size: size,
+	})
+}
+
+func (s *DiskStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lru.Remove(key)
+}
+
+func (s *DiskStore) DeletePrefix(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.RemovePrefix(prefix)
+}
+
+func (s *DiskStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.Size()
+}
+
+func (s *DiskStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.Len()
+}
+
+// load rebuilds the index from the entries in the store directory.
+func (s *DiskStore) load() error {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("read dir: %w", err)
+	}
+
+	type storedEntry struct {
+		key     string
+		size    int64
+		modTime int64
+	}
+	var stored []storedEntry
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || !strings.HasSuffix(name, diskEntryExt) {
+			continue
+		}
+		path := filepath.Join(s.dir, name)
+
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entry, err := readEntry(path)
+		if err != nil || s.path(entry.Key) != path {
+			// Discard corrupt entries.
+			s.logger.Warn(
+				"discarding invalid cache entry",
+				zap.String("path", path),
+				zap.Error(err),
+			)
+			_ = os.Remove(path)
+			continue
+		}
+		stored = append(stored, storedEntry{
+			key:     entry.Key,
+			size:    info.Size(),
+			modTime: info.ModTime().UnixNano(),
+		})
+	}
+
+	// Add the oldest entries first so the most recently written are the most
+	// recently used.
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].modTime < stored[j].modTime
+	})
+	for _, e := range stored {
+		s.lru.Add(&lruItem{
+			key:  e.key,
+			size: e.size,
+		})
+	}
+	return nil
+}
+
+func (s *DiskStore) onEvict(item *lruItem) {
+	if err := os.Remove(s.path(item.key)); err != nil && !os.IsNotExist(err) {
+		s.logger.Warn(
+			"failed to remove cache entry",
+			zap.String("key", item.key),
+			zap.Error(err),
+		)
+	}
+}
+
+func (s *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+diskEntryExt)
+}
+
+func readEntry(path string) (*Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entry Entry
+	if err := gob.NewDecoder(f).Decode(&entry); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return &entry, nil
+}
+
+// writeEntry atomically writes the entry to the given path, returning the
+// size of the written file.
+func writeEntry(dir string, path string, entry *Entry) (int64, error) {
+	f, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+
+	if err := gob.NewEncoder(f).Encode(entry); err != nil {
+		f.Close()
+		return 0, fmt.Errorf("encode: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+var _ Store = &DiskStore{}
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Entry is a stored response.
+//
+// Entries are immutable once stored.
+type Entry struct {
+	// Key is the cache key of the entry.
+	Key string
+
+	// Vary contains the request header names the response varies on. If
+	// set, the entry doesn't contain a response, instead it indicates the
+	// response variants are stored under secondary keys.
+	Vary []string
+
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	// RequestTime is the time the request that led to the response was
+	// sent.
+	RequestTime time.Time
+
+	// ResponseTime is the time the response was received.
+	ResponseTime time.Time
+
+	// DefaultTTL is the freshness lifetime to use if the response has no
+	// explicit freshness information.
+	DefaultTTL time.Duration
+}
+
+// Size returns the approximate size of the entry in bytes.
+func (e *Entry) Size() int64 {
+	size := len(e.Key) + len(e.Body)
+	for _, field := range e.Vary {
+		size += len(field)
+	}
+	for name, values := range e.Header {
+		size += len(name)
+		for _, value := range values {
+			size += len(value)
+		}
+	}
+	return int64(size)
+}
+
+// Age returns the current age of the response (RFC 9111 section 4.2.3).
+func (e *Entry) Age(now time.Time) time.Duration {
+	date := e.ResponseTime
+	if d, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		date = d
+	}
+	apparentAge := max(0, e.ResponseTime.Sub(date))
+
+	var ageValue time.Duration
+	if age, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && age > 0 {
+		ageValue = time.Duration(age) * time.Second
+	}
+	responseDelay := e.ResponseTime.Sub(e.RequestTime)
+	correctedAgeValue := ageValue + responseDelay
+
+	correctedInitialAge := max(apparentAge, correctedAgeValue)
+	residentTime := now.Sub(e.ResponseTime)
+	return correctedInitialAge + residentTime
+}
+
+// FreshnessLifetime returns the freshness lifetime of the response (RFC 9111
+// section 4.2.1).
+func (e *Entry) FreshnessLifetime() time.Duration {
+	cc := parseCacheControl(e.Header)
+	if lifetime, ok := cc.seconds("s-maxage"); ok {
+		return lifetime
+	}
+	if lifetime, ok := cc.seconds("max-age"); ok {
+		return lifetime
+	}
+	if expires := e.Header.Get("Expires"); expires != "" {
+		expiresTime, err := http.ParseTime(expires)
+		if err != nil {
+			// Invalid dates are treated as in the past.
+			return 0
+		}
+		date := e.ResponseTime
+		if d, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+			date = d
+		}
+		return max(0, expiresTime.Sub(date))
+	}
+	return e.DefaultTTL
+}
+
+// Fresh returns whether the stored response can be used to satisfy the
+// given request without revalidation (RFC 9111 section 4.2).
+func (e *Entry) Fresh(r *http.Request, now time.Time) bool {
+	respCC := parseCacheControl(e.Header)
+	if respCC.has("no-cache") {
+		return false
+	}
+
+	reqCC := parseCacheControl(r.Header)
+	if reqCC.has("no-cache") {
+		return false
+	}
+	if len(reqCC) == 0 && r.Header.Get("Pragma") == "no-cache" {
+		return false
+	}
+
+	lifetime := e.FreshnessLifetime()
+	age := e.Age(now)
+
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok {
+		age += minFresh
+	}
+	if age < lifetime {
+		return true
+	}
+
+	// The response is stale, though the client may accept a stale response
+	// unless the origin requires revalidation.
+	if respCC.has("must-revalidate") || respCC.has("proxy-revalidate") || respCC.has("s-maxage") {
+		return false
+	}
+	maxStale, ok := reqCC[`max-stale`]
+	if !ok {
+		return false
+	}
+	if maxStale == "" {
+		// Any staleness is acceptable.
+		return true
+	}
+	staleness, _ := reqCC.seconds("max-stale")
+	return age-lifetime <= staleness
+}
+
+// Validators returns the validators to revalidate the response.
+func (e *Entry) Validators() (etag string, lastModified string) {
+	return e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+}
+
+// NotModified returns whether the client conditional request headers match
+// the stored response, so the client can be sent a 304 response.
+func (e *Entry) NotModified(r *http.Request) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatch(ifNoneMatch, e.Header.Get("ETag"))
+	}
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		lastModified, err := http.ParseTime(e.Header.Get("Last-Modified"))
+		if err != nil {
+			return false
+		}
+		return !lastModified.After(since)
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package cache
+
+import (
+	"sync"
+)
+
+// MemoryStore is an in-memory LRU store.
+type MemoryStore struct {
+	lru *lru
+
+	mu sync.Mutex
+}
+
+func NewMemoryStore(maxSize int64) *MemoryStore {
+	return &MemoryStore{
+		lru: newLRU(maxSize, nil),
+	}
+}
+
+func (s *MemoryStore) Get(key string) (*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return item.value.(*Entry), true
+}
+
+func (s *MemoryStore) Set(entry *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lru.Add(&lruItem{
+		key:   entry.Key,
+		size:  entry.Size(),
+		value: entry,
+	})
+}
+
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lru.Remove(key)
+}
+
+func (s *MemoryStore) DeletePrefix(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.RemovePrefix(prefix)
+}
+
+func (s *MemoryStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.Size()
+}
+
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lru.Len()
+}
+
+var _ Store = &MemoryStore{}
@@ -0,0 +1,53 @@
+package cache
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type Metrics struct {
+	// RequestsTotal is the number of requests to cacheable endpoints.
+	// Labelled by result ('hit', 'miss' or 'revalidated').
+	RequestsTotal *prometheus.CounterVec
+
+	// Entries is the number of stored entries.
+	Entries prometheus.Gauge
+
+	// SizeBytes is the total size of the stored entries.
+	SizeBytes prometheus.Gauge
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		RequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "cache",
+				Name:      "requests_total",
+				Help:      "Number of requests to cacheable endpoints",
+			},
+			[]string{"result"},
+		),
+		Entries: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "piko",
+				Subsystem: "cache",
+				Name:      "entries",
+				Help:      "Number of stored cache entries",
+			},
+		),
+		SizeBytes: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: "piko",
+				Subsystem: "cache",
+				Name:      "size_bytes",
+				Help:      "Total size of the stored cache entries",
+			},
+		),
+	}
+}
+
+func (m *Metrics) Register(registry *prometheus.Registry) {
+	registry.MustRegister(
+		m.RequestsTotal,
+		m.Entries,
+		m.SizeBytes,
+	)
+}
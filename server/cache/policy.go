@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheControl contains the parsed directives from the 'Cache-Control'
+// header.
+//
+// Qualified 'no-cache' and 'private' directives (that list field names) are
+// treated as unqualified, which RFC 9111 permits.
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := make(cacheControl)
+	for _, v := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, value, _ := strings.Cut(directive, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			cc[name] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the value of a delta-seconds directive, such as 'max-age'.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		// An invalid value is treated as stale (RFC 9111 section 4.2.1).
+		return 0, true
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// cacheableStatus contains the status codes that are heuristically cacheable
+// (RFC 9110 section 15.1). Responses with other status codes are never
+// stored.
+var cacheableStatus = map[int]struct{}{
+	http.StatusOK:                   {},
+	http.StatusNonAuthoritativeInfo: {},
+	http.StatusNoContent:            {},
+	http.StatusMultipleChoices:      {},
+	http.StatusMovedPermanently:     {},
+	http.StatusPermanentRedirect:    {},
+	http.StatusNotFound:             {},
+	http.StatusMethodNotAllowed:     {},
+	http.StatusGone:                 {},
+	http.StatusRequestURITooLong:    {},
+	http.StatusNotImplemented:       {},
+}
+
+// storable returns whether a shared cache may store the response to the
+// given request (RFC 9111 section 3).
+func storable(r *http.Request, resp *http.Response, defaultTTL time.Duration) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	if _, ok := cacheableStatus[resp.StatusCode]; !ok {
+		return false
+	}
+
+	reqCC := parseCacheControl(r.Header)
+	if reqCC.has("no-store") {
+		return false
+	}
+
+	respCC := parseCacheControl(resp.Header)
+	if respCC.has("no-store") || respCC.has("private") {
+		return false
+	}
+
+	// A shared cache must not store responses to authenticated requests
+	// unless explicitly allowed (RFC 9111 section 3.5).
+	if r.Header.Get("Authorization") != "" {
+		if !respCC.has("public") && !respCC.has("s-maxage") && !respCC.has("must-revalidate") {
+			return false
+		}
+	}
+
+	for _, field := range varyFields(resp.Header) {
+		if field == "*" {
+			return false
+		}
+	}
+
+	// Avoid sharing per-client state between clients.
+	if resp.Header.Get("Set-Cookie") != "" {
+		return false
+	}
+
+	if respCC.has("s-maxage") || respCC.has("max-age") || resp.Header.Get("Expires") != "" {
+		return true
+	}
+	if resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != "" {
+		return true
+	}
+	return defaultTTL > 0
+}
+
+// varyFields returns the canonical request header names listed in the
+// 'Vary' header.
+func varyFields(h http.Header) []string {
+	var fields []string
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			if field == "*" {
+				fields = append(fields, field)
+				continue
+			}
+			fields = append(fields, http.CanonicalHeaderKey(field))
+		}
+	}
+	return fields
+}
+
+// unsafeMethod returns whether the method is unsafe, meaning a successful
+// response invalidates the cached responses for the target URI (RFC 9111
+// section 4.4).
+func unsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	default:
+		return true
+	}
+}
+
+// etagMatch returns whether any of the entity tags in an 'If-None-Match'
+// header match the given entity tag, using the weak comparison function.
+func etagMatch(ifNoneMatch string, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
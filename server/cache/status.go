@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/status"
+)
+
+const (
+	// localQuery is the query parameter set when a purge is broadcast to
+	// the other nodes in the cluster, so the receiving node only purges its
+	// local cache.
+	localQuery = "local"
+)
+
+type cacheStatus struct {
+	Entries   int   `json:"entries"`
+	SizeBytes int64 `json:"size_bytes"`
+}
+
+type purgeResult struct {
+	Purged int `json:"purged"`
+	// FailedNodes contains the IDs of the nodes that couldn't be purged.
+	FailedNodes []string `json:"failed_nodes,omitempty"`
+}
+
+type Status struct {
+	cache *Cache
+
+	// state is the cluster state used to broadcast purges to the other
+	// nodes, or nil if purges only apply to the local node.
+	state *cluster.State
+
+	scheme string
+	client *http.Client
+
+	logger log.Logger
+}
+
+// NewStatus creates the cache admin routes.
+//
+// Purges are broadcast to the other active nodes in the cluster using their
+// admin address, with peerTLS configuring TLS to the other nodes, or nil if
+// the admin server doesn't use TLS.
+func NewStatus(
+	cache *Cache,
+	state *cluster.State,
+	peerTLS *tls.Config,
+	logger log.Logger,
+) *Status {
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if peerTLS != nil {
+		scheme = "https"
+		transport.TLSClientConfig = peerTLS
+	}
+	return &Status{
+		cache:  cache,
+		state:  state,
+		scheme: scheme,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Second * 5,
+		},
+		logger: logger.WithSubsystem("cache"),
+	}
+}
+
+func (s *Status) Register(group *gin.RouterGroup) {
+	group.GET("", s.getCacheRoute)
+	group.DELETE("", s.purgeRoute)
+	group.DELETE("/endpoints/:endpointID", s.purgeEndpointRoute)
+}
+
+func (s *Status) getCacheRoute(c *gin.Context) {
+	entries, size := s.cache.Size()
+	c.JSON(http.StatusOK, &cacheStatus{
+		Entries:   entries,
+		SizeBytes: size,
+	})
+}
+
+// purgeRoute purges all stored responses from every node in the cluster.
+func (s *Status) purgeRoute(c *gin.Context) {
+	purged := s.cache.Purge("", "")
+	c.JSON(http.StatusOK, s.broadcastPurge(c, purged))
+}
+
+// purgeEndpointRoute purges the stored responses for an endpoint from every
+// node in the cluster. If the 'prefix' query is given, only responses whose
+// path has the given prefix are purged. If the 'tenant' query is given, the
+// endpoint is in that tenants namespace.
+func (s *Status) purgeEndpointRoute(c *gin.Context) {
+	endpointID := c.Param("endpointID")
+	prefix := c.Query("prefix")
+	purged := s.cache.Purge(cluster.EndpointKey(c.Query("tenant"), endpointID), prefix)
+	c.JSON(http.StatusOK, s.broadcastPurge(c, purged))
+}
+
+// broadcastPurge forwards the purge request to the other active nodes in the
+// cluster, and returns the total number of purged responses including the
+// given number purged locally.
+//
+// If the request was broadcast from another node, only the local cache is
+// purged.
+func (s *Status) broadcastPurge(c *gin.Context, purged int) *purgeResult {
+	result := &purgeResult{
+		Purged: purged,
+	}
+	if s.state == nil || c.Query(localQuery) == "true" {
+		return result
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range s.state.Nodes() {
+		if node.ID == s.state.LocalID() || node.Status != cluster.NodeStatusActive {
+			continue
+		}
+
+		wg.Add(1)
+		go func(node *cluster.Node) {
+			defer wg.Done()
+
+			nodePurged, err := s.purgeNode(c.Request, node.AdminAddr)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if err != nil {
+				s.logger.Warn(
+					"failed to purge node",
+					zap.String("node-id", node.ID),
+					zap.Error(err),
+				)
+				result.FailedNodes = append(result.FailedNodes, node.ID)
+				return
+			}
+			result.Purged += nodePurged
+		}(node)
+	}
+	wg.Wait()
+
+	return result
+}
+
+// purgeNode forwards the purge request to the node with the given admin
+// address, and returns the number of responses purged by that node.
+func (s *Status) purgeNode(r *http.Request, addr string) (int, error) {
+	query := r.URL.Query()
+	query.Del("forward")
+	query.Set(localQuery, "true")
+	u := url.URL{
+		Scheme:   s.scheme,
+		Host:     addr,
+		Path:     r.URL.Path,
+		RawQuery: query.Encode(),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*5)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, u.String(), nil)
+	if err != nil {
+		return 0, err
+	}
+	// The other nodes authenticate the request using the same credentials.
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("bad status: %d", resp.StatusCode)
+	}
+
+	var result purgeResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("decode: %w", err)
+	}
+	return result.Purged, nil
+}
+
+var _ status.Handler = &Status{}
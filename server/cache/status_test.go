@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+)
+
+func newStatusRouter(s *Status) *gin.Engine {
+	router := gin.New()
+	s.Register(router.Group("/status/cache"))
+	return router
+}
+
+func TestStatus_PurgeBroadcast(t *testing.T) {
+	now := time.Now()
+	header := http.Header{"Cache-Control": []string{"max-age=60"}}
+	newNodeCache := func() *Cache {
+		c := NewCacheWithStore(
+			NewMemoryStore(1<<20), defaultConfig(), log.NewNopLogger(),
+		)
+		for _, path := range []string{"/a/1", "/a/2", "/b/1"} {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			c.Store("endpoint-1", r, http.StatusOK, header, []byte("foo"), now, now)
+		}
+		return c
+	}
+
+	remoteCache := newNodeCache()
+	remoteState := cluster.NewState(&cluster.Node{ID: "remote"}, log.NewNopLogger())
+	remoteServer := httptest.NewServer(newStatusRouter(
+		NewStatus(remoteCache, remoteState, nil, log.NewNopLogger()),
+	))
+	defer remoteServer.Close()
+
+	// Find an address with nothing listening for an unreachable node.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unreachableAddr := ln.Addr().String()
+	ln.Close()
+
+	localCache := newNodeCache()
+	localState := cluster.NewState(&cluster.Node{ID: "local"}, log.NewNopLogger())
+	localState.AddNode(&cluster.Node{
+		ID:        "remote",
+		Status:    cluster.NodeStatusActive,
+		AdminAddr: remoteServer.Listener.Addr().String(),
+	})
+	localState.AddNode(&cluster.Node{
+		ID:        "unreachable",
+		Status:    cluster.NodeStatusActive,
+		AdminAddr: unreachableAddr,
+	})
+	localState.AddNode(&cluster.Node{
+		ID:        "left",
+		Status:    cluster.NodeStatusLeft,
+		AdminAddr: unreachableAddr,
+	})
+	router := newStatusRouter(
+		NewStatus(localCache, localState, nil, log.NewNopLogger()),
+	)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(
+		http.MethodDelete, "/status/cache/endpoints/endpoint-1?prefix=/a/", nil,
+	))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var result purgeResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, 4, result.Purged)
+	assert.Equal(t, []string{"unreachable"}, result.FailedNodes)
+
+	entries, _ := localCache.Size()
+	assert.Equal(t, 1, entries)
+	entries, _ = remoteCache.Size()
+	assert.Equal(t, 1, entries)
+
+	// Purge only the local node.
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(
+		http.MethodDelete, "/status/cache?local=true", nil,
+	))
+	require.Equal(t, http.StatusOK, w.Code)
+	result = purgeResult{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.Purged)
+	assert.Empty(t, result.FailedNodes)
+
+	entries, _ = localCache.Size()
+	assert.Equal(t, 0, entries)
+	entries, _ = remoteCache.Size()
+	assert.Equal(t, 1, entries)
+}
@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"container/list"
+	"strings"
+)
+
+// Store stores cache entries.
+type Store interface {
+	// Get returns the entry with the given key.
+	Get(key string) (*Entry, bool)
+
+	// Set stores the entry, evicting the least recently used entries if
+	// the store is full.
+	Set(entry *Entry)
+
+	// Delete removes the entry with the given key.
+	Delete(key string)
+
+	// DeletePrefix removes all entries whose key has the given prefix.
+	// Returns the number of removed entries.
+	DeletePrefix(prefix string) int
+
+	// Size returns the total size of the stored entries in bytes.
+	Size() int64
+
+	// Len returns the number of stored entries.
+	Len() int
+}
+
+type lruItem struct {
+	key   string
+	size  int64
+	value any
+}
+
+// lru indexes entries in order of use and tracks their total size.
+//
+// lru is not thread safe.
+type lru struct {
+	maxSize int64
+	size    int64
+
+	ll    *list.List
+	items map[string]*list.Element
+
+	// onEvict is called when an item is removed.
+	onEvict func(item *lruItem)
+}
+
+func newLRU(maxSize int64, onEvict func(item *lruItem)) *lru {
+	return &lru{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+		onEvict: onEvict,
+	}
+}
+
+// Get returns the item with the given key and marks it as recently used.
+func (l *lru) Get(key string) (*lruItem, bool) {
+	elem, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	l.ll.MoveToFront(elem)
+	return elem.Value.(*lruItem), true
+}
+
+// Add adds the item, replacing any existing item with the same key, then
+// evicts the least recently used items until within the maximum size.
+func (l *lru) Add(item *lruItem) {
+	if elem, ok := l.items[item.key]; ok {
+		l.remove(elem)
+	}
+
+	l.items[item.key] = l.ll.PushFront(item)
+	l.size += item.size
+
+	for l.size > l.maxSize {
+		oldest := l.ll.Back()
+		if oldest == nil {
+			return
+		}
+		l.remove(oldest)
+	}
+}
+
+// Remove removes the item with the given key.
+func (l *lru) Remove(key string) bool {
+	elem, ok := l.items[key]
+	if !ok {
+		return false
+	}
+	l.remove(elem)
+	return true
+}
+
+// RemovePrefix removes all items whose key has the given prefix.
+func (l *lru) RemovePrefix(prefix string) int {
+	var removed int
+	for key, elem := range l.items {
+		if strings.HasPrefix(key, prefix) {
+			l.remove(elem)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (l *lru) Size() int64 {
+	return l.size
+}
+
+func (l *lru) Len() int {
+	return len(l.items)
+}
+
+func (l *lru) remove(elem *list.Element) {
+	item := elem.Value.(*lruItem)
+	l.ll.Remove(elem)
+	delete(l.items, item.key)
+	l.size -= item.size
+
+	if l.onEvict != nil {
+		l.onEvict(item)
+	}
+}
@@ -0,0 +1,289 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/time/rate"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+)
+
+// ACMEManager obtains and renews certificates using ACME.
+//
+// Certificates are obtained when a client first connects with a server name
+// matching the configured domains, verified using either the HTTP-01 or
+// TLS-ALPN-01 challenge.
+//
+// To avoid obtaining certificates for arbitrary server names, hosts matching
+// a wildcard domain are only permitted if the hosts endpoint is active in the
+// cluster, and the rate of obtaining new certificates is limited.
+type ACMEManager struct {
+	manager *autocert.Manager
+
+	// domains contains the labels of each configured domain, where a '*'
+	// label matches any single label.
+	domains [][]string
+
+	// state is used to check whether the endpoint of a host matching a
+	// wildcard domain is active, or is nil if any matching host is
+	// permitted.
+	state *cluster.State
+
+	// endpointFromHost returns the endpoint ID and tenant ID from a host.
+	endpointFromHost func(host string) (string, string)
+
+	// issueLimiter limits the rate of obtaining new certificates.
+	issueLimiter *rate.Limiter
+
+	// admitted contains the hosts that are permitted to obtain a
+	// certificate, so checking the host policy again (which autocert does
+	// on each handshake) doesn't count towards the issue rate.
+	admitted map[string]struct{}
+
+	mu sync.Mutex
+
+	cache *clusterCache
+
+	httpServer *http.Server
+
+	logger log.Logger
+}
+
+// NewACMEManager creates an ACME manager with the given configuration.
+//
+// Hosts matching a wildcard domain are only permitted if endpointFromHost
+// returns an endpoint that is active in the cluster state. If state is nil,
+// any host matching the domains is permitted.
+//
+// If a cluster key is configured, entries missing from the local cache are
+// fetched from the other nodes in the cluster using their admin address,
+// where peerTLS is the TLS configuration to connect to other nodes, or nil if
+// the admin port doesn't use TLS.
+func NewACMEManager(
+	conf config.ACMEConfig,
+	state *cluster.State,
+	endpointFromHost func(host string) (string, string),
+	peerTLS *tls.Config,
+	logger log.Logger,
+) (*ACMEManager, error) {
+	logger = logger.WithSubsystem("certs.acme")
+
+	httpClient := &http.Client{}
+	if conf.RootCAs != "" {
+		caCert, err := os.ReadFile(conf.RootCAs)
+		if err != nil {
+			return nil, fmt.Errorf("open root cas: %s: %w", conf.RootCAs, err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("parse root cas: %s", conf.RootCAs)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs: caCertPool,
+		}
+		httpClient.Transport = transport
+	}
+
+	m := &ACMEManager{
+		state:            state,
+		endpointFromHost: endpointFromHost,
+		issueLimiter: rate.NewLimiter(
+			rate.Every(time.Hour/time.Duration(conf.IssueRate)), conf.IssueRate,
+		),
+		admitted: make(map[string]struct{}),
+		logger:   logger,
+	}
+	for _, domain := range conf.Domains {
+		m.domains = append(m.domains, strings.Split(strings.ToLower(domain), "."))
+	}
+
+	m.cache = newClusterCache(conf.CacheDir, conf.ClusterKey, state, peerTLS, logger)
+
+	m.manager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      m.cache,
+		HostPolicy: m.hostPolicy,
+		Email:      conf.Email,
+		Client: &acme.Client{
+			DirectoryURL: conf.DirectoryURL,
+			HTTPClient:   httpClient,
+		},
+	}
+	m.httpServer = &http.Server{
+		Handler:           m.manager.HTTPHandler(nil),
+		ReadHeaderTimeout: time.Second * 10,
+		ErrorLog:          logger.StdLogger(zapcore.WarnLevel),
+	}
+
+	return m, nil
+}
+
+// GetCertificateFunc returns a function to select the certificate to serve
+// for a TLS handshake, for use as tls.Config.GetCertificate.
+//
+// Certificates in the given store (which may be nil) take precedence over
+// ACME certificates. If the requested server name doesn't match either the
+// stores certificates or the ACME domains, the stores default certificate is
+// served.
+func (m *ACMEManager) GetCertificateFunc(
+	store *Store,
+) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		// TLS-ALPN-01 challenge.
+		if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+			return m.manager.GetCertificate(hello)
+		}
+
+		if store != nil {
+			if cert, ok := store.Match(hello.ServerName); ok {
+				return cert, nil
+			}
+		}
+		if m.hostPolicy(context.Background(), hello.ServerName) == nil {
+			cert, err := m.manager.GetCertificate(hello)
+			if err != nil {
+				m.logger.Warn(
+					"failed to get certificate",
+					zap.String("server-name", hello.ServerName),
+					zap.Error(err),
+				)
+			}
+			return cert, err
+		}
+		if store != nil {
+			return store.GetCertificate(hello)
+		}
+		return nil, fmt.Errorf("no certificate for server name: %q", hello.ServerName)
+	}
+}
+
+// LocalCacheGet returns the entry in the local cache with the given key,
+// or autocert.ErrCacheMiss if not found.
+func (m *ACMEManager) LocalCacheGet(ctx context.Context, key string) ([]byte, error) {
+	return m.cache.LocalGet(ctx, key)
+}
+
+// ServeHTTP serves HTTP-01 challenge responses on the given listener, and
+// redirects other requests to HTTPS.
+func (m *ACMEManager) ServeHTTP(ln net.Listener) error {
+	if err := m.httpServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("http serve: %w", err)
+	}
+	return nil
+}
+
+func (m *ACMEManager) Shutdown(ctx context.Context) error {
+	return m.httpServer.Shutdown(ctx)
+}
+
+// hostPolicy returns an error if the host isn't permitted to obtain a
+// certificate.
+//
+// The host must match one of the configured domains, and if it only matches
+// wildcard domains, the hosts endpoint must be active. Hosts without a
+// certificate are also limited by the issue rate.
+func (m *ACMEManager) hostPolicy(ctx context.Context, host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	labels := strings.Split(host, ".")
+
+	matched := false
+	wildcard := true
+	for _, domain := range m.domains {
+		if matchLabels(domain, labels) {
+			matched = true
+			if !slices.Contains(domain, "*") {
+				wildcard = false
+			}
+		}
+	}
+	if !matched {
+		return fmt.Errorf("host not permitted: %q", host)
+	}
+	if wildcard && !m.endpointActive(host) {
+		return fmt.Errorf("host endpoint not active: %q", host)
+	}
+	return m.admit(ctx, host)
+}
+
+// endpointActive returns whether the endpoint of the host has a listener
+// connected to the cluster.
+func (m *ACMEManager) endpointActive(host string) bool {
+	if m.state == nil {
+		return true
+	}
+	endpointID, tenantID := m.endpointFromHost(host)
+	if endpointID == "" {
+		return false
+	}
+	return m.state.EndpointActive(tenantID, endpointID)
+}
+
+// admit returns an error if the host doesn't have a certificate and the issue
+// rate is exceeded.
+func (m *ACMEManager) admit(ctx context.Context, host string) error {
+	m.mu.Lock()
+	_, ok := m.admitted[host]
+	m.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	// Hosts that already have a certificate, such as after a restart, don't
+	// count towards the issue rate.
+	if !m.hasCertificate(ctx, host) && !m.issueLimiter.Allow() {
+		m.logger.Warn(
+			"certificate issue rate exceeded",
+			zap.String("host", host),
+		)
+		return fmt.Errorf("certificate issue rate exceeded: %q", host)
+	}
+
+	m.mu.Lock()
+	m.admitted[host] = struct{}{}
+	m.mu.Unlock()
+	return nil
+}
+
+// hasCertificate returns whether the cache contains a certificate for the
+// host, using either an ECDSA or RSA key.
+func (m *ACMEManager) hasCertificate(ctx context.Context, host string) bool {
+	for _, key := range []string{host, host + "+rsa"} {
+		if _, err := m.cache.Get(ctx, key); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func matchLabels(pattern []string, labels []string) bool {
+	if len(pattern) != len(labels) {
+		return false
+	}
+	for i, label := range labels {
+		if label == "" {
+			return false
+		}
+		if pattern[i] != "*" && pattern[i] != label {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,153 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+)
+
+func newTestACMEManager(t *testing.T, domains []string) *ACMEManager {
+	t.Helper()
+
+	return newTestACMEManagerWithState(t, domains, nil, 100)
+}
+
+func newTestACMEManagerWithState(
+	t *testing.T,
+	domains []string,
+	state *cluster.State,
+	issueRate int,
+) *ACMEManager {
+	t.Helper()
+
+	m, err := NewACMEManager(config.ACMEConfig{
+		Domains:      domains,
+		DirectoryURL: "https://acme.example.com/directory",
+		AcceptTOS:    true,
+		CacheDir:     t.TempDir(),
+		IssueRate:    issueRate,
+	}, state, endpointFromHost, nil, log.NewNopLogger())
+	require.NoError(t, err)
+	return m
+}
+
+// endpointFromHost returns the bottom-level domain as the endpoint ID.
+func endpointFromHost(host string) (string, string) {
+	endpointID, _, _ := strings.Cut(host, ".")
+	return endpointID, ""
+}
+
+func TestACMEManager_HostPolicy(t *testing.T) {
+	m := newTestACMEManager(t, []string{
+		"*.piko.example.com",
+		"*.*.tenants.example.com",
+		"api.customer.com",
+	})
+
+	tests := []struct {
+		host      string
+		permitted bool
+	}{
+		{"my-endpoint.piko.example.com", true},
+		{"MY-ENDPOINT.Piko.Example.com.", true},
+		{"my-endpoint.my-tenant.tenants.example.com", true},
+		{"api.customer.com", true},
+		{"piko.example.com", false},
+		{"a.b.piko.example.com", false},
+		{".piko.example.com", false},
+		{"my-endpoint.tenants.example.com", false},
+		{"www.customer.com", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			err := m.hostPolicy(context.Background(), tt.host)
+			if tt.permitted {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestACMEManager_HostPolicyEndpointActive(t *testing.T) {
+	state := cluster.NewState(&cluster.Node{ID: "local"}, log.NewNopLogger())
+	state.AddLocalEndpoint("active-endpoint")
+
+	m := newTestACMEManagerWithState(t, []string{
+		"*.piko.example.com",
+		"inactive-endpoint.customer.com",
+	}, state, 100)
+
+	// Hosts matching a wildcard domain require an active endpoint.
+	assert.NoError(t, m.hostPolicy(context.Background(), "active-endpoint.piko.example.com"))
+	assert.Error(t, m.hostPolicy(context.Background(), "inactive-endpoint.piko.example.com"))
+
+	// Hosts matching a domain without a wildcard are always permitted.
+	assert.NoError(t, m.hostPolicy(context.Background(), "inactive-endpoint.customer.com"))
+}
+
+func TestACMEManager_HostPolicyIssueRate(t *testing.T) {
+	m := newTestACMEManagerWithState(t, []string{"*.piko.example.com"}, nil, 2)
+
+	// Store a certificate for a host, which doesn't count towards the rate.
+	require.NoError(t, m.cache.Put(
+		context.Background(), "existing.piko.example.com", []byte("cert"),
+	))
+	assert.NoError(t, m.hostPolicy(context.Background(), "existing.piko.example.com"))
+
+	assert.NoError(t, m.hostPolicy(context.Background(), "foo.piko.example.com"))
+	assert.NoError(t, m.hostPolicy(context.Background(), "bar.piko.example.com"))
+	assert.Error(t, m.hostPolicy(context.Background(), "baz.piko.example.com"))
+
+	// Hosts already admitted are still permitted.
+	assert.NoError(t, m.hostPolicy(context.Background(), "foo.piko.example.com"))
+	assert.NoError(t, m.hostPolicy(context.Background(), "existing.piko.example.com"))
+}
+
+func TestACMEManager_GetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	expiry := time.Now().Add(time.Hour)
+
+	store, err := NewStore("proxy", []config.CertificateConfig{
+		writeCert(t, dir, "default", []string{"default.com"}, expiry),
+		writeCert(t, dir, "static", []string{"static.piko.example.com"}, expiry),
+	}, nil, log.NewNopLogger())
+	require.NoError(t, err)
+
+	m := newTestACMEManager(t, []string{"*.piko.example.com"})
+
+	t.Run("static certificate", func(t *testing.T) {
+		// Static certificates take precedence over ACME.
+		cert, err := m.GetCertificateFunc(store)(&tls.ClientHelloInfo{
+			ServerName: "static.piko.example.com",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "static", commonName(t, cert))
+	})
+
+	t.Run("unknown domain", func(t *testing.T) {
+		cert, err := m.GetCertificateFunc(store)(&tls.ClientHelloInfo{
+			ServerName: "unknown.com",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "default", commonName(t, cert))
+	})
+
+	t.Run("unknown domain no store", func(t *testing.T) {
+		_, err := m.GetCertificateFunc(nil)(&tls.ClientHelloInfo{
+			ServerName: "unknown.com",
+		})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,152 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+)
+
+const (
+	// ClusterKeyHeader is the header containing the cluster key when
+	// fetching ACME cache entries from other nodes.
+	ClusterKeyHeader = "x-piko-cluster-key"
+)
+
+// clusterCache stores ACME data, such as certificates and challenge
+// responses, in a local directory.
+//
+// When an entry is missing from the local directory, such as a challenge
+// response created by another node, it is fetched from the other nodes in the
+// cluster.
+//
+// Entries fetched from other nodes aren't stored locally, so a node doesn't
+// serve a stale certificate after it is renewed by another node.
+type clusterCache struct {
+	local autocert.DirCache
+
+	// key is the cluster key to authenticate with other nodes, or empty if
+	// entries aren't fetched from other nodes.
+	key string
+
+	state *cluster.State
+
+	scheme string
+	client *http.Client
+
+	logger log.Logger
+}
+
+func newClusterCache(
+	dir string,
+	key string,
+	state *cluster.State,
+	peerTLS *tls.Config,
+	logger log.Logger,
+) *clusterCache {
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if peerTLS != nil {
+		scheme = "https"
+		transport.TLSClientConfig = peerTLS
+	}
+	return &clusterCache{
+		local:  autocert.DirCache(dir),
+		key:    key,
+		state:  state,
+		scheme: scheme,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Second * 5,
+		},
+		logger: logger,
+	}
+}
+
+func (c *clusterCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.local.Get(ctx, key)
+	if !errors.Is(err, autocert.ErrCacheMiss) || c.key == "" || c.state == nil {
+		return data, err
+	}
+
+	for _, node := range c.state.Nodes() {
+		if node.ID == c.state.LocalID() || node.Status != cluster.NodeStatusActive {
+			continue
+		}
+
+		data, err := c.fetch(ctx, node.AdminAddr, key)
+		if err != nil {
+			c.logger.Warn(
+				"failed to fetch cache entry from node",
+				zap.String("node-id", node.ID),
+				zap.String("key", key),
+				zap.Error(err),
+			)
+			continue
+		}
+		if data != nil {
+			return data, nil
+		}
+	}
+	return nil, autocert.ErrCacheMiss
+}
+
+// LocalGet returns the entry from the local directory, without fetching from
+// other nodes.
+func (c *clusterCache) LocalGet(ctx context.Context, key string) ([]byte, error) {
+	return c.local.Get(ctx, key)
+}
+
+func (c *clusterCache) Put(ctx context.Context, key string, data []byte) error {
+	return c.local.Put(ctx, key, data)
+}
+
+func (c *clusterCache) Delete(ctx context.Context, key string) error {
+	return c.local.Delete(ctx, key)
+}
+
+// fetch fetches the cache entry from the node with the given admin address.
+// Returns nil if the node doesn't have the entry.
+func (c *clusterCache) fetch(
+	ctx context.Context,
+	addr string,
+	key string,
+) ([]byte, error) {
+	u := url.URL{
+		Scheme: c.scheme,
+		Host:   addr,
+		Path:   "/acme/cache/" + key,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(ClusterKeyHeader, c.key)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(resp.Body)
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("bad status: %d", resp.StatusCode)
+	}
+}
+
+var _ autocert.Cache = &clusterCache{}
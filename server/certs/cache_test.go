@@ -0,0 +1,95 @@
+package certs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+)
+
+func TestClusterCache(t *testing.T) {
+	// Fake the admin route of a remote node.
+	remote := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get(ClusterKeyHeader) != "my-key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Path != "/acme/cache/remote-key" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte("remote-value"))
+		},
+	))
+	defer remote.Close()
+
+	state := cluster.NewState(&cluster.Node{
+		ID: "local",
+	}, log.NewNopLogger())
+	state.AddNode(&cluster.Node{
+		ID:        "remote",
+		Status:    cluster.NodeStatusActive,
+		AdminAddr: strings.TrimPrefix(remote.URL, "http://"),
+	})
+
+	t.Run("local", func(t *testing.T) {
+		cache := newClusterCache(
+			t.TempDir(), "my-key", state, nil, log.NewNopLogger(),
+		)
+		require.NoError(t, cache.Put(context.TODO(), "local-key", []byte("local-value")))
+
+		data, err := cache.Get(context.TODO(), "local-key")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("local-value"), data)
+	})
+
+	t.Run("remote", func(t *testing.T) {
+		cache := newClusterCache(
+			t.TempDir(), "my-key", state, nil, log.NewNopLogger(),
+		)
+
+		data, err := cache.Get(context.TODO(), "remote-key")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("remote-value"), data)
+
+		// Remote entries aren't stored locally.
+		_, err = cache.LocalGet(context.TODO(), "remote-key")
+		assert.ErrorIs(t, err, autocert.ErrCacheMiss)
+	})
+
+	t.Run("miss", func(t *testing.T) {
+		cache := newClusterCache(
+			t.TempDir(), "my-key", state, nil, log.NewNopLogger(),
+		)
+
+		_, err := cache.Get(context.TODO(), "unknown-key")
+		assert.ErrorIs(t, err, autocert.ErrCacheMiss)
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		cache := newClusterCache(
+			t.TempDir(), "invalid-key", state, nil, log.NewNopLogger(),
+		)
+
+		_, err := cache.Get(context.TODO(), "remote-key")
+		assert.ErrorIs(t, err, autocert.ErrCacheMiss)
+	})
+
+	t.Run("no cluster key", func(t *testing.T) {
+		cache := newClusterCache(
+			t.TempDir(), "", state, nil, log.NewNopLogger(),
+		)
+
+		_, err := cache.Get(context.TODO(), "remote-key")
+		assert.ErrorIs(t, err, autocert.ErrCacheMiss)
+	})
+}
@@ -0,0 +1,156 @@
+package certs
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// tlsRecordTypeHandshake is the first byte of a TLS client hello.
+	tlsRecordTypeHandshake = 0x16
+
+	// sniffTimeout is the maximum time to wait for the first byte of a
+	// connection.
+	sniffTimeout = time.Second * 10
+)
+
+// SplitListener splits the connections accepted by the given listener into
+// TLS connections and plain connections, by inspecting the first byte sent by
+// the client.
+//
+// Closing the returned listeners only closes the underlying listener once
+// both are closed.
+func SplitListener(ln net.Listener) (tlsLn net.Listener, plainLn net.Listener) {
+	s := &splitListener{
+		ln:       ln,
+		closeCh:  make(chan struct{}),
+		children: 2,
+	}
+	tlsChild := &childListener{
+		parent:  s,
+		connCh:  make(chan net.Conn),
+		closeCh: make(chan struct{}),
+	}
+	plainChild := &childListener{
+		parent:  s,
+		connCh:  make(chan net.Conn),
+		closeCh: make(chan struct{}),
+	}
+	go s.run(tlsChild, plainChild)
+	return tlsChild, plainChild
+}
+
+type splitListener struct {
+	ln net.Listener
+
+	// closeCh is closed when the underlying listener fails.
+	closeCh chan struct{}
+	err     error
+
+	// children is the number of child listeners that haven't been closed.
+	children int
+	mu       sync.Mutex
+}
+
+func (s *splitListener) run(tlsChild *childListener, plainChild *childListener) {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			s.err = err
+			close(s.closeCh)
+			return
+		}
+
+		go func() {
+			conn, isTLS, err := sniff(conn)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			child := plainChild
+			if isTLS {
+				child = tlsChild
+			}
+			select {
+			case child.connCh <- conn:
+			case <-child.closeCh:
+				conn.Close()
+			case <-s.closeCh:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+func (s *splitListener) closeChild() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.children--
+	if s.children == 0 {
+		return s.ln.Close()
+	}
+	return nil
+}
+
+type childListener struct {
+	parent *splitListener
+
+	connCh    chan net.Conn
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *childListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, net.ErrClosed
+	case <-l.parent.closeCh:
+		return nil, l.parent.err
+	}
+}
+
+func (l *childListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closeCh)
+		err = l.parent.closeChild()
+	})
+	return err
+}
+
+func (l *childListener) Addr() net.Addr {
+	return l.parent.ln.Addr()
+}
+
+// sniff reads the first byte of the connection to determine whether the
+// client is using TLS. The returned connection replays the read byte.
+func sniff(conn net.Conn) (net.Conn, bool, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(sniffTimeout)); err != nil {
+		return conn, false, err
+	}
+	r := bufio.NewReaderSize(conn, 16)
+	b, err := r.Peek(1)
+	if err != nil {
+		return conn, false, err
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return conn, false, err
+	}
+	return &peekedConn{Conn: conn, r: r}, b[0] == tlsRecordTypeHandshake, nil
+}
+
+// peekedConn is a connection that reads from a buffered reader containing
+// bytes already read from the connection.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,76 @@
+package certs
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	tlsLn, plainLn := SplitListener(ln)
+
+	t.Run("plain", func(t *testing.T) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\n"))
+		require.NoError(t, err)
+
+		accepted, err := plainLn.Accept()
+		require.NoError(t, err)
+		defer accepted.Close()
+
+		// The sniffed byte is replayed.
+		line, err := bufio.NewReader(accepted).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "GET / HTTP/1.1\r\n", line)
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		go func() {
+			// Send a client hello. The handshake won't complete.
+			_ = tls.Client(conn, &tls.Config{
+				ServerName: "example.com",
+			}).Handshake()
+		}()
+
+		accepted, err := tlsLn.Accept()
+		require.NoError(t, err)
+		defer accepted.Close()
+
+		b := make([]byte, 1)
+		_, err = io.ReadFull(accepted, b)
+		require.NoError(t, err)
+		assert.Equal(t, byte(tlsRecordTypeHandshake), b[0])
+	})
+
+	t.Run("close", func(t *testing.T) {
+		require.NoError(t, plainLn.Close())
+
+		// The underlying listener is only closed once both listeners
+		// are closed.
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		conn.Close()
+
+		require.NoError(t, tlsLn.Close())
+
+		_, err = tlsLn.Accept()
+		assert.ErrorIs(t, err, net.ErrClosed)
+		_, err = net.Dial("tcp", ln.Addr().String())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,30 @@
+package certs
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type Metrics struct {
+	// ExpiryTimestampSeconds is the expiry time of each served certificate
+	// as a Unix timestamp. Labelled by listener ('proxy', 'upstream' or
+	// 'admin') and certificate file path.
+	ExpiryTimestampSeconds *prometheus.GaugeVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		ExpiryTimestampSeconds: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: "piko",
+				Subsystem: "tls",
+				Name:      "certificate_expiry_timestamp_seconds",
+				Help:      "Expiry time of each served certificate as a Unix timestamp",
+			},
+			[]string{"listener", "certificate"},
+		),
+	}
+}
+
+func (m *Metrics) Register(registry *prometheus.Registry) {
+	registry.MustRegister(
+		m.ExpiryTimestampSeconds,
+	)
+}
@@ -0,0 +1,262 @@
+// Package certs serves TLS certificates loaded from files, which are reloaded
+// when the files change.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/config"
+)
+
+const (
+	// defaultReloadInterval is the interval to check the certificate files
+	// for changes if no interval is configured.
+	defaultReloadInterval = time.Second * 10
+)
+
+// certificates contains the loaded certificates.
+type certificates struct {
+	// names contains the certificate for each lower case DNS name, including
+	// wildcard names such as '*.example.com'.
+	names map[string]*tls.Certificate
+
+	// fallback is the certificate served when no certificate matches the
+	// requested server name.
+	fallback *tls.Certificate
+}
+
+// fileInfo is used to detect when a file changes.
+type fileInfo struct {
+	modTime time.Time
+	size    int64
+}
+
+// Store serves certificates loaded from PEM encoded files, selecting the
+// certificate using the server name (SNI) requested by the client.
+//
+// The files are reloaded when they change, such as when certificates are
+// rotated by cert-manager.
+type Store struct {
+	listener string
+	pairs    []config.CertificateConfig
+
+	certs atomic.Pointer[certificates]
+
+	// files contains the info of each loaded file, used to detect changes.
+	files map[string]fileInfo
+	// mu protects files, and ensures a single reload runs at a time.
+	mu sync.Mutex
+
+	metrics *Metrics
+
+	logger log.Logger
+}
+
+// NewStore creates a store for the given certificate pairs, where the first
+// pair is the default certificate. Returns an error if the certificates
+// can't be loaded.
+//
+// The listener names the listener serving the certificates, which is used to
+// label metrics.
+func NewStore(
+	listener string,
+	pairs []config.CertificateConfig,
+	metrics *Metrics,
+	logger log.Logger,
+) (*Store, error) {
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no certificates")
+	}
+
+	s := &Store{
+		listener: listener,
+		pairs:    pairs,
+		metrics:  metrics,
+		logger:   logger.WithSubsystem("certs"),
+	}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetCertificate returns the certificate matching the requested server name,
+// or the default certificate if none match.
+//
+// See tls.Config.GetCertificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert, ok := s.Match(hello.ServerName); ok {
+		return cert, nil
+	}
+	return s.certs.Load().fallback, nil
+}
+
+// Match returns the certificate matching the server name, or false if no
+// certificate matches.
+func (s *Store) Match(serverName string) (*tls.Certificate, bool) {
+	certs := s.certs.Load()
+
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if name == "" {
+		return nil, false
+	}
+	if cert, ok := certs.names[name]; ok {
+		return cert, true
+	}
+	// Wildcards only match a single label.
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := certs.names["*"+name[i:]]; ok {
+			return cert, true
+		}
+	}
+	return nil, false
+}
+
+// Reload loads the certificate files. If any certificate is invalid, the
+// existing certificates are kept.
+func (s *Store) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := make(map[string]fileInfo)
+	certs := &certificates{
+		names: make(map[string]*tls.Certificate),
+	}
+	loaded := make([]*tls.Certificate, 0, len(s.pairs))
+	for _, pair := range s.pairs {
+		// Stat the files before loading, so if a file is updated while
+		// loading it will be reloaded.
+		for _, path := range []string{pair.Cert, pair.Key} {
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("certificate: %w", err)
+			}
+			files[path] = fileInfo{
+				modTime: info.ModTime(),
+				size:    info.Size(),
+			}
+		}
+
+		cert, err := tls.LoadX509KeyPair(pair.Cert, pair.Key)
+		if err != nil {
+			return fmt.Errorf("certificate: %s: load key pair: %w", pair.Cert, err)
+		}
+		if cert.Leaf == nil {
+			cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				return fmt.Errorf("certificate: %s: parse: %w", pair.Cert, err)
+			}
+		}
+		loaded = append(loaded, &cert)
+
+		if certs.fallback == nil {
+			certs.fallback = &cert
+		}
+		for _, name := range certificateNames(cert.Leaf) {
+			// If multiple certificates have the same name, the first
+			// configured certificate is used.
+			if _, ok := certs.names[name]; !ok {
+				certs.names[name] = &cert
+			}
+		}
+	}
+
+	s.certs.Store(certs)
+	s.files = files
+
+	if s.metrics != nil {
+		s.metrics.ExpiryTimestampSeconds.DeletePartialMatch(prometheus.Labels{
+			"listener": s.listener,
+		})
+		for i, cert := range loaded {
+			s.metrics.ExpiryTimestampSeconds.With(prometheus.Labels{
+				"listener":    s.listener,
+				"certificate": s.pairs[i].Cert,
+			}).Set(float64(cert.Leaf.NotAfter.Unix()))
+		}
+	}
+
+	return nil
+}
+
+// Watch reloads the certificates when the files change, until the context is
+// cancelled.
+func (s *Store) Watch(ctx context.Context, interval time.Duration) {
+	if interval == 0 {
+		interval = defaultReloadInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if !s.changed() {
+			continue
+		}
+		if err := s.Reload(); err != nil {
+			// The files may be partially written, so will retry on the next
+			// interval.
+			s.logger.Warn(
+				"failed to reload certificates",
+				zap.String("listener", s.listener),
+				zap.Error(err),
+			)
+			continue
+		}
+		s.logger.Info(
+			"reloaded certificates",
+			zap.String("listener", s.listener),
+		)
+	}
+}
+
+// changed returns whether any of the certificate files have changed since
+// they were last loaded.
+func (s *Store) changed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for path, loaded := range s.files {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(loaded.modTime) || info.Size() != loaded.size {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateNames returns the lower case DNS names the certificate is valid
+// for.
+func certificateNames(cert *x509.Certificate) []string {
+	names := cert.DNSNames
+	if len(names) == 0 && cert.Subject.CommonName != "" {
+		names = []string{cert.Subject.CommonName}
+	}
+
+	lower := make([]string, 0, len(names))
+	for _, name := range names {
+		lower = append(lower, strings.ToLower(name))
+	}
+	return lower
+}
@@ -0,0 +1,171 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/config"
+)
+
+// writeCert writes a self-signed certificate for the given DNS names to the
+// directory.
+func writeCert(
+	t *testing.T,
+	dir string,
+	name string,
+	dnsNames []string,
+	notAfter time.Time,
+) config.CertificateConfig {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, template, template, &key.PublicKey, key,
+	)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	conf := config.CertificateConfig{
+		Cert: filepath.Join(dir, name+".crt"),
+		Key:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(conf.Cert, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: der,
+	}), 0o600))
+	require.NoError(t, os.WriteFile(conf.Key, pem.EncodeToMemory(&pem.Block{
+		Type: "EC PRIVATE KEY", Bytes: keyDER,
+	}), 0o600))
+	return conf
+}
+
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func TestStore_GetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	expiry := time.Now().Add(time.Hour)
+
+	store, err := NewStore("proxy", []config.CertificateConfig{
+		writeCert(t, dir, "default", []string{"default.com"}, expiry),
+		writeCert(t, dir, "wildcard-com", []string{"*.example.com"}, expiry),
+		writeCert(t, dir, "wildcard-org", []string{"*.example.org"}, expiry),
+		writeCert(t, dir, "exact", []string{"api.example.com"}, expiry),
+	}, nil, log.NewNopLogger())
+	require.NoError(t, err)
+
+	tests := []struct {
+		serverName string
+		expected   string
+	}{
+		{"default.com", "default"},
+		{"foo.example.com", "wildcard-com"},
+		{"FOO.Example.com.", "wildcard-com"},
+		{"foo.example.org", "wildcard-org"},
+		// Exact names take precedence over wildcards.
+		{"api.example.com", "exact"},
+		// Wildcards only match a single label.
+		{"foo.bar.example.com", "default"},
+		{"example.com", "default"},
+		{"unknown.com", "default"},
+		{"", "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			cert, err := store.GetCertificate(&tls.ClientHelloInfo{
+				ServerName: tt.serverName,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, commonName(t, cert))
+		})
+	}
+}
+
+func TestStore_Watch(t *testing.T) {
+	dir := t.TempDir()
+
+	metrics := NewMetrics()
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	conf := writeCert(t, dir, "cert", []string{"example.com"}, expiry)
+
+	store, err := NewStore(
+		"proxy", []config.CertificateConfig{conf}, metrics, log.NewNopLogger(),
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, float64(expiry.Unix()), testutil.ToFloat64(
+		metrics.ExpiryTimestampSeconds.WithLabelValues("proxy", conf.Cert),
+	))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Watch(ctx, time.Millisecond*10)
+
+	// Rotate the certificate.
+	rotatedExpiry := expiry.Add(time.Hour)
+	writeCert(t, dir, "cert", []string{"example.com"}, rotatedExpiry)
+
+	assert.Eventually(t, func() bool {
+		cert, err := store.GetCertificate(&tls.ClientHelloInfo{
+			ServerName: "example.com",
+		})
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		return leaf.NotAfter.Equal(rotatedExpiry)
+	}, time.Second, time.Millisecond*10)
+
+	assert.Equal(t, float64(rotatedExpiry.Unix()), testutil.ToFloat64(
+		metrics.ExpiryTimestampSeconds.WithLabelValues("proxy", conf.Cert),
+	))
+}
+
+func TestStore_ReloadInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	conf := writeCert(t, dir, "cert", []string{"example.com"}, time.Now().Add(time.Hour))
+	store, err := NewStore(
+		"proxy", []config.CertificateConfig{conf}, nil, log.NewNopLogger(),
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(conf.Key, []byte("invalid"), 0o600))
+	assert.Error(t, store.Reload())
+
+	// The existing certificate is kept.
+	cert, err := store.GetCertificate(&tls.ClientHelloInfo{
+		ServerName: "example.com",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "cert", commonName(t, cert))
+}
@@ -0,0 +1,36 @@
+package cluster
+
+import (
+	"strings"
+)
+
+// endpointKeySeparator separates the tenant ID and endpoint ID in an endpoint
+// key. Endpoint IDs must not contain the separator.
+const endpointKeySeparator = "/"
+
+// EndpointKey returns the key identifying the endpoint with the given ID in
+// the tenants namespace.
+//
+// Endpoints are namespaced by tenant, so different tenants can register the
+// same endpoint ID. The default tenant uses the endpoint ID as the key.
+func EndpointKey(tenantID, endpointID string) string {
+	if tenantID == "" {
+		return endpointID
+	}
+	return tenantID + endpointKeySeparator + endpointID
+}
+
+// ParseEndpointKey returns the tenant ID and endpoint ID from an endpoint key.
+func ParseEndpointKey(key string) (string, string) {
+	i := strings.LastIndex(key, endpointKeySeparator)
+	if i < 0 {
+		return "", key
+	}
+	return key[:i], key[i+len(endpointKeySeparator):]
+}
+
+// ValidEndpointID returns whether the endpoint ID is valid. Endpoint IDs
+// must not be empty or contain the namespace separator.
+func ValidEndpointID(endpointID string) bool {
+	return endpointID != "" && !strings.Contains(endpointID, endpointKeySeparator)
+}
@@ -0,0 +1,33 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointKey(t *testing.T) {
+	tests := []struct {
+		tenantID   string
+		endpointID string
+		key        string
+	}{
+		{"", "my-endpoint", "my-endpoint"},
+		{"my-tenant", "my-endpoint", "my-tenant/my-endpoint"},
+		{"my/tenant", "my-endpoint", "my/tenant/my-endpoint"},
+	}
+	for _, tt := range tests {
+		key := EndpointKey(tt.tenantID, tt.endpointID)
+		assert.Equal(t, tt.key, key)
+
+		tenantID, endpointID := ParseEndpointKey(key)
+		assert.Equal(t, tt.tenantID, tenantID)
+		assert.Equal(t, tt.endpointID, endpointID)
+	}
+}
+
+func TestValidEndpointID(t *testing.T) {
+	assert.True(t, ValidEndpointID("my-endpoint"))
+	assert.False(t, ValidEndpointID(""))
+	assert.False(t, ValidEndpointID("my-tenant/my-endpoint"))
+}
@@ -47,9 +47,15 @@ type Node struct {
 	// Endpoints contains the known active endpoints on the node (endpoints
 	// with at least one upstream listener).
 	//
-	// This maps the endpoint ID to the number of known listeners for that
-	// endpoint.
+	// This maps the endpoint key, which namespaces the endpoint ID by tenant
+	// (see EndpointKey), to the number of known listeners for that endpoint.
 	Endpoints map[string]int `json:"endpoints"`
+
+	// Connections contains the number of active proxied connections to
+	// upstreams connected to the node, keyed by tenant ID.
+	//
+	// Connections are only tracked for tenants with a connection quota.
+	Connections map[string]int `json:"connections,omitempty"`
 }
 
 func (n *Node) Copy() *Node {
@@ -60,12 +66,20 @@ func (n *Node) Copy() *Node {
 			endpoints[endpointID] = listeners
 		}
 	}
+	var connections map[string]int
+	if len(n.Connections) > 0 {
+		connections = make(map[string]int)
+		for tenantID, conns := range n.Connections {
+			connections[tenantID] = conns
+		}
+	}
 	return &Node{
-		ID:        n.ID,
-		Status:    n.Status,
-		ProxyAddr: n.ProxyAddr,
-		AdminAddr: n.AdminAddr,
-		Endpoints: endpoints,
+		ID:          n.ID,
+		Status:      n.Status,
+		ProxyAddr:   n.ProxyAddr,
+		AdminAddr:   n.AdminAddr,
+		Endpoints:   endpoints,
+		Connections: connections,
 	}
 }
 
@@ -84,6 +98,25 @@ func (n *Node) Metadata() *NodeMetadata {
 	}
 }
 
+// TenantUsage contains the usage of a tenant across the cluster.
+type TenantUsage struct {
+	// Endpoints maps the tenants active endpoint IDs to the number of
+	// connected upstreams.
+	Endpoints map[string]int `json:"endpoints"`
+
+	// Connections is the number of active proxied connections.
+	Connections int `json:"connections"`
+}
+
+// Upstreams returns the total number of connected upstreams.
+func (u *TenantUsage) Upstreams() int {
+	upstreams := 0
+	for _, endpointUpstreams := range u.Endpoints {
+		upstreams += endpointUpstreams
+	}
+	return upstreams
+}
+
 // NodeMetadata contains metadata fields from Node.
 type NodeMetadata struct {
 	ID        string     `json:"id"`
@@ -19,6 +19,14 @@ type State struct {
 	localEndpointSubscribers  []func(endpointID string)
 	remoteEndpointSubscribers []func(nodeID string, endpointID string)
 
+	localConnectionsSubscribers []func(tenantID string)
+
+	// reservedUpstreams maps endpoint keys to the number of upstreams
+	// reserved on the local node that haven't yet been added as endpoint
+	// listeners. Reservations are included in the tenant usage but aren't
+	// propagated to other nodes.
+	reservedUpstreams map[string]int
+
 	// mu protects the above fields.
 	mu sync.RWMutex
 
@@ -37,10 +45,11 @@ func NewState(
 	nodes[localNode.ID] = localNode
 
 	s := &State{
-		localID: localNode.ID,
-		nodes:   nodes,
-		metrics: NewMetrics(),
-		logger:  logger.WithSubsystem("cluster"),
+		localID:           localNode.ID,
+		nodes:             nodes,
+		reservedUpstreams: make(map[string]int),
+		metrics:           NewMetrics(),
+		logger:            logger.WithSubsystem("cluster"),
 	}
 	s.addMetricsNode(localNode.Status)
 	return s
@@ -124,6 +133,35 @@ func (s *State) LookupEndpoint(endpointID string) (*Node, bool) {
 	return nil, false
 }
 
+// EndpointActive returns whether the endpoint with the given ID has a
+// listener on any active node, including the local node.
+//
+// If the tenant ID is empty, matches the endpoint in any tenant.
+func (s *State) EndpointActive(tenantID string, endpointID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, node := range s.nodes {
+		if node.ID != s.localID && node.Status != NodeStatusActive {
+			// Ignore unreachable and left nodes.
+			continue
+		}
+		for key, listeners := range node.Endpoints {
+			if listeners == 0 {
+				continue
+			}
+			keyTenantID, keyEndpointID := ParseEndpointKey(key)
+			if keyEndpointID != endpointID {
+				continue
+			}
+			if tenantID == "" || keyTenantID == tenantID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // AddLocalEndpoint adds the active endpoint to the local node state.
 func (s *State) AddLocalEndpoint(endpointID string) {
 	s.mu.Lock()
@@ -219,6 +257,215 @@ func (s *State) OnRemoteEndpointUpdate(f func(nodeID string, endpointID string))
 	s.remoteEndpointSubscribers = append(s.remoteEndpointSubscribers, f)
 }
 
+// AddLocalConnection adds an active proxied connection for the tenant to the
+// local node state.
+func (s *State) AddLocalConnection(tenantID string) {
+	s.addLocalConnection(tenantID, 0)
+}
+
+// ReserveLocalConnection adds an active proxied connection for the tenant to
+// the local node state, unless the tenant already has maxConnections active
+// connections across the cluster.
+//
+// Checking the connections and adding the connection is a single atomic
+// operation, so concurrent reservations on the local node can't exceed
+// maxConnections. Returns false if the connection wasn't added.
+func (s *State) ReserveLocalConnection(tenantID string, maxConnections int) bool {
+	return s.addLocalConnection(tenantID, maxConnections)
+}
+
+// addLocalConnection adds an active proxied connection for the tenant, unless
+// maxConnections is non-zero and the tenant already has maxConnections
+// active connections across the cluster.
+func (s *State) addLocalConnection(tenantID string, maxConnections int) bool {
+	s.mu.Lock()
+
+	node, ok := s.nodes[s.localID]
+	if !ok {
+		panic("local node not in cluster")
+	}
+
+	if maxConnections != 0 && s.tenantConnectionsLocked(tenantID) >= maxConnections {
+		s.mu.Unlock()
+		return false
+	}
+
+	if node.Connections == nil {
+		node.Connections = make(map[string]int)
+	}
+
+	node.Connections[tenantID] = node.Connections[tenantID] + 1
+
+	subscribers := make([]func(tenantID string), 0, len(s.localConnectionsSubscribers))
+	subscribers = append(subscribers, s.localConnectionsSubscribers...)
+
+	s.mu.Unlock()
+
+	for _, f := range subscribers {
+		f(tenantID)
+	}
+	return true
+}
+
+// RemoveLocalConnection removes an active proxied connection for the tenant
+// from the local node state.
+func (s *State) RemoveLocalConnection(tenantID string) {
+	s.mu.Lock()
+
+	node, ok := s.nodes[s.localID]
+	if !ok {
+		panic("local node not in cluster")
+	}
+
+	conns, ok := node.Connections[tenantID]
+	if !ok || conns == 0 {
+		s.logger.Warn("remove local connection: tenant not found")
+		s.mu.Unlock()
+		return
+	}
+
+	if conns > 1 {
+		node.Connections[tenantID] = conns - 1
+	} else {
+		delete(node.Connections, tenantID)
+	}
+
+	subscribers := make([]func(tenantID string), 0, len(s.localConnectionsSubscribers))
+	subscribers = append(subscribers, s.localConnectionsSubscribers...)
+
+	s.mu.Unlock()
+
+	for _, f := range subscribers {
+		f(tenantID)
+	}
+}
+
+func (s *State) LocalConnections(tenantID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.nodes[s.localID]
+	if !ok {
+		panic("local node not in cluster")
+	}
+
+	if node.Connections == nil {
+		return 0
+	}
+	return node.Connections[tenantID]
+}
+
+// OnLocalConnectionsUpdate subscribes to changes to the local nodes active
+// proxied connections.
+func (s *State) OnLocalConnectionsUpdate(f func(tenantID string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.localConnectionsSubscribers = append(s.localConnectionsSubscribers, f)
+}
+
+// TenantUsage returns the usage of the tenant with the given ID across the
+// cluster.
+//
+// Nodes that have left the cluster are ignored, though unreachable nodes are
+// included as their upstreams may still be connected.
+func (s *State) TenantUsage(tenantID string) *TenantUsage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.tenantUsageLocked(tenantID)
+}
+
+// ReserveLocalUpstream reserves an upstream for the tenants endpoint on the
+// local node, unless check returns an error for the tenants current usage.
+//
+// The usage passed to check includes upstreams already reserved on the local
+// node. Checking the usage and adding the reservation is a single atomic
+// operation, so concurrent reservations on the local node can't exceed a
+// quota. check is called with the cluster mutex locked so must not block or
+// call back to the cluster.
+//
+// The returned function releases the reservation, and must be called once the
+// upstream is added as an endpoint listener, or if the upstream fails to
+// connect.
+func (s *State) ReserveLocalUpstream(
+	tenantID string,
+	endpointID string,
+	check func(usage *TenantUsage) error,
+) (func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := check(s.tenantUsageLocked(tenantID)); err != nil {
+		return nil, err
+	}
+
+	key := EndpointKey(tenantID, endpointID)
+	s.reservedUpstreams[key]++
+
+	return sync.OnceFunc(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.reservedUpstreams[key] > 1 {
+			s.reservedUpstreams[key]--
+		} else {
+			delete(s.reservedUpstreams, key)
+		}
+	}), nil
+}
+
+// tenantUsageLocked returns the usage of the tenant across the cluster,
+// including upstreams reserved on the local node. s.mu must be held.
+func (s *State) tenantUsageLocked(tenantID string) *TenantUsage {
+	usage := &TenantUsage{
+		Endpoints: make(map[string]int),
+	}
+	for _, node := range s.nodes {
+		if node.Status == NodeStatusLeft {
+			continue
+		}
+		for key, listeners := range node.Endpoints {
+			endpointTenantID, endpointID := ParseEndpointKey(key)
+			if endpointTenantID != tenantID || listeners <= 0 {
+				continue
+			}
+			usage.Endpoints[endpointID] += listeners
+		}
+		usage.Connections += node.Connections[tenantID]
+	}
+	for key, reserved := range s.reservedUpstreams {
+		endpointTenantID, endpointID := ParseEndpointKey(key)
+		if endpointTenantID != tenantID {
+			continue
+		}
+		usage.Endpoints[endpointID] += reserved
+	}
+	return usage
+}
+
+// TenantConnections returns the number of active proxied connections for the
+// tenant with the given ID across the cluster.
+func (s *State) TenantConnections(tenantID string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.tenantConnectionsLocked(tenantID)
+}
+
+// tenantConnectionsLocked returns the number of active proxied connections
+// for the tenant across the cluster. s.mu must be held.
+func (s *State) tenantConnectionsLocked(tenantID string) int {
+	conns := 0
+	for _, node := range s.nodes {
+		if node.Status == NodeStatusLeft {
+			continue
+		}
+		conns += node.Connections[tenantID]
+	}
+	return conns
+}
+
 // AddNode adds the given node to the cluster.
 func (s *State) AddNode(node *Node) {
 	s.mu.Lock()
@@ -331,6 +578,60 @@ func (s *State) RemoveRemoteEndpoint(id string, endpointID string) bool {
 	return true
 }
 
+// UpdateRemoteConnections sets the number of active proxied connections for
+// the tenant on the node with the given ID.
+func (s *State) UpdateRemoteConnections(
+	id string,
+	tenantID string,
+	conns int,
+) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id == s.localID {
+		s.logger.Warn("update remote connections: cannot update local node")
+		return false
+	}
+
+	n, ok := s.nodes[id]
+	if !ok {
+		s.logger.Warn("update remote connections: node not in cluster")
+		return false
+	}
+
+	if n.Connections == nil {
+		n.Connections = make(map[string]int)
+	}
+
+	n.Connections[tenantID] = conns
+
+	return true
+}
+
+// RemoveRemoteConnections removes the active proxied connections for the
+// tenant from the node with the given ID.
+func (s *State) RemoveRemoteConnections(id string, tenantID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id == s.localID {
+		s.logger.Warn("remove remote connections: cannot update local node")
+		return false
+	}
+
+	n, ok := s.nodes[id]
+	if !ok {
+		s.logger.Warn("remove remote connections: node not in cluster")
+		return false
+	}
+
+	if n.Connections != nil {
+		delete(n.Connections, tenantID)
+	}
+
+	return true
+}
+
 // AvgConns returns the mean number of connections per node in the cluster.
 func (s *State) AvgConns() int {
 	s.mu.RLock()
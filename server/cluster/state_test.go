@@ -365,3 +365,80 @@ func TestState_LookupEndpoint(t *testing.T) {
 		assert.False(t, ok)
 	})
 }
+
+func TestState_EndpointActive(t *testing.T) {
+	s := NewState(&Node{
+		ID:     "local",
+		Status: NodeStatusActive,
+	}, log.NewNopLogger())
+	s.AddLocalEndpoint("local-endpoint")
+
+	s.AddNode(&Node{
+		ID:     "remote",
+		Status: NodeStatusActive,
+	})
+	assert.True(t, s.UpdateRemoteEndpoint(
+		"remote", EndpointKey("my-tenant", "remote-endpoint"), 1,
+	))
+
+	s.AddNode(&Node{
+		ID:     "unreachable",
+		Status: NodeStatusUnreachable,
+	})
+	assert.True(t, s.UpdateRemoteEndpoint("unreachable", "unreachable-endpoint", 1))
+
+	assert.True(t, s.EndpointActive("", "local-endpoint"))
+	assert.True(t, s.EndpointActive("", "remote-endpoint"))
+	assert.True(t, s.EndpointActive("my-tenant", "remote-endpoint"))
+	assert.False(t, s.EndpointActive("other-tenant", "remote-endpoint"))
+	assert.False(t, s.EndpointActive("my-tenant", "local-endpoint"))
+	assert.False(t, s.EndpointActive("", "unreachable-endpoint"))
+	assert.False(t, s.EndpointActive("", "unknown-endpoint"))
+
+	s.RemoveLocalEndpoint("local-endpoint")
+	assert.False(t, s.EndpointActive("", "local-endpoint"))
+}
+
+func TestState_TenantUsage(t *testing.T) {
+	localNode := &Node{
+		ID:     "local",
+		Status: NodeStatusActive,
+	}
+	s := NewState(localNode.Copy(), log.NewNopLogger())
+	s.AddNode(&Node{
+		ID:     "remote-1",
+		Status: NodeStatusActive,
+	})
+	s.AddNode(&Node{
+		ID:     "remote-2",
+		Status: NodeStatusLeft,
+	})
+
+	s.AddLocalEndpoint("my-tenant/endpoint-1")
+	s.AddLocalEndpoint("my-tenant/endpoint-1")
+	s.AddLocalEndpoint("other-tenant/endpoint-1")
+	s.AddLocalEndpoint("endpoint-1")
+	s.UpdateRemoteEndpoint("remote-1", "my-tenant/endpoint-1", 1)
+	s.UpdateRemoteEndpoint("remote-1", "my-tenant/endpoint-2", 3)
+	// Ignored as the node has left.
+	s.UpdateRemoteEndpoint("remote-2", "my-tenant/endpoint-3", 3)
+
+	s.AddLocalConnection("my-tenant")
+	s.UpdateRemoteConnections("remote-1", "my-tenant", 4)
+	s.UpdateRemoteConnections("remote-2", "my-tenant", 10)
+
+	usage := s.TenantUsage("my-tenant")
+	assert.Equal(t, &TenantUsage{
+		Endpoints: map[string]int{
+			"endpoint-1": 3,
+			"endpoint-2": 3,
+		},
+		Connections: 5,
+	}, usage)
+	assert.Equal(t, 6, usage.Upstreams())
+	assert.Equal(t, 5, s.TenantConnections("my-tenant"))
+
+	s.RemoveLocalConnection("my-tenant")
+	s.RemoveRemoteConnections("remote-1", "my-tenant")
+	assert.Equal(t, 0, s.TenantConnections("my-tenant"))
+}
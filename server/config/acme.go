@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// ACMEConfig configures obtaining and renewing proxy certificates
+// automatically using ACME, such as with Let's Encrypt.
+type ACMEConfig struct {
+	// Domains contains the domains to obtain certificates for. A '*' label
+	// matches any single label, such as '*.piko.example.com', though only
+	// hosts whose endpoint has a registered listener obtain a certificate.
+	// If empty, ACME is disabled.
+	Domains []string `json:"domains" yaml:"domains"`
+
+	// DirectoryURL is the ACME directory URL.
+	DirectoryURL string `json:"directory_url" yaml:"directory_url"`
+
+	// Email is the contact email of the ACME account (optional).
+	Email string `json:"email" yaml:"email"`
+
+	// AcceptTOS indicates whether you accept the ACME servers terms of
+	// service, which is required to register an account.
+	AcceptTOS bool `json:"accept_tos" yaml:"accept_tos"`
+
+	// CacheDir is the directory to store the ACME account key and
+	// certificates.
+	CacheDir string `json:"cache_dir" yaml:"cache_dir"`
+
+	// RootCAs contains a path to root certificate authorities to verify the
+	// ACME server, such as when testing with Pebble.
+	//
+	// Defaults to using the host root CAs.
+	RootCAs string `json:"root_cas" yaml:"root_cas"`
+
+	// ClusterKey is a shared secret the nodes in the cluster use to fetch
+	// certificates and challenge responses from each other. Requires the
+	// admin port to use TLS. If empty, nodes only use their local cache.
+	ClusterKey string `json:"cluster_key" yaml:"cluster_key"`
+
+	// IssueRate is the maximum number of new certificates each node
+	// obtains per hour.
+	IssueRate int `json:"issue_rate" yaml:"issue_rate"`
+}
+
+func (c *ACMEConfig) Enabled() bool {
+	return len(c.Domains) > 0
+}
+
+func (c *ACMEConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	for _, domain := range c.Domains {
+		if err := validateACMEDomain(domain); err != nil {
+			return fmt.Errorf("domain: %s: %w", domain, err)
+		}
+	}
+	if c.DirectoryURL == "" {
+		return fmt.Errorf("missing directory url")
+	}
+	if !c.AcceptTOS {
+		return fmt.Errorf("must accept terms of service")
+	}
+	if c.CacheDir == "" {
+		return fmt.Errorf("missing cache dir")
+	}
+	if c.IssueRate <= 0 {
+		return fmt.Errorf("issue rate must be positive")
+	}
+	return nil
+}
+
+func (c *ACMEConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	prefix += ".acme."
+
+	fs.StringSliceVar(
+		&c.Domains,
+		prefix+"domains",
+		c.Domains,
+		`
+Domains to obtain certificates for using ACME.
+
+Certificates are obtained when a client first connects with a matching server
+name (SNI), then renewed automatically. A '*' label matches any single label,
+such as '*.piko.example.com' matches 'my-endpoint.piko.example.com'.
+
+Hosts matching a domain with a '*' label only obtain a certificate if the
+hosts endpoint has a listener connected to the cluster, where the endpoint is
+taken from the host the same as proxy requests (see '--proxy.host-pattern').
+Domains without a '*' label, such as custom domains mapped to an endpoint,
+always obtain a certificate.
+
+Certificates are verified with the HTTP-01 and TLS-ALPN-01 challenges on the
+proxy port, which accepts both TLS and plain HTTP connections when ACME is
+enabled. Plain HTTP requests other than HTTP-01 challenges are redirected to
+HTTPS. Note wildcard certificates aren't supported, so a certificate is
+obtained for each server name.
+
+Certificates configured with '--proxy.tls.cert' take precedence over ACME.
+
+When running a cluster without a '--proxy.tls.cert' certificate, configure
+'--proxy.tls.client.server-name' with a name matching the ACME domains, so
+nodes can verify each other when forwarding requests.
+
+If empty, ACME is disabled.`,
+	)
+	fs.StringVar(
+		&c.DirectoryURL,
+		prefix+"directory-url",
+		c.DirectoryURL,
+		`
+ACME directory URL.
+
+Defaults to Let's Encrypt.`,
+	)
+	fs.StringVar(
+		&c.Email,
+		prefix+"email",
+		c.Email,
+		`
+Contact email of the ACME account, used by the ACME server to notify about
+problems with issued certificates.`,
+	)
+	fs.BoolVar(
+		&c.AcceptTOS,
+		prefix+"accept-tos",
+		c.AcceptTOS,
+		`
+Accept the ACME servers terms of service, which is required to register an
+account.`,
+	)
+	fs.StringVar(
+		&c.CacheDir,
+		prefix+"cache-dir",
+		c.CacheDir,
+		`
+Directory to store the ACME account key and certificates.
+
+When running a cluster, either use a shared volume, or configure
+'--proxy.acme.cluster-key' so nodes can fetch certificates and challenge
+responses from each other.`,
+	)
+	fs.StringVar(
+		&c.RootCAs,
+		prefix+"root-cas",
+		c.RootCAs,
+		`
+A path to a certificate PEM file containing root certificate authorities to
+verify the ACME server, such as when testing with Pebble.
+
+Defaults to using the host root CAs.`,
+	)
+	fs.StringVar(
+		&c.ClusterKey,
+		prefix+"cluster-key",
+		c.ClusterKey,
+		`
+Shared secret the nodes in the cluster use to fetch certificates and
+challenge responses from each other using the admin port.
+
+This means challenges can be completed regardless of which node the ACME
+server connects to, and certificates obtained by one node are used by the
+other nodes.
+
+Since the cache contains certificate private keys, the admin port must use
+TLS, configured with '--admin.tls.cert'.
+
+If empty, nodes only use their local cache directory.`,
+	)
+	fs.IntVar(
+		&c.IssueRate,
+		prefix+"issue-rate",
+		c.IssueRate,
+		`
+The maximum number of new certificates each node obtains per hour.
+
+This avoids exhausting the ACME servers rate limits, such as if clients
+connect with many different server names. Serving and renewing existing
+certificates doesn't count towards the limit.`,
+	)
+}
+
+func validateACMEDomain(domain string) error {
+	if domain == "" {
+		return fmt.Errorf("empty domain")
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("domain must have at least two labels")
+	}
+	for _, label := range labels {
+		if label == "" {
+			return fmt.Errorf("empty label")
+		}
+		if label != "*" && strings.Contains(label, "*") {
+			return fmt.Errorf("wildcard must be a complete label")
+		}
+	}
+	if labels[len(labels)-1] == "*" {
+		return fmt.Errorf("top-level domain cannot be a wildcard")
+	}
+	return nil
+}
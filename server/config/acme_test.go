@@ -0,0 +1,43 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACMEConfig_Validate(t *testing.T) {
+	valid := func() ACMEConfig {
+		return ACMEConfig{
+			Domains:      []string{"*.piko.example.com"},
+			DirectoryURL: "https://acme.example.com/directory",
+			AcceptTOS:    true,
+			CacheDir:     "/var/lib/piko/acme",
+			IssueRate:    20,
+		}
+	}
+
+	conf := valid()
+	assert.NoError(t, conf.Validate())
+
+	// Disabled.
+	assert.NoError(t, (&ACMEConfig{}).Validate())
+
+	conf = valid()
+	conf.AcceptTOS = false
+	assert.EqualError(t, conf.Validate(), "must accept terms of service")
+
+	conf = valid()
+	conf.CacheDir = ""
+	assert.EqualError(t, conf.Validate(), "missing cache dir")
+
+	conf = valid()
+	conf.IssueRate = 0
+	assert.EqualError(t, conf.Validate(), "issue rate must be positive")
+
+	for _, domain := range []string{"com", "foo*.example.com", "example.*", "a..com"} {
+		conf = valid()
+		conf.Domains = []string{domain}
+		assert.Error(t, conf.Validate(), domain)
+	}
+}
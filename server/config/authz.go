@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// AuthzConfig configures an external authorization webhook to decide
+// whether clients may listen on or connect to endpoints.
+type AuthzConfig struct {
+	// URL is the URL to POST authorization requests to. If empty, the
+	// webhook is disabled.
+	URL string `json:"url" yaml:"url"`
+
+	// Timeout is the timeout for each webhook request.
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+
+	// CacheTTL is the duration to cache each decision. If zero, decisions
+	// aren't cached.
+	CacheTTL time.Duration `json:"cache_ttl" yaml:"cache_ttl"`
+
+	// FailOpen allows requests if the webhook is unavailable or returns an
+	// error. By default such requests are rejected.
+	FailOpen bool `json:"fail_open" yaml:"fail_open"`
+}
+
+func (c *AuthzConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+func (c *AuthzConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid url: %s", c.URL)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	if c.CacheTTL < 0 {
+		return fmt.Errorf("cache ttl cannot be negative")
+	}
+	return nil
+}
+
+func (c *AuthzConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	prefix += ".authz."
+
+	fs.StringVar(
+		&c.URL,
+		prefix+"url",
+		c.URL,
+		`
+URL of an external authorization service to decide whether clients may
+listen on or connect to endpoints.
+
+Piko POSTs a JSON request containing the action ('listen' or 'connect'),
+endpoint ID, tenant ID, client IP and token claims, and expects a JSON
+response containing 'allow' and optionally 'reason' and 'headers'. Headers
+are added to requests proxied to the upstream.
+
+If empty, the webhook is disabled.`,
+	)
+	fs.DurationVar(
+		&c.Timeout,
+		prefix+"timeout",
+		c.Timeout,
+		`
+Timeout for each authorization request.`,
+	)
+	fs.DurationVar(
+		&c.CacheTTL,
+		prefix+"cache-ttl",
+		c.CacheTTL,
+		`
+Duration to cache each authorization decision. If zero, decisions aren't
+cached.`,
+	)
+	fs.BoolVar(
+		&c.FailOpen,
+		prefix+"fail-open",
+		c.FailOpen,
+		`
+Whether to allow requests if the authorization service is unavailable or
+returns an error. By default such requests are rejected.`,
+	)
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+)
+
+// BandwidthLimitConfig overrides the bandwidth limit for an endpoint or
+// tenant.
+type BandwidthLimitConfig struct {
+	// ID is the endpoint or tenant ID.
+	ID string `json:"id" yaml:"id"`
+
+	// Rate is the maximum bandwidth in bytes per second, combined across
+	// both directions. If zero bandwidth is unlimited.
+	Rate int64 `json:"rate" yaml:"rate"`
+}
+
+func (c *BandwidthLimitConfig) Validate() error {
+	if c.ID == "" {
+		return fmt.Errorf("missing id")
+	}
+	if c.Rate < 0 {
+		return fmt.Errorf("rate cannot be negative")
+	}
+	return nil
+}
+
+// BandwidthConfig configures accounting and limiting the bandwidth used by
+// endpoints and tenants.
+type BandwidthConfig struct {
+	// EndpointRate is the default maximum bandwidth of each endpoint in
+	// bytes per second, combined across all connections in both directions.
+	//
+	// If zero, endpoint bandwidth is unlimited.
+	EndpointRate int64 `json:"endpoint_rate" yaml:"endpoint_rate"`
+
+	// TenantRate is the default maximum bandwidth of each tenant in bytes
+	// per second, combined across all of the tenants endpoints.
+	//
+	// If zero, tenant bandwidth is unlimited.
+	TenantRate int64 `json:"tenant_rate" yaml:"tenant_rate"`
+
+	// Endpoints contains per-endpoint bandwidth limit overrides.
+	Endpoints []BandwidthLimitConfig `json:"endpoints" yaml:"endpoints"`
+
+	// Tenants contains per-tenant bandwidth limit overrides.
+	Tenants []BandwidthLimitConfig `json:"tenants" yaml:"tenants"`
+
+	// MaxMetricsLabels is the maximum number of endpoints and tenants with
+	// their own label in the bandwidth metrics. Once exceeded, the bytes of
+	// any new endpoints or tenants are recorded with the label 'other'.
+	MaxMetricsLabels int `json:"max_metrics_labels" yaml:"max_metrics_labels"`
+}
+
+// Endpoint returns the bandwidth limit for the endpoint with the given ID.
+func (c *BandwidthConfig) Endpoint(endpointID string) int64 {
+	for _, endpoint := range c.Endpoints {
+		if endpoint.ID == endpointID {
+			return endpoint.Rate
+		}
+	}
+	return c.EndpointRate
+}
+
+// Tenant returns the bandwidth limit for the tenant with the given ID.
+func (c *BandwidthConfig) Tenant(tenantID string) int64 {
+	for _, tenant := range c.Tenants {
+		if tenant.ID == tenantID {
+			return tenant.Rate
+		}
+	}
+	return c.TenantRate
+}
+
+func (c *BandwidthConfig) Validate() error {
+	if c.EndpointRate < 0 {
+		return fmt.Errorf("endpoint rate cannot be negative")
+	}
+	if c.TenantRate < 0 {
+		return fmt.Errorf("tenant rate cannot be negative")
+	}
+	for _, endpoint := range c.Endpoints {
+		if err := endpoint.Validate(); err != nil {
+			return fmt.Errorf("endpoint: %w", err)
+		}
+	}
+	for _, tenant := range c.Tenants {
+		if err := tenant.Validate(); err != nil {
+			return fmt.Errorf("tenant: %w", err)
+		}
+	}
+	if c.MaxMetricsLabels < 0 {
+		return fmt.Errorf("max metrics labels cannot be negative")
+	}
+	return nil
+}
+
+func (c *BandwidthConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	prefix += ".bandwidth."
+
+	fs.Int64Var(
+		&c.EndpointRate,
+		prefix+"endpoint-rate",
+		c.EndpointRate,
+		`
+The default maximum bandwidth of each endpoint in bytes per second, combined
+across all connections in both directions.
+
+Per-endpoint limits can be configured in the configuration file.
+
+Set to 0 for no limit.`,
+	)
+	fs.Int64Var(
+		&c.TenantRate,
+		prefix+"tenant-rate",
+		c.TenantRate,
+		`
+The default maximum bandwidth of each tenant in bytes per second, combined
+across all of the tenants endpoints.
+
+Per-tenant limits can be configured in the configuration file.
+
+Set to 0 for no limit.`,
+	)
+	fs.IntVar(
+		&c.MaxMetricsLabels,
+		prefix+"max-metrics-labels",
+		c.MaxMetricsLabels,
+		`
+The maximum number of endpoints and tenants with their own label in the
+bandwidth metrics. Once exceeded, the bytes of any new endpoints or tenants are
+recorded with the label 'other'.`,
+	)
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+type CacheBackend string
+
+const (
+	CacheBackendMemory CacheBackend = "memory"
+	CacheBackendDisk   CacheBackend = "disk"
+)
+
+// EndpointCacheConfig overrides the cache configuration for an endpoint.
+type EndpointCacheConfig struct {
+	// ID is the endpoint ID.
+	ID string `json:"id" yaml:"id"`
+
+	// Disable disables caching responses from the endpoint.
+	Disable bool `json:"disable" yaml:"disable"`
+
+	// DefaultTTL is the freshness lifetime of cacheable responses that
+	// don't include explicit freshness information (such as
+	// 'Cache-Control: max-age').
+	//
+	// If zero, such responses are only stored if they include a validator
+	// ('ETag' or 'Last-Modified'), and are revalidated on each request.
+	DefaultTTL time.Duration `json:"default_ttl" yaml:"default_ttl"`
+
+	// MaxObjectSize overrides the maximum size of a cached response body for
+	// the endpoint. If zero the global maximum is used.
+	MaxObjectSize int64 `json:"max_object_size" yaml:"max_object_size"`
+}
+
+func (c *EndpointCacheConfig) Validate() error {
+	if c.ID == "" {
+		return fmt.Errorf("missing id")
+	}
+	if c.DefaultTTL < 0 {
+		return fmt.Errorf("default ttl cannot be negative")
+	}
+	if c.MaxObjectSize < 0 {
+		return fmt.Errorf("max object size cannot be negative")
+	}
+	return nil
+}
+
+// CacheConfig configures the shared HTTP response cache.
+type CacheConfig struct {
+	// Enabled indicates whether to cache upstream responses.
+	//
+	// Responses are cached following RFC 9111 semantics for a shared cache.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// Backend is the cache storage backend. Either 'memory' or 'disk'.
+	Backend CacheBackend `json:"backend" yaml:"backend"`
+
+	// Dir is the directory to store cached responses when using the 'disk'
+	// backend.
+	Dir string `json:"dir" yaml:"dir"`
+
+	// MaxSize is the maximum size of the cache in bytes. When exceeded, the
+	// least recently used responses are evicted.
+	MaxSize int64 `json:"max_size" yaml:"max_size"`
+
+	// MaxObjectSize is the maximum size of a cached response body in bytes.
+	// Larger responses are not cached.
+	MaxObjectSize int64 `json:"max_object_size" yaml:"max_object_size"`
+
+	// Endpoints contains per-endpoint cache configuration overrides.
+	//
+	// Endpoints that aren't listed use the default configuration.
+	Endpoints []EndpointCacheConfig `json:"endpoints" yaml:"endpoints"`
+}
+
+// Endpoint returns the configuration for the endpoint with the given ID.
+func (c *CacheConfig) Endpoint(endpointID string) EndpointCacheConfig {
+	for _, endpoint := range c.Endpoints {
+		if endpoint.ID == endpointID {
+			if endpoint.MaxObjectSize == 0 {
+				endpoint.MaxObjectSize = c.MaxObjectSize
+			}
+			return endpoint
+		}
+	}
+	return EndpointCacheConfig{
+		ID:            endpointID,
+		MaxObjectSize: c.MaxObjectSize,
+	}
+}
+
+func (c *CacheConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	switch c.Backend {
+	case CacheBackendMemory:
+	case CacheBackendDisk:
+		if c.Dir == "" {
+			return fmt.Errorf("missing dir")
+		}
+	default:
+		return fmt.Errorf("unsupported backend: %s", c.Backend)
+	}
+	if c.MaxSize <= 0 {
+		return fmt.Errorf("max size must be positive")
+	}
+	if c.MaxObjectSize <= 0 {
+		return fmt.Errorf("max object size must be positive")
+	}
+	if c.MaxObjectSize > c.MaxSize {
+		return fmt.Errorf("max object size cannot exceed max size")
+	}
+	for _, endpoint := range c.Endpoints {
+		if err := endpoint.Validate(); err != nil {
+			return fmt.Errorf("endpoint: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *CacheConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	prefix += ".cache."
+
+	fs.BoolVar(
+		&c.Enabled,
+		prefix+"enabled",
+		c.Enabled,
+		`
+Whether to cache upstream responses.
+
+Responses are cached following RFC 9111 semantics for a shared cache, so
+honour 'Cache-Control', 'Vary' and are revalidated using 'ETag' and
+'Last-Modified'.`,
+	)
+	fs.StringVar(
+		(*string)(&c.Backend),
+		prefix+"backend",
+		string(c.Backend),
+		`
+The cache storage backend. Either 'memory' or 'disk'.`,
+	)
+	fs.StringVar(
+		&c.Dir,
+		prefix+"dir",
+		c.Dir,
+		`
+The directory to store cached responses when using the 'disk' backend.`,
+	)
+	fs.Int64Var(
+		&c.MaxSize,
+		prefix+"max-size",
+		c.MaxSize,
+		`
+The maximum size of the cache in bytes. When exceeded, the least recently
+used responses are evicted.`,
+	)
+	fs.Int64Var(
+		&c.MaxObjectSize,
+		prefix+"max-object-size",
+		c.MaxObjectSize,
+		`
+The maximum size of a cached response body in bytes. Larger responses are not
+cached.`,
+	)
+}
@@ -2,11 +2,15 @@ package config
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
+	"golang.org/x/crypto/acme"
 
+	"github.com/andydunstall/piko/pkg/audit"
 	"github.com/andydunstall/piko/pkg/auth"
+	"github.com/andydunstall/piko/pkg/compress"
 	"github.com/andydunstall/piko/pkg/gossip"
 	"github.com/andydunstall/piko/pkg/log"
 )
@@ -215,10 +219,50 @@ keys and values, including the request line.`,
 	)
 }
 
+// QuotaConfig configures the quotas of a tenant across the cluster.
+//
+// A quota of 0 means unlimited.
+type QuotaConfig struct {
+	// MaxEndpoints is the maximum number of active endpoints.
+	MaxEndpoints int `json:"max_endpoints" yaml:"max_endpoints"`
+
+	// MaxEndpointUpstreams is the maximum number of upstream connections
+	// per endpoint.
+	MaxEndpointUpstreams int `json:"max_endpoint_upstreams" yaml:"max_endpoint_upstreams"`
+
+	// MaxUpstreams is the maximum number of upstream connections across all
+	// endpoints.
+	MaxUpstreams int `json:"max_upstreams" yaml:"max_upstreams"`
+
+	// MaxConnections is the maximum number of concurrent proxied connections
+	// and requests.
+	MaxConnections int `json:"max_connections" yaml:"max_connections"`
+}
+
+func (c *QuotaConfig) Validate() error {
+	if c.MaxEndpoints < 0 {
+		return fmt.Errorf("max endpoints must be non-negative")
+	}
+	if c.MaxEndpointUpstreams < 0 {
+		return fmt.Errorf("max endpoint upstreams must be non-negative")
+	}
+	if c.MaxUpstreams < 0 {
+		return fmt.Errorf("max upstreams must be non-negative")
+	}
+	if c.MaxConnections < 0 {
+		return fmt.Errorf("max connections must be non-negative")
+	}
+	return nil
+}
+
 type TenantConfig struct {
 	ID string `json:"id" yaml:"id"`
 
 	Auth auth.Config `json:"auth" yaml:"auth"`
+
+	// Quota configures the tenants quotas. Upstream quotas are enforced by
+	// the upstream port and connection quotas by the proxy port.
+	Quota QuotaConfig `json:"quota" yaml:"quota"`
 }
 
 func (c *TenantConfig) Validate() error {
@@ -229,7 +273,27 @@ func (c *TenantConfig) Validate() error {
 		// Require tenants to be authenticated (theres no point otherwise).
 		return fmt.Errorf("tenant auth disabled")
 	}
+	if err := c.Quota.Validate(); err != nil {
+		return fmt.Errorf("quota: %w", err)
+	}
+
+	return nil
+}
 
+func validateTenants(tenants []TenantConfig, tlsConfig *TLSConfig) error {
+	ids := make(map[string]struct{})
+	for _, tenant := range tenants {
+		if err := tenant.Validate(); err != nil {
+			return fmt.Errorf("tenant: %w", err)
+		}
+		if _, ok := ids[tenant.ID]; ok {
+			return fmt.Errorf("tenant: duplicate tenant id: %s", tenant.ID)
+		}
+		ids[tenant.ID] = struct{}{}
+		if err := validateCertAuth(&tenant.Auth, tlsConfig); err != nil {
+			return fmt.Errorf("tenant: %s: auth: %w", tenant.ID, err)
+		}
+	}
 	return nil
 }
 
@@ -243,15 +307,52 @@ type ProxyConfig struct {
 	// Timeout is the timeout to forward incoming requests to the upstream.
 	Timeout time.Duration `json:"timeout" yaml:"timeout"`
 
+	// HostPattern is a pattern to extract the endpoint ID and tenant ID from
+	// the request host, such as '{endpoint}.{tenant}.piko.example.com'.
+	//
+	// If empty, the endpoint ID is the bottom-level domain of the host.
+	HostPattern string `json:"host_pattern" yaml:"host_pattern"`
+
 	// AccessLog allows us to control how the incoming requests to
 	// the proxy are logged.
 	AccessLog log.AccessLogConfig `json:"access_log" yaml:"access_log"`
 
+	// Compression configures compressing responses to proxied requests.
+	Compression compress.Config `json:"compression" yaml:"compression"`
+
+	// Cache configures caching upstream responses.
+	Cache CacheConfig `json:"cache" yaml:"cache"`
+
+	// Limits configures limits on requests forwarded to upstreams.
+	Limits LimitsConfig `json:"limits" yaml:"limits"`
+
+	// Bandwidth configures accounting and limiting bandwidth.
+	Bandwidth BandwidthConfig `json:"bandwidth" yaml:"bandwidth"`
+
 	Auth auth.Config `json:"auth" yaml:"auth"`
 
+	// Authz configures an external authorization webhook.
+	Authz AuthzConfig `json:"authz" yaml:"authz"`
+
+	// SignedURLs configures granting temporary access to endpoints using
+	// signed URLs.
+	SignedURLs SignedURLConfig `json:"signed_urls" yaml:"signed_urls"`
+
+	// Identity configures forwarding the verified client identity to
+	// upstreams.
+	Identity IdentityConfig `json:"identity" yaml:"identity"`
+
 	HTTP HTTPConfig `json:"http" yaml:"http"`
 
 	TLS TLSConfig `json:"tls" yaml:"tls"`
+
+	// ACME configures obtaining certificates automatically using ACME.
+	ACME ACMEConfig `json:"acme" yaml:"acme"`
+
+	// Tenants contains the list of supported tenants.
+	//
+	// Experimental.
+	Tenants []TenantConfig `json:"tenants" yaml:"tenants"`
 }
 
 func (c *ProxyConfig) Validate() error {
@@ -261,10 +362,47 @@ func (c *ProxyConfig) Validate() error {
 	if err := c.TLS.Validate(); err != nil {
 		return fmt.Errorf("tls: %w", err)
 	}
+	if err := validateCertAuth(&c.Auth, &c.TLS); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	if err := validateTenants(c.Tenants, &c.TLS); err != nil {
+		return err
+	}
+	if err := validateHostPattern(c.HostPattern); err != nil {
+		return fmt.Errorf("host pattern: %w", err)
+	}
+	if err := c.Authz.Validate(); err != nil {
+		return fmt.Errorf("authz: %w", err)
+	}
+	if err := c.SignedURLs.Validate(); err != nil {
+		return fmt.Errorf("signed urls: %w", err)
+	}
+	if err := c.Identity.Validate(); err != nil {
+		return fmt.Errorf("identity: %w", err)
+	}
+	if err := c.ACME.Validate(); err != nil {
+		return fmt.Errorf("acme: %w", err)
+	}
 
 	if err := c.AccessLog.Validate(); err != nil {
 		return fmt.Errorf("access log: %w", err)
 	}
+
+	if err := c.Compression.Validate(); err != nil {
+		return fmt.Errorf("compression: %w", err)
+	}
+
+	if err := c.Cache.Validate(); err != nil {
+		return fmt.Errorf("cache: %w", err)
+	}
+
+	if err := c.Limits.Validate(); err != nil {
+		return fmt.Errorf("limits: %w", err)
+	}
+
+	if err := c.Bandwidth.Validate(); err != nil {
+		return fmt.Errorf("bandwidth: %w", err)
+	}
 	return nil
 }
 
@@ -305,13 +443,66 @@ advertise address of '10.26.104.14:8000'.`,
 Timeout when forwarding incoming requests to the upstream.`,
 	)
 
+	fs.StringVar(
+		&c.HostPattern,
+		"proxy.host-pattern",
+		c.HostPattern,
+		`
+Pattern to extract the endpoint ID and tenant ID from the request host.
+
+The pattern must contain an '{endpoint}' placeholder and may contain a
+'{tenant}' placeholder, where each placeholder matches a single domain
+label. Such as '{endpoint}.{tenant}.piko.example.com' will route requests
+to 'my-endpoint.my-tenant.piko.example.com' to endpoint 'my-endpoint' of
+tenant 'my-tenant'.
+
+If the tenant is taken from the host and the client authenticates, the
+tenant must match the tokens tenant, so tokens without a tenant can't access
+hosts with a tenant.
+
+By default, the endpoint ID is the bottom-level domain of the host.`,
+	)
+
 	c.AccessLog.RegisterFlags(fs, "proxy")
 
+	c.Compression.RegisterFlags(fs, "proxy")
+
+	c.Cache.RegisterFlags(fs, "proxy")
+
+	c.Limits.RegisterFlags(fs, "proxy")
+
+	c.Bandwidth.RegisterFlags(fs, "proxy")
+
 	c.HTTP.RegisterFlags(fs, "proxy")
 
 	c.Auth.RegisterFlags(fs, "proxy")
 
+	c.Authz.RegisterFlags(fs, "proxy")
+
+	c.SignedURLs.RegisterFlags(fs, "proxy")
+
+	c.Identity.RegisterFlags(fs, "proxy")
+
 	c.TLS.RegisterFlags(fs, "proxy")
+
+	c.ACME.RegisterFlags(fs, "proxy")
+}
+
+func validateHostPattern(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	switch strings.Count(pattern, "{endpoint}") {
+	case 0:
+		return fmt.Errorf("missing {endpoint} placeholder")
+	case 1:
+	default:
+		return fmt.Errorf("duplicate {endpoint} placeholder")
+	}
+	if strings.Count(pattern, "{tenant}") > 1 {
+		return fmt.Errorf("duplicate {tenant} placeholder")
+	}
+	return nil
 }
 
 type UpstreamConfig struct {
@@ -323,6 +514,9 @@ type UpstreamConfig struct {
 
 	Auth auth.Config `json:"auth" yaml:"auth"`
 
+	// Authz configures an external authorization webhook.
+	Authz AuthzConfig `json:"authz" yaml:"authz"`
+
 	Rebalance RebalanceConfig `json:"rebalance" yaml:"rebalance"`
 
 	TLS TLSConfig `json:"tls" yaml:"tls"`
@@ -343,12 +537,13 @@ func (c *UpstreamConfig) Validate() error {
 	if err := c.TLS.Validate(); err != nil {
 		return fmt.Errorf("tls: %w", err)
 	}
-	for _, tenant := range c.Tenants {
-		if err := tenant.Validate(); err != nil {
-			return fmt.Errorf("tenant: %w", err)
-		}
+	if err := validateCertAuth(&c.Auth, &c.TLS); err != nil {
+		return fmt.Errorf("auth: %w", err)
 	}
-	return nil
+	if err := c.Authz.Validate(); err != nil {
+		return fmt.Errorf("authz: %w", err)
+	}
+	return validateTenants(c.Tenants, &c.TLS)
 }
 
 func (c *UpstreamConfig) RegisterFlags(fs *pflag.FlagSet) {
@@ -381,6 +576,8 @@ advertise address of '10.26.104.14:8000'.`,
 
 	c.Auth.RegisterFlags(fs, "upstream")
 
+	c.Authz.RegisterFlags(fs, "upstream")
+
 	c.Rebalance.RegisterFlags(fs, "upstream")
 
 	c.TLS.RegisterFlags(fs, "upstream")
@@ -396,6 +593,17 @@ type AdminConfig struct {
 	Auth auth.Config `json:"auth" yaml:"auth"`
 
 	TLS TLSConfig `json:"tls" yaml:"tls"`
+
+	// TokenIssuer configures issuing Piko tokens using the '/tokens' route.
+	// Disabled unless a signing key is configured.
+	TokenIssuer auth.IssuerConfig `json:"token_issuer" yaml:"token_issuer"`
+
+	// Tenants contains the list of supported tenants.
+	//
+	// Tenants can only access the status of their own endpoints.
+	//
+	// Experimental.
+	Tenants []TenantConfig `json:"tenants" yaml:"tenants"`
 }
 
 func (c *AdminConfig) Validate() error {
@@ -405,7 +613,16 @@ func (c *AdminConfig) Validate() error {
 	if err := c.TLS.Validate(); err != nil {
 		return fmt.Errorf("tls: %w", err)
 	}
-	return nil
+	if err := validateCertAuth(&c.Auth, &c.TLS); err != nil {
+		return fmt.Errorf("auth: %w", err)
+	}
+	if err := c.TokenIssuer.Validate(); err != nil {
+		return fmt.Errorf("token issuer: %w", err)
+	}
+	if c.TokenIssuer.Enabled() && !c.Auth.Enabled() {
+		return fmt.Errorf("token issuer: requires auth")
+	}
+	return validateTenants(c.Tenants, &c.TLS)
 }
 
 func (c *AdminConfig) RegisterFlags(fs *pflag.FlagSet) {
@@ -439,6 +656,8 @@ advertise address of '10.26.104.14:8002'.`,
 
 	c.Auth.RegisterFlags(fs, "admin")
 
+	c.TokenIssuer.RegisterFlags(fs, "admin")
+
 	c.TLS.RegisterFlags(fs, "admin")
 }
 
@@ -553,6 +772,10 @@ type Config struct {
 
 	Cluster ClusterConfig `json:"cluster" yaml:"cluster"`
 
+	Metering MeteringConfig `json:"metering" yaml:"metering"`
+
+	Audit audit.Config `json:"audit" yaml:"audit"`
+
 	Log log.Config `json:"log" yaml:"log"`
 
 	// GracePeriod is the duration to gracefully shutdown the server. During
@@ -570,6 +793,19 @@ func Default() *Config {
 				Level:   "info",
 				Disable: false,
 			},
+			Compression: compress.Config{
+				Enabled: false,
+				MinSize: 1024,
+			},
+			Cache: CacheConfig{
+				Enabled:       false,
+				Backend:       CacheBackendMemory,
+				MaxSize:       256 << 20,
+				MaxObjectSize: 8 << 20,
+			},
+			Bandwidth: BandwidthConfig{
+				MaxMetricsLabels: 1000,
+			},
 			HTTP: HTTPConfig{
 				ReadTimeout:       time.Second * 10,
 				ReadHeaderTimeout: time.Second * 10,
@@ -577,9 +813,27 @@ func Default() *Config {
 				IdleTimeout:       time.Minute * 5,
 				MaxHeaderBytes:    1 << 20,
 			},
+			Authz: AuthzConfig{
+				Timeout:  time.Second * 5,
+				CacheTTL: time.Second * 10,
+			},
+			SignedURLs: SignedURLConfig{
+				CookieTTL: time.Minute * 15,
+			},
+			Identity: IdentityConfig{
+				JWTTTL: time.Minute,
+			},
+			ACME: ACMEConfig{
+				DirectoryURL: acme.LetsEncryptURL,
+				IssueRate:    20,
+			},
 		},
 		Upstream: UpstreamConfig{
 			BindAddr: ":8001",
+			Authz: AuthzConfig{
+				Timeout:  time.Second * 5,
+				CacheTTL: time.Second * 10,
+			},
 			Rebalance: RebalanceConfig{
 				// Disable by default.
 				Threshold: 0,
@@ -592,6 +846,9 @@ func Default() *Config {
 		},
 		Admin: AdminConfig{
 			BindAddr: ":8002",
+			TokenIssuer: auth.IssuerConfig{
+				MaxTTL: time.Hour,
+			},
 		},
 		Cluster: ClusterConfig{
 			JoinTimeout:      time.Minute,
@@ -602,6 +859,26 @@ func Default() *Config {
 				MaxPacketSize: 1400,
 			},
 		},
+		Metering: MeteringConfig{
+			Enabled:  false,
+			Interval: time.Minute,
+			Webhook: MeteringWebhookConfig{
+				Timeout: time.Second * 10,
+			},
+			MaxPendingRecords: 100000,
+		},
+		Audit: audit.Config{
+			Enabled:  false,
+			Interval: time.Second,
+			File: audit.FileConfig{
+				MaxSize:    100 * 1024 * 1024,
+				MaxBackups: 10,
+			},
+			Webhook: audit.WebhookConfig{
+				Timeout: time.Second * 10,
+			},
+			MaxPendingEvents: 100000,
+		},
 		Log: log.Config{
 			Level: "info",
 		},
@@ -630,6 +907,20 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("admin: %w", err)
 	}
 
+	// Nodes fetch ACME cache entries, which include certificate private
+	// keys, from each other using the admin port, so require TLS.
+	if c.Proxy.ACME.ClusterKey != "" && len(c.Admin.TLS.CertificatePairs()) == 0 {
+		return fmt.Errorf("proxy: acme: cluster key requires admin tls")
+	}
+
+	if err := c.Metering.Validate(); err != nil {
+		return fmt.Errorf("metering: %w", err)
+	}
+
+	if err := c.Audit.Validate(); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+
 	if err := c.Log.Validate(); err != nil {
 		return fmt.Errorf("log: %w", err)
 	}
@@ -652,6 +943,10 @@ func (c *Config) RegisterFlags(fs *pflag.FlagSet) {
 
 	c.Admin.RegisterFlags(fs)
 
+	c.Metering.RegisterFlags(fs)
+
+	c.Audit.RegisterFlags(fs)
+
 	c.Log.RegisterFlags(fs)
 
 	fs.DurationVar(
@@ -8,10 +8,13 @@ import (
 	"github.com/spf13/pflag"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/andydunstall/piko/pkg/audit"
 	"github.com/andydunstall/piko/pkg/auth"
+	"github.com/andydunstall/piko/pkg/compress"
 	"github.com/andydunstall/piko/pkg/config"
 	"github.com/andydunstall/piko/pkg/gossip"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/tlsconfig"
 )
 
 // Tests the default configuration is valid (not including node ID).
@@ -21,6 +24,24 @@ func TestConfig_Default(t *testing.T) {
 	assert.NoError(t, conf.Validate())
 }
 
+// Tests the ACME cluster key requires admin TLS, since cache entries
+// include certificate private keys.
+func TestConfig_ValidateACMEClusterKey(t *testing.T) {
+	conf := Default()
+	conf.Cluster.NodeID = "my-node"
+	conf.Proxy.ACME.Domains = []string{"*.piko.example.com"}
+	conf.Proxy.ACME.AcceptTOS = true
+	conf.Proxy.ACME.CacheDir = "/var/lib/piko/acme"
+	conf.Proxy.ACME.ClusterKey = "acme-cluster-key"
+	assert.EqualError(
+		t, conf.Validate(), "proxy: acme: cluster key requires admin tls",
+	)
+
+	conf.Admin.TLS.Cert = "/piko/admin.pem"
+	conf.Admin.TLS.Key = "/piko/admin-key.pem"
+	assert.NoError(t, conf.Validate())
+}
+
 // Tests loading the server configuration from YAML.
 func TestConfig_LoadYAML(t *testing.T) {
 	yaml := `
@@ -28,6 +49,33 @@ proxy:
   bind_addr: 10.15.104.25:8000
   advertise_addr: 1.2.3.4:8000
   timeout: 20s
+  host_pattern: '{endpoint}.{tenant}.piko.example.com'
+  authz:
+    url: http://authz.example.com/check
+    timeout: 2s
+    cache_ttl: 30s
+    fail_open: true
+  signed_urls:
+    secret_key: my-secret
+    cookie_ttl: 5m
+  identity:
+    strip_credentials: true
+    headers: true
+    claims:
+      - email
+    jwt_secret_key: identity-secret
+    jwt_ttl: 30s
+  acme:
+    domains:
+      - "*.piko.example.com"
+      - api.customer.com
+    directory_url: https://localhost:14000/dir
+    email: admin@example.com
+    accept_tos: true
+    cache_dir: /var/lib/piko/acme
+    root_cas: /piko/pebble.pem
+    cluster_key: acme-cluster-key
+    issue_rate: 50
   access_log:
     level: debug
     request_headers:
@@ -40,6 +88,44 @@ proxy:
         - ghi
     disable: false
 
+  compression:
+    enabled: true
+    algorithms:
+      - br
+      - gzip
+    content_types:
+      - text/html
+    min_size: 512
+
+  cache:
+    enabled: true
+    backend: disk
+    dir: /var/cache/piko
+    max_size: 1048576
+    max_object_size: 1024
+    endpoints:
+      - id: my-endpoint
+        default_ttl: 1m
+        max_object_size: 2048
+
+  limits:
+    max_request_body_size: 1048576
+    max_upload_rate: 524288
+    endpoints:
+      - id: my-endpoint
+        max_request_body_size: 4096
+
+  bandwidth:
+    endpoint_rate: 1048576
+    tenant_rate: 10485760
+    endpoints:
+      - id: my-endpoint
+        rate: 2097152
+    tenants:
+      - id: my-tenant
+        rate: 0
+    max_metrics_labels: 500
+
   http:
     read_timeout: 5s
     read_header_timeout: 5s
@@ -57,12 +143,27 @@ proxy:
   tls:
     cert: /piko/cert.pem
     key: /piko/key.pem
-    
+    certificates:
+      - cert: /piko/example-org.pem
+        key: /piko/example-org-key.pem
+    reload_interval: 30s
+    client_cas: /piko/client-ca.pem
+    client_auth: verify_if_given
+    alpn:
+      - http/1.1
+    min_version: "1.2"
+    cipher_suites:
+      - TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384
+    curve_preferences:
+      - X25519
+      - P384
+
     client:
       cert: /piko/cert2.pem
       key: /piko/key2.pem
       root_cas: /piko/ca.pem
       server_name: piko.example.com
+      min_version: "1.3"
 
 upstream:
   bind_addr: 10.15.104.25:8001
@@ -74,6 +175,9 @@ upstream:
     ecdsa_public_key: ecdsa-public-key
     audience: my-audience
     issuer: my-issuer
+    api_keys:
+      file: /piko/api-keys.yaml
+      reload_interval: 10s
 
   rebalance:
     threshold: 0.2
@@ -88,6 +192,10 @@ upstream:
     - id: tenant-1
       auth:
         hmac_secret_key: hmac-secret-key
+      quota:
+        max_endpoints: 10
+        max_endpoint_upstreams: 2
+        max_upstreams: 15
     - id: tenant-2
       auth:
         rsa_public_key: rsa-public-key
@@ -107,6 +215,11 @@ admin:
     cert: /piko/cert.pem
     key: /piko/key.pem
 
+  tenants:
+    - id: tenant-1
+      auth:
+        hmac_secret_key: hmac-secret-key
+
 stream:
   max_window_size: 4194304
 
@@ -125,6 +238,27 @@ cluster:
     interval: 100ms
     max_packet_size: 1400
 
+metering:
+  enabled: true
+  interval: 30s
+  file: /piko/usage.jsonl
+  webhook:
+    url: https://billing.example.com/usage
+    timeout: 5s
+  max_pending_records: 5000
+
+audit:
+  enabled: true
+  interval: 2s
+  file:
+    path: /piko/audit.jsonl
+    max_size: 1048576
+    max_backups: 3
+  webhook:
+    url: https://siem.example.com/audit
+    timeout: 5s
+  max_pending_events: 5000
+
 log:
   level: info
   subsystems:
@@ -149,6 +283,34 @@ grace_period: 2m
 			BindAddr:      "10.15.104.25:8000",
 			AdvertiseAddr: "1.2.3.4:8000",
 			Timeout:       time.Second * 20,
+			HostPattern:   "{endpoint}.{tenant}.piko.example.com",
+			Authz: AuthzConfig{
+				URL:      "http://authz.example.com/check",
+				Timeout:  time.Second * 2,
+				CacheTTL: time.Second * 30,
+				FailOpen: true,
+			},
+			SignedURLs: SignedURLConfig{
+				SecretKey: "my-secret",
+				CookieTTL: time.Minute * 5,
+			},
+			Identity: IdentityConfig{
+				StripCredentials: true,
+				Headers:          true,
+				Claims:           []string{"email"},
+				JWTSecretKey:     "identity-secret",
+				JWTTTL:           time.Second * 30,
+			},
+			ACME: ACMEConfig{
+				Domains:      []string{"*.piko.example.com", "api.customer.com"},
+				DirectoryURL: "https://localhost:14000/dir",
+				Email:        "admin@example.com",
+				AcceptTOS:    true,
+				CacheDir:     "/var/lib/piko/acme",
+				RootCAs:      "/piko/pebble.pem",
+				ClusterKey:   "acme-cluster-key",
+				IssueRate:    50,
+			},
 			AccessLog: log.AccessLogConfig{
 				Level: "debug",
 				RequestHeaders: log.AccessLogHeaderConfig{
@@ -159,6 +321,53 @@ grace_period: 2m
 				},
 				Disable: false,
 			},
+			Compression: compress.Config{
+				Enabled:      true,
+				Algorithms:   []string{"br", "gzip"},
+				ContentTypes: []string{"text/html"},
+				MinSize:      512,
+			},
+			Cache: CacheConfig{
+				Enabled:       true,
+				Backend:       CacheBackendDisk,
+				Dir:           "/var/cache/piko",
+				MaxSize:       1048576,
+				MaxObjectSize: 1024,
+				Endpoints: []EndpointCacheConfig{
+					{
+						ID:            "my-endpoint",
+						DefaultTTL:    time.Minute,
+						MaxObjectSize: 2048,
+					},
+				},
+			},
+			Limits: LimitsConfig{
+				MaxRequestBodySize: 1048576,
+				MaxUploadRate:      524288,
+				Endpoints: []EndpointLimitsConfig{
+					{
+						ID:                 "my-endpoint",
+						MaxRequestBodySize: 4096,
+					},
+				},
+			},
+			Bandwidth: BandwidthConfig{
+				EndpointRate: 1048576,
+				TenantRate:   10485760,
+				Endpoints: []BandwidthLimitConfig{
+					{
+						ID:   "my-endpoint",
+						Rate: 2097152,
+					},
+				},
+				Tenants: []BandwidthLimitConfig{
+					{
+						ID:   "my-tenant",
+						Rate: 0,
+					},
+				},
+				MaxMetricsLabels: 500,
+			},
 			HTTP: HTTPConfig{
 				ReadTimeout:       time.Second * 5,
 				ReadHeaderTimeout: time.Second * 5,
@@ -176,11 +385,31 @@ grace_period: 2m
 			TLS: TLSConfig{
 				Cert: "/piko/cert.pem",
 				Key:  "/piko/key.pem",
+				Certificates: []CertificateConfig{
+					{
+						Cert: "/piko/example-org.pem",
+						Key:  "/piko/example-org-key.pem",
+					},
+				},
+				ReloadInterval: time.Second * 30,
+				ClientCAs:      "/piko/client-ca.pem",
+				ClientAuth:     "verify_if_given",
+				ALPN:           []string{"http/1.1"},
+				Options: tlsconfig.Options{
+					MinVersion: "1.2",
+					CipherSuites: []string{
+						"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+					},
+					CurvePreferences: []string{"X25519", "P384"},
+				},
 				Client: ClientTLSConfig{
 					Cert:       "/piko/cert2.pem",
 					Key:        "/piko/key2.pem",
 					RootCAs:    "/piko/ca.pem",
 					ServerName: "piko.example.com",
+					Options: tlsconfig.Options{
+						MinVersion: "1.3",
+					},
 				},
 			},
 		},
@@ -193,6 +422,10 @@ grace_period: 2m
 				ECDSAPublicKey: "ecdsa-public-key",
 				Audience:       "my-audience",
 				Issuer:         "my-issuer",
+				APIKeys: auth.APIKeysConfig{
+					File:           "/piko/api-keys.yaml",
+					ReloadInterval: 10 * time.Second,
+				},
 			},
 			Rebalance: RebalanceConfig{
 				Threshold: 0.2,
@@ -209,6 +442,11 @@ grace_period: 2m
 					Auth: auth.Config{
 						HMACSecretKey: "hmac-secret-key",
 					},
+					Quota: QuotaConfig{
+						MaxEndpoints:         10,
+						MaxEndpointUpstreams: 2,
+						MaxUpstreams:         15,
+					},
 				},
 				{
 					ID: "tenant-2",
@@ -235,6 +473,14 @@ grace_period: 2m
 				Cert: "/piko/cert.pem",
 				Key:  "/piko/key.pem",
 			},
+			Tenants: []TenantConfig{
+				{
+					ID: "tenant-1",
+					Auth: auth.Config{
+						HMACSecretKey: "hmac-secret-key",
+					},
+				},
+			},
 		},
 		Cluster: ClusterConfig{
 			NodeID: "my-node",
@@ -252,6 +498,30 @@ grace_period: 2m
 				MaxPacketSize: 1400,
 			},
 		},
+		Metering: MeteringConfig{
+			Enabled:  true,
+			Interval: 30 * time.Second,
+			File:     "/piko/usage.jsonl",
+			Webhook: MeteringWebhookConfig{
+				URL:     "https://billing.example.com/usage",
+				Timeout: 5 * time.Second,
+			},
+			MaxPendingRecords: 5000,
+		},
+		Audit: audit.Config{
+			Enabled:  true,
+			Interval: 2 * time.Second,
+			File: audit.FileConfig{
+				Path:       "/piko/audit.jsonl",
+				MaxSize:    1048576,
+				MaxBackups: 3,
+			},
+			Webhook: audit.WebhookConfig{
+				URL:     "https://siem.example.com/audit",
+				Timeout: 5 * time.Second,
+			},
+			MaxPendingEvents: 5000,
+		},
 		Log: log.Config{
 			Level: "info",
 			Subsystems: []string{
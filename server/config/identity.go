@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+var claimNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// IdentityConfig configures forwarding the verified identity of proxy
+// clients to upstream services.
+type IdentityConfig struct {
+	// StripCredentials removes the header containing the Piko bearer token
+	// before forwarding requests to the upstream.
+	StripCredentials bool `json:"strip_credentials" yaml:"strip_credentials"`
+
+	// Headers adds the verified identity to requests forwarded to the
+	// upstream as 'x-piko-identity-*' headers.
+	Headers bool `json:"headers" yaml:"headers"`
+
+	// Claims contains the names of additional token claims to forward to
+	// the upstream.
+	Claims []string `json:"claims" yaml:"claims"`
+
+	// JWTSecretKey is the HMAC secret key to sign a short-lived JWT
+	// containing the verified identity, which is added to requests
+	// forwarded to the upstream as the 'x-piko-identity-token' header. If
+	// empty, no JWT is added.
+	JWTSecretKey string `json:"jwt_secret_key" yaml:"jwt_secret_key"`
+
+	// JWTTTL is the lifetime of the identity JWT.
+	JWTTTL time.Duration `json:"jwt_ttl" yaml:"jwt_ttl"`
+}
+
+// Enabled returns whether the identity is forwarded to upstreams.
+func (c *IdentityConfig) Enabled() bool {
+	return c.Headers || c.JWTSecretKey != ""
+}
+
+func (c *IdentityConfig) Validate() error {
+	for _, claim := range c.Claims {
+		if !claimNameRegexp.MatchString(claim) {
+			return fmt.Errorf("invalid claim: %s", claim)
+		}
+	}
+	if c.JWTSecretKey != "" && c.JWTTTL <= 0 {
+		return fmt.Errorf("jwt ttl must be positive")
+	}
+	return nil
+}
+
+func (c *IdentityConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	prefix += ".identity."
+
+	fs.BoolVar(
+		&c.StripCredentials,
+		prefix+"strip-credentials",
+		c.StripCredentials,
+		`
+Whether to remove the header containing the Piko bearer token before
+forwarding requests to the upstream.
+
+The token is taken from the 'x-piko-authorization' header if given, otherwise
+the 'Authorization' header, and only that header is removed. The header is
+kept when forwarding requests to another Piko node, which authenticates the
+request again.`,
+	)
+	fs.BoolVar(
+		&c.Headers,
+		prefix+"headers",
+		c.Headers,
+		`
+Whether to add the verified client identity to requests forwarded to the
+upstream.
+
+The token subject is added as 'x-piko-identity-subject', the tenant as
+'x-piko-identity-tenant', and each of the configured claims as
+'x-piko-identity-claim-<name>'. Any 'x-piko-identity-*' headers sent by the
+client are removed.`,
+	)
+	fs.StringSliceVar(
+		&c.Claims,
+		prefix+"claims",
+		c.Claims,
+		`
+Names of additional token claims to forward to the upstream, such as
+'email'. String claims are forwarded as is, other claims are JSON encoded.`,
+	)
+	fs.StringVar(
+		&c.JWTSecretKey,
+		prefix+"jwt-secret-key",
+		c.JWTSecretKey,
+		`
+HMAC secret key to sign a short-lived JWT containing the verified client
+identity, which is added to requests forwarded to the upstream as the
+'x-piko-identity-token' header.
+
+The JWT contains the 'sub' claim, the endpoint ID as the 'aud' claim, the
+'tenant_id' claim, and the configured claims in a 'claims' object. Upstreams
+can verify the JWT using the same secret key.
+
+If empty, no JWT is added.`,
+	)
+	fs.DurationVar(
+		&c.JWTTTL,
+		prefix+"jwt-ttl",
+		c.JWTTTL,
+		`
+Lifetime of the identity JWT.`,
+	)
+}
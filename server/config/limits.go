@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+
+	"github.com/andydunstall/piko/pkg/limit"
+)
+
+// EndpointLimitsConfig overrides the request limits for an endpoint.
+type EndpointLimitsConfig struct {
+	// ID is the endpoint ID.
+	ID string `json:"id" yaml:"id"`
+
+	// MaxRequestBodySize overrides the maximum request body size in bytes
+	// for the endpoint. If zero the default limit is used.
+	MaxRequestBodySize int64 `json:"max_request_body_size" yaml:"max_request_body_size"`
+
+	// MaxUploadRate overrides the maximum upload rate in bytes per second
+	// for the endpoint. If zero the default limit is used.
+	MaxUploadRate int64 `json:"max_upload_rate" yaml:"max_upload_rate"`
+}
+
+func (c *EndpointLimitsConfig) Validate() error {
+	if c.ID == "" {
+		return fmt.Errorf("missing id")
+	}
+	if c.MaxRequestBodySize < 0 {
+		return fmt.Errorf("max request body size cannot be negative")
+	}
+	if c.MaxUploadRate < 0 {
+		return fmt.Errorf("max upload rate cannot be negative")
+	}
+	return nil
+}
+
+// LimitsConfig configures limits on the requests forwarded to upstreams.
+//
+// The limits may be lowered (but never raised) for an upstream by the
+// 'limits' claim in the upstream's token.
+type LimitsConfig struct {
+	// MaxRequestBodySize is the default maximum size of a request body in
+	// bytes. Requests whose body exceeds the limit are rejected with
+	// '413 Content Too Large'.
+	//
+	// If zero, the request body size is unlimited.
+	MaxRequestBodySize int64 `json:"max_request_body_size" yaml:"max_request_body_size"`
+
+	// MaxUploadRate is the default maximum rate a request body is forwarded
+	// to the upstream in bytes per second.
+	//
+	// If zero, the upload rate is unlimited.
+	MaxUploadRate int64 `json:"max_upload_rate" yaml:"max_upload_rate"`
+
+	// Endpoints contains per-endpoint limit overrides.
+	//
+	// Endpoints that aren't listed use the default limits.
+	Endpoints []EndpointLimitsConfig `json:"endpoints" yaml:"endpoints"`
+}
+
+// Endpoint returns the limits for the endpoint with the given ID.
+func (c *LimitsConfig) Endpoint(endpointID string) limit.Config {
+	limits := limit.Config{
+		MaxRequestBodySize: c.MaxRequestBodySize,
+		MaxUploadRate:      c.MaxUploadRate,
+	}
+	for _, endpoint := range c.Endpoints {
+		if endpoint.ID == endpointID {
+			return limits.Override(limit.Config{
+				MaxRequestBodySize: endpoint.MaxRequestBodySize,
+				MaxUploadRate:      endpoint.MaxUploadRate,
+			})
+		}
+	}
+	return limits
+}
+
+func (c *LimitsConfig) Validate() error {
+	if c.MaxRequestBodySize < 0 {
+		return fmt.Errorf("max request body size cannot be negative")
+	}
+	if c.MaxUploadRate < 0 {
+		return fmt.Errorf("max upload rate cannot be negative")
+	}
+	for _, endpoint := range c.Endpoints {
+		if err := endpoint.Validate(); err != nil {
+			return fmt.Errorf("endpoint: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *LimitsConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	prefix += ".limits."
+
+	fs.Int64Var(
+		&c.MaxRequestBodySize,
+		prefix+"max-request-body-size",
+		c.MaxRequestBodySize,
+		`
+The default maximum size of a request body in bytes. Requests whose body
+exceeds the limit are rejected with '413 Content Too Large'.
+
+Per-endpoint limits can be configured in the configuration file, and an
+upstream can lower (but not raise) the limits using the 'piko.limits' claim in
+its token.
+
+Set to 0 for no limit.`,
+	)
+	fs.Int64Var(
+		&c.MaxUploadRate,
+		prefix+"max-upload-rate",
+		c.MaxUploadRate,
+		`
+The default maximum rate to forward a request body to the upstream in bytes
+per second.
+
+Set to 0 for no limit.`,
+	)
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+type MeteringWebhookConfig struct {
+	// URL is the URL to POST usage records to. If empty, records aren't
+	// sent to a webhook.
+	URL string `json:"url" yaml:"url"`
+
+	// Timeout is the timeout for each webhook request.
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+}
+
+// MeteringConfig configures recording tenant usage for billing.
+type MeteringConfig struct {
+	// Enabled indicates whether to record usage.
+	Enabled bool `json:"enabled" yaml:"enabled"`
+
+	// Interval is the interval to flush usage records to the configured
+	// sinks.
+	Interval time.Duration `json:"interval" yaml:"interval"`
+
+	// File is the path of a file to append usage records to as JSON lines.
+	// If empty, records aren't written to a file.
+	File string `json:"file" yaml:"file"`
+
+	// Webhook configures sending usage records to a HTTP webhook.
+	Webhook MeteringWebhookConfig `json:"webhook" yaml:"webhook"`
+
+	// MaxPendingRecords is the maximum number of records to buffer for a
+	// sink that is failing. Once exceeded, the oldest records are dropped.
+	MaxPendingRecords int `json:"max_pending_records" yaml:"max_pending_records"`
+}
+
+func (c *MeteringConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	if c.File == "" && c.Webhook.URL == "" {
+		return fmt.Errorf("missing sink; must configure a file or webhook")
+	}
+	if c.Webhook.URL != "" {
+		u, err := url.Parse(c.Webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("webhook: invalid url: %s", c.Webhook.URL)
+		}
+		if c.Webhook.Timeout <= 0 {
+			return fmt.Errorf("webhook: timeout must be positive")
+		}
+	}
+	if c.MaxPendingRecords < 0 {
+		return fmt.Errorf("max pending records cannot be negative")
+	}
+	return nil
+}
+
+func (c *MeteringConfig) RegisterFlags(fs *pflag.FlagSet) {
+	fs.BoolVar(
+		&c.Enabled,
+		"metering.enabled",
+		c.Enabled,
+		`
+Whether to record usage for billing.
+
+Usage is recorded per tenant and endpoint, including the number of requests,
+bytes proxied, upstream connection seconds and TCP connection seconds. Usage
+is only recorded by the node the upstream is connected to, so requests
+forwarded between nodes aren't counted twice.`,
+	)
+	fs.DurationVar(
+		&c.Interval,
+		"metering.interval",
+		c.Interval,
+		`
+The interval to flush usage records to the configured sinks.`,
+	)
+	fs.StringVar(
+		&c.File,
+		"metering.file",
+		c.File,
+		`
+The path of a file to append usage records to as JSON lines.`,
+	)
+	fs.StringVar(
+		&c.Webhook.URL,
+		"metering.webhook.url",
+		c.Webhook.URL,
+		`
+A URL to POST usage records to as a JSON array.
+
+If the webhook fails, records are retried on the next flush.`,
+	)
+	fs.DurationVar(
+		&c.Webhook.Timeout,
+		"metering.webhook.timeout",
+		c.Webhook.Timeout,
+		`
+Timeout for each webhook request.`,
+	)
+	fs.IntVar(
+		&c.MaxPendingRecords,
+		"metering.max-pending-records",
+		c.MaxPendingRecords,
+		`
+The maximum number of records to buffer for a sink that is failing. Once
+exceeded, the oldest records are dropped.`,
+	)
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// reloadableFields contains the paths of the fields that can be updated
+// without restarting the server.
+var reloadableFields = map[string]struct{}{
+	"proxy.auth":       {},
+	"proxy.tenants":    {},
+	"proxy.access_log": {},
+
+	"upstream.auth":      {},
+	"upstream.tenants":   {},
+	"upstream.rebalance": {},
+
+	"admin.auth":    {},
+	"admin.tenants": {},
+}
+
+// reloadableTLSFields contains the TLS fields of each listener that can be
+// updated without restarting the server.
+var reloadableTLSFields = []string{
+	"cert",
+	"key",
+	"client_cas",
+	"certificates",
+	"reload_interval",
+	"client_auth",
+	"alpn",
+	"min_version",
+	"cipher_suites",
+	"curve_preferences",
+}
+
+func init() {
+	for _, listener := range []string{"proxy", "upstream", "admin"} {
+		for _, field := range reloadableTLSFields {
+			reloadableFields[listener+".tls."+field] = struct{}{}
+		}
+	}
+}
+
+// CheckReload returns an error if the updated configuration changes any
+// fields that can't be updated without restarting the server.
+func (c *Config) CheckReload(updated *Config) error {
+	changed := changedFields(reflect.ValueOf(*c), reflect.ValueOf(*updated), "")
+	if len(changed) > 0 {
+		return fmt.Errorf(
+			"fields cannot be changed without a restart: %s",
+			strings.Join(changed, ", "),
+		)
+	}
+
+	// Enabling or disabling authentication or TLS can't be done without a
+	// restart.
+	if enabled(&c.Proxy.Auth, c.Proxy.Tenants) != enabled(&updated.Proxy.Auth, updated.Proxy.Tenants) {
+		return fmt.Errorf("proxy.auth: cannot enable or disable auth without a restart")
+	}
+	if enabled(&c.Upstream.Auth, c.Upstream.Tenants) != enabled(&updated.Upstream.Auth, updated.Upstream.Tenants) {
+		return fmt.Errorf("upstream.auth: cannot enable or disable auth without a restart")
+	}
+	if enabled(&c.Admin.Auth, c.Admin.Tenants) != enabled(&updated.Admin.Auth, updated.Admin.Tenants) {
+		return fmt.Errorf("admin.auth: cannot enable or disable auth without a restart")
+	}
+	if c.Proxy.TLS.enabled() != updated.Proxy.TLS.enabled() {
+		return fmt.Errorf("proxy.tls: cannot enable or disable tls without a restart")
+	}
+	if c.Upstream.TLS.enabled() != updated.Upstream.TLS.enabled() {
+		return fmt.Errorf("upstream.tls: cannot enable or disable tls without a restart")
+	}
+	if c.Admin.TLS.enabled() != updated.Admin.TLS.enabled() {
+		return fmt.Errorf("admin.tls: cannot enable or disable tls without a restart")
+	}
+	return nil
+}
+
+// changedFields returns the paths of the non-reloadable fields that differ
+// between the given values.
+func changedFields(a reflect.Value, b reflect.Value, path string) []string {
+	if _, ok := reloadableFields[path]; ok {
+		return nil
+	}
+
+	if a.Kind() != reflect.Struct {
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			return []string{path}
+		}
+		return nil
+	}
+
+	var changed []string
+	for i := 0; i != a.NumField(); i++ {
+		field := a.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if opts == "inline" {
+			changed = append(changed, changedFields(a.Field(i), b.Field(i), path)...)
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		changed = append(changed, changedFields(a.Field(i), b.Field(i), fieldPath)...)
+	}
+	return changed
+}
+
+func enabled(conf interface{ Enabled() bool }, tenants []TenantConfig) bool {
+	return conf.Enabled() || len(tenants) > 0
+}
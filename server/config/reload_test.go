@@ -0,0 +1,64 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_CheckReload(t *testing.T) {
+	t.Run("reloadable", func(t *testing.T) {
+		conf := Default()
+		conf.Proxy.Auth.HMACSecretKey = "old-key"
+
+		updated := Default()
+		updated.Proxy.Auth.HMACSecretKey = "new-key"
+		updated.Proxy.AccessLog.Disable = true
+		updated.Upstream.Rebalance.Threshold = 0.5
+		updated.Proxy.TLS.MinVersion = "1.3"
+		updated.Admin.TLS.ClientAuth = "request"
+
+		assert.NoError(t, conf.CheckReload(updated))
+	})
+
+	t.Run("not reloadable", func(t *testing.T) {
+		conf := Default()
+
+		updated := Default()
+		updated.Proxy.BindAddr = "0.0.0.0:9000"
+		updated.Proxy.TLS.Client.MinVersion = "1.3"
+		updated.Cluster.JoinTimeout = conf.Cluster.JoinTimeout * 2
+
+		err := conf.CheckReload(updated)
+		assert.EqualError(
+			t,
+			err,
+			"fields cannot be changed without a restart: proxy.bind_addr, proxy.tls.client.min_version, cluster.join_timeout",
+		)
+	})
+
+	t.Run("enable auth", func(t *testing.T) {
+		conf := Default()
+
+		updated := Default()
+		updated.Admin.Auth.HMACSecretKey = "my-key"
+
+		err := conf.CheckReload(updated)
+		assert.EqualError(
+			t, err, "admin.auth: cannot enable or disable auth without a restart",
+		)
+	})
+
+	t.Run("enable tls", func(t *testing.T) {
+		conf := Default()
+
+		updated := Default()
+		updated.Proxy.TLS.Cert = "cert.pem"
+		updated.Proxy.TLS.Key = "key.pem"
+
+		err := conf.CheckReload(updated)
+		assert.EqualError(
+			t, err, "proxy.tls: cannot enable or disable tls without a restart",
+		)
+	})
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// SignedURLConfig configures granting temporary access to endpoints using
+// HMAC signed URLs, as an alternative to bearer tokens.
+type SignedURLConfig struct {
+	// SecretKey is the HMAC secret key to verify signed URLs. If empty,
+	// signed URLs are disabled.
+	//
+	// All nodes must use the same key.
+	SecretKey string `json:"secret_key" yaml:"secret_key"`
+
+	// CookieTTL is the maximum lifetime of the cookie set when a client
+	// first uses a signed URL.
+	CookieTTL time.Duration `json:"cookie_ttl" yaml:"cookie_ttl"`
+}
+
+func (c *SignedURLConfig) Enabled() bool {
+	return c.SecretKey != ""
+}
+
+func (c *SignedURLConfig) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.CookieTTL <= 0 {
+		return fmt.Errorf("cookie ttl must be positive")
+	}
+	return nil
+}
+
+func (c *SignedURLConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
+	prefix += ".signed-urls."
+
+	fs.StringVar(
+		&c.SecretKey,
+		prefix+"secret-key",
+		c.SecretKey,
+		`
+HMAC secret key to verify signed URLs.
+
+Signed URLs grant temporary access to an endpoint without a bearer token,
+such as to share an endpoint with an external user. Generate signed URLs
+with 'piko token sign-url'.
+
+All nodes in the cluster must use the same key, since requests forwarded to
+another node are verified again by that node.
+
+If empty, signed URLs are disabled.`,
+	)
+	fs.DurationVar(
+		&c.CookieTTL,
+		prefix+"cookie-ttl",
+		c.CookieTTL,
+		`
+Maximum lifetime of the cookie set when a client first uses a signed URL.
+The cookie never outlives the signed URL.`,
+	)
+}
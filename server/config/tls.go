@@ -5,15 +5,72 @@ import (
 	"crypto/x509"
 	"fmt"
 	"os"
+	"slices"
+	"sync/atomic"
+	"time"
 
 	"github.com/spf13/pflag"
+
+	"github.com/andydunstall/piko/pkg/auth"
+	"github.com/andydunstall/piko/pkg/tlsconfig"
 )
 
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// supportedALPN contains the application protocols the HTTP servers support.
+var supportedALPN = []string{"h2", "http/1.1"}
+
+// CertificateConfig configures a PEM encoded certificate and key pair.
+type CertificateConfig struct {
+	Cert string `json:"cert" yaml:"cert"`
+	Key  string `json:"key" yaml:"key"`
+}
+
+func (c *CertificateConfig) Validate() error {
+	if c.Cert == "" {
+		return fmt.Errorf("missing cert")
+	}
+	if c.Key == "" {
+		return fmt.Errorf("missing key")
+	}
+	return nil
+}
+
 type TLSConfig struct {
 	Cert      string `json:"cert" yaml:"cert"`
 	Key       string `json:"key" yaml:"key"`
 	ClientCAs string `json:"client_cas" yaml:"client_cas"`
 
+	// Certificates contains additional certificates to serve, where the
+	// certificate is selected using the server name (SNI) requested by the
+	// client.
+	Certificates []CertificateConfig `json:"certificates" yaml:"certificates"`
+
+	// ReloadInterval is the interval to check the certificate files for
+	// changes.
+	ReloadInterval time.Duration `json:"reload_interval" yaml:"reload_interval"`
+
+	// ClientAuth is the policy for client certificates, either 'none',
+	// 'request', 'require', 'verify_if_given' or 'require_and_verify'.
+	//
+	// Defaults to 'require_and_verify' if client CAs are configured,
+	// otherwise 'none'.
+	ClientAuth string `json:"client_auth" yaml:"client_auth"`
+
+	// ALPN contains the application protocols to negotiate in order of
+	// preference, either 'h2' or 'http/1.1'.
+	//
+	// Defaults to 'h2' and 'http/1.1'.
+	ALPN []string `json:"alpn" yaml:"alpn"`
+
+	tlsconfig.Options `json:",inline" yaml:",inline"`
+
 	Client ClientTLSConfig `json:"client" yaml:"client"`
 }
 
@@ -22,15 +79,63 @@ func (c *TLSConfig) Validate() error {
 		return nil
 	}
 
-	if c.Cert == "" {
-		return fmt.Errorf("missing cert")
+	if c.Cert != "" || c.Key != "" {
+		if c.Cert == "" {
+			return fmt.Errorf("missing cert")
+		}
+		if c.Key == "" {
+			return fmt.Errorf("missing key")
+		}
 	}
-	if c.Key == "" {
-		return fmt.Errorf("missing key")
+	for i, cert := range c.Certificates {
+		if err := cert.Validate(); err != nil {
+			return fmt.Errorf("certificates[%d]: %w", i, err)
+		}
+	}
+	if c.ReloadInterval < 0 {
+		return fmt.Errorf("negative reload interval")
+	}
+	if c.ClientAuth != "" {
+		clientAuth, ok := clientAuthTypes[c.ClientAuth]
+		if !ok {
+			return fmt.Errorf("unsupported client auth: %s", c.ClientAuth)
+		}
+		if clientAuth >= tls.VerifyClientCertIfGiven && c.ClientCAs == "" {
+			return fmt.Errorf("client auth: %s requires client cas", c.ClientAuth)
+		}
+	}
+	for _, proto := range c.ALPN {
+		if !slices.Contains(supportedALPN, proto) {
+			return fmt.Errorf("unsupported alpn protocol: %s", proto)
+		}
+	}
+	if len(c.ALPN) > 0 && !slices.Contains(c.ALPN, "http/1.1") {
+		// WebSocket connections require HTTP/1.1.
+		return fmt.Errorf("alpn must include http/1.1")
+	}
+	if err := c.Options.Validate(); err != nil {
+		return err
+	}
+	if err := c.Client.Options.Validate(); err != nil {
+		return fmt.Errorf("client: %w", err)
 	}
 	return nil
 }
 
+// CertificatePairs returns the configured certificate and key pairs, where
+// the first pair is the default certificate served when no certificate
+// matches the client's requested server name.
+func (c *TLSConfig) CertificatePairs() []CertificateConfig {
+	var pairs []CertificateConfig
+	if c.Cert != "" {
+		pairs = append(pairs, CertificateConfig{
+			Cert: c.Cert,
+			Key:  c.Key,
+		})
+	}
+	return append(pairs, c.Certificates...)
+}
+
 func (c *TLSConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
 	prefix += ".tls."
 
@@ -41,7 +146,23 @@ func (c *TLSConfig) RegisterFlags(fs *pflag.FlagSet, prefix string) {
 		`
 Path to the PEM encoded certificate file.
 
-If given the server will listen on TLS`,
+If given the server will listen on TLS.
+
+Additional certificates can be configured in YAML using 'certificates', where
+the server selects the certificate matching the server name (SNI) requested by
+the client, such as:
+
+  certificates:
+    - cert: /etc/piko/tls/example-com.crt
+      key: /etc/piko/tls/example-com.key
+    - cert: /etc/piko/tls/example-org.crt
+      key: /etc/piko/tls/example-org.key
+
+If no certificate matches, the certificate configured by 'cert' is served, or
+the first configured certificate if 'cert' isn't set.
+
+Certificate files are reloaded when they change, so rotated certificates
+are served without restarting the server.`,
 	)
 	fs.StringVar(
 		&c.Key,
@@ -58,23 +179,61 @@ Path to the PEM encoded key file.`,
 A path to a certificate PEM file containing client certificiate authorities to
 verify the client certificates.
 
-When set the client must set a valid certificate during the TLS handshake.`,
+When set the client must set a valid certificate during the TLS handshake,
+unless configured otherwise by 'client-auth'.`,
+	)
+	fs.StringVar(
+		&c.ClientAuth,
+		prefix+"client-auth",
+		c.ClientAuth,
+		`
+Policy for client certificates, either:
+- none: Client certificates aren't requested
+- request: Client certificates are requested but not required or verified
+- require: Client certificates are required but not verified
+- verify_if_given: Client certificates aren't required, though are verified
+if given
+- require_and_verify: Client certificates are required and verified
+
+The 'verify_if_given' and 'require_and_verify' policies require 'client-cas'.
+
+Defaults to 'require_and_verify' if 'client-cas' is set, otherwise 'none'.`,
+	)
+	fs.StringSliceVar(
+		&c.ALPN,
+		prefix+"alpn",
+		c.ALPN,
+		`
+Application protocols to negotiate in order of preference, either 'h2' or
+'http/1.1'. Must include 'http/1.1' which is required by WebSocket connections.
+
+Defaults to 'h2' and 'http/1.1'.`,
 	)
+	fs.DurationVar(
+		&c.ReloadInterval,
+		prefix+"reload-interval",
+		c.ReloadInterval,
+		`
+Interval to check the certificate files for changes. Defaults to 10s.`,
+	)
+
+	c.Options.RegisterFlags(fs, prefix)
 
 	c.Client.RegisterFlags(fs, prefix[:len(prefix)-1])
 }
 
-func (c *TLSConfig) Load() (*tls.Config, error) {
-	if !c.enabled() {
-		return nil, nil
+// Load loads the server TLS configuration, where the certificate served is
+// selected using getCertificate.
+func (c *TLSConfig) Load(
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error),
+) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		GetCertificate: getCertificate,
+		NextProtos:     c.ALPN,
 	}
-
-	tlsConfig := &tls.Config{}
-	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
-	if err != nil {
-		return nil, fmt.Errorf("load key pair: %w", err)
+	if err := c.Options.Apply(tlsConfig); err != nil {
+		return nil, err
 	}
-	tlsConfig.Certificates = []tls.Certificate{cert}
 
 	if c.ClientCAs != "" {
 		caCert, err := os.ReadFile(c.ClientCAs)
@@ -87,14 +246,25 @@ func (c *TLSConfig) Load() (*tls.Config, error) {
 			return nil, fmt.Errorf("parse client cas: %s: %w", c.ClientCAs, err)
 		}
 		tlsConfig.ClientCAs = caCertPool
-		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 	}
+	tlsConfig.ClientAuth = c.clientAuth()
 
 	return tlsConfig, nil
 }
 
 func (c *TLSConfig) enabled() bool {
-	return c.Cert != "" || c.Key != ""
+	return c.Cert != "" || c.Key != "" || len(c.Certificates) > 0
+}
+
+// clientAuth returns the client certificate policy.
+func (c *TLSConfig) clientAuth() tls.ClientAuthType {
+	if clientAuth, ok := clientAuthTypes[c.ClientAuth]; ok {
+		return clientAuth
+	}
+	if c.ClientCAs != "" {
+		return tls.RequireAndVerifyClientCert
+	}
+	return tls.NoClientCert
 }
 
 type ClientTLSConfig struct {
@@ -123,12 +293,17 @@ type ClientTLSConfig struct {
 	//
 	// See https://pkg.go.dev/crypto/tls#Config.
 	ServerName string `json:"server_name" yaml:"server_name"`
+
+	tlsconfig.Options `json:",inline" yaml:",inline"`
 }
 
 func (c *ClientTLSConfig) Validate() error {
 	if c.Cert != "" && c.Key == "" {
 		return fmt.Errorf("missing key")
 	}
+	if err := c.Options.Validate(); err != nil {
+		return err
+	}
 
 	_, err := c.Load()
 	return err
@@ -184,10 +359,15 @@ is issued for a hostname).
 
 If not set, the hostname from the dial address is used for verification.`,
 	)
+
+	c.Options.RegisterFlags(fs, prefix)
 }
 
 func (c *ClientTLSConfig) Load() (*tls.Config, error) {
 	tlsConfig := &tls.Config{}
+	if err := c.Options.Apply(tlsConfig); err != nil {
+		return nil, err
+	}
 
 	if c.Cert != "" {
 		cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
@@ -218,3 +398,56 @@ func (c *ClientTLSConfig) Load() (*tls.Config, error) {
 
 	return tlsConfig, nil
 }
+
+// validateCertAuth validates the client certificate auth configuration, which
+// requires TLS client CAs and a client auth policy that verifies the client
+// certificates.
+func validateCertAuth(authConfig *auth.Config, tlsConfig *TLSConfig) error {
+	if !authConfig.Cert.Enabled() {
+		return nil
+	}
+	if err := authConfig.Cert.Validate(); err != nil {
+		return fmt.Errorf("cert: %w", err)
+	}
+	if tlsConfig.ClientCAs == "" {
+		return fmt.Errorf("cert: requires tls client cas")
+	}
+	if tlsConfig.clientAuth() < tls.VerifyClientCertIfGiven {
+		return fmt.Errorf("cert: requires tls client auth that verifies certificates")
+	}
+	return nil
+}
+
+// TLSReloader serves a TLS configuration that can be replaced without
+// restarting the server, such as to rotate certificates.
+type TLSReloader struct {
+	current atomic.Pointer[tls.Config]
+}
+
+func NewTLSReloader(conf *tls.Config) *TLSReloader {
+	r := &TLSReloader{}
+	r.Update(conf)
+	return r
+}
+
+// ServerConfig returns the TLS configuration to serve, which selects the
+// current configuration on each handshake.
+func (r *TLSReloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}
+}
+
+// Update replaces the served TLS configuration. Existing connections aren't
+// affected.
+func (r *TLSReloader) Update(conf *tls.Config) {
+	conf = conf.Clone()
+	if len(conf.NextProtos) == 0 {
+		// The configuration is used in place of the server configuration,
+		// so must include the protocols the HTTP server supports.
+		conf.NextProtos = slices.Clone(supportedALPN)
+	}
+	r.current.Store(conf)
+}
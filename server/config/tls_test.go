@@ -0,0 +1,163 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/pkg/auth"
+)
+
+func TestTLSConfig_Validate(t *testing.T) {
+	valid := func() TLSConfig {
+		return TLSConfig{
+			Cert:      "/piko/cert.pem",
+			Key:       "/piko/key.pem",
+			ClientCAs: "/piko/ca.pem",
+		}
+	}
+
+	conf := valid()
+	assert.NoError(t, conf.Validate())
+
+	conf = valid()
+	conf.ClientAuth = "verify_if_given"
+	conf.ALPN = []string{"http/1.1"}
+	conf.MinVersion = "1.3"
+	assert.NoError(t, conf.Validate())
+
+	conf = valid()
+	conf.ClientAuth = "unknown"
+	assert.EqualError(t, conf.Validate(), "unsupported client auth: unknown")
+
+	conf = valid()
+	conf.ClientCAs = ""
+	conf.ClientAuth = "require_and_verify"
+	assert.EqualError(
+		t, conf.Validate(), "client auth: require_and_verify requires client cas",
+	)
+
+	conf = valid()
+	conf.ALPN = []string{"h3"}
+	assert.EqualError(t, conf.Validate(), "unsupported alpn protocol: h3")
+
+	conf = valid()
+	conf.ALPN = []string{"h2"}
+	assert.EqualError(t, conf.Validate(), "alpn must include http/1.1")
+
+	conf = valid()
+	conf.MinVersion = "1.0"
+	assert.EqualError(t, conf.Validate(), "unsupported min version: 1.0")
+
+	conf = valid()
+	conf.Client.CurvePreferences = []string{"P224"}
+	assert.EqualError(t, conf.Validate(), "client: unsupported curve: P224")
+}
+
+func TestTLSConfig_Load(t *testing.T) {
+	caPath := writeCACert(t)
+
+	tests := []struct {
+		name       string
+		clientCAs  string
+		clientAuth string
+		expected   tls.ClientAuthType
+	}{
+		{
+			name:     "default",
+			expected: tls.NoClientCert,
+		},
+		{
+			name:      "default with client cas",
+			clientCAs: caPath,
+			expected:  tls.RequireAndVerifyClientCert,
+		},
+		{
+			name:       "verify if given",
+			clientCAs:  caPath,
+			clientAuth: "verify_if_given",
+			expected:   tls.VerifyClientCertIfGiven,
+		},
+		{
+			name:       "request",
+			clientAuth: "request",
+			expected:   tls.RequestClientCert,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := TLSConfig{
+				ClientCAs:  tt.clientCAs,
+				ClientAuth: tt.clientAuth,
+				ALPN:       []string{"http/1.1"},
+			}
+			conf.MinVersion = "1.3"
+
+			tlsConfig, err := conf.Load(nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, tlsConfig.ClientAuth)
+			assert.Equal(t, []string{"http/1.1"}, tlsConfig.NextProtos)
+			assert.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MinVersion)
+		})
+	}
+}
+
+func TestValidateCertAuth(t *testing.T) {
+	authConfig := &auth.Config{
+		Cert: auth.CertConfig{
+			Rules: []auth.CertRule{{CommonName: "*"}},
+		},
+	}
+
+	tlsConfig := &TLSConfig{
+		ClientCAs: "/piko/ca.pem",
+	}
+	assert.NoError(t, validateCertAuth(authConfig, tlsConfig))
+
+	tlsConfig.ClientAuth = "verify_if_given"
+	assert.NoError(t, validateCertAuth(authConfig, tlsConfig))
+
+	// Client certificates must be verified.
+	tlsConfig.ClientAuth = "require"
+	assert.EqualError(
+		t,
+		validateCertAuth(authConfig, tlsConfig),
+		"cert: requires tls client auth that verifies certificates",
+	)
+}
+
+// writeCACert writes a self-signed CA certificate and returns its path.
+func writeCACert(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "piko-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(
+		path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600,
+	))
+	return path
+}
@@ -7,6 +7,7 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/andydunstall/piko/pkg/auth"
 	"github.com/andydunstall/piko/pkg/backoff"
 	"github.com/andydunstall/piko/pkg/gossip"
 	"github.com/andydunstall/piko/pkg/log"
@@ -35,11 +36,12 @@ func NewGossip(
 	streamLn net.Listener,
 	packetLn net.PacketConn,
 	conf *gossip.Config,
+	revocations *auth.RevocationList,
 	logger log.Logger,
 ) *Gossip {
 	logger = logger.WithSubsystem("gossip")
 
-	syncer := newSyncer(clusterState, logger)
+	syncer := newSyncer(clusterState, revocations, logger)
 	gossiper := gossip.New(
 		clusterState.LocalNode().ID,
 		conf,
@@ -1,17 +1,26 @@
 package gossip
 
 import (
+	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
+	"github.com/andydunstall/piko/pkg/auth"
 	"github.com/andydunstall/piko/pkg/gossip"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/server/cluster"
 )
 
+// connectionsSyncInterval is the interval to batch updates to the local
+// nodes proxied connections before propagating, since connections change on
+// every proxied request.
+const connectionsSyncInterval = time.Millisecond * 100
+
 type gossiper interface {
 	UpsertLocal(key, value string)
 	DeleteLocal(key string)
@@ -23,6 +32,11 @@ type gossiper interface {
 // until we have the full node state. Since gossip propagates state updates in
 // order, we only add a node to the cluster when we have the required immutable
 // fields.
+//
+// Token revocations are also propagated using gossip. Each node publishes
+// every revocation it knows about in its own local state, so revocations
+// are kept even if the node that received the original revocation leaves the
+// cluster.
 type syncer struct {
 	// pendingNodes contains nodes that we haven't received the full state for
 	// yet so can't be added to the cluster.
@@ -31,18 +45,58 @@ type syncer struct {
 	// mu protects the above fields.
 	mu sync.Mutex
 
+	// pendingConnections contains the tenants whose local connections have
+	// changed since they were last propagated.
+	pendingConnections map[string]struct{}
+
+	// connectionsScheduled indicates whether the pending connections are
+	// scheduled to be propagated.
+	connectionsScheduled bool
+
+	// connectionsMu protects the above fields.
+	connectionsMu sync.Mutex
+
+	// connectionsInterval is the interval to batch connection updates.
+	connectionsInterval time.Duration
+
+	// flushMu ensures only one batch of connection updates is propagated at
+	// a time, so updates are propagated in order.
+	flushMu sync.Mutex
+
+	// revocationUpdates contains the pending revocation updates to apply to
+	// the local gossip state, in the order the revocations changed.
+	revocationUpdates []func()
+
+	// revocationDraining indicates whether a goroutine is applying the
+	// pending revocation updates.
+	revocationDraining bool
+
+	// revocationMu protects the above fields.
+	revocationMu sync.Mutex
+
 	clusterState *cluster.State
 
+	// revocations contains the revoked tokens, or nil if revocations aren't
+	// propagated.
+	revocations *auth.RevocationList
+
 	gossiper gossiper
 
 	logger log.Logger
 }
 
-func newSyncer(clusterState *cluster.State, logger log.Logger) *syncer {
+func newSyncer(
+	clusterState *cluster.State,
+	revocations *auth.RevocationList,
+	logger log.Logger,
+) *syncer {
 	return &syncer{
-		pendingNodes: make(map[string]*cluster.Node),
-		clusterState: clusterState,
-		logger:       logger,
+		pendingNodes:        make(map[string]*cluster.Node),
+		pendingConnections:  make(map[string]struct{}),
+		connectionsInterval: connectionsSyncInterval,
+		clusterState:        clusterState,
+		revocations:         revocations,
+		logger:              logger,
 	}
 }
 
@@ -50,6 +104,7 @@ func (s *syncer) Sync(gossiper gossiper) {
 	s.gossiper = gossiper
 
 	s.clusterState.OnLocalEndpointUpdate(s.onLocalEndpointUpdate)
+	s.clusterState.OnLocalConnectionsUpdate(s.onLocalConnectionsUpdate)
 
 	localNode := s.clusterState.LocalNode()
 	// First add immutable fields.
@@ -60,6 +115,30 @@ func (s *syncer) Sync(gossiper gossiper) {
 		key := "endpoint:" + endpointID
 		s.gossiper.UpsertLocal(key, strconv.Itoa(listeners))
 	}
+	for tenantID, conns := range localNode.Connections {
+		key := "connections:" + tenantID
+		s.gossiper.UpsertLocal(key, strconv.Itoa(conns))
+	}
+
+	if s.revocations != nil {
+		// Note revocations may be added while handling a gossip update, when
+		// the gossip state is locked, so must update the local state in the
+		// background. Updates are queued so they're applied in order, such
+		// as a revocation expiring and being re-added.
+		s.revocations.OnRevoke(func(r auth.Revocation) {
+			s.queueRevocationUpdate(func() {
+				s.onLocalRevoke(r)
+			})
+		})
+		s.revocations.OnExpire(func(r auth.Revocation) {
+			s.queueRevocationUpdate(func() {
+				s.gossiper.DeleteLocal(revocationKey(r))
+			})
+		})
+		for _, r := range s.revocations.List() {
+			s.onLocalRevoke(r)
+		}
+	}
 }
 
 func (s *syncer) OnJoin(nodeID string) {
@@ -258,6 +337,13 @@ func (s *syncer) OnUpsertKey(nodeID, key, value string) {
 		return
 	}
 
+	// Revocations aren't part of the cluster state so are handled
+	// separately.
+	if strings.HasPrefix(key, "revoked:") {
+		s.onRemoteRevoke(nodeID, key, value)
+		return
+	}
+
 	if key == "proxy_addr" || key == "admin_addr" {
 		// Ignore immutable fields if the node is in the cluster state. This
 		// may occur after a compaction so immutable fields are re-versioned.
@@ -284,6 +370,22 @@ func (s *syncer) OnUpsertKey(nodeID, key, value string) {
 			return
 		}
 	}
+	if strings.HasPrefix(key, "connections:") {
+		tenantID, _ := strings.CutPrefix(key, "connections:")
+		conns, err := strconv.Atoi(value)
+		if err != nil {
+			s.logger.Error(
+				"node upsert state; invalid connections",
+				zap.String("node-id", nodeID),
+				zap.String("connections", value),
+				zap.Error(err),
+			)
+			return
+		}
+		if s.clusterState.UpdateRemoteConnections(nodeID, tenantID, conns) {
+			return
+		}
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -317,6 +419,22 @@ func (s *syncer) OnUpsertKey(nodeID, key, value string) {
 			node.Endpoints = make(map[string]int)
 		}
 		node.Endpoints[endpointID] = listeners
+	} else if strings.HasPrefix(key, "connections:") {
+		tenantID, _ := strings.CutPrefix(key, "connections:")
+		conns, err := strconv.Atoi(value)
+		if err != nil {
+			s.logger.Error(
+				"node upsert state; invalid connections",
+				zap.String("node-id", nodeID),
+				zap.String("connections", value),
+				zap.Error(err),
+			)
+			return
+		}
+		if node.Connections == nil {
+			node.Connections = make(map[string]int)
+		}
+		node.Connections[tenantID] = conns
 	} else {
 		s.logger.Error(
 			"node upsert state; unsupported key",
@@ -363,7 +481,17 @@ func (s *syncer) OnDeleteKey(nodeID, key string) {
 		return
 	}
 
-	// Only endpoint state can be deleted.
+	// Revocations are removed by each node once expired, so ignore deletes.
+	if strings.HasPrefix(key, "revoked:") {
+		return
+	}
+
+	if strings.HasPrefix(key, "connections:") {
+		s.onRemoteConnectionsDelete(nodeID, key)
+		return
+	}
+
+	// Only endpoint and connections state can be deleted.
 	if !strings.HasPrefix(key, "endpoint:") {
 		s.logger.Error(
 			"node delete state; unsupported key",
@@ -407,6 +535,30 @@ func (s *syncer) OnDeleteKey(nodeID, key string) {
 	)
 }
 
+func (s *syncer) onRemoteConnectionsDelete(nodeID, key string) {
+	tenantID, _ := strings.CutPrefix(key, "connections:")
+	if s.clusterState.RemoveRemoteConnections(nodeID, tenantID) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.pendingNodes[nodeID]
+	if !ok {
+		s.logger.Warn(
+			"node delete state; unknown node",
+			zap.String("node-id", nodeID),
+			zap.String("key", key),
+		)
+		return
+	}
+
+	if node.Connections != nil {
+		delete(node.Connections, tenantID)
+	}
+}
+
 func (s *syncer) onLocalEndpointUpdate(endpointID string) {
 	key := "endpoint:" + endpointID
 	listeners := s.clusterState.LocalEndpointListeners(endpointID)
@@ -417,4 +569,144 @@ func (s *syncer) onLocalEndpointUpdate(endpointID string) {
 	}
 }
 
+// onLocalConnectionsUpdate schedules propagating the tenants local
+// connections. Updates are batched, so each tenant is updated at most once
+// per interval rather than on every proxied request.
+func (s *syncer) onLocalConnectionsUpdate(tenantID string) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+
+	s.pendingConnections[tenantID] = struct{}{}
+	if !s.connectionsScheduled {
+		s.connectionsScheduled = true
+		time.AfterFunc(s.connectionsInterval, s.flushConnections)
+	}
+}
+
+// flushConnections propagates the local connections of each tenant updated
+// since the last flush.
+func (s *syncer) flushConnections() {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.connectionsMu.Lock()
+	pending := s.pendingConnections
+	s.pendingConnections = make(map[string]struct{})
+	s.connectionsScheduled = false
+	s.connectionsMu.Unlock()
+
+	for tenantID := range pending {
+		key := "connections:" + tenantID
+		conns := s.clusterState.LocalConnections(tenantID)
+		if conns > 0 {
+			s.gossiper.UpsertLocal(key, strconv.Itoa(conns))
+		} else {
+			s.gossiper.DeleteLocal(key)
+		}
+	}
+}
+
+// queueRevocationUpdate queues an update to the local revocations gossip
+// state. Updates are applied in order by a single goroutine.
+func (s *syncer) queueRevocationUpdate(f func()) {
+	s.revocationMu.Lock()
+	defer s.revocationMu.Unlock()
+
+	s.revocationUpdates = append(s.revocationUpdates, f)
+	if !s.revocationDraining {
+		s.revocationDraining = true
+		go s.drainRevocationUpdates()
+	}
+}
+
+// drainRevocationUpdates applies the queued revocation updates until the
+// queue is empty.
+func (s *syncer) drainRevocationUpdates() {
+	for {
+		s.revocationMu.Lock()
+		if len(s.revocationUpdates) == 0 {
+			s.revocationDraining = false
+			s.revocationMu.Unlock()
+			return
+		}
+		f := s.revocationUpdates[0]
+		s.revocationUpdates = s.revocationUpdates[1:]
+		s.revocationMu.Unlock()
+
+		f()
+	}
+}
+
+func (s *syncer) onLocalRevoke(r auth.Revocation) {
+	// The revocation may have expired before it was published.
+	if r.Expired(time.Now()) {
+		return
+	}
+	s.gossiper.UpsertLocal(
+		revocationKey(r), strconv.FormatInt(r.Expiry.Unix(), 10),
+	)
+}
+
+func (s *syncer) onRemoteRevoke(nodeID, key, value string) {
+	if s.revocations == nil {
+		return
+	}
+
+	r, err := parseRevocation(key, value)
+	if err != nil {
+		s.logger.Error(
+			"node upsert state; invalid revocation",
+			zap.String("node-id", nodeID),
+			zap.String("key", key),
+			zap.String("value", value),
+			zap.Error(err),
+		)
+		return
+	}
+
+	if s.revocations.Revoke(r) {
+		s.logger.Info(
+			"token revoked",
+			zap.String("node-id", nodeID),
+			zap.String("tenant-id", r.TenantID),
+			zap.String("type", r.Type),
+			zap.String("value", r.Value),
+			zap.Time("expiry", r.Expiry),
+		)
+	}
+}
+
+// revocationKey returns the gossip key for a revocation, formatted as
+// 'revoked:<type>:<tenant>:<value>'. The tenant ID is escaped since it may
+// contain ':'.
+func revocationKey(r auth.Revocation) string {
+	return "revoked:" + r.Type + ":" + url.QueryEscape(r.TenantID) + ":" + r.Value
+}
+
+// parseRevocation parses a revocation from its gossip key and value, where
+// the value is the expiry as a Unix timestamp in seconds.
+func parseRevocation(key, value string) (auth.Revocation, error) {
+	key, _ = strings.CutPrefix(key, "revoked:")
+	revocationType, key, _ := strings.Cut(key, ":")
+	escapedTenantID, revocationValue, _ := strings.Cut(key, ":")
+	tenantID, err := url.QueryUnescape(escapedTenantID)
+	if err != nil {
+		return auth.Revocation{}, fmt.Errorf("invalid tenant: %w", err)
+	}
+	expiry, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return auth.Revocation{}, fmt.Errorf("invalid expiry: %w", err)
+	}
+	r := auth.Revocation{
+		TenantID: tenantID,
+		Type:     revocationType,
+		Value:    revocationValue,
+		Expiry:   time.Unix(expiry, 0),
+	}
+	if err := r.Validate(); err != nil {
+		return auth.Revocation{}, err
+	}
+	return r, nil
+}
+
 var _ gossip.Watcher = &syncer{}
@@ -1,10 +1,14 @@
 package gossip
 
 import (
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/andydunstall/piko/pkg/auth"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/server/cluster"
 )
@@ -17,9 +21,14 @@ type upsert struct {
 type fakeGossiper struct {
 	upserts []upsert
 	deletes []string
+
+	mu sync.Mutex
 }
 
 func (g *fakeGossiper) UpsertLocal(key, value string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	g.upserts = append(g.upserts, upsert{
 		Key:   key,
 		Value: value,
@@ -27,9 +36,26 @@ func (g *fakeGossiper) UpsertLocal(key, value string) {
 }
 
 func (g *fakeGossiper) DeleteLocal(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	g.deletes = append(g.deletes, key)
 }
 
+func (g *fakeGossiper) Upserts() []upsert {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]upsert(nil), g.upserts...)
+}
+
+func (g *fakeGossiper) Deletes() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]string(nil), g.deletes...)
+}
+
 var _ gossiper = &fakeGossiper{}
 
 func TestSyncer_Sync(t *testing.T) {
@@ -43,7 +69,7 @@ func TestSyncer_Sync(t *testing.T) {
 	m.AddLocalEndpoint("my-endpoint")
 	m.AddLocalEndpoint("my-endpoint")
 
-	sync := newSyncer(m, log.NewNopLogger())
+	sync := newSyncer(m, nil, log.NewNopLogger())
 
 	gossiper := &fakeGossiper{}
 	sync.Sync(gossiper)
@@ -67,7 +93,7 @@ func TestSyncer_OnLocalEndpointUpdate(t *testing.T) {
 	}
 	m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-	sync := newSyncer(m, log.NewNopLogger())
+	sync := newSyncer(m, nil, log.NewNopLogger())
 
 	gossiper := &fakeGossiper{}
 	sync.Sync(gossiper)
@@ -101,6 +127,61 @@ func TestSyncer_OnLocalEndpointUpdate(t *testing.T) {
 	)
 }
 
+func TestSyncer_OnLocalConnectionsUpdate(t *testing.T) {
+	localNode := &cluster.Node{
+		ID:        "local",
+		ProxyAddr: "10.26.104.56:8000",
+		AdminAddr: "10.26.104.56:8001",
+	}
+	m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+
+	sync := newSyncer(m, nil, log.NewNopLogger())
+	sync.connectionsInterval = time.Millisecond * 10
+
+	gossiper := &fakeGossiper{}
+	sync.Sync(gossiper)
+
+	connectionUpserts := func() []upsert {
+		var upserts []upsert
+		for _, u := range gossiper.Upserts() {
+			if u.Key == "connections:my-tenant" {
+				upserts = append(upserts, u)
+			}
+		}
+		return upserts
+	}
+
+	// Updates within the interval are batched.
+	m.AddLocalConnection("my-tenant")
+	m.AddLocalConnection("my-tenant")
+	m.AddLocalConnection("my-tenant")
+	m.RemoveLocalConnection("my-tenant")
+	assert.Eventually(t, func() bool {
+		return len(connectionUpserts()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(
+		t,
+		[]upsert{{"connections:my-tenant", "2"}},
+		connectionUpserts(),
+	)
+
+	m.RemoveLocalConnection("my-tenant")
+	assert.Eventually(t, func() bool {
+		return len(connectionUpserts()) == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(
+		t,
+		upsert{"connections:my-tenant", "1"},
+		connectionUpserts()[1],
+	)
+
+	m.RemoveLocalConnection("my-tenant")
+	assert.Eventually(t, func() bool {
+		return len(gossiper.Deletes()) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, []string{"connections:my-tenant"}, gossiper.Deletes())
+}
+
 func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 	t.Run("add node", func(t *testing.T) {
 		localNode := &cluster.Node{
@@ -110,7 +191,7 @@ func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, nil, log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -119,6 +200,9 @@ func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 		sync.OnUpsertKey("remote", "proxy_addr", "10.26.104.98:8000")
 		sync.OnUpsertKey("remote", "admin_addr", "10.26.104.98:8001")
 		sync.OnUpsertKey("remote", "endpoint:my-endpoint", "5")
+		// Endpoints namespaced by tenant.
+		sync.OnUpsertKey("remote", "endpoint:my-tenant/my-endpoint", "2")
+		sync.OnUpsertKey("remote", "connections:my-tenant", "3")
 
 		node, ok := m.Node("remote")
 		assert.True(t, ok)
@@ -128,7 +212,11 @@ func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 			ProxyAddr: "10.26.104.98:8000",
 			AdminAddr: "10.26.104.98:8001",
 			Endpoints: map[string]int{
-				"my-endpoint": 5,
+				"my-endpoint":           5,
+				"my-tenant/my-endpoint": 2,
+			},
+			Connections: map[string]int{
+				"my-tenant": 3,
 			},
 		})
 	})
@@ -141,7 +229,7 @@ func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, nil, log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -163,7 +251,7 @@ func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, nil, log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -185,7 +273,7 @@ func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, nil, log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -200,6 +288,8 @@ func TestSyncer_RemoteNodeUpdate(t *testing.T) {
 
 		sync.OnUpsertKey("remote", "endpoint:my-endpoint-2", "8")
 		sync.OnDeleteKey("remote", "endpoint:my-endpoint")
+		sync.OnUpsertKey("remote", "connections:my-tenant", "3")
+		sync.OnDeleteKey("remote", "connections:my-tenant")
 
 		node, ok := m.Node("remote")
 		assert.True(t, ok)
@@ -224,7 +314,7 @@ func TestSyncer_RemoteNodeLeave(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, nil, log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -265,7 +355,7 @@ func TestSyncer_RemoteNodeLeave(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, nil, log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -292,7 +382,7 @@ func TestSyncer_RemoteNodeLeave(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, nil, log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -313,7 +403,7 @@ func TestSyncer_RemoteNodeUnreachable(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, nil, log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -354,7 +444,7 @@ func TestSyncer_RemoteNodeUnreachable(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, nil, log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -392,7 +482,7 @@ func TestSyncer_RemoteNodeUnreachable(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, nil, log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -425,7 +515,7 @@ func TestSyncer_RemoteNodeUnreachable(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, nil, log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -453,7 +543,7 @@ func TestSyncer_RemoteNodeUnreachable(t *testing.T) {
 		}
 		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
 
-		sync := newSyncer(m, log.NewNopLogger())
+		sync := newSyncer(m, nil, log.NewNopLogger())
 
 		gossiper := &fakeGossiper{}
 		sync.Sync(gossiper)
@@ -464,3 +554,108 @@ func TestSyncer_RemoteNodeUnreachable(t *testing.T) {
 		assert.Equal(t, localNode, m.LocalNode())
 	})
 }
+
+func TestSyncer_Revocations(t *testing.T) {
+	localNode := &cluster.Node{
+		ID:        "local",
+		ProxyAddr: "10.26.104.56:8000",
+		AdminAddr: "10.26.104.56:8001",
+	}
+
+	t.Run("local revoke", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		revocations := auth.NewRevocationList()
+
+		expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+		existing := auth.Revocation{
+			Type:   auth.RevocationTypeID,
+			Value:  "existing-id",
+			Expiry: expiry,
+		}
+		revocations.Revoke(existing)
+
+		sync := newSyncer(m, revocations, log.NewNopLogger())
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		// Existing revocations are published on sync.
+		value := strconv.FormatInt(expiry.Unix(), 10)
+		assert.Contains(
+			t, gossiper.Upserts(), upsert{"revoked:jti::existing-id", value},
+		)
+
+		revocations.Revoke(auth.Revocation{
+			Type:   auth.RevocationTypeSubject,
+			Value:  "my-subject",
+			Expiry: expiry,
+		})
+		assert.Eventually(t, func() bool {
+			for _, u := range gossiper.Upserts() {
+				if u == (upsert{"revoked:sub::my-subject", value}) {
+					return true
+				}
+			}
+			return false
+		}, time.Second, time.Millisecond*10)
+
+		revocations.Prune(expiry)
+		assert.Eventually(t, func() bool {
+			return len(gossiper.Deletes()) == 2
+		}, time.Second, time.Millisecond*10)
+		assert.ElementsMatch(
+			t,
+			[]string{"revoked:jti::existing-id", "revoked:sub::my-subject"},
+			gossiper.Deletes(),
+		)
+	})
+
+	t.Run("remote revoke", func(t *testing.T) {
+		m := cluster.NewState(localNode.Copy(), log.NewNopLogger())
+		revocations := auth.NewRevocationList()
+
+		sync := newSyncer(m, revocations, log.NewNopLogger())
+
+		gossiper := &fakeGossiper{}
+		sync.Sync(gossiper)
+
+		expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+		value := strconv.FormatInt(expiry.Unix(), 10)
+
+		// Revocations are applied even from unknown nodes.
+		sync.OnUpsertKey("remote", "revoked:sub:my%3Atenant:my:subject", value)
+		assert.True(t, revocations.Revoked(&auth.Token{
+			TenantID: "my:tenant",
+			Subject:  "my:subject",
+		}))
+		// Revocations only apply to the revoked tenant.
+		assert.False(t, revocations.Revoked(&auth.Token{Subject: "my:subject"}))
+
+		// Revocations are republished by the local node.
+		assert.Eventually(t, func() bool {
+			for _, u := range gossiper.Upserts() {
+				if u == (upsert{"revoked:sub:my%3Atenant:my:subject", value}) {
+					return true
+				}
+			}
+			return false
+		}, time.Second, time.Millisecond*10)
+
+		// Deleting the remote key doesn't remove the revocation.
+		sync.OnDeleteKey("remote", "revoked:sub:my%3Atenant:my:subject")
+		assert.True(t, revocations.Revoked(&auth.Token{
+			TenantID: "my:tenant",
+			Subject:  "my:subject",
+		}))
+
+		// Invalid and expired revocations are discarded.
+		sync.OnUpsertKey("remote", "revoked:foo::bar", value)
+		sync.OnUpsertKey("remote", "revoked:jti::my-id", "invalid")
+		sync.OnUpsertKey(
+			"remote",
+			"revoked:jti::expired",
+			strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10),
+		)
+		assert.Len(t, revocations.List(), 1)
+	})
+}
@@ -0,0 +1,298 @@
+package metering
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/bandwidth"
+	"github.com/andydunstall/piko/server/config"
+)
+
+type usageKey struct {
+	tenantID   string
+	endpointID string
+}
+
+type usage struct {
+	requests        int64
+	bytesIn         int64
+	bytesOut        int64
+	upstreamSeconds float64
+	tcpSeconds      float64
+}
+
+type connectionKind int
+
+const (
+	connectionUpstream connectionKind = iota
+	connectionTCP
+)
+
+// connection is an open connection whose duration is being recorded.
+type connection struct {
+	key  usageKey
+	kind connectionKind
+	// since is the time the connection was last recorded.
+	since time.Time
+}
+
+// Meter records the usage of each tenant and endpoint, and periodically
+// flushes usage records to the configured sinks.
+//
+// Usage is only recorded by the node the upstream is connected to, so
+// requests forwarded between nodes aren't counted twice.
+type Meter struct {
+	nodeID string
+
+	usages map[usageKey]*usage
+	conns  map[*connection]struct{}
+	// periodStart is the start of the current period.
+	periodStart time.Time
+
+	mu sync.Mutex
+
+	sinks []*sinkQueue
+
+	// flushMu ensures only a single flush runs at a time.
+	flushMu sync.Mutex
+
+	config config.MeteringConfig
+
+	metrics *Metrics
+
+	logger log.Logger
+}
+
+// sinkQueue contains the records that are pending for a sink. Each sink has
+// its own queue so a failing sink doesn't cause records to be written to
+// other sinks multiple times.
+type sinkQueue struct {
+	sink    Sink
+	pending []Record
+}
+
+func NewMeter(
+	nodeID string,
+	conf config.MeteringConfig,
+	sinks []Sink,
+	logger log.Logger,
+) *Meter {
+	m := &Meter{
+		nodeID:      nodeID,
+		usages:      make(map[usageKey]*usage),
+		conns:       make(map[*connection]struct{}),
+		periodStart: time.Now(),
+		config:      conf,
+		metrics:     NewMetrics(),
+		logger:      logger.WithSubsystem("metering"),
+	}
+	for _, sink := range sinks {
+		m.sinks = append(m.sinks, &sinkQueue{sink: sink})
+	}
+	return m
+}
+
+// Request records a HTTP request to the endpoint.
+func (m *Meter) Request(tenantID string, endpointID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.usage(usageKey{tenantID: tenantID, endpointID: endpointID}).requests++
+}
+
+// RecordBytes records bytes sent to or from the endpoint.
+func (m *Meter) RecordBytes(
+	endpointID string,
+	tenantID string,
+	direction string,
+	n int,
+) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u := m.usage(usageKey{tenantID: tenantID, endpointID: endpointID})
+	if direction == bandwidth.DirectionIn {
+		u.bytesIn += int64(n)
+	} else {
+		u.bytesOut += int64(n)
+	}
+}
+
+// UpstreamConnected records an upstream connected for the endpoint. The
+// returned function must be called once the upstream disconnects.
+func (m *Meter) UpstreamConnected(tenantID string, endpointID string) func() {
+	return m.connected(usageKey{tenantID: tenantID, endpointID: endpointID}, connectionUpstream)
+}
+
+// TCPConnected records a client TCP connection to the endpoint. The returned
+// function must be called once the connection closes.
+func (m *Meter) TCPConnected(tenantID string, endpointID string) func() {
+	return m.connected(usageKey{tenantID: tenantID, endpointID: endpointID}, connectionTCP)
+}
+
+// Run flushes records to the sinks every interval until the context is
+// cancelled, then flushes the remaining records.
+func (m *Meter) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.Flush(ctx)
+		case <-ctx.Done():
+			// Flush the final records with a new context as ctx is
+			// cancelled.
+			flushCtx, cancel := context.WithTimeout(
+				context.Background(), m.config.Webhook.Timeout,
+			)
+			m.Flush(flushCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+// Flush writes the records for the current period to the sinks, and starts
+// a new period.
+func (m *Meter) Flush(ctx context.Context) {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	records := m.collect(time.Now())
+
+	for _, queue := range m.sinks {
+		queue.pending = append(queue.pending, records...)
+		if m.config.MaxPendingRecords > 0 && len(queue.pending) > m.config.MaxPendingRecords {
+			dropped := len(queue.pending) - m.config.MaxPendingRecords
+			queue.pending = queue.pending[dropped:]
+
+			m.metrics.RecordsDroppedTotal.WithLabelValues(queue.sink.Name()).Add(float64(dropped))
+			m.logger.Warn(
+				"dropped usage records",
+				zap.String("sink", queue.sink.Name()),
+				zap.Int("dropped", dropped),
+			)
+		}
+		if len(queue.pending) == 0 {
+			continue
+		}
+
+		if err := queue.sink.Write(ctx, queue.pending); err != nil {
+			m.metrics.WriteErrorsTotal.WithLabelValues(queue.sink.Name()).Inc()
+			m.logger.Warn(
+				"failed to write usage records",
+				zap.String("sink", queue.sink.Name()),
+				zap.Int("pending", len(queue.pending)),
+				zap.Error(err),
+			)
+			continue
+		}
+
+		m.metrics.RecordsTotal.WithLabelValues(queue.sink.Name()).Add(float64(len(queue.pending)))
+		queue.pending = nil
+	}
+}
+
+func (m *Meter) Metrics() *Metrics {
+	return m.metrics
+}
+
+// collect returns the records for the current period and starts a new
+// period.
+func (m *Meter) collect(now time.Time) []Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Record the duration of open connections up to the end of the period.
+	for conn := range m.conns {
+		m.recordConnection(conn, now)
+	}
+
+	records := make([]Record, 0, len(m.usages))
+	for key, u := range m.usages {
+		records = append(records, Record{
+			NodeID:                    m.nodeID,
+			TenantID:                  key.tenantID,
+			EndpointID:                key.endpointID,
+			Start:                     m.periodStart,
+			End:                       now,
+			Requests:                  u.requests,
+			BytesIn:                   u.bytesIn,
+			BytesOut:                  u.bytesOut,
+			UpstreamConnectionSeconds: u.upstreamSeconds,
+			TCPConnectionSeconds:      u.tcpSeconds,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].TenantID == records[j].TenantID {
+			return records[i].EndpointID < records[j].EndpointID
+		}
+		return records[i].TenantID < records[j].TenantID
+	})
+
+	m.usages = make(map[usageKey]*usage)
+	m.periodStart = now
+
+	return records
+}
+
+func (m *Meter) connected(key usageKey, kind connectionKind) func() {
+	conn := &connection{
+		key:   key,
+		kind:  kind,
+		since: time.Now(),
+	}
+
+	m.mu.Lock()
+	m.conns[conn] = struct{}{}
+	m.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			m.recordConnection(conn, time.Now())
+			delete(m.conns, conn)
+		})
+	}
+}
+
+// recordConnection records the duration of the connection since it was last
+// recorded.
+//
+// The mutex must be held.
+func (m *Meter) recordConnection(conn *connection, now time.Time) {
+	seconds := now.Sub(conn.since).Seconds()
+	conn.since = now
+
+	u := m.usage(conn.key)
+	switch conn.kind {
+	case connectionUpstream:
+		u.upstreamSeconds += seconds
+	case connectionTCP:
+		u.tcpSeconds += seconds
+	}
+}
+
+// usage returns the usage for the given key, creating it if it doesn't
+// exist.
+//
+// The mutex must be held.
+func (m *Meter) usage(key usageKey) *usage {
+	u, ok := m.usages[key]
+	if !ok {
+		u = &usage{}
+		m.usages[key] = u
+	}
+	return u
+}
+
+var _ bandwidth.Recorder = &Meter{}
@@ -0,0 +1,209 @@
+package metering
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/bandwidth"
+	"github.com/andydunstall/piko/server/config"
+)
+
+type fakeSink struct {
+	records [][]Record
+	err     error
+}
+
+func (s *fakeSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeSink) Write(_ context.Context, records []Record) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.records = append(s.records, append([]Record(nil), records...))
+	return nil
+}
+
+func TestMeter_Flush(t *testing.T) {
+	sink := &fakeSink{}
+	meter := NewMeter("node-1", config.MeteringConfig{
+		Interval: time.Minute,
+	}, []Sink{sink}, log.NewNopLogger())
+
+	meter.Request("tenant-1", "endpoint-1")
+	meter.Request("tenant-1", "endpoint-1")
+	meter.RecordBytes("endpoint-1", "tenant-1", bandwidth.DirectionIn, 100)
+	meter.RecordBytes("endpoint-1", "tenant-1", bandwidth.DirectionOut, 200)
+	meter.Request("", "endpoint-2")
+
+	meter.Flush(context.Background())
+
+	require.Len(t, sink.records, 1)
+	records := sink.records[0]
+	require.Len(t, records, 2)
+
+	assert.Equal(t, "node-1", records[0].NodeID)
+	assert.Equal(t, "", records[0].TenantID)
+	assert.Equal(t, "endpoint-2", records[0].EndpointID)
+	assert.Equal(t, int64(1), records[0].Requests)
+
+	assert.Equal(t, "tenant-1", records[1].TenantID)
+	assert.Equal(t, "endpoint-1", records[1].EndpointID)
+	assert.Equal(t, int64(2), records[1].Requests)
+	assert.Equal(t, int64(100), records[1].BytesIn)
+	assert.Equal(t, int64(200), records[1].BytesOut)
+	assert.False(t, records[1].End.Before(records[1].Start))
+
+	// Flushing with no usage doesn't write any records.
+	meter.Flush(context.Background())
+	assert.Len(t, sink.records, 1)
+
+	// Periods don't overlap.
+	meter.Request("tenant-1", "endpoint-1")
+	meter.Flush(context.Background())
+	require.Len(t, sink.records, 2)
+	assert.False(t, sink.records[1][0].Start.Before(records[1].End))
+}
+
+func TestMeter_Connections(t *testing.T) {
+	sink := &fakeSink{}
+	meter := NewMeter("node-1", config.MeteringConfig{
+		Interval: time.Minute,
+	}, []Sink{sink}, log.NewNopLogger())
+
+	upstreamDone := meter.UpstreamConnected("tenant-1", "endpoint-1")
+	tcpDone := meter.TCPConnected("tenant-1", "endpoint-1")
+
+	time.Sleep(time.Millisecond * 20)
+	tcpDone()
+	// Calling done multiple times has no effect.
+	tcpDone()
+
+	// The open upstream connection is recorded up to the flush.
+	meter.Flush(context.Background())
+	require.Len(t, sink.records, 1)
+	record := sink.records[0][0]
+	assert.GreaterOrEqual(t, record.UpstreamConnectionSeconds, 0.02)
+	assert.GreaterOrEqual(t, record.TCPConnectionSeconds, 0.02)
+
+	time.Sleep(time.Millisecond * 20)
+	upstreamDone()
+
+	meter.Flush(context.Background())
+	require.Len(t, sink.records, 2)
+	record = sink.records[1][0]
+	assert.GreaterOrEqual(t, record.UpstreamConnectionSeconds, 0.02)
+	assert.Equal(t, 0.0, record.TCPConnectionSeconds)
+
+	// Once closed, connections are no longer recorded.
+	meter.Flush(context.Background())
+	assert.Len(t, sink.records, 2)
+}
+
+func TestMeter_SinkFailure(t *testing.T) {
+	failing := &fakeSink{err: errors.New("unavailable")}
+	healthy := &fakeSink{}
+	meter := NewMeter("node-1", config.MeteringConfig{
+		Interval:          time.Minute,
+		MaxPendingRecords: 2,
+	}, []Sink{failing, healthy}, log.NewNopLogger())
+
+	for _, endpointID := range []string{"endpoint-1", "endpoint-2", "endpoint-3"} {
+		meter.Request("", endpointID)
+		meter.Flush(context.Background())
+	}
+
+	// The healthy sink isn't affected by the failing sink.
+	assert.Len(t, healthy.records, 3)
+
+	// Once the failing sink recovers, the pending records are retried,
+	// excluding the oldest which were dropped.
+	failing.err = nil
+	meter.Flush(context.Background())
+	require.Len(t, failing.records, 1)
+	require.Len(t, failing.records[0], 2)
+	assert.Equal(t, "endpoint-2", failing.records[0][0].EndpointID)
+	assert.Equal(t, "endpoint-3", failing.records[0][1].EndpointID)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(
+		meter.Metrics().RecordsDroppedTotal.WithLabelValues("fake"),
+	))
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl")
+	sink := NewFileSink(path)
+
+	require.NoError(t, sink.Write(context.Background(), []Record{
+		{EndpointID: "endpoint-1", Requests: 1},
+		{EndpointID: "endpoint-2", Requests: 2},
+	}))
+	// Writes append to the existing file.
+	require.NoError(t, sink.Write(context.Background(), []Record{
+		{EndpointID: "endpoint-3", Requests: 3},
+	}))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var endpointIDs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		endpointIDs = append(endpointIDs, record.EndpointID)
+	}
+	assert.Equal(t, []string{"endpoint-1", "endpoint-2", "endpoint-3"}, endpointIDs)
+}
+
+func TestWebhookSink(t *testing.T) {
+	t.Run("ok", func(t *testing.T) {
+		var received []Record
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+				assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+			},
+		))
+		defer server.Close()
+
+		sink := NewWebhookSink(server.URL, time.Second)
+		require.NoError(t, sink.Write(context.Background(), []Record{
+			{TenantID: "tenant-1", EndpointID: "endpoint-1", BytesIn: 10},
+		}))
+
+		assert.Equal(t, []Record{
+			{TenantID: "tenant-1", EndpointID: "endpoint-1", BytesIn: 10},
+		}, received)
+	})
+
+	t.Run("bad status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+		))
+		defer server.Close()
+
+		sink := NewWebhookSink(server.URL, time.Second)
+		assert.Error(t, sink.Write(context.Background(), []Record{
+			{EndpointID: "endpoint-1"},
+		}))
+	})
+}
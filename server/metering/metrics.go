@@ -0,0 +1,58 @@
+package metering
+
+import "github.com/prometheus/client_golang/prometheus"
+
+type Metrics struct {
+	// RecordsTotal is the number of records written to each sink.
+	// Labelled by sink name.
+	RecordsTotal *prometheus.CounterVec
+
+	// WriteErrorsTotal is the number of failed writes to each sink.
+	// Labelled by sink name.
+	WriteErrorsTotal *prometheus.CounterVec
+
+	// RecordsDroppedTotal is the number of records dropped as a sink
+	// exceeded the maximum number of pending records. Labelled by sink
+	// name.
+	RecordsDroppedTotal *prometheus.CounterVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		RecordsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "metering",
+				Name:      "records_total",
+				Help:      "Number of usage records written to each sink",
+			},
+			[]string{"sink"},
+		),
+		WriteErrorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "metering",
+				Name:      "write_errors_total",
+				Help:      "Number of failed writes to each sink",
+			},
+			[]string{"sink"},
+		),
+		RecordsDroppedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: "piko",
+				Subsystem: "metering",
+				Name:      "records_dropped_total",
+				Help:      "Number of usage records dropped due to a failing sink",
+			},
+			[]string{"sink"},
+		),
+	}
+}
+
+func (m *Metrics) Register(registry *prometheus.Registry) {
+	registry.MustRegister(
+		m.RecordsTotal,
+		m.WriteErrorsTotal,
+		m.RecordsDroppedTotal,
+	)
+}
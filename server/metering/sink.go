@@ -0,0 +1,148 @@
+package metering
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Record contains the usage of an endpoint owned by a tenant over a period.
+type Record struct {
+	// NodeID is the ID of the node that recorded the usage.
+	NodeID string `json:"node_id"`
+
+	// TenantID is the ID of the tenant the upstream authenticated with, or
+	// empty if the upstream has no tenant.
+	TenantID string `json:"tenant_id"`
+
+	EndpointID string `json:"endpoint_id"`
+
+	// Start is the start of the period the record covers (inclusive).
+	Start time.Time `json:"start"`
+
+	// End is the end of the period the record covers (exclusive).
+	End time.Time `json:"end"`
+
+	// Requests is the number of HTTP requests proxied to the endpoint.
+	Requests int64 `json:"requests"`
+
+	// BytesIn is the number of bytes sent from clients to the endpoint.
+	BytesIn int64 `json:"bytes_in"`
+
+	// BytesOut is the number of bytes sent from the endpoint to clients.
+	BytesOut int64 `json:"bytes_out"`
+
+	// UpstreamConnectionSeconds is the total time upstreams were connected
+	// for the endpoint.
+	UpstreamConnectionSeconds float64 `json:"upstream_connection_seconds"`
+
+	// TCPConnectionSeconds is the total time client TCP connections to the
+	// endpoint were open.
+	TCPConnectionSeconds float64 `json:"tcp_connection_seconds"`
+}
+
+// Sink receives usage records.
+type Sink interface {
+	// Name returns the name of the sink used for logging and metrics.
+	Name() string
+
+	// Write writes the records to the sink. If an error is returned, the
+	// records will be retried.
+	Write(ctx context.Context, records []Record) error
+}
+
+// FileSink appends records to a file as JSON lines.
+type FileSink struct {
+	path string
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{
+		path: path,
+	}
+}
+
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+func (s *FileSink) Write(_ context.Context, records []Record) error {
+	// Open the file on each write to support rotating the file.
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("encode: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync: %w", err)
+	}
+	return nil
+}
+
+// WebhookSink sends records to a HTTP webhook as a JSON array.
+type WebhookSink struct {
+	url string
+
+	httpClient *http.Client
+}
+
+func NewWebhookSink(url string, timeout time.Duration) *WebhookSink {
+	return &WebhookSink{
+		url: url,
+		httpClient: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (s *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSink) Write(ctx context.Context, records []Record) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, s.url, bytes.NewReader(body),
+	)
+	if err != nil {
+		return fmt.Errorf("request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request: %w", err)
+	}
+	defer resp.Body.Close()
+	// Drain the body to reuse the connection.
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("request: bad status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+var _ Sink = &FileSink{}
+var _ Sink = &WebhookSink{}
@@ -0,0 +1,279 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andydunstall/piko/server/cache"
+	"github.com/andydunstall/piko/server/cluster"
+)
+
+const (
+	cacheResultHit         = "hit"
+	cacheResultMiss        = "miss"
+	cacheResultRevalidated = "revalidated"
+)
+
+// cacheRequest contains the cache state for a request forwarded to an
+// upstream.
+type cacheRequest struct {
+	// endpointKey is the endpoint ID namespaced by tenant.
+	endpointKey string
+
+	// request is the request as received from the client, before any
+	// conditional headers were replaced for revalidation.
+	request *http.Request
+
+	// entry is the stale stored response being revalidated, or nil if the
+	// request is not a revalidation.
+	entry *cache.Entry
+
+	requestTime time.Time
+}
+
+// serveFromCache attempts to serve the request from the cache.
+//
+// Returns true if the request was served. Otherwise returns the request to
+// forward to the upstream, which may have been modified to revalidate a stale
+// stored response.
+func (p *HTTPProxy) serveFromCache(
+	w http.ResponseWriter,
+	r *http.Request,
+	tenantID string,
+	endpointID string,
+) (*http.Request, bool) {
+	endpointKey := cluster.EndpointKey(tenantID, endpointID)
+	now := time.Now()
+	cacheReq := &cacheRequest{
+		endpointKey: endpointKey,
+		request:     r,
+		requestTime: now,
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		entry, ok := p.cache.Lookup(endpointKey, r)
+		if ok && entry.Fresh(r, now) {
+			p.cache.Observe(cacheResultHit)
+			w, done := p.meterCacheHit(w, r, tenantID, endpointID)
+			writeCachedResponse(w, r, entry, now)
+			done()
+			return r, true
+		}
+
+		if !ok && onlyIfCached(r) {
+			p.cache.Observe(cacheResultMiss)
+			_ = errorResponse(w, http.StatusGatewayTimeout, "not cached")
+			return r, true
+		}
+
+		etag, lastModified := "", ""
+		if ok {
+			etag, lastModified = entry.Validators()
+		}
+		if etag != "" || lastModified != "" {
+			// Replace any client conditional headers with the stored
+			// validators. Whether the client gets a 304 response is decided
+			// once the stored response is updated.
+			r = r.Clone(r.Context())
+			r.Header.Del("If-None-Match")
+			r.Header.Del("If-Modified-Since")
+			if etag != "" {
+				r.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				r.Header.Set("If-Modified-Since", lastModified)
+			}
+			cacheReq.entry = entry
+		} else {
+			p.cache.Observe(cacheResultMiss)
+		}
+	}
+
+	return r.WithContext(
+		context.WithValue(r.Context(), cacheContextKey, cacheReq),
+	), false
+}
+
+// meterCacheHit records a request served from the cache and returns a
+// response writer that accounts and limits the response bandwidth, the same
+// as responses from the upstream.
+//
+// The returned function must be called once the response is written.
+func (p *HTTPProxy) meterCacheHit(
+	w http.ResponseWriter,
+	r *http.Request,
+	tenantID string,
+	endpointID string,
+) (http.ResponseWriter, func()) {
+	if p.metering != nil {
+		p.metering.Request(tenantID, endpointID)
+	}
+	if p.bandwidth != nil {
+		stream := p.bandwidth.Stream(endpointID, tenantID)
+		return stream.ResponseWriter(r.Context(), w), stream.Close
+	}
+	return w, func() {}
+}
+
+// modifyResponse updates the cache with the upstream response.
+func (p *HTTPProxy) modifyResponse(resp *http.Response) error {
+	cacheReq, ok := resp.Request.Context().Value(cacheContextKey).(*cacheRequest)
+	if !ok {
+		return nil
+	}
+	r := cacheReq.request
+	responseTime := time.Now()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		p.cache.Invalidate(cacheReq.endpointKey, r, resp.StatusCode)
+		return nil
+	}
+
+	if cacheReq.entry != nil {
+		if resp.StatusCode == http.StatusNotModified {
+			p.cache.Observe(cacheResultRevalidated)
+
+			entry := p.cache.Revalidated(
+				cacheReq.endpointKey,
+				r,
+				cacheReq.entry,
+				resp,
+				cacheReq.requestTime,
+				responseTime,
+			)
+			_ = resp.Body.Close()
+			setCachedResponse(resp, r, entry, responseTime)
+			return nil
+		}
+		p.cache.Observe(cacheResultMiss)
+	}
+
+	if maxSize, ok := p.cache.Storable(cacheReq.endpointKey, r, resp); ok {
+		statusCode := resp.StatusCode
+		header := resp.Header.Clone()
+		resp.Body = &captureBody{
+			ReadCloser: resp.Body,
+			maxSize:    maxSize,
+			onComplete: func(body []byte) {
+				p.cache.Store(
+					cacheReq.endpointKey,
+					r,
+					statusCode,
+					header,
+					body,
+					cacheReq.requestTime,
+					responseTime,
+				)
+			},
+		}
+	}
+	resp.Header.Set("x-piko-cache", cacheResultMiss)
+
+	return nil
+}
+
+// writeCachedResponse writes the stored response to the client.
+func writeCachedResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	entry *cache.Entry,
+	now time.Time,
+) {
+	h := w.Header()
+	for name, values := range entry.Header {
+		h[name] = append([]string(nil), values...)
+	}
+	h.Set("Age", formatAge(entry.Age(now)))
+	h.Set("x-piko-cache", cacheResultHit)
+
+	if entry.NotModified(r) {
+		h.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	h.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	w.WriteHeader(entry.StatusCode)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(entry.Body)
+	}
+}
+
+// setCachedResponse replaces the upstream response with the stored response.
+func setCachedResponse(
+	resp *http.Response,
+	r *http.Request,
+	entry *cache.Entry,
+	now time.Time,
+) {
+	resp.Header = entry.Header.Clone()
+	resp.Header.Set("Age", formatAge(entry.Age(now)))
+	resp.Header.Set("x-piko-cache", cacheResultRevalidated)
+	resp.TransferEncoding = nil
+
+	if entry.NotModified(r) {
+		resp.StatusCode = http.StatusNotModified
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = 0
+		resp.Body = http.NoBody
+		return
+	}
+
+	resp.StatusCode = entry.StatusCode
+	resp.Header.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+	resp.ContentLength = int64(len(entry.Body))
+	resp.Body = io.NopCloser(bytes.NewReader(entry.Body))
+}
+
+func formatAge(age time.Duration) string {
+	return strconv.FormatInt(int64(age/time.Second), 10)
+}
+
+// onlyIfCached returns whether the client only wants a stored response.
+func onlyIfCached(r *http.Request) bool {
+	for _, v := range r.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "only-if-cached") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// captureBody captures the response body as it is proxied to the client, so
+// the response can be stored once the full body has been read.
+//
+// If the body exceeds the maximum size, it is proxied but not stored.
+type captureBody struct {
+	io.ReadCloser
+
+	buf      bytes.Buffer
+	maxSize  int64
+	exceeded bool
+	complete bool
+
+	onComplete func(body []byte)
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.exceeded {
+		if int64(b.buf.Len()+n) > b.maxSize {
+			b.exceeded = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.exceeded && !b.complete {
+		b.complete = true
+		b.onComplete(b.buf.Bytes())
+	}
+	return n, err
+}
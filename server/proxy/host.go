@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+const (
+	hostEndpointPlaceholder = "{endpoint}"
+	hostTenantPlaceholder   = "{tenant}"
+)
+
+// hostPattern extracts the endpoint ID and tenant ID from the request host
+// using a pattern such as '{endpoint}.{tenant}.piko.example.com'.
+type hostPattern struct {
+	re *regexp.Regexp
+}
+
+// newHostPattern compiles the host pattern. The pattern must already be
+// validated.
+func newHostPattern(pattern string) *hostPattern {
+	var expr strings.Builder
+	expr.WriteString("(?i)^")
+	for pattern != "" {
+		endpointIndex := strings.Index(pattern, hostEndpointPlaceholder)
+		tenantIndex := strings.Index(pattern, hostTenantPlaceholder)
+
+		// Find the next placeholder.
+		index, placeholder, group := endpointIndex, hostEndpointPlaceholder, "endpoint"
+		if index < 0 || (tenantIndex >= 0 && tenantIndex < index) {
+			index, placeholder, group = tenantIndex, hostTenantPlaceholder, "tenant"
+		}
+		if index < 0 {
+			expr.WriteString(regexp.QuoteMeta(pattern))
+			break
+		}
+
+		expr.WriteString(regexp.QuoteMeta(pattern[:index]))
+		expr.WriteString("(?P<" + group + ">[^.]+)")
+		pattern = pattern[index+len(placeholder):]
+	}
+	expr.WriteString("$")
+
+	return &hostPattern{
+		re: regexp.MustCompile(expr.String()),
+	}
+}
+
+// EndpointFromHost returns a function that extracts the endpoint ID and
+// tenant ID from a host, the same as the proxy does for requests without an
+// 'x-piko-endpoint' header.
+//
+// If the host pattern is empty or doesn't match the host, the endpoint ID is
+// the bottom-level domain of the host.
+func EndpointFromHost(pattern string) func(host string) (string, string) {
+	var p *hostPattern
+	if pattern != "" {
+		p = newHostPattern(pattern)
+	}
+	return func(host string) (string, string) {
+		if p != nil {
+			if endpointID, tenantID, ok := p.Match(host); ok {
+				return endpointID, tenantID
+			}
+		}
+		return endpointIDFromHost(host), ""
+	}
+}
+
+// Match returns the endpoint ID and tenant ID from the host. The host may
+// include a port.
+//
+// Returns false if the host doesn't match the pattern.
+func (p *hostPattern) Match(host string) (string, string, bool) {
+	// Strip the port if given.
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	match := p.re.FindStringSubmatch(host)
+	if match == nil {
+		return "", "", false
+	}
+
+	var endpointID, tenantID string
+	for i, name := range p.re.SubexpNames() {
+		switch name {
+		case "endpoint":
+			endpointID = match[i]
+		case "tenant":
+			tenantID = match[i]
+		}
+	}
+	return endpointID, tenantID, true
+}
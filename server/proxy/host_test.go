@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostPattern(t *testing.T) {
+	pattern := newHostPattern("{endpoint}.{tenant}.piko.example.com")
+
+	tests := []struct {
+		host       string
+		endpointID string
+		tenantID   string
+		ok         bool
+	}{
+		{"my-endpoint.my-tenant.piko.example.com", "my-endpoint", "my-tenant", true},
+		{"my-endpoint.my-tenant.piko.example.com:8000", "my-endpoint", "my-tenant", true},
+		{"MY-ENDPOINT.my-tenant.Piko.Example.com", "MY-ENDPOINT", "my-tenant", true},
+		{"my-endpoint.piko.example.com", "", "", false},
+		{"a.b.my-tenant.piko.example.com", "", "", false},
+		{"my-endpoint.my-tenant.pikoxexample.com", "", "", false},
+		{"10.26.104.56", "", "", false},
+	}
+	for _, tt := range tests {
+		endpointID, tenantID, ok := pattern.Match(tt.host)
+		assert.Equal(t, tt.ok, ok, tt.host)
+		assert.Equal(t, tt.endpointID, endpointID, tt.host)
+		assert.Equal(t, tt.tenantID, tenantID, tt.host)
+	}
+
+	// The tenant may appear before the endpoint.
+	pattern = newHostPattern("{tenant}--{endpoint}.example.com")
+	endpointID, tenantID, ok := pattern.Match("my-tenant--my-endpoint.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "my-endpoint", endpointID)
+	assert.Equal(t, "my-tenant", tenantID)
+}
+
+func TestEndpointFromHost(t *testing.T) {
+	endpointFromHost := EndpointFromHost("{endpoint}.{tenant}.piko.example.com")
+
+	endpointID, tenantID := endpointFromHost("my-endpoint.my-tenant.piko.example.com")
+	assert.Equal(t, "my-endpoint", endpointID)
+	assert.Equal(t, "my-tenant", tenantID)
+
+	// Falls back to the bottom-level domain if the pattern doesn't match.
+	endpointID, tenantID = endpointFromHost("my-endpoint.example.com")
+	assert.Equal(t, "my-endpoint", endpointID)
+	assert.Equal(t, "", tenantID)
+
+	endpointFromHost = EndpointFromHost("")
+	endpointID, tenantID = endpointFromHost("my-endpoint.piko.example.com:8000")
+	assert.Equal(t, "my-endpoint", endpointID)
+	assert.Equal(t, "", tenantID)
+}
@@ -12,7 +12,13 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/andydunstall/piko/pkg/limit"
 	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/bandwidth"
+	"github.com/andydunstall/piko/server/cache"
+	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/metering"
+	"github.com/andydunstall/piko/server/quota"
 	"github.com/andydunstall/piko/server/upstream"
 )
 
@@ -21,6 +27,10 @@ type contextKey int
 const (
 	endpointContextKey contextKey = iota
 	upstreamContextKey
+	cacheContextKey
+	tenantContextKey
+	signedURLContextKey
+	credentialHeaderContextKey
 )
 
 // HTTPProxy proxies HTTP traffic to upsteam listeners.
@@ -31,17 +41,45 @@ type HTTPProxy struct {
 
 	timeout time.Duration
 
+	limits config.LimitsConfig
+
+	// bandwidth accounts and limits the bandwidth of requests, or is nil if
+	// bandwidth isn't accounted.
+	bandwidth *bandwidth.Meter
+
+	// metering records the usage of endpoints, or is nil if usage isn't
+	// recorded.
+	metering *metering.Meter
+
+	// cache is the cache for upstream responses, or nil if caching is
+	// disabled.
+	cache *cache.Cache
+
+	// quotas enforces tenant connection quotas, or is nil if there are no
+	// quotas.
+	quotas *quota.Quotas
+
 	logger log.Logger
 }
 
 func NewHTTPProxy(
 	upstreams upstream.Manager,
 	timeout time.Duration,
+	limits config.LimitsConfig,
+	bandwidth *bandwidth.Meter,
+	metering *metering.Meter,
+	cache *cache.Cache,
+	quotas *quota.Quotas,
 	logger log.Logger,
 ) *HTTPProxy {
 	rp := &HTTPProxy{
 		upstreams: upstreams,
 		timeout:   timeout,
+		limits:    limits,
+		bandwidth: bandwidth,
+		metering:  metering,
+		cache:     cache,
+		quotas:    quotas,
 		logger:    logger.WithSubsystem("proxy.http"),
 	}
 
@@ -60,6 +98,9 @@ func NewHTTPProxy(
 		ErrorLog:     logger.StdLogger(zapcore.WarnLevel),
 		ErrorHandler: rp.errorHandler,
 	}
+	if cache != nil {
+		rp.proxy.ModifyResponse = rp.modifyResponse
+	}
 
 	return rp
 }
@@ -67,12 +108,23 @@ func NewHTTPProxy(
 func (p *HTTPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, endpointID string) {
 	// Whether the request was forwarded from another Piko node.
 	forwarded := r.Header.Get("x-piko-forward") == "true"
+	tenantID := tenantFromRequest(r)
+
+	// Only cache on the node that received the request from the client,
+	// rather than the node the request is forwarded to.
+	if p.cache != nil && !forwarded && p.cache.Enabled(endpointID) {
+		var served bool
+		r, served = p.serveFromCache(w, r, tenantID, endpointID)
+		if served {
+			return
+		}
+	}
 
 	// If there is a connected upstream, attempt to forward the request to one
 	// of those upstreams. Note this includes remote nodes that are reporting
 	// they have an available upstream. We don't allow multiple hops, so if
 	// forwarded is true we only select from local nodes.
-	upstream, ok := p.upstreams.Select(endpointID, !forwarded)
+	upstream, ok := p.upstreams.Select(tenantID, endpointID, !forwarded)
 	if !ok {
 		p.logger.Warn(
 			"no available upstreams",
@@ -99,6 +151,65 @@ func (p *HTTPProxy) ServeHTTPWithUpstream(
 		r = r.WithContext(ctx)
 	}
 
+	// If the upstream is connected to a remote node, the limits are applied
+	// and usage accounted by that node, since only it knows the upstream's
+	// token.
+	if !upstream.Forward() {
+		// Remove the client credential if configured to strip credentials.
+		if header, ok := r.Context().Value(credentialHeaderContextKey).(string); ok {
+			// Remove from a copy of the request, since the cache must still
+			// see the credential to decide whether the response is
+			// storable.
+			r = r.Clone(r.Context())
+			r.Header.Del(header)
+		}
+
+		if p.quotas != nil {
+			release, err := p.quotas.AcquireConnection(upstream.TenantID())
+			if err != nil {
+				p.logger.Warn(
+					"request rejected",
+					zap.String("endpoint-id", endpointID),
+					zap.String("tenant-id", upstream.TenantID()),
+					zap.Error(err),
+				)
+				_ = errorResponse(w, http.StatusTooManyRequests, err.Error())
+				return
+			}
+			defer release()
+		}
+
+		limits := p.limits.Endpoint(endpointID).Restrict(upstream.Limits())
+		if err := limit.LimitBody(w, r, limits); err != nil {
+			p.logger.Warn(
+				"request body too large",
+				zap.String("endpoint-id", endpointID),
+				zap.Int64("content-length", r.ContentLength),
+				zap.Int64("max-request-body-size", limits.MaxRequestBodySize),
+			)
+			_ = errorResponse(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+
+		if p.bandwidth != nil {
+			stream := p.bandwidth.Stream(endpointID, upstream.TenantID())
+			defer stream.Close()
+			if r.Body != nil && r.Body != http.NoBody {
+				r.Body = stream.ReadCloser(r.Context(), r.Body, bandwidth.DirectionIn)
+			}
+			w = stream.ResponseWriter(r.Context(), w)
+		}
+
+		if p.metering != nil {
+			p.metering.Request(upstream.TenantID(), endpointID)
+		}
+	} else if grant, ok := r.Context().Value(signedURLContextKey).(string); ok {
+		// The node the request is forwarded to authenticates the request
+		// again, so forward the signed URL grant, which has been removed
+		// from the URL.
+		r.Header.Set(signedURLHeader, grant)
+	}
+
 	r.Header.Set("x-piko-forward", "true")
 
 	r = r.WithContext(context.WithValue(r.Context(), endpointContextKey, endpointID))
@@ -124,6 +235,11 @@ func (p *HTTPProxy) dialUpstream(ctx context.Context, _, _ string) (net.Conn, er
 func (p *HTTPProxy) errorHandler(w http.ResponseWriter, _ *http.Request, err error) {
 	p.logger.Warn("proxy request", zap.Error(err))
 
+	if limit.IsBodyTooLarge(err) {
+		_ = errorResponse(w, http.StatusRequestEntityTooLarge, "request body too large")
+		return
+	}
+
 	if errors.Is(err, context.DeadlineExceeded) {
 		_ = errorResponse(w, http.StatusGatewayTimeout, "upstream timeout")
 		return
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/andydunstall/piko/pkg/auth"
+	"github.com/andydunstall/piko/pkg/middleware"
+	"github.com/andydunstall/piko/server/config"
+)
+
+const (
+	identityHeaderPrefix       = "X-Piko-Identity-"
+	identitySubjectHeader      = identityHeaderPrefix + "Subject"
+	identityTenantHeader       = identityHeaderPrefix + "Tenant"
+	identityClaimHeaderPrefix  = identityHeaderPrefix + "Claim-"
+	identityTokenHeader        = identityHeaderPrefix + "Token"
+	identityJWTIssuer          = "piko"
+	identityJWTClaimsClaimName = "claims"
+)
+
+// identity forwards the verified client identity to upstreams, so upstreams
+// can authorize users without verifying Piko tokens themselves.
+type identity struct {
+	stripCredentials bool
+	headers          bool
+	claims           []string
+
+	jwtSecretKey []byte
+	jwtTTL       time.Duration
+}
+
+func newIdentity(conf config.IdentityConfig) *identity {
+	var jwtSecretKey []byte
+	if conf.JWTSecretKey != "" {
+		jwtSecretKey = []byte(conf.JWTSecretKey)
+	}
+	return &identity{
+		stripCredentials: conf.StripCredentials,
+		headers:          conf.Headers,
+		claims:           conf.Claims,
+		jwtSecretKey:     jwtSecretKey,
+		jwtTTL:           conf.JWTTTL,
+	}
+}
+
+// Apply updates the request to forward to the upstream with the verified
+// client identity.
+func (i *identity) Apply(c *gin.Context, endpointID string, tenantID string) error {
+	if i.stripCredentials {
+		if header, ok := c.Get(middleware.CredentialHeaderContextKey); ok {
+			// The credential is only removed when the request is written to
+			// a local upstream, since if the request is forwarded to another
+			// node, that node authenticates the request again.
+			c.Request = c.Request.WithContext(context.WithValue(
+				c.Request.Context(), credentialHeaderContextKey, header.(string),
+			))
+		}
+	}
+
+	if !i.headers && i.jwtSecretKey == nil {
+		return nil
+	}
+
+	// Remove any identity headers sent by the client so they can't be
+	// spoofed.
+	for k := range c.Request.Header {
+		if strings.HasPrefix(http.CanonicalHeaderKey(k), identityHeaderPrefix) {
+			c.Request.Header.Del(k)
+		}
+	}
+
+	t, ok := c.Get(middleware.TokenContextKey)
+	if !ok {
+		// The client isn't authenticated.
+		return nil
+	}
+	token := t.(*auth.Token)
+
+	claims := make(map[string]any)
+	for _, name := range i.claims {
+		if v, ok := token.Claims[name]; ok {
+			claims[name] = v
+		}
+	}
+
+	if i.headers {
+		if token.Subject != "" {
+			c.Request.Header.Set(identitySubjectHeader, token.Subject)
+		}
+		if tenantID != "" {
+			c.Request.Header.Set(identityTenantHeader, tenantID)
+		}
+		for name, v := range claims {
+			value, err := claimHeaderValue(v)
+			if err != nil {
+				return fmt.Errorf("claim %s: %w", name, err)
+			}
+			c.Request.Header.Set(identityClaimHeaderPrefix+name, value)
+		}
+	}
+
+	if i.jwtSecretKey != nil {
+		now := time.Now()
+		jwtClaims := jwt.MapClaims{
+			"iss": identityJWTIssuer,
+			"aud": endpointID,
+			"iat": now.Unix(),
+			"exp": now.Add(i.jwtTTL).Unix(),
+		}
+		if token.Subject != "" {
+			jwtClaims["sub"] = token.Subject
+		}
+		if tenantID != "" {
+			jwtClaims["tenant_id"] = tenantID
+		}
+		if len(claims) > 0 {
+			jwtClaims[identityJWTClaimsClaimName] = claims
+		}
+
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwtClaims).SignedString(i.jwtSecretKey)
+		if err != nil {
+			return fmt.Errorf("sign: %w", err)
+		}
+		c.Request.Header.Set(identityTokenHeader, signed)
+	}
+
+	return nil
+}
+
+// claimHeaderValue returns the header value for the claim. Strings are
+// returned as is and other values are JSON encoded.
+func claimHeaderValue(v any) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
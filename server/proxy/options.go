@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"github.com/andydunstall/piko/pkg/audit"
+	"github.com/andydunstall/piko/server/bandwidth"
+	"github.com/andydunstall/piko/server/cache"
+	"github.com/andydunstall/piko/server/metering"
+	"github.com/andydunstall/piko/server/quota"
+)
+
+type options struct {
+	cache     *cache.Cache
+	bandwidth *bandwidth.Meter
+	metering  *metering.Meter
+	quotas    *quota.Quotas
+	audit     *audit.Logger
+}
+
+type cacheOption struct {
+	Cache *cache.Cache
+}
+
+func (o cacheOption) apply(opts *options) {
+	opts.cache = o.Cache
+}
+
+// WithCache configures a cache for upstream HTTP responses. Defaults to no
+// cache.
+func WithCache(cache *cache.Cache) Option {
+	return cacheOption{Cache: cache}
+}
+
+type bandwidthOption struct {
+	Meter *bandwidth.Meter
+}
+
+func (o bandwidthOption) apply(opts *options) {
+	opts.bandwidth = o.Meter
+}
+
+// WithBandwidth configures a meter to account and limit the bandwidth of
+// proxied requests and connections. Defaults to no accounting.
+func WithBandwidth(meter *bandwidth.Meter) Option {
+	return bandwidthOption{Meter: meter}
+}
+
+type meteringOption struct {
+	Meter *metering.Meter
+}
+
+func (o meteringOption) apply(opts *options) {
+	opts.metering = o.Meter
+}
+
+// WithMetering configures a meter to record the usage of endpoints for
+// billing. Defaults to no metering.
+func WithMetering(meter *metering.Meter) Option {
+	return meteringOption{Meter: meter}
+}
+
+type quotasOption struct {
+	Quotas *quota.Quotas
+}
+
+func (o quotasOption) apply(opts *options) {
+	opts.quotas = o.Quotas
+}
+
+// WithQuotas configures tenant connection quotas. Defaults to no quotas.
+func WithQuotas(quotas *quota.Quotas) Option {
+	return quotasOption{Quotas: quotas}
+}
+
+type auditOption struct {
+	Logger *audit.Logger
+}
+
+func (o auditOption) apply(opts *options) {
+	opts.audit = o.Logger
+}
+
+// WithAudit configures recording authentication failures to the audit log.
+// Defaults to no audit logging.
+func WithAudit(logger *audit.Logger) Option {
+	return auditOption{Logger: logger}
+}
+
+type Option interface {
+	apply(*options)
+}
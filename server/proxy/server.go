@@ -13,9 +13,12 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/andydunstall/piko/pkg/audit"
 	"github.com/andydunstall/piko/pkg/auth"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/pkg/middleware"
+	"github.com/andydunstall/piko/server/authz"
+	"github.com/andydunstall/piko/server/cluster"
 	"github.com/andydunstall/piko/server/config"
 	"github.com/andydunstall/piko/server/upstream"
 )
@@ -24,6 +27,20 @@ type Server struct {
 	httpProxy *HTTPProxy
 	tcpProxy  *TCPProxy
 
+	// hostPattern extracts the endpoint ID and tenant ID from the request
+	// host, or nil if not configured.
+	hostPattern *hostPattern
+
+	// authorizer authorizes connections using an external webhook, or nil
+	// if not configured.
+	authorizer *authz.Authorizer
+
+	// identity forwards the verified client identity to upstreams, or nil
+	// if not configured.
+	identity *identity
+
+	accessLogger *middleware.AccessLogger
+
 	httpServer *http.Server
 
 	logger log.Logger
@@ -36,15 +53,37 @@ func NewServer(
 	verifier *auth.MultiTenantVerifier,
 	tlsConfig *tls.Config,
 	logger log.Logger,
+	opts ...Option,
 ) *Server {
+	options := options{}
+	for _, o := range opts {
+		o.apply(&options)
+	}
+
 	logger = logger.WithSubsystem("proxy")
 
-	httpProxy := NewHTTPProxy(upstreams, proxyConfig.Timeout, logger)
+	httpProxy := NewHTTPProxy(
+		upstreams,
+		proxyConfig.Timeout,
+		proxyConfig.Limits,
+		options.bandwidth,
+		options.metering,
+		options.cache,
+		options.quotas,
+		logger,
+	)
 
 	router := gin.New()
 	s := &Server{
 		httpProxy: httpProxy,
-		tcpProxy:  NewTCPProxy(upstreams, httpProxy, logger),
+		tcpProxy: NewTCPProxy(
+			upstreams,
+			httpProxy,
+			options.bandwidth,
+			options.metering,
+			options.quotas,
+			logger,
+		),
 		httpServer: &http.Server{
 			Handler:           router,
 			TLSConfig:         tlsConfig,
@@ -57,16 +96,36 @@ func NewServer(
 		},
 		logger: logger,
 	}
+	if proxyConfig.HostPattern != "" {
+		s.hostPattern = newHostPattern(proxyConfig.HostPattern)
+	}
+	if proxyConfig.Authz.Enabled() {
+		s.authorizer = authz.NewAuthorizer(proxyConfig.Authz, logger)
+	}
+	if proxyConfig.Identity.Enabled() || proxyConfig.Identity.StripCredentials {
+		s.identity = newIdentity(proxyConfig.Identity)
+	}
 
 	// Recover from panics.
 	router.Use(gin.CustomRecoveryWithWriter(nil, s.panicRoute))
 
+	// Verify signed URLs before the bearer token, so requests with a signed
+	// URL don't require a token.
+	if proxyConfig.SignedURLs.Enabled() {
+		router.Use(newSignedURLs(proxyConfig.SignedURLs, s.hostPattern, logger).Verify)
+	}
+
 	if verifier != nil {
-		authMiddleware := middleware.NewAuth(verifier, logger)
+		var auditLogger *audit.Logger
+		if options.audit != nil {
+			auditLogger = options.audit.WithListener("proxy")
+		}
+		authMiddleware := middleware.NewAuth(verifier, auditLogger, logger)
 		router.Use(authMiddleware.Verify)
 	}
 
-	router.Use(middleware.NewLogger(proxyConfig.AccessLog, logger))
+	s.accessLogger = middleware.NewAccessLogger(proxyConfig.AccessLog, logger)
+	router.Use(s.accessLogger.Handler)
 
 	metrics := middleware.NewMetrics("proxy")
 	if registry != nil {
@@ -74,6 +133,10 @@ func NewServer(
 	}
 	router.Use(metrics.Handler())
 
+	if proxyConfig.Compression.Enabled {
+		router.Use(middleware.NewCompression(proxyConfig.Compression))
+	}
+
 	s.registerRoutes(router)
 
 	return s
@@ -105,6 +168,11 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return nil
 }
 
+// UpdateAccessLog replaces the access log configuration.
+func (s *Server) UpdateAccessLog(conf log.AccessLogConfig) {
+	s.accessLogger.Update(conf)
+}
+
 func (s *Server) registerRoutes(router *gin.Engine) {
 	// All /_piko routes are reserved.
 	piko := router.Group("/_piko")
@@ -115,7 +183,7 @@ func (s *Server) registerRoutes(router *gin.Engine) {
 }
 
 func (s *Server) proxyHTTPRoute(c *gin.Context) {
-	endpointID := EndpointIDFromRequest(c.Request)
+	endpointID, hostTenantID := s.endpointFromRequest(c.Request)
 	if endpointID == "" {
 		s.logger.Warn("request missing endpoint id")
 		c.JSON(
@@ -124,24 +192,27 @@ func (s *Server) proxyHTTPRoute(c *gin.Context) {
 		)
 		return
 	}
+	if !cluster.ValidEndpointID(endpointID) {
+		s.logger.Warn("invalid endpoint id", zap.String("endpoint-id", endpointID))
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "invalid endpoint id"},
+		)
+		return
+	}
 
-	// Verify the token is permitted to access the target endpoint.
-	token, ok := c.Get(middleware.TokenContextKey)
-	if ok {
-		// If the token contains a set of permitted endpoints, verify the
-		// target endpoint matches one of those endpoints. Otherwise if the
-		// token doesn't contain any endpoints the client can access any
-		// endpoint.
-		endpointToken := token.(*auth.Token)
-		if !endpointToken.EndpointPermitted(endpointID) {
-			s.logger.Warn(
-				"endpoint not permitted",
-				zap.Strings("token-endpoints", endpointToken.Endpoints),
-				zap.String("endpoint-id", endpointID),
-			)
+	tenantID, ok := s.authorize(c, endpointID, hostTenantID)
+	if !ok {
+		return
+	}
+	c.Request = withTenant(c.Request, tenantID)
+
+	if s.identity != nil {
+		if err := s.identity.Apply(c, endpointID, tenantID); err != nil {
+			s.logger.Error("failed to forward identity", zap.Error(err))
 			c.JSON(
-				http.StatusUnauthorized,
-				gin.H{"error": "endpoint not permitted"},
+				http.StatusInternalServerError,
+				gin.H{"error": "internal server error"},
 			)
 			return
 		}
@@ -153,31 +224,172 @@ func (s *Server) proxyHTTPRoute(c *gin.Context) {
 func (s *Server) proxyTCPRoute(c *gin.Context) {
 	endpointID := c.Param("endpointID")
 
-	// Verify the token is permitted to access the target endpoint.
-	token, ok := c.Get(middleware.TokenContextKey)
-	if ok {
-		// If the token contains a set of permitted endpoints, verify the
-		// target endpoint matches one of those endpoints. Otherwise if the
-		// token doesn't contain any endpoints the client can access any
-		// endpoint.
-		endpointToken := token.(*auth.Token)
-		if !endpointToken.EndpointPermitted(endpointID) {
-			s.logger.Warn(
-				"endpoint not permitted",
-				zap.Strings("token-endpoints", endpointToken.Endpoints),
-				zap.String("endpoint-id", endpointID),
-			)
-			c.JSON(
-				http.StatusUnauthorized,
-				gin.H{"error": "endpoint not permitted"},
-			)
-			return
-		}
+	// The endpoint ID is given in the path, though the tenant may still be
+	// given in the host.
+	var hostTenantID string
+	if s.hostPattern != nil {
+		_, hostTenantID, _ = s.hostPattern.Match(c.Request.Host)
 	}
 
+	tenantID, ok := s.authorize(c, endpointID, hostTenantID)
+	if !ok {
+		return
+	}
+	c.Request = withTenant(c.Request, tenantID)
+
 	s.tcpProxy.ServeHTTP(c.Writer, c.Request, endpointID)
 }
 
+// authorize verifies the client is permitted to access the target endpoint
+// and returns the tenant whose namespace the endpoint is in.
+//
+// If the client authenticated, the endpoint is in the namespace of the tokens
+// tenant, and the tenant in the host, if given, must match. Tokens without a
+// tenant can only access the default tenant. If the client is
+// unauthenticated, the tenant is taken from the host, if given.
+//
+// If an authorization webhook is configured, the connection must also be
+// allowed by the webhook.
+//
+// If the client isn't permitted, authorize replies to the client and returns
+// false.
+func (s *Server) authorize(
+	c *gin.Context,
+	endpointID string,
+	hostTenantID string,
+) (string, bool) {
+	var token *auth.Token
+	if t, ok := c.Get(middleware.TokenContextKey); ok {
+		token = t.(*auth.Token)
+	}
+
+	tenantID, ok := s.authorizeToken(c, token, endpointID, hostTenantID)
+	if !ok {
+		return "", false
+	}
+	if !s.authorizeWebhook(c, token, endpointID, tenantID) {
+		return "", false
+	}
+	return tenantID, true
+}
+
+func (s *Server) authorizeToken(
+	c *gin.Context,
+	endpointToken *auth.Token,
+	endpointID string,
+	hostTenantID string,
+) (string, bool) {
+	if endpointToken == nil {
+		return hostTenantID, true
+	}
+
+	// If the token contains a set of permitted endpoints, verify the
+	// target endpoint matches one of those endpoints. Otherwise if the
+	// token doesn't contain any endpoints the client can access any
+	// endpoint.
+	if !endpointToken.ConnectPermitted(endpointID) {
+		s.logger.Warn(
+			"endpoint not permitted",
+			zap.Strings("token-endpoints", endpointToken.Endpoints),
+			zap.Strings("token-connect-endpoints", endpointToken.ConnectEndpoints),
+			zap.String("endpoint-id", endpointID),
+		)
+		c.JSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "endpoint not permitted"},
+		)
+		return "", false
+	}
+
+	// The host tenant must match the token tenant, which includes tokens
+	// for the default tenant, which would otherwise access any tenants
+	// endpoints using the tenants host.
+	if hostTenantID != "" && hostTenantID != endpointToken.TenantID {
+		s.logger.Warn(
+			"tenant not permitted",
+			zap.String("token-tenant-id", endpointToken.TenantID),
+			zap.String("host-tenant-id", hostTenantID),
+			zap.String("endpoint-id", endpointID),
+		)
+		c.JSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "endpoint not permitted"},
+		)
+		return "", false
+	}
+	return endpointToken.TenantID, true
+}
+
+// authorizeWebhook checks the connection is allowed by the authorization
+// webhook, if configured, and adds any headers from the decision to the
+// request.
+func (s *Server) authorizeWebhook(
+	c *gin.Context,
+	token *auth.Token,
+	endpointID string,
+	tenantID string,
+) bool {
+	if s.authorizer == nil {
+		return true
+	}
+
+	req := authz.Request{
+		Action:     authz.ActionConnect,
+		EndpointID: endpointID,
+		TenantID:   tenantID,
+		ClientIP:   c.ClientIP(),
+	}
+	if token != nil {
+		req.Claims = token.Claims
+	}
+	decision, err := s.authorizer.Authorize(c.Request.Context(), req)
+	if err != nil {
+		c.JSON(
+			http.StatusServiceUnavailable,
+			gin.H{"error": "authorization unavailable"},
+		)
+		return false
+	}
+	if !decision.Allow {
+		s.logger.Warn(
+			"connection denied by authorization webhook",
+			zap.String("endpoint-id", endpointID),
+			zap.String("tenant-id", tenantID),
+			zap.String("reason", decision.Reason),
+		)
+		reason := decision.Reason
+		if reason == "" {
+			reason = "not authorized"
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": reason})
+		return false
+	}
+
+	for k, v := range decision.Headers {
+		c.Request.Header.Set(k, v)
+	}
+	return true
+}
+
+// endpointFromRequest returns the endpoint ID and tenant ID from the HTTP
+// request.
+//
+// If a host pattern is configured and the host matches, the endpoint ID and
+// tenant ID are taken from the host, though the 'x-piko-endpoint' header
+// still takes precedence for the endpoint ID.
+func (s *Server) endpointFromRequest(r *http.Request) (string, string) {
+	if s.hostPattern != nil {
+		endpointID, tenantID, ok := s.hostPattern.Match(r.Host)
+		if ok {
+			if headerEndpointID := r.Header.Get("x-piko-endpoint"); headerEndpointID != "" {
+				endpointID = headerEndpointID
+			}
+			return endpointID, tenantID
+		}
+	}
+	return EndpointIDFromRequest(r), ""
+}
+
 func (s *Server) panicRoute(c *gin.Context, err any) {
 	s.logger.Error(
 		"handler panic",
@@ -187,6 +399,22 @@ func (s *Server) panicRoute(c *gin.Context, err any) {
 	c.AbortWithStatus(http.StatusInternalServerError)
 }
 
+// withTenant adds the tenant whose namespace the request endpoint is in to
+// the request context.
+func withTenant(r *http.Request, tenantID string) *http.Request {
+	if tenantID == "" {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), tenantContextKey, tenantID))
+}
+
+// tenantFromRequest returns the tenant whose namespace the request endpoint
+// is in, or an empty string for the default tenant.
+func tenantFromRequest(r *http.Request) string {
+	tenantID, _ := r.Context().Value(tenantContextKey).(string)
+	return tenantID
+}
+
 // EndpointIDFromRequest returns the endpoint ID from the HTTP request, or an
 // empty string if no endpoint ID is specified.
 //
@@ -197,11 +425,15 @@ func EndpointIDFromRequest(r *http.Request) string {
 	if endpointID != "" {
 		return endpointID
 	}
+	return endpointIDFromHost(r.Host)
+}
 
+// endpointIDFromHost returns the endpoint ID from the bottom-level domain of
+// the host, or an empty string if the host has no endpoint ID.
+func endpointIDFromHost(host string) string {
 	// Strip the port if given.
-	host, _, err := net.SplitHostPort(r.Host)
-	if err != nil {
-		host = r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
 	}
 
 	if host == "" {
@@ -2,7 +2,9 @@ package proxy
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,29 +12,39 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/andydunstall/piko/pkg/auth"
+	"github.com/andydunstall/piko/pkg/limit"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/pkg/websocket"
+	"github.com/andydunstall/piko/server/authz"
+	"github.com/andydunstall/piko/server/bandwidth"
+	"github.com/andydunstall/piko/server/cache"
+	"github.com/andydunstall/piko/server/cluster"
 	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/quota"
 	"github.com/andydunstall/piko/server/upstream"
 )
 
 type fakeManager struct {
-	handler func(endpointID string, allowForward bool) (upstream.Upstream, bool)
+	handler func(endpointKey string, allowForward bool) (upstream.Upstream, bool)
 }
 
+// Select calls the handler with the namespaced endpoint key.
 func (m *fakeManager) Select(
+	tenantID string,
 	endpointID string,
 	allowForward bool,
 ) (upstream.Upstream, bool) {
-	return m.handler(endpointID, allowForward)
+	return m.handler(cluster.EndpointKey(tenantID, endpointID), allowForward)
 }
 
 func (m *fakeManager) AddConn(_ upstream.Upstream) {
@@ -42,8 +54,10 @@ func (m *fakeManager) RemoveConn(_ upstream.Upstream) {
 }
 
 type tcpUpstream struct {
-	addr    string
-	forward bool
+	addr     string
+	forward  bool
+	tenantID string
+	limits   limit.Config
 }
 
 func (u *tcpUpstream) Dial() (net.Conn, error) {
@@ -58,6 +72,14 @@ func (u *tcpUpstream) Forward() bool {
 	return u.forward
 }
 
+func (u *tcpUpstream) TenantID() string {
+	return u.tenantID
+}
+
+func (u *tcpUpstream) Limits() limit.Config {
+	return u.limits
+}
+
 func echoListener(ln net.Listener) {
 	conn, err := ln.Accept()
 	if err != nil {
@@ -152,6 +174,310 @@ func TestServer_HTTP(t *testing.T) {
 		assert.Equal(t, "bar", buf.String())
 	})
 
+	// Tests compressing the upstream response.
+	t.Run("compression", func(t *testing.T) {
+		body := strings.Repeat("bar", 1024)
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				// The client encoding is forwarded to the upstream.
+				assert.Equal(t, "gzip", r.Header.Get("Accept-Encoding"))
+
+				w.Header().Set("Content-Type", "text/plain")
+				// nolint
+				w.Write([]byte(body))
+			},
+		))
+		defer upstreamServer.Close()
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		conf := config.Default().Proxy
+		conf.Compression.Enabled = true
+		s := NewServer(
+			&fakeManager{
+				handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+					return &tcpUpstream{
+						addr: upstreamServer.Listener.Addr().String(),
+					}, true
+				},
+			},
+			conf,
+			nil,
+			nil,
+			nil,
+			log.NewNopLogger(),
+		)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf("http://%s/", ln.Addr().String())
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Add("x-piko-endpoint", "my-endpoint")
+		req.Header.Add("Accept-Encoding", "gzip")
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+		gr, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		decoded, err := io.ReadAll(gr)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(decoded))
+	})
+
+	// Tests serving cached responses and revalidating stale responses.
+	t.Run("cache", func(t *testing.T) {
+		requests := 0
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				requests++
+
+				w.Header().Set("ETag", `"v1"`)
+				if r.URL.Path == "/stale" {
+					w.Header().Set("Cache-Control", "max-age=0")
+				} else {
+					w.Header().Set("Cache-Control", "max-age=60")
+				}
+				if r.Header.Get("If-None-Match") == `"v1"` {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				// nolint
+				w.Write([]byte("bar"))
+			},
+		))
+		defer upstreamServer.Close()
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		conf := config.Default().Proxy
+		conf.Cache.Enabled = true
+		c, err := cache.NewCache(conf.Cache, log.NewNopLogger())
+		require.NoError(t, err)
+		meter := bandwidth.NewMeter(conf.Bandwidth)
+		s := NewServer(
+			&fakeManager{
+				handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+					return &tcpUpstream{
+						addr: upstreamServer.Listener.Addr().String(),
+					}, true
+				},
+			},
+			conf,
+			nil,
+			nil,
+			nil,
+			log.NewNopLogger(),
+			WithCache(c),
+			WithBandwidth(meter),
+		)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		get := func(path string) (*http.Response, string) {
+			url := fmt.Sprintf("http://%s%s", ln.Addr().String(), path)
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			req.Header.Add("x-piko-endpoint", "my-endpoint")
+
+			client := &http.Client{}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			return resp, string(body)
+		}
+
+		resp, body := get("/fresh")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "miss", resp.Header.Get("x-piko-cache"))
+		assert.Equal(t, "bar", body)
+
+		resp, body = get("/fresh")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hit", resp.Header.Get("x-piko-cache"))
+		assert.Equal(t, "bar", body)
+		assert.Equal(t, 1, requests)
+
+		// Responses served from the cache are accounted.
+		assert.Equal(t, []bandwidth.Usage{
+			{ID: "my-endpoint", BytesOut: 6},
+		}, meter.TopEndpoints("", 0, true))
+
+		resp, body = get("/stale")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "miss", resp.Header.Get("x-piko-cache"))
+		assert.Equal(t, "bar", body)
+
+		resp, body = get("/stale")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "revalidated", resp.Header.Get("x-piko-cache"))
+		assert.Equal(t, "bar", body)
+		assert.Equal(t, 3, requests)
+	})
+
+	// Tests rejecting requests whose body exceeds the endpoint limit.
+	t.Run("request body too large", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				// nolint
+				io.Copy(w, r.Body)
+			},
+		))
+		defer upstreamServer.Close()
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		conf := config.Default().Proxy
+		conf.Limits.MaxRequestBodySize = 1024
+		conf.Limits.Endpoints = []config.EndpointLimitsConfig{
+			{
+				ID:                 "small-endpoint",
+				MaxRequestBodySize: 16,
+			},
+		}
+		s := NewServer(
+			&fakeManager{
+				handler: func(endpointID string, _ bool) (upstream.Upstream, bool) {
+					u := &tcpUpstream{
+						addr: upstreamServer.Listener.Addr().String(),
+					}
+					switch endpointID {
+					case "raise-endpoint":
+						// The upstream token can't raise the limit.
+						u.limits = limit.Config{MaxRequestBodySize: 4096}
+					case "lower-endpoint":
+						// The upstream token lowers the limit.
+						u.limits = limit.Config{MaxRequestBodySize: 8}
+					}
+					return u, true
+				},
+			},
+			conf,
+			nil,
+			nil,
+			nil,
+			log.NewNopLogger(),
+		)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		post := func(endpointID string, body io.Reader) int {
+			url := fmt.Sprintf("http://%s/", ln.Addr().String())
+			req, _ := http.NewRequest(http.MethodPost, url, body)
+			req.Header.Add("x-piko-endpoint", endpointID)
+
+			client := &http.Client{}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			// nolint
+			io.Copy(io.Discard, resp.Body)
+			return resp.StatusCode
+		}
+
+		body := strings.Repeat("a", 2048)
+
+		assert.Equal(t, http.StatusOK, post("my-endpoint", strings.NewReader("foo")))
+		assert.Equal(
+			t,
+			http.StatusRequestEntityTooLarge,
+			post("my-endpoint", strings.NewReader(body)),
+		)
+		// Unknown content length.
+		assert.Equal(
+			t,
+			http.StatusRequestEntityTooLarge,
+			post("my-endpoint", io.MultiReader(strings.NewReader(body))),
+		)
+		assert.Equal(
+			t,
+			http.StatusRequestEntityTooLarge,
+			post("small-endpoint", strings.NewReader(strings.Repeat("a", 32))),
+		)
+		assert.Equal(
+			t,
+			http.StatusRequestEntityTooLarge,
+			post("raise-endpoint", strings.NewReader(body)),
+		)
+		assert.Equal(
+			t,
+			http.StatusRequestEntityTooLarge,
+			post("lower-endpoint", strings.NewReader(strings.Repeat("a", 16))),
+		)
+		assert.Equal(t, http.StatusOK, post("lower-endpoint", strings.NewReader("foo")))
+	})
+
+	// Tests accounting the bandwidth of requests.
+	t.Run("bandwidth", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				// nolint
+				io.Copy(io.Discard, r.Body)
+				// nolint
+				w.Write([]byte(strings.Repeat("a", 200)))
+			},
+		))
+		defer upstreamServer.Close()
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		conf := config.Default().Proxy
+		meter := bandwidth.NewMeter(conf.Bandwidth)
+		s := NewServer(
+			&fakeManager{
+				handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+					return &tcpUpstream{
+						addr: upstreamServer.Listener.Addr().String(),
+					}, true
+				},
+			},
+			conf,
+			nil,
+			nil,
+			nil,
+			log.NewNopLogger(),
+			WithBandwidth(meter),
+		)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf("http://%s/", ln.Addr().String())
+		req, _ := http.NewRequest(
+			http.MethodPost, url, strings.NewReader(strings.Repeat("a", 100)),
+		)
+		req.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		// nolint
+		io.Copy(io.Discard, resp.Body)
+
+		assert.Equal(t, []bandwidth.Usage{
+			{ID: "my-endpoint", BytesIn: 100, BytesOut: 200},
+		}, meter.TopEndpoints("", 0, true))
+	})
+
 	// Tests a request times out when upstream doesn't respond.
 	t.Run("timeout", func(t *testing.T) {
 		blockCh := make(chan struct{})
@@ -289,6 +615,72 @@ func TestServer_HTTP(t *testing.T) {
 		assert.Equal(t, "no available upstreams", m.Error)
 	})
 
+	// Tests the server rejects requests once the upstreams tenant reaches
+	// its connection quota.
+	t.Run("connection quota exceeded", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				// nolint
+				w.Write([]byte("bar"))
+			},
+		))
+		defer upstreamServer.Close()
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		state := cluster.NewState(&cluster.Node{ID: "local"}, log.NewNopLogger())
+		state.AddNode(&cluster.Node{ID: "remote", Status: cluster.NodeStatusActive})
+		quotas := quota.NewQuotas([]config.TenantConfig{
+			{ID: "my-tenant", Quota: config.QuotaConfig{MaxConnections: 1}},
+		}, state)
+
+		s := NewServer(
+			&fakeManager{
+				handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+					return &tcpUpstream{
+						addr:     upstreamServer.Listener.Addr().String(),
+						tenantID: "my-tenant",
+					}, true
+				},
+			},
+			config.Default().Proxy,
+			nil,
+			nil,
+			nil,
+			log.NewNopLogger(),
+			WithQuotas(quotas),
+		)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		for _, tt := range []struct {
+			remoteConns int
+			statusCode  int
+		}{
+			{0, http.StatusOK},
+			{1, http.StatusTooManyRequests},
+		} {
+			state.UpdateRemoteConnections("remote", "my-tenant", tt.remoteConns)
+
+			url := fmt.Sprintf("http://%s/", ln.Addr().String())
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			req.Header.Add("x-piko-endpoint", "my-endpoint")
+
+			client := &http.Client{}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode)
+		}
+
+		// The connection is released once the request completes.
+		assert.Equal(t, 0, state.LocalConnections("my-tenant"))
+	})
+
 	// Tests the server returns an error if the request is missing an endpoint
 	// ID.
 	t.Run("missing endpoint id", func(t *testing.T) {
@@ -322,6 +714,98 @@ func TestServer_HTTP(t *testing.T) {
 		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&m))
 		assert.Equal(t, "missing endpoint id", m.Error)
 	})
+
+	// Tests the server returns an error if the endpoint ID contains the
+	// tenant namespace separator.
+	t.Run("invalid endpoint id", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		s := NewServer(
+			nil,
+			config.Default().Proxy,
+			nil,
+			nil,
+			nil,
+			log.NewNopLogger(),
+		)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf("http://%s/", ln.Addr().String())
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Add("x-piko-endpoint", "my-tenant/my-endpoint")
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		m := errorMessage{}
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&m))
+		assert.Equal(t, "invalid endpoint id", m.Error)
+	})
+
+	// Tests routing requests to the endpoint and tenant in the host.
+	t.Run("host pattern", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				// nolint
+				w.Write([]byte("bar"))
+			},
+		))
+		defer upstreamServer.Close()
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		var endpointKeys []string
+		conf := config.Default().Proxy
+		conf.HostPattern = "{endpoint}.{tenant}.piko.example.com"
+		s := NewServer(
+			&fakeManager{
+				handler: func(endpointKey string, _ bool) (upstream.Upstream, bool) {
+					endpointKeys = append(endpointKeys, endpointKey)
+					return &tcpUpstream{
+						addr: upstreamServer.Listener.Addr().String(),
+					}, true
+				},
+			},
+			conf,
+			nil,
+			nil,
+			nil,
+			log.NewNopLogger(),
+		)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		for _, host := range []string{
+			"my-endpoint.my-tenant.piko.example.com",
+			// Hosts that don't match the pattern use the bottom-level
+			// domain.
+			"my-endpoint.example.com",
+		} {
+			url := fmt.Sprintf("http://%s/foo", ln.Addr().String())
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			req.Host = host
+
+			client := &http.Client{}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+
+		assert.Equal(t, []string{"my-tenant/my-endpoint", "my-endpoint"}, endpointKeys)
+	})
 }
 
 // TestServer_TCP tests proxying TCP traffic to upstreams.
@@ -524,6 +1008,190 @@ func TestServer_Authentication(t *testing.T) {
 		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
 	})
 
+	// Tests a token that can only listen on the endpoint can't connect to
+	// the endpoint.
+	t.Run("connect not permitted", func(t *testing.T) {
+		verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+			handler: func(token string) (*auth.Token, error) {
+				assert.Equal(t, "123", token)
+				return &auth.Token{
+					Expiry:          time.Now().Add(time.Hour),
+					ListenEndpoints: []string{"my-endpoint"},
+				}, nil
+			},
+		}, nil)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		s := NewServer(
+			nil,
+			config.Default().Proxy,
+			nil,
+			verifier,
+			nil,
+			log.NewNopLogger(),
+		)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf("http://%s/foo", ln.Addr().String())
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Add("x-piko-endpoint", "my-endpoint")
+		req.Header.Add("Authorization", "Bearer 123")
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	// Tests a tenant can only access endpoints in its own namespace.
+	t.Run("tenant", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				// nolint
+				w.Write([]byte("bar"))
+			},
+		))
+		defer upstreamServer.Close()
+
+		verifier := auth.NewMultiTenantVerifier(nil, map[string]auth.Verifier{
+			"tenant-1": &fakeVerifier{
+				handler: func(token string) (*auth.Token, error) {
+					assert.Equal(t, "123", token)
+					return &auth.Token{
+						Expiry: time.Now().Add(time.Hour),
+					}, nil
+				},
+			},
+		})
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		conf := config.Default().Proxy
+		conf.HostPattern = "{endpoint}.{tenant}.piko.example.com"
+		s := NewServer(
+			&fakeManager{
+				handler: func(endpointKey string, _ bool) (upstream.Upstream, bool) {
+					if endpointKey != "tenant-1/my-endpoint" {
+						return nil, false
+					}
+					return &tcpUpstream{
+						addr:     upstreamServer.Listener.Addr().String(),
+						tenantID: "tenant-1",
+					}, true
+				},
+			},
+			conf,
+			nil,
+			verifier,
+			nil,
+			log.NewNopLogger(),
+		)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		for _, tt := range []struct {
+			host       string
+			statusCode int
+		}{
+			{"my-endpoint.tenant-1.piko.example.com", http.StatusOK},
+			{"my-endpoint", http.StatusOK},
+			{"other-endpoint.tenant-1.piko.example.com", http.StatusBadGateway},
+			// The tenant in the host must match the token.
+			{"my-endpoint.tenant-2.piko.example.com", http.StatusUnauthorized},
+		} {
+			url := fmt.Sprintf("http://%s/foo", ln.Addr().String())
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			req.Host = tt.host
+			if !strings.Contains(tt.host, ".") {
+				req.Header.Add("x-piko-endpoint", tt.host)
+			}
+			req.Header.Add("x-piko-tenant-id", "tenant-1")
+			req.Header.Add("Authorization", "Bearer 123")
+
+			client := &http.Client{}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode, tt.host)
+		}
+	})
+
+	// Tests a token without a tenant can't access tenant endpoints using
+	// the tenants host.
+	t.Run("default tenant", func(t *testing.T) {
+		upstreamServer := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				// nolint
+				w.Write([]byte("bar"))
+			},
+		))
+		defer upstreamServer.Close()
+
+		verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+			handler: func(token string) (*auth.Token, error) {
+				assert.Equal(t, "123", token)
+				return &auth.Token{
+					Expiry: time.Now().Add(time.Hour),
+				}, nil
+			},
+		}, nil)
+
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		conf := config.Default().Proxy
+		conf.HostPattern = "{endpoint}.{tenant}.piko.example.com"
+		s := NewServer(
+			&fakeManager{
+				handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+					return &tcpUpstream{
+						addr: upstreamServer.Listener.Addr().String(),
+					}, true
+				},
+			},
+			conf,
+			nil,
+			verifier,
+			nil,
+			log.NewNopLogger(),
+		)
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		for _, tt := range []struct {
+			host       string
+			statusCode int
+		}{
+			{"my-endpoint.example.com", http.StatusOK},
+			{"my-endpoint.tenant-1.piko.example.com", http.StatusUnauthorized},
+		} {
+			url := fmt.Sprintf("http://%s/foo", ln.Addr().String())
+			req, _ := http.NewRequest(http.MethodGet, url, nil)
+			req.Host = tt.host
+			req.Header.Add("Authorization", "Bearer 123")
+
+			client := &http.Client{}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, tt.statusCode, resp.StatusCode, tt.host)
+		}
+	})
+
 	// Tests authenticating with a token that doesn't contain any endpoints
 	// (meaning the client can access ALL endpoints).
 	t.Run("token missing endpoints", func(t *testing.T) {
@@ -633,6 +1301,677 @@ func TestServer_Authentication(t *testing.T) {
 	})
 }
 
+func TestServer_Authz(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// Echo the header added by the authorization webhook.
+			// nolint
+			w.Write([]byte(r.Header.Get("x-user")))
+		},
+	))
+	defer upstreamServer.Close()
+
+	authzServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var req authz.Request
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, authz.ActionConnect, req.Action)
+			assert.Equal(t, "127.0.0.1", req.ClientIP)
+
+			switch req.EndpointID {
+			case "allowed":
+				_ = json.NewEncoder(w).Encode(authz.Decision{
+					Allow:   true,
+					Headers: map[string]string{"x-user": "my-user"},
+				})
+			case "denied":
+				_ = json.NewEncoder(w).Encode(authz.Decision{
+					Allow:  false,
+					Reason: "endpoint denied",
+				})
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		},
+	))
+	defer authzServer.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	conf := config.Default().Proxy
+	conf.Authz.URL = authzServer.URL
+	s := NewServer(
+		&fakeManager{
+			handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		},
+		conf,
+		nil,
+		nil,
+		nil,
+		log.NewNopLogger(),
+	)
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	get := func(endpointID string) (int, string) {
+		url := fmt.Sprintf("http://%s/", ln.Addr().String())
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Add("x-piko-endpoint", endpointID)
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		status, body := get("allowed")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "my-user", body)
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		status, body := get("denied")
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Equal(t, `{"error":"endpoint denied"}`, body)
+	})
+
+	t.Run("unavailable", func(t *testing.T) {
+		status, body := get("unknown")
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+		assert.Equal(t, `{"error":"authorization unavailable"}`, body)
+	})
+}
+
+func TestServer_SignedURLs(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// The signed URL and cookie must not be forwarded.
+			assert.Equal(t, "a=b", r.URL.RawQuery)
+			assert.Equal(t, "other=1", r.Header.Get("Cookie"))
+
+			// nolint
+			w.Write([]byte("bar"))
+		},
+	))
+	defer upstreamServer.Close()
+
+	// Reject all bearer tokens, so requests must use a signed URL.
+	verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+		handler: func(_ string) (*auth.Token, error) {
+			return nil, auth.ErrInvalidToken
+		},
+	}, nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	conf := config.Default().Proxy
+	conf.SignedURLs.SecretKey = "my-secret"
+	conf.HostPattern = "{endpoint}.{tenant}.piko.example.com"
+	s := NewServer(
+		&fakeManager{
+			handler: func(endpointKey string, _ bool) (upstream.Upstream, bool) {
+				assert.Contains(t, []string{"my-endpoint", "my-tenant/my-endpoint"}, endpointKey)
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		},
+		conf,
+		nil,
+		verifier,
+		nil,
+		log.NewNopLogger(),
+	)
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	signer := auth.NewURLSigner([]byte("my-secret"))
+
+	get := func(endpointID string, path string, grant *auth.SignedURLGrant, cookie *http.Cookie) *http.Response {
+		u, _ := url.Parse(fmt.Sprintf("http://%s%s?a=b", ln.Addr().String(), path))
+		if grant != nil {
+			require.NoError(t, signer.SignURL(u, *grant))
+		}
+		req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+		req.Header.Add("x-piko-endpoint", endpointID)
+		req.AddCookie(&http.Cookie{Name: "other", Value: "1"})
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		// nolint
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	grant := &auth.SignedURLGrant{
+		EndpointID: "my-endpoint",
+		Expiry:     time.Now().Add(time.Hour),
+		PathPrefix: "/docs",
+	}
+
+	t.Run("signed url ok", func(t *testing.T) {
+		resp := get("my-endpoint", "/docs/foo", grant, nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// Use the returned cookie without the signed URL.
+		var cookie *http.Cookie
+		for _, c := range resp.Cookies() {
+			if c.Name == signedURLCookie {
+				cookie = c
+			}
+		}
+		require.NotNil(t, cookie)
+		assert.Equal(t, "/docs", cookie.Path)
+		assert.True(t, cookie.HttpOnly)
+		assert.True(t, cookie.Expires.Before(grant.Expiry))
+
+		resp = get("my-endpoint", "/docs/bar", nil, cookie)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// An invalid cookie falls back to the bearer token.
+		cookie.Value = "invalid"
+		resp = get("my-endpoint", "/docs/bar", nil, cookie)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("path not permitted", func(t *testing.T) {
+		resp := get("my-endpoint", "/admin", grant, nil)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("path traversal", func(t *testing.T) {
+		for _, path := range []string{
+			"/docs-admin",
+			"/docs/../admin",
+			"/docs/%2e%2e/admin",
+			"/docs%2F..%2Fadmin",
+		} {
+			u, _ := url.Parse(fmt.Sprintf("http://%s%s", ln.Addr().String(), path))
+			require.NoError(t, signer.SignURL(u, *grant))
+			req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+			// Set the path directly, since NewRequest would clean it.
+			req.URL.Opaque = "//" + ln.Addr().String() + path
+			req.Header.Add("x-piko-endpoint", "my-endpoint")
+
+			client := &http.Client{}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, path)
+		}
+	})
+
+	t.Run("tenant", func(t *testing.T) {
+		getHost := func(host string, grant auth.SignedURLGrant) int {
+			u, _ := url.Parse(fmt.Sprintf("http://%s/docs/foo?a=b", ln.Addr().String()))
+			require.NoError(t, signer.SignURL(u, grant))
+			req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+			req.Host = host
+			req.AddCookie(&http.Cookie{Name: "other", Value: "1"})
+
+			client := &http.Client{}
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		tenantGrant := *grant
+		tenantGrant.TenantID = "my-tenant"
+		assert.Equal(t, http.StatusOK, getHost("my-endpoint.my-tenant.piko.example.com", tenantGrant))
+		assert.Equal(t, http.StatusUnauthorized, getHost("my-endpoint.other-tenant.piko.example.com", tenantGrant))
+
+		// A grant for the default tenant can't be used with another
+		// tenant.
+		assert.Equal(t, http.StatusUnauthorized, getHost("my-endpoint.my-tenant.piko.example.com", *grant))
+	})
+
+	t.Run("endpoint not permitted", func(t *testing.T) {
+		resp := get("other-endpoint", "/docs/foo", grant, nil)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expired := *grant
+		expired.Expiry = time.Now().Add(-time.Second)
+		resp := get("my-endpoint", "/docs/foo", &expired, nil)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		u, _ := url.Parse(fmt.Sprintf("http://%s/docs/foo", ln.Addr().String()))
+		require.NoError(t, auth.NewURLSigner([]byte("other-secret")).SignURL(u, *grant))
+		req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+		req.Header.Add("x-piko-endpoint", "my-endpoint")
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+// Tests signed URLs when the upstream is connected to another node.
+func TestServer_SignedURLsForward(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// The signed URL, cookie and forwarded grant must not be
+			// forwarded to the upstream.
+			assert.Equal(t, "a=b", r.URL.RawQuery)
+			assert.Equal(t, "other=1", r.Header.Get("Cookie"))
+			assert.Empty(t, r.Header.Get(signedURLHeader))
+
+			// nolint
+			w.Write([]byte("bar"))
+		},
+	))
+	defer upstreamServer.Close()
+
+	// Reject all bearer tokens, so requests must use a signed URL.
+	verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+		handler: func(_ string) (*auth.Token, error) {
+			return nil, auth.ErrInvalidToken
+		},
+	}, nil)
+
+	conf := config.Default().Proxy
+	conf.SignedURLs.SecretKey = "my-secret"
+
+	// Node 2 has the upstream connected.
+	ln2, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s2 := NewServer(
+		&fakeManager{
+			handler: func(endpointKey string, allowForward bool) (upstream.Upstream, bool) {
+				assert.Equal(t, "my-tenant/my-endpoint", endpointKey)
+				assert.False(t, allowForward)
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		},
+		conf,
+		nil,
+		verifier,
+		nil,
+		log.NewNopLogger(),
+	)
+	go func() {
+		require.NoError(t, s2.Serve(ln2))
+	}()
+	defer s2.Shutdown(context.TODO())
+
+	// Node 1 forwards requests to node 2.
+	ln1, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s1 := NewServer(
+		&fakeManager{
+			handler: func(endpointKey string, allowForward bool) (upstream.Upstream, bool) {
+				assert.Equal(t, "my-tenant/my-endpoint", endpointKey)
+				assert.True(t, allowForward)
+				return &tcpUpstream{
+					addr:    ln2.Addr().String(),
+					forward: true,
+				}, true
+			},
+		},
+		conf,
+		nil,
+		verifier,
+		nil,
+		log.NewNopLogger(),
+	)
+	go func() {
+		require.NoError(t, s1.Serve(ln1))
+	}()
+	defer s1.Shutdown(context.TODO())
+
+	signer := auth.NewURLSigner([]byte("my-secret"))
+	grant := auth.SignedURLGrant{
+		EndpointID: "my-endpoint",
+		TenantID:   "my-tenant",
+		Expiry:     time.Now().Add(time.Hour),
+		PathPrefix: "/docs",
+	}
+
+	get := func(addr string, signed bool, cookie *http.Cookie, header string) *http.Response {
+		u, _ := url.Parse(fmt.Sprintf("http://%s/docs/foo?a=b", addr))
+		if signed {
+			require.NoError(t, signer.SignURL(u, grant))
+		}
+		req, _ := http.NewRequest(http.MethodGet, u.String(), nil)
+		req.Header.Add("x-piko-endpoint", "my-endpoint")
+		req.AddCookie(&http.Cookie{Name: "other", Value: "1"})
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if header != "" {
+			req.Header.Set(signedURLHeader, header)
+		}
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		// nolint
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("signed url", func(t *testing.T) {
+		resp := get(ln1.Addr().String(), true, nil, "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		// Only the node that received the request sets the cookie.
+		var cookies []*http.Cookie
+		for _, c := range resp.Cookies() {
+			if c.Name == signedURLCookie {
+				cookies = append(cookies, c)
+			}
+		}
+		require.Len(t, cookies, 1)
+
+		resp = get(ln1.Addr().String(), false, cookies[0], "")
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("invalid forwarded grant", func(t *testing.T) {
+		query, err := auth.NewURLSigner([]byte("other-secret")).Sign(grant)
+		require.NoError(t, err)
+		header := base64.RawURLEncoding.EncodeToString([]byte(query.Encode()))
+		resp := get(ln2.Addr().String(), false, nil, header)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestServer_Identity(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// The Piko credential is stripped but the application
+			// authorization header is kept.
+			assert.Empty(t, r.Header.Get("x-piko-authorization"))
+			assert.Equal(t, "Bearer app-token", r.Header.Get("Authorization"))
+
+			assert.Equal(t, "my-subject", r.Header.Get("x-piko-identity-subject"))
+			assert.Equal(t, "my@example.com", r.Header.Get("x-piko-identity-claim-email"))
+			assert.Equal(t, `["admin"]`, r.Header.Get("x-piko-identity-claim-roles"))
+			// Claims that aren't configured aren't forwarded.
+			assert.Empty(t, r.Header.Get("x-piko-identity-claim-secret"))
+			// Spoofed headers are removed.
+			assert.Empty(t, r.Header.Get("x-piko-identity-tenant"))
+
+			claims := jwt.MapClaims{}
+			_, err := jwt.ParseWithClaims(
+				r.Header.Get("x-piko-identity-token"),
+				claims,
+				func(_ *jwt.Token) (any, error) {
+					return []byte("identity-secret"), nil
+				},
+				jwt.WithAudience("my-endpoint"),
+				jwt.WithIssuer("piko"),
+				jwt.WithExpirationRequired(),
+			)
+			assert.NoError(t, err)
+			assert.Equal(t, "my-subject", claims["sub"])
+			assert.Equal(t, map[string]any{
+				"email": "my@example.com",
+				"roles": []any{"admin"},
+			}, claims["claims"])
+
+			// nolint
+			w.Write([]byte("bar"))
+		},
+	))
+	defer upstreamServer.Close()
+
+	verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+		handler: func(token string) (*auth.Token, error) {
+			assert.Equal(t, "123", token)
+			return &auth.Token{
+				Expiry:  time.Now().Add(time.Hour),
+				Subject: "my-subject",
+				Claims: map[string]any{
+					"email":  "my@example.com",
+					"roles":  []any{"admin"},
+					"secret": "foo",
+				},
+			}, nil
+		},
+	}, nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	conf := config.Default().Proxy
+	conf.Identity.StripCredentials = true
+	conf.Identity.Headers = true
+	conf.Identity.Claims = []string{"email", "roles"}
+	conf.Identity.JWTSecretKey = "identity-secret"
+	s := NewServer(
+		&fakeManager{
+			handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		},
+		conf,
+		nil,
+		verifier,
+		nil,
+		log.NewNopLogger(),
+	)
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	url := fmt.Sprintf("http://%s/", ln.Addr().String())
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Add("x-piko-endpoint", "my-endpoint")
+	req.Header.Add("x-piko-authorization", "Bearer 123")
+	req.Header.Add("Authorization", "Bearer app-token")
+	req.Header.Add("x-piko-identity-tenant", "spoofed")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+// Tests responses to requests whose credential is stripped aren't stored by
+// the cache, since the request was still authenticated.
+func TestServer_IdentityCache(t *testing.T) {
+	requests := 0
+	upstreamServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+
+			assert.Empty(t, r.Header.Get("Authorization"))
+
+			w.Header().Set("Cache-Control", "max-age=60")
+			// nolint
+			w.Write([]byte("bar"))
+		},
+	))
+	defer upstreamServer.Close()
+
+	verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+		handler: func(token string) (*auth.Token, error) {
+			if token != "123" {
+				return nil, auth.ErrInvalidToken
+			}
+			return &auth.Token{
+				Expiry:  time.Now().Add(time.Hour),
+				Subject: "my-subject",
+			}, nil
+		},
+	}, nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	conf := config.Default().Proxy
+	conf.Identity.StripCredentials = true
+	conf.Cache.Enabled = true
+	c, err := cache.NewCache(conf.Cache, log.NewNopLogger())
+	require.NoError(t, err)
+	s := NewServer(
+		&fakeManager{
+			handler: func(_ string, _ bool) (upstream.Upstream, bool) {
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		},
+		conf,
+		nil,
+		verifier,
+		nil,
+		log.NewNopLogger(),
+		WithCache(c),
+	)
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	for i := 0; i != 2; i++ {
+		url := fmt.Sprintf("http://%s/", ln.Addr().String())
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		req.Header.Add("x-piko-endpoint", "my-endpoint")
+		req.Header.Add("Authorization", "Bearer 123")
+
+		client := &http.Client{}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "miss", resp.Header.Get("x-piko-cache"))
+	}
+	assert.Equal(t, 2, requests)
+
+	entries, _ := c.Size()
+	assert.Equal(t, 0, entries)
+}
+
+// Tests stripping credentials when the upstream is connected to another
+// node, which must authenticate the request again.
+func TestServer_IdentityForward(t *testing.T) {
+	upstreamServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Empty(t, r.Header.Get("x-piko-authorization"))
+			assert.Equal(t, "Bearer app-token", r.Header.Get("Authorization"))
+			assert.Equal(t, "my-subject", r.Header.Get("x-piko-identity-subject"))
+
+			// nolint
+			w.Write([]byte("bar"))
+		},
+	))
+	defer upstreamServer.Close()
+
+	verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+		handler: func(token string) (*auth.Token, error) {
+			if token != "123" {
+				return nil, auth.ErrInvalidToken
+			}
+			return &auth.Token{
+				Expiry:  time.Now().Add(time.Hour),
+				Subject: "my-subject",
+			}, nil
+		},
+	}, nil)
+
+	conf := config.Default().Proxy
+	conf.Identity.StripCredentials = true
+	conf.Identity.Headers = true
+
+	// Node 2 has the upstream connected.
+	ln2, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s2 := NewServer(
+		&fakeManager{
+			handler: func(_ string, allowForward bool) (upstream.Upstream, bool) {
+				assert.False(t, allowForward)
+				return &tcpUpstream{
+					addr: upstreamServer.Listener.Addr().String(),
+				}, true
+			},
+		},
+		conf,
+		nil,
+		verifier,
+		nil,
+		log.NewNopLogger(),
+	)
+	go func() {
+		require.NoError(t, s2.Serve(ln2))
+	}()
+	defer s2.Shutdown(context.TODO())
+
+	// Node 1 forwards requests to node 2.
+	ln1, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s1 := NewServer(
+		&fakeManager{
+			handler: func(_ string, allowForward bool) (upstream.Upstream, bool) {
+				assert.True(t, allowForward)
+				return &tcpUpstream{
+					addr:    ln2.Addr().String(),
+					forward: true,
+				}, true
+			},
+		},
+		conf,
+		nil,
+		verifier,
+		nil,
+		log.NewNopLogger(),
+	)
+	go func() {
+		require.NoError(t, s1.Serve(ln1))
+	}()
+	defer s1.Shutdown(context.TODO())
+
+	url := fmt.Sprintf("http://%s/", ln1.Addr().String())
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Add("x-piko-endpoint", "my-endpoint")
+	req.Header.Add("x-piko-authorization", "Bearer 123")
+	req.Header.Add("Authorization", "Bearer app-token")
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
 func TestEndpointIDFromRequest(t *testing.T) {
 	t.Run("host header", func(t *testing.T) {
 		endpointID := EndpointIDFromRequest(&http.Request{
@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/andydunstall/piko/pkg/auth"
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/pkg/middleware"
+	"github.com/andydunstall/piko/server/config"
+)
+
+const (
+	// signedURLCookie is the cookie set when a client first uses a signed
+	// URL.
+	signedURLCookie = "piko_signed_url"
+
+	// signedURLHeader contains the signed grant when a request authenticated
+	// with a signed URL is forwarded to another Piko node, since the signed
+	// URL parameters and cookie are removed before forwarding. The receiving
+	// node verifies the grant again.
+	signedURLHeader = "x-piko-signed-url"
+)
+
+// signedURLs is middleware to authenticate requests using signed URLs, as
+// an alternative to a bearer token.
+//
+// When a client first uses a signed URL, the signed grant is stripped from
+// the URL before forwarding and a short-lived cookie is set containing the
+// grant, so the client can continue accessing the endpoint without the
+// signed query parameters.
+//
+// If the request is forwarded to another Piko node, the grant is forwarded
+// in the 'x-piko-signed-url' header, which is never sent to the upstream.
+type signedURLs struct {
+	signer    *auth.URLSigner
+	cookieTTL time.Duration
+
+	// hostPattern extracts the tenant ID from the request host, or is nil
+	// if no host pattern is configured.
+	hostPattern *hostPattern
+
+	logger log.Logger
+}
+
+func newSignedURLs(
+	conf config.SignedURLConfig,
+	hostPattern *hostPattern,
+	logger log.Logger,
+) *signedURLs {
+	return &signedURLs{
+		signer:      auth.NewURLSigner([]byte(conf.SecretKey)),
+		cookieTTL:   conf.CookieTTL,
+		hostPattern: hostPattern,
+		logger:      logger,
+	}
+}
+
+// Verify verifies the signed URL, forwarded grant or cookie and adds a token
+// permitted to connect to the granted endpoint to the context.
+//
+// If the request has an invalid signed URL or forwarded grant, returns 401
+// to the client. If the request doesn't have a signed URL, forwarded grant or
+// valid cookie, the request is passed to the next handler unauthenticated.
+func (m *signedURLs) Verify(c *gin.Context) {
+	// Remove any forwarded grant so it's never sent to the upstream.
+	forwardedGrant := c.Request.Header.Get(signedURLHeader)
+	c.Request.Header.Del(signedURLHeader)
+
+	query := c.Request.URL.Query()
+	if query.Has(auth.SignedURLSignatureParam) {
+		grant, err := m.signer.Verify(query)
+		if err != nil {
+			m.logger.Warn("invalid signed url", zap.Error(err))
+			msg := "invalid signature"
+			if errors.Is(err, auth.ErrExpiredSignature) {
+				msg = "expired signature"
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		c.Request.URL.RawQuery = stripSignedURLParams(c.Request.URL.RawQuery)
+		m.setCookie(c, grant)
+		m.grant(c, grant, m.encodeGrant(grant))
+		return
+	}
+
+	if forwardedGrant != "" {
+		grant, err := m.verifyEncodedGrant(forwardedGrant)
+		if err != nil {
+			m.logger.Warn("invalid forwarded signed url", zap.Error(err))
+			msg := "invalid signature"
+			if errors.Is(err, auth.ErrExpiredSignature) {
+				msg = "expired signature"
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+		m.grant(c, grant, forwardedGrant)
+		return
+	}
+
+	cookie, err := c.Request.Cookie(signedURLCookie)
+	if err != nil {
+		return
+	}
+	// Don't forward the cookie to the upstream.
+	removeCookie(c.Request, signedURLCookie)
+
+	grant, err := m.verifyEncodedGrant(cookie.Value)
+	if err != nil {
+		// Fallback to bearer token authentication.
+		return
+	}
+	m.grant(c, grant, cookie.Value)
+}
+
+// grant adds a token for the verified grant to the context. The encoded
+// grant is added to the request context, so it can be forwarded if the
+// request is forwarded to another node.
+func (m *signedURLs) grant(
+	c *gin.Context,
+	grant *auth.SignedURLGrant,
+	encodedGrant string,
+) {
+	if !grant.PathPermitted(c.Request.URL.Path) {
+		m.logger.Warn(
+			"signed url path not permitted",
+			zap.String("path", c.Request.URL.Path),
+			zap.String("path-prefix", grant.PathPrefix),
+		)
+		c.AbortWithStatusJSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "path not permitted"},
+		)
+		return
+	}
+
+	// The grant is bound to a tenant, so if the host selects a tenant it
+	// must match the granted tenant. This includes grants for the default
+	// tenant, which would otherwise resolve against the host tenant.
+	if m.hostPattern != nil {
+		_, hostTenantID, _ := m.hostPattern.Match(c.Request.Host)
+		if hostTenantID != "" && hostTenantID != grant.TenantID {
+			m.logger.Warn(
+				"signed url tenant not permitted",
+				zap.String("tenant-id", grant.TenantID),
+				zap.String("host-tenant-id", hostTenantID),
+			)
+			c.AbortWithStatusJSON(
+				http.StatusUnauthorized,
+				gin.H{"error": "tenant not permitted"},
+			)
+			return
+		}
+	}
+
+	c.Request = c.Request.WithContext(
+		context.WithValue(c.Request.Context(), signedURLContextKey, encodedGrant),
+	)
+	c.Set(middleware.TokenContextKey, grant.Token())
+	c.Next()
+}
+
+// setCookie sets a cookie containing the grant, which expires after the
+// cookie TTL or when the grant expires, whichever is sooner.
+func (m *signedURLs) setCookie(c *gin.Context, grant *auth.SignedURLGrant) {
+	cookieGrant := *grant
+	if expiry := time.Now().Add(m.cookieTTL); expiry.Before(cookieGrant.Expiry) {
+		cookieGrant.Expiry = expiry
+	}
+
+	value := m.encodeGrant(&cookieGrant)
+	path := grant.PathPrefix
+	if !strings.HasPrefix(path, "/") {
+		path = "/"
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     signedURLCookie,
+		Value:    value,
+		Path:     path,
+		Expires:  cookieGrant.Expiry,
+		HttpOnly: true,
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// encodeGrant returns the signed grant encoded for a cookie or the
+// 'x-piko-signed-url' header.
+func (m *signedURLs) encodeGrant(grant *auth.SignedURLGrant) string {
+	// The grant has already been verified, so always has a valid endpoint.
+	query, _ := m.signer.Sign(*grant)
+	return base64.RawURLEncoding.EncodeToString([]byte(query.Encode()))
+}
+
+// verifyEncodedGrant verifies a grant encoded with encodeGrant.
+func (m *signedURLs) verifyEncodedGrant(value string) (*auth.SignedURLGrant, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, auth.ErrInvalidSignature
+	}
+	query, err := url.ParseQuery(string(b))
+	if err != nil {
+		return nil, auth.ErrInvalidSignature
+	}
+	return m.signer.Verify(query)
+}
+
+// stripSignedURLParams removes the signed URL parameters from the raw query,
+// preserving the order of the remaining parameters.
+func stripSignedURLParams(rawQuery string) string {
+	var params []string
+	for _, param := range strings.Split(rawQuery, "&") {
+		key, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil && auth.IsSignedURLParam(unescaped) {
+			continue
+		}
+		params = append(params, param)
+	}
+	return strings.Join(params, "&")
+}
+
+// removeCookie removes the cookie with the given name from the request.
+func removeCookie(r *http.Request, name string) {
+	var cookies []string
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == name {
+			continue
+		}
+		cookies = append(cookies, cookie.String())
+	}
+	r.Header.Del("Cookie")
+	if len(cookies) > 0 {
+		r.Header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+}
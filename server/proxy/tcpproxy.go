@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"errors"
 	"io"
 	"net"
@@ -12,6 +13,9 @@ import (
 
 	"github.com/andydunstall/piko/pkg/log"
 	pikowebsocket "github.com/andydunstall/piko/pkg/websocket"
+	"github.com/andydunstall/piko/server/bandwidth"
+	"github.com/andydunstall/piko/server/metering"
+	"github.com/andydunstall/piko/server/quota"
 	"github.com/andydunstall/piko/server/upstream"
 )
 
@@ -24,6 +28,18 @@ type TCPProxy struct {
 
 	httpProxy *HTTPProxy
 
+	// bandwidth accounts and limits the bandwidth of connections, or is nil
+	// if bandwidth isn't accounted.
+	bandwidth *bandwidth.Meter
+
+	// metering records the usage of endpoints, or is nil if usage isn't
+	// recorded.
+	metering *metering.Meter
+
+	// quotas enforces tenant connection quotas, or is nil if there are no
+	// quotas.
+	quotas *quota.Quotas
+
 	websocketUpgrader *websocket.Upgrader
 
 	logger log.Logger
@@ -32,11 +48,17 @@ type TCPProxy struct {
 func NewTCPProxy(
 	upstreams upstream.Manager,
 	httpProxy *HTTPProxy,
+	bandwidth *bandwidth.Meter,
+	metering *metering.Meter,
+	quotas *quota.Quotas,
 	logger log.Logger,
 ) *TCPProxy {
 	return &TCPProxy{
 		upstreams:         upstreams,
 		httpProxy:         httpProxy,
+		bandwidth:         bandwidth,
+		metering:          metering,
+		quotas:            quotas,
 		websocketUpgrader: &websocket.Upgrader{},
 		logger:            logger.WithSubsystem("proxy.tcp"),
 	}
@@ -49,7 +71,7 @@ func (p *TCPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, endpointID
 	// of those upstreams. Note this includes remote nodes that are reporting
 	// they have an available upstream. We don't allow multiple hops, so if
 	// forwarded is true we only select from local nodes.
-	u, ok := p.upstreams.Select(endpointID, !forwarded)
+	u, ok := p.upstreams.Select(tenantFromRequest(r), endpointID, !forwarded)
 	if !ok {
 		p.logger.Warn(
 			"no available upstreams",
@@ -69,6 +91,21 @@ func (p *TCPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, endpointID
 		return
 	}
 
+	if p.quotas != nil {
+		release, err := p.quotas.AcquireConnection(u.TenantID())
+		if err != nil {
+			p.logger.Warn(
+				"connection rejected",
+				zap.String("endpoint-id", endpointID),
+				zap.String("tenant-id", u.TenantID()),
+				zap.Error(err),
+			)
+			_ = errorResponse(w, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		defer release()
+	}
+
 	upstreamConn, err := u.Dial()
 	if err != nil {
 		if errors.Is(err, upstream.ErrGone) {
@@ -89,16 +126,48 @@ func (p *TCPProxy) ServeHTTP(w http.ResponseWriter, r *http.Request, endpointID
 	downstreamConn := pikowebsocket.New(wsConn)
 	defer downstreamConn.Close()
 
-	p.forward(upstreamConn, downstreamConn)
+	var stream *bandwidth.Stream
+	if p.bandwidth != nil {
+		stream = p.bandwidth.Stream(endpointID, u.TenantID())
+		defer stream.Close()
+	}
+	if p.metering != nil {
+		defer p.metering.TCPConnected(u.TenantID(), endpointID)()
+	}
+	p.forward(r.Context(), upstreamConn, downstreamConn, stream)
 }
 
-func (p *TCPProxy) forward(upstream net.Conn, downstream net.Conn) {
+// forward copies data between the upstream and downstream connections. If
+// stream is not nil, the copied bytes are accounted and limited by the
+// stream.
+//
+// Both connections are closed if the context is cancelled, such as if the
+// clients token is revoked.
+func (p *TCPProxy) forward(
+	ctx context.Context,
+	upstream net.Conn,
+	downstream net.Conn,
+	stream *bandwidth.Stream,
+) {
+	stop := context.AfterFunc(ctx, func() {
+		upstream.Close()
+		downstream.Close()
+	})
+	defer stop()
+
+	var upstreamReader io.Reader = upstream
+	var downstreamReader io.Reader = downstream
+	if stream != nil {
+		upstreamReader = stream.Reader(ctx, upstream, bandwidth.DirectionOut)
+		downstreamReader = stream.Reader(ctx, downstream, bandwidth.DirectionIn)
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
 		defer upstream.Close()
-		_, err := io.Copy(upstream, downstream)
+		_, err := io.Copy(upstream, downstreamReader)
 		if err != nil {
 			p.logger.Debug("copy to upstream closed", zap.Error(err))
 		}
@@ -106,7 +175,7 @@ func (p *TCPProxy) forward(upstream net.Conn, downstream net.Conn) {
 	go func() {
 		defer wg.Done()
 		defer downstream.Close()
-		_, err := io.Copy(downstream, upstream)
+		_, err := io.Copy(downstream, upstreamReader)
 		if err != nil {
 			p.logger.Debug("copy to downstream closed", zap.Error(err))
 		}
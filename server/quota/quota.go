@@ -0,0 +1,128 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+)
+
+var (
+	ErrQuotaExceeded = errors.New("tenant quota exceeded")
+)
+
+// Quotas enforces tenant quotas using the usage of each tenant across the
+// cluster.
+//
+// Since the cluster state is eventually consistent, a tenant may briefly
+// exceed its quota when connecting to multiple nodes concurrently.
+type Quotas struct {
+	// quotas contains the quota for each tenant, which may be replaced when
+	// the configuration is reloaded.
+	quotas atomic.Pointer[map[string]config.QuotaConfig]
+
+	cluster *cluster.State
+}
+
+func NewQuotas(tenants []config.TenantConfig, cluster *cluster.State) *Quotas {
+	q := &Quotas{
+		cluster: cluster,
+	}
+	q.Update(tenants)
+	return q
+}
+
+// Update replaces the tenant quotas.
+//
+// Note if a connection quota is added for a tenant, the tenants existing
+// connections aren't counted.
+func (q *Quotas) Update(tenants []config.TenantConfig) {
+	quotas := make(map[string]config.QuotaConfig)
+	for _, tenant := range tenants {
+		quotas[tenant.ID] = tenant.Quota
+	}
+	q.quotas.Store(&quotas)
+}
+
+// ReserveUpstream reserves an upstream for the endpoint, or returns an error
+// if connecting another upstream to the endpoint would exceed the tenants
+// quota.
+//
+// The returned function must be called to release the reservation once the
+// upstream is connected, or if the upstream fails to connect.
+func (q *Quotas) ReserveUpstream(tenantID string, endpointID string) (func(), error) {
+	quota, ok := (*q.quotas.Load())[tenantID]
+	if !ok {
+		return func() {}, nil
+	}
+	if quota.MaxEndpoints == 0 &&
+		quota.MaxEndpointUpstreams == 0 &&
+		quota.MaxUpstreams == 0 {
+		return func() {}, nil
+	}
+
+	// Check the quota and reserve the upstream atomically, so concurrent
+	// upstreams on the same node can't exceed the quota.
+	return q.cluster.ReserveLocalUpstream(
+		tenantID,
+		endpointID,
+		func(usage *cluster.TenantUsage) error {
+			return checkUpstream(quota, usage, endpointID)
+		},
+	)
+}
+
+// checkUpstream returns an error if connecting another upstream to the
+// endpoint would exceed the quota, given the tenants current usage.
+func checkUpstream(
+	quota config.QuotaConfig,
+	usage *cluster.TenantUsage,
+	endpointID string,
+) error {
+	endpointUpstreams, active := usage.Endpoints[endpointID]
+	if !active && quota.MaxEndpoints != 0 && len(usage.Endpoints) >= quota.MaxEndpoints {
+		return fmt.Errorf(
+			"%w: max endpoints (%d)", ErrQuotaExceeded, quota.MaxEndpoints,
+		)
+	}
+	if quota.MaxEndpointUpstreams != 0 && endpointUpstreams >= quota.MaxEndpointUpstreams {
+		return fmt.Errorf(
+			"%w: max upstreams per endpoint (%d)",
+			ErrQuotaExceeded, quota.MaxEndpointUpstreams,
+		)
+	}
+	if quota.MaxUpstreams != 0 && usage.Upstreams() >= quota.MaxUpstreams {
+		return fmt.Errorf(
+			"%w: max upstreams (%d)", ErrQuotaExceeded, quota.MaxUpstreams,
+		)
+	}
+	return nil
+}
+
+// AcquireConnection acquires a proxied connection for the tenant, or returns
+// an error if the tenant has reached its connection quota.
+//
+// The returned function must be called to release the connection once
+// closed.
+func (q *Quotas) AcquireConnection(tenantID string) (func(), error) {
+	quota, ok := (*q.quotas.Load())[tenantID]
+	if !ok || quota.MaxConnections == 0 {
+		// Connections are only tracked for tenants with a quota.
+		return func() {}, nil
+	}
+
+	// Check the quota and add the connection atomically, so concurrent
+	// connections on the same node can't exceed the quota.
+	if !q.cluster.ReserveLocalConnection(tenantID, quota.MaxConnections) {
+		return nil, fmt.Errorf(
+			"%w: max connections (%d)", ErrQuotaExceeded, quota.MaxConnections,
+		)
+	}
+
+	return sync.OnceFunc(func() {
+		q.cluster.RemoveLocalConnection(tenantID)
+	}), nil
+}
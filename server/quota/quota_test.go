@@ -0,0 +1,237 @@
+package quota
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
+	"github.com/andydunstall/piko/server/config"
+)
+
+func newState() *cluster.State {
+	state := cluster.NewState(&cluster.Node{
+		ID: "local",
+	}, log.NewNopLogger())
+	state.AddNode(&cluster.Node{
+		ID:     "remote",
+		Status: cluster.NodeStatusActive,
+	})
+	return state
+}
+
+// reserveUpstream reserves an upstream and immediately releases the
+// reservation.
+func reserveUpstream(quotas *Quotas, tenantID string, endpointID string) error {
+	release, err := quotas.ReserveUpstream(tenantID, endpointID)
+	if err != nil {
+		return err
+	}
+	release()
+	return nil
+}
+
+func TestQuotas_ReserveUpstream(t *testing.T) {
+	t.Run("max endpoints", func(t *testing.T) {
+		state := newState()
+		quotas := NewQuotas([]config.TenantConfig{
+			{ID: "my-tenant", Quota: config.QuotaConfig{MaxEndpoints: 2}},
+		}, state)
+
+		state.AddLocalEndpoint(cluster.EndpointKey("my-tenant", "endpoint-1"))
+		state.UpdateRemoteEndpoint(
+			"remote", cluster.EndpointKey("my-tenant", "endpoint-2"), 1,
+		)
+		// Endpoints belonging to other tenants are ignored.
+		state.AddLocalEndpoint(cluster.EndpointKey("other-tenant", "endpoint-3"))
+
+		// Active endpoints can still add upstreams.
+		assert.NoError(t, reserveUpstream(quotas, "my-tenant", "endpoint-1"))
+		assert.NoError(t, reserveUpstream(quotas, "my-tenant", "endpoint-2"))
+
+		err := reserveUpstream(quotas, "my-tenant", "endpoint-3")
+		assert.ErrorIs(t, err, ErrQuotaExceeded)
+		assert.Equal(t, "tenant quota exceeded: max endpoints (2)", err.Error())
+
+		// Nodes that have left are ignored.
+		state.UpdateRemoteStatus("remote", cluster.NodeStatusLeft)
+		assert.NoError(t, reserveUpstream(quotas, "my-tenant", "endpoint-3"))
+	})
+
+	t.Run("max endpoint upstreams", func(t *testing.T) {
+		state := newState()
+		quotas := NewQuotas([]config.TenantConfig{
+			{ID: "my-tenant", Quota: config.QuotaConfig{MaxEndpointUpstreams: 2}},
+		}, state)
+
+		key := cluster.EndpointKey("my-tenant", "endpoint-1")
+		state.AddLocalEndpoint(key)
+		assert.NoError(t, reserveUpstream(quotas, "my-tenant", "endpoint-1"))
+
+		state.UpdateRemoteEndpoint("remote", key, 1)
+		assert.ErrorIs(
+			t, reserveUpstream(quotas, "my-tenant", "endpoint-1"), ErrQuotaExceeded,
+		)
+		assert.NoError(t, reserveUpstream(quotas, "my-tenant", "endpoint-2"))
+	})
+
+	t.Run("max upstreams", func(t *testing.T) {
+		state := newState()
+		quotas := NewQuotas([]config.TenantConfig{
+			{ID: "my-tenant", Quota: config.QuotaConfig{MaxUpstreams: 3}},
+		}, state)
+
+		state.AddLocalEndpoint(cluster.EndpointKey("my-tenant", "endpoint-1"))
+		state.UpdateRemoteEndpoint(
+			"remote", cluster.EndpointKey("my-tenant", "endpoint-2"), 1,
+		)
+		assert.NoError(t, reserveUpstream(quotas, "my-tenant", "endpoint-3"))
+
+		state.AddLocalEndpoint(cluster.EndpointKey("my-tenant", "endpoint-1"))
+		assert.ErrorIs(
+			t, reserveUpstream(quotas, "my-tenant", "endpoint-3"), ErrQuotaExceeded,
+		)
+	})
+
+	t.Run("no quota", func(t *testing.T) {
+		state := newState()
+		quotas := NewQuotas([]config.TenantConfig{
+			{ID: "my-tenant"},
+		}, state)
+
+		state.AddLocalEndpoint(cluster.EndpointKey("my-tenant", "endpoint-1"))
+		assert.NoError(t, reserveUpstream(quotas, "my-tenant", "endpoint-2"))
+		assert.NoError(t, reserveUpstream(quotas, "", "endpoint-2"))
+	})
+}
+
+// Tests concurrent upstreams can't exceed the quota.
+func TestQuotas_ReserveUpstreamConcurrent(t *testing.T) {
+	state := newState()
+	quotas := NewQuotas([]config.TenantConfig{
+		{ID: "my-tenant", Quota: config.QuotaConfig{
+			MaxEndpoints: 5,
+			MaxUpstreams: 10,
+		}},
+	}, state)
+
+	var reserved atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i != 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			endpointID := fmt.Sprintf("endpoint-%d", i%20)
+			if _, err := quotas.ReserveUpstream("my-tenant", endpointID); err == nil {
+				reserved.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	usage := state.TenantUsage("my-tenant")
+	assert.LessOrEqual(t, len(usage.Endpoints), 5)
+	assert.Equal(t, int64(usage.Upstreams()), reserved.Load())
+	assert.LessOrEqual(t, reserved.Load(), int64(10))
+}
+
+// Tests releasing a reservation, such as if the upstream fails to connect.
+func TestQuotas_ReserveUpstreamRelease(t *testing.T) {
+	state := newState()
+	quotas := NewQuotas([]config.TenantConfig{
+		{ID: "my-tenant", Quota: config.QuotaConfig{MaxUpstreams: 1}},
+	}, state)
+
+	release, err := quotas.ReserveUpstream("my-tenant", "endpoint-1")
+	require.NoError(t, err)
+	_, err = quotas.ReserveUpstream("my-tenant", "endpoint-1")
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	// Releasing multiple times only releases once.
+	release()
+	release()
+	assert.Empty(t, state.TenantUsage("my-tenant").Endpoints)
+
+	_, err = quotas.ReserveUpstream("my-tenant", "endpoint-1")
+	assert.NoError(t, err)
+}
+
+func TestQuotas_AcquireConnection(t *testing.T) {
+	state := newState()
+	quotas := NewQuotas([]config.TenantConfig{
+		{ID: "my-tenant", Quota: config.QuotaConfig{MaxConnections: 3}},
+	}, state)
+
+	state.UpdateRemoteConnections("remote", "my-tenant", 1)
+
+	release1, err := quotas.AcquireConnection("my-tenant")
+	require.NoError(t, err)
+	release2, err := quotas.AcquireConnection("my-tenant")
+	require.NoError(t, err)
+	assert.Equal(t, 2, state.LocalConnections("my-tenant"))
+
+	_, err = quotas.AcquireConnection("my-tenant")
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+	assert.Equal(t, "tenant quota exceeded: max connections (3)", err.Error())
+
+	// Releasing multiple times only releases once.
+	release1()
+	release1()
+	assert.Equal(t, 1, state.LocalConnections("my-tenant"))
+
+	release3, err := quotas.AcquireConnection("my-tenant")
+	require.NoError(t, err)
+	release2()
+	release3()
+	assert.Equal(t, 0, state.LocalConnections("my-tenant"))
+
+	// Connections for tenants without a quota aren't tracked.
+	release, err := quotas.AcquireConnection("other-tenant")
+	require.NoError(t, err)
+	release()
+	assert.Nil(t, state.LocalNode().Connections)
+}
+
+// Tests concurrent connections can't exceed the quota.
+func TestQuotas_AcquireConnectionConcurrent(t *testing.T) {
+	state := newState()
+	quotas := NewQuotas([]config.TenantConfig{
+		{ID: "my-tenant", Quota: config.QuotaConfig{MaxConnections: 10}},
+	}, state)
+
+	var acquired atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i != 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := quotas.AcquireConnection("my-tenant"); err == nil {
+				acquired.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(10), acquired.Load())
+	assert.Equal(t, 10, state.LocalConnections("my-tenant"))
+}
+
+func TestQuotas_Update(t *testing.T) {
+	state := newState()
+	quotas := NewQuotas(nil, state)
+
+	state.AddLocalEndpoint(cluster.EndpointKey("my-tenant", "endpoint-1"))
+	assert.NoError(t, reserveUpstream(quotas, "my-tenant", "endpoint-2"))
+
+	quotas.Update([]config.TenantConfig{
+		{ID: "my-tenant", Quota: config.QuotaConfig{MaxEndpoints: 1}},
+	})
+	assert.ErrorIs(
+		t, reserveUpstream(quotas, "my-tenant", "endpoint-2"), ErrQuotaExceeded,
+	)
+}
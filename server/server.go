@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -14,15 +15,22 @@ import (
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
 
+	"github.com/andydunstall/piko/pkg/audit"
 	"github.com/andydunstall/piko/pkg/auth"
 	"github.com/andydunstall/piko/pkg/build"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/server/admin"
+	"github.com/andydunstall/piko/server/bandwidth"
+	"github.com/andydunstall/piko/server/cache"
+	"github.com/andydunstall/piko/server/certs"
 	"github.com/andydunstall/piko/server/cluster"
 	"github.com/andydunstall/piko/server/config"
 	"github.com/andydunstall/piko/server/gossip"
+	"github.com/andydunstall/piko/server/metering"
 	"github.com/andydunstall/piko/server/proxy"
+	"github.com/andydunstall/piko/server/quota"
 	"github.com/andydunstall/piko/server/upstream"
 )
 
@@ -39,11 +47,26 @@ type Server struct {
 	rebalanceCtx    context.Context
 	rebalanceCancel context.CancelFunc
 
+	// meter records usage, or is nil if metering is disabled.
+	meter          *metering.Meter
+	meteringCtx    context.Context
+	meteringCancel context.CancelFunc
+
+	// auditLogger records audit events, or is nil if audit logging is
+	// disabled.
+	auditLogger *audit.Logger
+	auditCtx    context.Context
+	auditCancel context.CancelFunc
+
 	adminLn     net.Listener
 	adminServer *admin.Server
 
 	gossiper *gossip.Gossip
 
+	// revocations contains the revoked tokens, which is propagated to the
+	// rest of the cluster using gossip.
+	revocations *auth.RevocationList
+
 	conf *config.Config
 
 	// fatalCh triggers a shutdown when a fatal error occurs.
@@ -61,8 +84,41 @@ type Server struct {
 
 	logger log.Logger
 
-	// stopJWKSRefresher will stop the routine that refreshes the JWKS.
+	// stopJWKSRefresher will stop the routines that refresh the JWKS, reload
+	// API keys and prune expired revocations.
 	stopJWKSRefresher func()
+
+	// The reloadable components, which are updated when the configuration
+	// is reloaded. Verifiers and TLS reloaders are nil if auth or TLS is
+	// disabled.
+	proxyVerifier    *auth.MultiTenantVerifier
+	upstreamVerifier *auth.MultiTenantVerifier
+	adminVerifier    *auth.MultiTenantVerifier
+	proxyTLS         *config.TLSReloader
+	upstreamTLS      *config.TLSReloader
+	adminTLS         *config.TLSReloader
+	proxyQuotas      *quota.Quotas
+	upstreamQuotas   *quota.Quotas
+
+	// jwksCtx is the parent context of the verifiers, cancelled on shutdown.
+	jwksCtx context.Context
+	// reloadCancel cancels the routines of the current verifiers and
+	// certificate stores, such as refreshing the JWKS, when they are replaced
+	// by a configuration reload.
+	reloadCancel context.CancelFunc
+
+	certMetrics *certs.Metrics
+
+	// acme obtains proxy certificates using ACME, or is nil if ACME is
+	// disabled.
+	acme *certs.ACMEManager
+
+	// loadConfig loads the configuration to reload, or is nil if reloading
+	// isn't supported.
+	loadConfig func() (*config.Config, error)
+
+	// reloadMu serialises configuration reloads.
+	reloadMu sync.Mutex
 }
 
 // NewServer creates a server node with the given configuration.
@@ -84,7 +140,16 @@ func NewServer(conf *config.Config, logger log.Logger) (*Server, error) {
 		registry:          registry,
 		logger:            logger,
 		stopJWKSRefresher: jwksCancel,
+		revocations:       auth.NewRevocationList(),
+		jwksCtx:           jwksCtx,
 	}
+	go s.revocations.Run(jwksCtx, time.Minute)
+
+	reloadCtx, reloadCancel := context.WithCancel(jwksCtx)
+	s.reloadCancel = reloadCancel
+
+	s.certMetrics = certs.NewMetrics()
+	s.certMetrics.Register(registry)
 
 	// Proxy listener.
 
@@ -116,11 +181,6 @@ func NewServer(conf *config.Config, logger log.Logger) (*Server, error) {
 
 	// Cluster.
 
-	proxyTLSConfig, err := conf.Proxy.TLS.Load()
-	if err != nil {
-		return nil, fmt.Errorf("proxy tls: %w", err)
-	}
-
 	s.clusterState = cluster.NewState(&cluster.Node{
 		ID:        conf.Cluster.NodeID,
 		ProxyAddr: conf.Proxy.AdvertiseAddr,
@@ -128,6 +188,38 @@ func NewServer(conf *config.Config, logger log.Logger) (*Server, error) {
 	}, logger)
 	s.clusterState.Metrics().Register(registry)
 
+	// Nodes send requests to each other using the admin port, such as to
+	// fetch ACME cache entries and broadcast cache purges.
+	var peerTLSConfig *tls.Config
+	if len(conf.Admin.TLS.CertificatePairs()) > 0 {
+		peerTLSConfig, err = conf.Admin.TLS.Client.Load()
+		if err != nil {
+			return nil, fmt.Errorf("admin client tls: %w", err)
+		}
+	}
+
+	if conf.Proxy.ACME.Enabled() {
+		s.acme, err = certs.NewACMEManager(
+			conf.Proxy.ACME,
+			s.clusterState,
+			proxy.EndpointFromHost(conf.Proxy.HostPattern),
+			peerTLSConfig,
+			logger,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: acme: %w", err)
+		}
+	}
+
+	proxyTLSConfig, err := s.loadTLS(reloadCtx, "proxy", &conf.Proxy.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("proxy tls: %w", err)
+	}
+	if proxyTLSConfig != nil {
+		s.proxyTLS = config.NewTLSReloader(proxyTLSConfig)
+		proxyTLSConfig = s.proxyTLS.ServerConfig()
+	}
+
 	var tlsConfig *tls.Config
 	if proxyTLSConfig != nil {
 		tlsConfig, err = conf.Proxy.TLS.Client.Load()
@@ -141,15 +233,78 @@ func NewServer(conf *config.Config, logger log.Logger) (*Server, error) {
 
 	// Proxy server.
 
-	var proxyVerifier *auth.MultiTenantVerifier
-	if conf.Proxy.Auth.Enabled() {
-		verifierConf, err := conf.Proxy.Auth.Load(jwksCtx)
-		if err != nil {
-			return nil, fmt.Errorf("proxy: load auth: %w", err)
+	proxyVerifier, err := s.loadVerifier(
+		reloadCtx, &conf.Proxy.Auth, conf.Proxy.Tenants,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("proxy: %w", err)
+	}
+	s.proxyVerifier = proxyVerifier
+	if conf.Audit.Enabled {
+		var sinks []audit.Sink
+		if conf.Audit.File.Path != "" {
+			sinks = append(sinks, audit.NewFileSink(conf.Audit.File))
+		}
+		if conf.Audit.Webhook.URL != "" {
+			sinks = append(sinks, audit.NewWebhookSink(
+				conf.Audit.Webhook.URL, conf.Audit.Webhook.Timeout,
+			))
+		}
+		s.auditLogger = audit.NewLogger(
+			conf.Cluster.NodeID, conf.Audit, sinks, logger,
+		)
+		s.auditLogger.Metrics().Register(registry)
+
+		auditCtx, auditCancel := context.WithCancel(context.Background())
+		s.auditCtx = auditCtx
+		s.auditCancel = auditCancel
+	}
+
+	var bandwidthRecorders []bandwidth.Recorder
+	if conf.Metering.Enabled {
+		var sinks []metering.Sink
+		if conf.Metering.File != "" {
+			sinks = append(sinks, metering.NewFileSink(conf.Metering.File))
+		}
+		if conf.Metering.Webhook.URL != "" {
+			sinks = append(sinks, metering.NewWebhookSink(
+				conf.Metering.Webhook.URL, conf.Metering.Webhook.Timeout,
+			))
 		}
-		proxyVerifier = auth.NewMultiTenantVerifier(
-			auth.NewJWTVerifier(verifierConf), nil,
+		s.meter = metering.NewMeter(
+			conf.Cluster.NodeID, conf.Metering, sinks, logger,
 		)
+		s.meter.Metrics().Register(registry)
+		bandwidthRecorders = append(bandwidthRecorders, s.meter)
+
+		meteringCtx, meteringCancel := context.WithCancel(context.Background())
+		s.meteringCtx = meteringCtx
+		s.meteringCancel = meteringCancel
+	}
+
+	bandwidthMeter := bandwidth.NewMeter(conf.Proxy.Bandwidth, bandwidthRecorders...)
+	bandwidthMeter.Metrics().Register(registry)
+	go bandwidthMeter.Run(jwksCtx, time.Minute)
+
+	s.proxyQuotas = quota.NewQuotas(conf.Proxy.Tenants, s.clusterState)
+	proxyOpts := []proxy.Option{
+		proxy.WithBandwidth(bandwidthMeter),
+		proxy.WithQuotas(s.proxyQuotas),
+	}
+	if s.meter != nil {
+		proxyOpts = append(proxyOpts, proxy.WithMetering(s.meter))
+	}
+	if s.auditLogger != nil {
+		proxyOpts = append(proxyOpts, proxy.WithAudit(s.auditLogger))
+	}
+	var proxyCache *cache.Cache
+	if conf.Proxy.Cache.Enabled {
+		proxyCache, err = cache.NewCache(conf.Proxy.Cache, logger)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: cache: %w", err)
+		}
+		proxyCache.Metrics().Register(registry)
+		proxyOpts = append(proxyOpts, proxy.WithCache(proxyCache))
 	}
 	s.proxyServer = proxy.NewServer(
 		upstreams,
@@ -158,35 +313,27 @@ func NewServer(conf *config.Config, logger log.Logger) (*Server, error) {
 		proxyVerifier,
 		proxyTLSConfig,
 		logger,
+		proxyOpts...,
 	)
 
 	// Upstream server.
 
-	var upstreamVerifier *auth.MultiTenantVerifier
-	if conf.Upstream.Auth.Enabled() || len(conf.Upstream.Tenants) > 0 {
-		verifierConf, err := conf.Upstream.Auth.Load(jwksCtx)
-		if err != nil {
-			return nil, fmt.Errorf("upstream: load auth: %w", err)
-		}
-		defaultUpstreamVerifier := auth.NewJWTVerifier(verifierConf)
-
-		upstreamTenantVerifiers := make(map[string]auth.Verifier)
-		for _, tenantConf := range conf.Upstream.Tenants {
-			tenantVerifierConf, err := tenantConf.Auth.Load(jwksCtx)
-			if err != nil {
-				return nil, fmt.Errorf("upstream: tenant %s: load auth: %w", tenantConf.ID, err)
-			}
-			upstreamTenantVerifiers[tenantConf.ID] = auth.NewJWTVerifier(tenantVerifierConf)
-		}
-
-		upstreamVerifier = auth.NewMultiTenantVerifier(
-			defaultUpstreamVerifier, upstreamTenantVerifiers,
-		)
+	upstreamVerifier, err := s.loadVerifier(
+		reloadCtx, &conf.Upstream.Auth, conf.Upstream.Tenants,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("upstream: %w", err)
 	}
-	upstreamTLSConfig, err := conf.Upstream.TLS.Load()
+	s.upstreamVerifier = upstreamVerifier
+	upstreamTLSConfig, err := s.loadTLS(reloadCtx, "upstream", &conf.Upstream.TLS)
 	if err != nil {
 		return nil, fmt.Errorf("upstream: load tls: %w", err)
 	}
+	if upstreamTLSConfig != nil {
+		s.upstreamTLS = config.NewTLSReloader(upstreamTLSConfig)
+		upstreamTLSConfig = s.upstreamTLS.ServerConfig()
+	}
+	s.upstreamQuotas = quota.NewQuotas(conf.Upstream.Tenants, s.clusterState)
 	s.upstreamServer = upstream.NewServer(
 		upstreams,
 		upstreamVerifier,
@@ -194,34 +341,59 @@ func NewServer(conf *config.Config, logger log.Logger) (*Server, error) {
 		s.clusterState,
 		conf.Upstream,
 		conf.Stream,
+		s.meter,
+		s.upstreamQuotas,
+		s.auditLogger,
 		logger,
 	)
 
 	// Admin server.
 
-	var adminVerifier *auth.MultiTenantVerifier
-	if conf.Admin.Auth.Enabled() {
-		verifierConf, err := conf.Admin.Auth.Load(jwksCtx)
-		if err != nil {
-			return nil, fmt.Errorf("admin: load auth: %w", err)
-		}
-		adminVerifier = auth.NewMultiTenantVerifier(
-			auth.NewJWTVerifier(verifierConf), nil,
-		)
+	adminVerifier, err := s.loadVerifier(
+		reloadCtx, &conf.Admin.Auth, conf.Admin.Tenants,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("admin: %w", err)
 	}
-	adminTLSConfig, err := conf.Admin.TLS.Load()
+	s.adminVerifier = adminVerifier
+	adminTLSConfig, err := s.loadTLS(reloadCtx, "admin", &conf.Admin.TLS)
 	if err != nil {
 		return nil, fmt.Errorf("admin tls: %w", err)
 	}
+	if adminTLSConfig != nil {
+		s.adminTLS = config.NewTLSReloader(adminTLSConfig)
+		adminTLSConfig = s.adminTLS.ServerConfig()
+	}
 	s.adminServer = admin.NewServer(
 		s.clusterState,
 		registry,
 		adminVerifier,
 		adminTLSConfig,
+		s.auditLogger,
 		logger,
 	)
-	s.adminServer.AddStatus("/upstream", upstream.NewStatus(upstreams))
+	s.adminServer.AddTenantStatus("/upstream", upstream.NewStatus(upstreams))
 	s.adminServer.AddStatus("/cluster", cluster.NewStatus(s.clusterState))
+	s.adminServer.AddTenantStatus("/bandwidth", bandwidth.NewStatus(bandwidthMeter))
+	if proxyCache != nil {
+		s.adminServer.AddStatus("/cache", cache.NewStatus(
+			proxyCache, s.clusterState, peerTLSConfig, logger,
+		))
+	}
+	s.adminServer.AddRevocations(s.revocations)
+	s.adminServer.AddUpstreams(upstreams)
+	if s.acme != nil && conf.Proxy.ACME.ClusterKey != "" {
+		s.adminServer.AddACMECache(
+			s.acme.LocalCacheGet, conf.Proxy.ACME.ClusterKey,
+		)
+	}
+	if conf.Admin.TokenIssuer.Enabled() {
+		issuer, err := conf.Admin.TokenIssuer.Load()
+		if err != nil {
+			return nil, fmt.Errorf("admin: token issuer: %w", err)
+		}
+		s.adminServer.AddTokens(issuer)
+	}
 
 	return s, nil
 }
@@ -235,6 +407,8 @@ func (s *Server) Start() error {
 	)
 	s.logger.Debug("piko config", zap.Any("config", s.conf))
 
+	s.startAudit()
+
 	// Start the admin server. This includes a '/ready' route that will be
 	// false until the server has started.
 	s.startAdminServer()
@@ -270,6 +444,7 @@ func (s *Server) Start() error {
 	// server and proxy server.
 	s.startUpstreamServer()
 	s.startProxyServer()
+	s.startMetering()
 
 	// Now we've joined the cluster and started all servers, mark the server
 	// as ready to begin accepting requests.
@@ -311,7 +486,7 @@ func (s *Server) Shutdown() {
 	)
 	defer cancel()
 
-	// Stop the routine that refreshes the JWKS entries.
+	// Stop the routines that refresh the JWKS entries and reload API keys.
 	s.stopJWKSRefresher()
 
 	// Set the ready to false to stop incoming traffic.
@@ -331,6 +506,10 @@ func (s *Server) Shutdown() {
 	// requests from other cluster nodes so can shut down the proxy server.
 	s.shutdownProxyServer(ctx)
 
+	// Now the upstream and proxy servers are shut down, flush the remaining
+	// usage records.
+	s.shutdownMetering()
+
 	// Leave the cluster.
 	if err := s.gossiper.Leave(ctx); err != nil {
 		s.logger.Warn("failed to leave cluster", zap.Error(err))
@@ -343,6 +522,9 @@ func (s *Server) Shutdown() {
 
 	s.shutdownAdminServer(ctx)
 
+	// Now all servers are shut down, flush the remaining audit events.
+	s.shutdownAudit()
+
 	s.wg.Wait()
 
 	s.logger.Info("shutdown complete")
@@ -371,6 +553,277 @@ func (s *Server) Wait(ctx context.Context) bool {
 	return ok
 }
 
+// SetConfigLoader sets the function used to load the configuration when
+// reloading, such as to re-read the YAML configuration file, and registers
+// the admin route to trigger a reload.
+//
+// Must be called before the server is started.
+func (s *Server) SetConfigLoader(f func() (*config.Config, error)) {
+	s.loadConfig = f
+	s.adminServer.AddReload(s.ReloadConfig)
+}
+
+// ReloadConfig loads the configuration using the configured loader and
+// applies it to the running server.
+func (s *Server) ReloadConfig() error {
+	if s.loadConfig == nil {
+		return fmt.Errorf("config reloading not supported")
+	}
+
+	conf, err := s.loadConfig()
+	if err != nil {
+		s.logger.Warn("failed to reload config", zap.Error(err))
+		return fmt.Errorf("load config: %w", err)
+	}
+	if err := s.Reload(conf); err != nil {
+		s.logger.Warn("failed to reload config", zap.Error(err))
+		return err
+	}
+
+	s.logger.Info("reloaded config")
+	return nil
+}
+
+// Reload applies the given configuration to the running server.
+//
+// Only the authentication, tenants, TLS certificates, access log and
+// rebalance configuration can be updated. If any other fields changed, or the
+// configuration is invalid, the reload is rejected and the server is
+// unchanged.
+func (s *Server) Reload(conf *config.Config) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	// Fields derived on startup aren't included in the loaded configuration,
+	// so are inherited from the running configuration.
+	if conf.Cluster.NodeID == "" {
+		conf.Cluster.NodeID = s.conf.Cluster.NodeID
+	}
+	if conf.Proxy.AdvertiseAddr == "" {
+		conf.Proxy.AdvertiseAddr = s.conf.Proxy.AdvertiseAddr
+	}
+	if conf.Upstream.AdvertiseAddr == "" {
+		conf.Upstream.AdvertiseAddr = s.conf.Upstream.AdvertiseAddr
+	}
+	if conf.Admin.AdvertiseAddr == "" {
+		conf.Admin.AdvertiseAddr = s.conf.Admin.AdvertiseAddr
+	}
+	if conf.Cluster.Gossip.AdvertiseAddr == "" {
+		conf.Cluster.Gossip.AdvertiseAddr = s.conf.Cluster.Gossip.AdvertiseAddr
+	}
+
+	if err := conf.Validate(); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if err := s.conf.CheckReload(conf); err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+
+	// Load the new components before updating the server, so if any fail to
+	// load the server is unchanged.
+
+	reloadCtx, reloadCancel := context.WithCancel(s.jwksCtx)
+	var (
+		proxyDefault, upstreamDefault, adminDefault auth.Verifier
+		proxyTenants, upstreamTenants, adminTenants map[string]auth.Verifier
+		err                                         error
+	)
+	if s.proxyVerifier != nil {
+		proxyDefault, proxyTenants, err = s.loadVerifiers(
+			reloadCtx, &conf.Proxy.Auth, conf.Proxy.Tenants,
+		)
+		if err != nil {
+			reloadCancel()
+			return fmt.Errorf("proxy: %w", err)
+		}
+	}
+	if s.upstreamVerifier != nil {
+		upstreamDefault, upstreamTenants, err = s.loadVerifiers(
+			reloadCtx, &conf.Upstream.Auth, conf.Upstream.Tenants,
+		)
+		if err != nil {
+			reloadCancel()
+			return fmt.Errorf("upstream: %w", err)
+		}
+	}
+	if s.adminVerifier != nil {
+		adminDefault, adminTenants, err = s.loadVerifiers(
+			reloadCtx, &conf.Admin.Auth, conf.Admin.Tenants,
+		)
+		if err != nil {
+			reloadCancel()
+			return fmt.Errorf("admin: %w", err)
+		}
+	}
+
+	proxyTLSConfig, err := s.loadTLS(reloadCtx, "proxy", &conf.Proxy.TLS)
+	if err != nil {
+		reloadCancel()
+		return fmt.Errorf("proxy tls: %w", err)
+	}
+	upstreamTLSConfig, err := s.loadTLS(reloadCtx, "upstream", &conf.Upstream.TLS)
+	if err != nil {
+		reloadCancel()
+		return fmt.Errorf("upstream tls: %w", err)
+	}
+	adminTLSConfig, err := s.loadTLS(reloadCtx, "admin", &conf.Admin.TLS)
+	if err != nil {
+		reloadCancel()
+		return fmt.Errorf("admin tls: %w", err)
+	}
+
+	if s.proxyVerifier != nil {
+		s.proxyVerifier.Update(proxyDefault, proxyTenants)
+	}
+	if s.upstreamVerifier != nil {
+		s.upstreamVerifier.Update(upstreamDefault, upstreamTenants)
+	}
+	if s.adminVerifier != nil {
+		s.adminVerifier.Update(adminDefault, adminTenants)
+	}
+	// Stop the routines of the replaced verifiers and certificate stores.
+	s.reloadCancel()
+	s.reloadCancel = reloadCancel
+
+	if s.proxyTLS != nil {
+		s.proxyTLS.Update(proxyTLSConfig)
+	}
+	if s.upstreamTLS != nil {
+		s.upstreamTLS.Update(upstreamTLSConfig)
+	}
+	if s.adminTLS != nil {
+		s.adminTLS.Update(adminTLSConfig)
+	}
+
+	s.proxyServer.UpdateAccessLog(conf.Proxy.AccessLog)
+	s.upstreamServer.UpdateRebalance(conf.Upstream.Rebalance)
+	s.proxyQuotas.Update(conf.Proxy.Tenants)
+	s.upstreamQuotas.Update(conf.Upstream.Tenants)
+
+	s.conf.Proxy.Auth = conf.Proxy.Auth
+	s.conf.Proxy.Tenants = conf.Proxy.Tenants
+	s.conf.Proxy.AccessLog = conf.Proxy.AccessLog
+	s.conf.Proxy.TLS = conf.Proxy.TLS
+	s.conf.Upstream.Auth = conf.Upstream.Auth
+	s.conf.Upstream.Tenants = conf.Upstream.Tenants
+	s.conf.Upstream.Rebalance = conf.Upstream.Rebalance
+	s.conf.Upstream.TLS = conf.Upstream.TLS
+	s.conf.Admin.Auth = conf.Admin.Auth
+	s.conf.Admin.Tenants = conf.Admin.Tenants
+	s.conf.Admin.TLS = conf.Admin.TLS
+
+	return nil
+}
+
+// loadTLS loads the TLS configuration for the named listener, and watches the
+// certificate files for changes until the context is cancelled.
+//
+// The proxy listener also serves ACME certificates if enabled.
+//
+// Returns nil if TLS is disabled.
+func (s *Server) loadTLS(
+	ctx context.Context,
+	listener string,
+	conf *config.TLSConfig,
+) (*tls.Config, error) {
+	var acmeManager *certs.ACMEManager
+	if listener == "proxy" {
+		acmeManager = s.acme
+	}
+
+	pairs := conf.CertificatePairs()
+	if len(pairs) == 0 && acmeManager == nil {
+		return nil, nil
+	}
+
+	var store *certs.Store
+	if len(pairs) > 0 {
+		var err error
+		store, err = certs.NewStore(listener, pairs, s.certMetrics, s.logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var tlsConfig *tls.Config
+	var err error
+	if acmeManager != nil {
+		tlsConfig, err = conf.Load(acmeManager.GetCertificateFunc(store))
+		if err != nil {
+			return nil, err
+		}
+		nextProtos := conf.ALPN
+		if len(nextProtos) == 0 {
+			nextProtos = []string{"h2", "http/1.1"}
+		}
+		tlsConfig.NextProtos = append(slices.Clone(nextProtos), acme.ALPNProto)
+	} else {
+		tlsConfig, err = conf.Load(store.GetCertificate)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if store != nil {
+		go store.Watch(ctx, conf.ReloadInterval)
+	}
+
+	return tlsConfig, nil
+}
+
+// loadVerifier loads the verifier for the default tenant and each configured
+// tenant.
+//
+// Returns nil if authentication is disabled.
+func (s *Server) loadVerifier(
+	ctx context.Context,
+	defaultConf *auth.Config,
+	tenants []config.TenantConfig,
+) (*auth.MultiTenantVerifier, error) {
+	if !defaultConf.Enabled() && len(tenants) == 0 {
+		return nil, nil
+	}
+
+	defaultVerifier, tenantVerifiers, err := s.loadVerifiers(
+		ctx, defaultConf, tenants,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.NewMultiTenantVerifier(
+		defaultVerifier,
+		tenantVerifiers,
+		auth.WithRevocations(s.revocations),
+	), nil
+}
+
+// loadVerifiers loads the verifiers for the default tenant and each configured
+// tenant.
+func (s *Server) loadVerifiers(
+	ctx context.Context,
+	defaultConf *auth.Config,
+	tenants []config.TenantConfig,
+) (auth.Verifier, map[string]auth.Verifier, error) {
+	defaultVerifier, err := auth.NewVerifier(ctx, defaultConf, s.logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load auth: %w", err)
+	}
+
+	var tenantVerifiers map[string]auth.Verifier
+	if len(tenants) > 0 {
+		tenantVerifiers = make(map[string]auth.Verifier)
+	}
+	for _, tenantConf := range tenants {
+		tenantVerifier, err := auth.NewVerifier(ctx, &tenantConf.Auth, s.logger)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tenant %s: load auth: %w", tenantConf.ID, err)
+		}
+		tenantVerifiers[tenantConf.ID] = tenantVerifier
+	}
+	return defaultVerifier, tenantVerifiers, nil
+}
+
 func (s *Server) startGossip() error {
 	gossipStreamLn, err := net.Listen("tcp", s.conf.Cluster.Gossip.BindAddr)
 	if err != nil {
@@ -401,6 +854,7 @@ func (s *Server) startGossip() error {
 		gossipStreamLn,
 		gossipPacketLn,
 		&s.conf.Cluster.Gossip,
+		s.revocations,
 		s.logger,
 	)
 	s.gossiper.Metrics().Register(s.registry)
@@ -410,8 +864,21 @@ func (s *Server) startGossip() error {
 }
 
 func (s *Server) startProxyServer() {
+	proxyLn := s.proxyLn
+	if s.acme != nil {
+		// The proxy port accepts both TLS and plain HTTP connections to
+		// support both the TLS-ALPN-01 and HTTP-01 challenges.
+		var plainLn net.Listener
+		proxyLn, plainLn = certs.SplitListener(s.proxyLn)
+		s.runGoroutine(func() {
+			if err := s.acme.ServeHTTP(plainLn); err != nil {
+				s.logger.Error("failed to run acme http server", zap.Error(err))
+			}
+		})
+	}
+
 	s.runGoroutine(func() {
-		if err := s.proxyServer.Serve(s.proxyLn); err != nil {
+		if err := s.proxyServer.Serve(proxyLn); err != nil {
 			s.logger.Error("failed to run proxy server", zap.Error(err))
 		}
 	})
@@ -423,11 +890,29 @@ func (s *Server) startUpstreamServer() {
 			s.logger.Error("failed to run upstream server", zap.Error(err))
 		}
 	})
-	if s.conf.Upstream.Rebalance.Threshold != 0 {
-		s.runGoroutine(func() {
-			s.upstreamRebalance()
-		})
+	// Note the rebalance loop runs even when rebalancing is disabled, since
+	// the threshold may be updated when the configuration is reloaded.
+	s.runGoroutine(func() {
+		s.upstreamRebalance()
+	})
+}
+
+func (s *Server) startMetering() {
+	if s.meter == nil {
+		return
+	}
+	s.runGoroutine(func() {
+		s.meter.Run(s.meteringCtx)
+	})
+}
+
+func (s *Server) startAudit() {
+	if s.auditLogger == nil {
+		return
 	}
+	s.runGoroutine(func() {
+		s.auditLogger.Run(s.auditCtx)
+	})
 }
 
 func (s *Server) startAdminServer() {
@@ -439,12 +924,35 @@ func (s *Server) startAdminServer() {
 }
 
 func (s *Server) shutdownProxyServer(ctx context.Context) {
+	if s.acme != nil {
+		if err := s.acme.Shutdown(ctx); err != nil {
+			s.logger.Error("failed to shutdown acme http server", zap.Error(err))
+		}
+	}
 	if err := s.proxyServer.Shutdown(ctx); err != nil {
 		s.logger.Error("failed to shutdown proxy server", zap.Error(err))
 	}
 	s.logger.Info("shutdown proxy server")
 }
 
+func (s *Server) shutdownMetering() {
+	if s.meter == nil {
+		return
+	}
+	// Cancelling the context flushes the remaining records. Server shutdown
+	// waits for the flush to complete.
+	s.meteringCancel()
+}
+
+func (s *Server) shutdownAudit() {
+	if s.auditLogger == nil {
+		return
+	}
+	// Cancelling the context flushes the remaining events. Server shutdown
+	// waits for the flush to complete.
+	s.auditCancel()
+}
+
 func (s *Server) shutdownUpstreamServer(ctx context.Context) {
 	s.rebalanceCancel()
 	if err := s.upstreamServer.Shutdown(ctx); err != nil {
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/andydunstall/piko/server/bandwidth"
+)
+
+type Bandwidth struct {
+	client *Client
+}
+
+func NewBandwidth(client *Client) *Bandwidth {
+	return &Bandwidth{
+		client: client,
+	}
+}
+
+func (c *Bandwidth) Endpoints() ([]bandwidth.Usage, error) {
+	return c.top("/status/bandwidth/endpoints")
+}
+
+func (c *Bandwidth) Tenants() ([]bandwidth.Usage, error) {
+	return c.top("/status/bandwidth/tenants")
+}
+
+func (c *Bandwidth) top(path string) ([]bandwidth.Usage, error) {
+	r, err := c.client.Request(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var usages []bandwidth.Usage
+	if err := json.NewDecoder(r).Decode(&usages); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return usages, nil
+}
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -35,19 +37,54 @@ func (c *Client) SetForward(forward string) {
 }
 
 func (c *Client) Request(path string) (io.ReadCloser, error) {
+	return c.RequestWithQuery(path, nil)
+}
+
+// RequestWithQuery sends a request to the given path with the query
+// parameters.
+func (c *Client) RequestWithQuery(
+	path string,
+	query url.Values,
+) (io.ReadCloser, error) {
+	return c.do(http.MethodGet, path, query, nil)
+}
+
+// Post sends a POST request to the given path with the JSON encoded body.
+func (c *Client) Post(path string, body any) (io.ReadCloser, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("encode body: %w", err)
+	}
+	return c.do(http.MethodPost, path, nil, bytes.NewReader(b))
+}
+
+func (c *Client) do(
+	method string,
+	path string,
+	query url.Values,
+	body io.Reader,
+) (io.ReadCloser, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+
 	url := new(url.URL)
 	*url = *c.url
 
 	if c.forward != "" {
-		url.RawQuery = "forward=" + c.forward
+		query.Set("forward", c.forward)
 	}
+	url.RawQuery = query.Encode()
 
 	url.Path = fspath.Join(url.Path, path)
 
-	req, err := http.NewRequest(http.MethodGet, url.String(), nil)
+	req, err := http.NewRequest(method, url.String(), body)
 	if err != nil {
 		return nil, fmt.Errorf("request: %w", err)
 	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
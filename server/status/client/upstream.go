@@ -3,6 +3,10 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/andydunstall/piko/server/upstream"
 )
 
 type Upstream struct {
@@ -28,3 +32,60 @@ func (c *Upstream) Endpoints() (map[string]int, error) {
 	}
 	return endpoints, nil
 }
+
+// Connections returns the upstream connections matching the filter, starting
+// at offset. If limit is zero the server default is used.
+func (c *Upstream) Connections(
+	filter upstream.ConnectionFilter,
+	offset int,
+	limit int,
+) (*upstream.ConnectionList, error) {
+	query := url.Values{}
+	if filter.EndpointID != "" {
+		query.Set("endpoint", filter.EndpointID)
+	}
+	if filter.TenantID != "" {
+		query.Set("tenant", filter.TenantID)
+	}
+	if filter.ClientIP != "" {
+		query.Set("client_ip", filter.ClientIP)
+	}
+	if filter.Subject != "" {
+		query.Set("subject", filter.Subject)
+	}
+	if offset != 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	r, err := c.client.RequestWithQuery("/status/upstream/connections", query)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var connections upstream.ConnectionList
+	if err := json.NewDecoder(r).Decode(&connections); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &connections, nil
+}
+
+// Disconnect disconnects the upstreams selected by the request.
+func (c *Upstream) Disconnect(
+	req upstream.DisconnectRequest,
+) (*upstream.DisconnectResponse, error) {
+	r, err := c.client.Post("/upstream/disconnect", req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var resp upstream.DisconnectResponse
+	if err := json.NewDecoder(r).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &resp, nil
+}
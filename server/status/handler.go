@@ -1,6 +1,11 @@
 package status
 
-import "github.com/gin-gonic/gin"
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/andydunstall/piko/pkg/auth"
+	"github.com/andydunstall/piko/pkg/middleware"
+)
 
 // Handler is a handler in the Piko status API.
 //
@@ -10,3 +15,15 @@ type Handler interface {
 	// Register registers routes on the given group for the handler.
 	Register(group *gin.RouterGroup)
 }
+
+// TenantID returns the ID of the tenant the client belongs to, or an empty
+// string if the client doesn't belong to a tenant.
+//
+// Handlers must only return the status of the clients tenant.
+func TenantID(c *gin.Context) string {
+	token, ok := c.Get(middleware.TokenContextKey)
+	if !ok {
+		return ""
+	}
+	return token.(*auth.Token).TenantID
+}
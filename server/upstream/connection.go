@@ -0,0 +1,202 @@
+package upstream
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Connection describes an upstream connected to the local node.
+type Connection struct {
+	// ID is a unique ID for the connection.
+	ID string `json:"id"`
+
+	EndpointID string `json:"endpoint_id"`
+
+	// TenantID is the ID of the tenant the upstream authenticated with, or
+	// empty if the upstream has no tenant.
+	TenantID string `json:"tenant_id,omitempty"`
+
+	ClientIP string `json:"client_ip"`
+
+	// Subject is the token subject ('sub' claim) the upstream authenticated
+	// with, or empty if the token has no subject.
+	Subject string `json:"subject,omitempty"`
+
+	// AgentVersion is the Piko version of the upstream agent, or empty if
+	// unknown.
+	AgentVersion string `json:"agent_version,omitempty"`
+
+	// ConnectedAt is the time the upstream connected.
+	ConnectedAt time.Time `json:"connected_at"`
+
+	// Streams is the number of open streams.
+	Streams int `json:"streams"`
+
+	// BytesIn is the number of bytes received from the upstream.
+	BytesIn int64 `json:"bytes_in"`
+
+	// BytesOut is the number of bytes sent to the upstream.
+	BytesOut int64 `json:"bytes_out"`
+
+	// LastActive is the time data was last sent or received on the
+	// connection.
+	LastActive time.Time `json:"last_active"`
+}
+
+// ConnectionFilter filters the listed connections. Empty fields match all
+// connections.
+type ConnectionFilter struct {
+	ID         string
+	EndpointID string
+	TenantID   string
+	ClientIP   string
+	Subject    string
+}
+
+func (f *ConnectionFilter) Match(conn Connection) bool {
+	if f.ID != "" && f.ID != conn.ID {
+		return false
+	}
+	if f.EndpointID != "" && f.EndpointID != conn.EndpointID {
+		return false
+	}
+	if f.TenantID != "" && f.TenantID != conn.TenantID {
+		return false
+	}
+	if f.ClientIP != "" && f.ClientIP != conn.ClientIP {
+		return false
+	}
+	if f.Subject != "" && f.Subject != conn.Subject {
+		return false
+	}
+	return true
+}
+
+// ConnectionList is a page of connections.
+type ConnectionList struct {
+	Connections []Connection `json:"connections"`
+
+	// Total is the number of connections matching the filter, including
+	// connections not in the page.
+	Total int `json:"total"`
+}
+
+// DisconnectRequest selects the upstream connections to disconnect.
+type DisconnectRequest struct {
+	// ID is the ID of the connection to disconnect.
+	ID string `json:"id,omitempty"`
+
+	// EndpointID disconnects all connections for the endpoint.
+	EndpointID string `json:"endpoint_id,omitempty"`
+
+	// TenantID disconnects all connections for the tenant.
+	TenantID string `json:"tenant_id,omitempty"`
+
+	// Force closes the sessions immediately, rather than sending a GoAway
+	// and waiting for open streams to complete.
+	Force bool `json:"force,omitempty"`
+}
+
+func (r *DisconnectRequest) Validate() error {
+	if r.ID == "" && r.EndpointID == "" && r.TenantID == "" {
+		return errors.New("missing id, endpoint id or tenant id")
+	}
+	return nil
+}
+
+func (r *DisconnectRequest) Filter() ConnectionFilter {
+	return ConnectionFilter{
+		ID:         r.ID,
+		EndpointID: r.EndpointID,
+		TenantID:   r.TenantID,
+	}
+}
+
+// DisconnectResponse contains the disconnected upstream connections.
+type DisconnectResponse struct {
+	Disconnected []Connection `json:"disconnected"`
+}
+
+// ConnStats records the bytes transferred over a connection and the time of
+// the last activity.
+type ConnStats struct {
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+	// lastActive is the time of the last activity in Unix nanoseconds.
+	lastActive atomic.Int64
+}
+
+func NewConnStats() *ConnStats {
+	s := &ConnStats{}
+	s.lastActive.Store(time.Now().UnixNano())
+	return s
+}
+
+func (s *ConnStats) BytesIn() int64 {
+	return s.bytesIn.Load()
+}
+
+func (s *ConnStats) BytesOut() int64 {
+	return s.bytesOut.Load()
+}
+
+func (s *ConnStats) LastActive() time.Time {
+	return time.Unix(0, s.lastActive.Load())
+}
+
+// Conn wraps the connection to record its stats.
+func (s *ConnStats) Conn(conn net.Conn) net.Conn {
+	return &statsConn{
+		Conn:  conn,
+		stats: s,
+	}
+}
+
+type statsConn struct {
+	net.Conn
+	stats *ConnStats
+}
+
+func (c *statsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.stats.bytesIn.Add(int64(n))
+		c.stats.lastActive.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *statsConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.stats.bytesOut.Add(int64(n))
+		c.stats.lastActive.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+// agentVersion returns the Piko version from the upstream user agent, such
+// as 'piko/v0.8.0', or an empty string if the user agent isn't a Piko
+// agent.
+func agentVersion(userAgent string) string {
+	version, ok := strings.CutPrefix(userAgent, "piko/")
+	if !ok {
+		return ""
+	}
+	return version
+}
+
+func generateConnectionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// We don't expect to ever get an error so panic rather than try to
+		// handle.
+		panic("failed to generate random number: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
@@ -2,6 +2,7 @@ package upstream
 
 import (
 	"crypto/tls"
+	"sort"
 	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -14,7 +15,8 @@ import (
 // This includes upstreams connected to the local node, or other server nodes
 // in the cluster with a connected upstream for the target endpoint.
 type Manager interface {
-	// Select looks up an upstream for the given endpoint ID.
+	// Select looks up an upstream for the given endpoint ID in the tenants
+	// namespace.
 	//
 	// This will first look for an upstream connected to the local node, and
 	// load balance among the available connected upstreams.
@@ -22,7 +24,7 @@ type Manager interface {
 	// If there are no upstreams connected for the endpoint, and 'allowForward'
 	// is true, it will look for another node in the cluster that has an
 	// upstream connection for the endpoint and use that node as the upstream.
-	Select(endpointID string, allowForward bool) (Upstream, bool)
+	Select(tenantID string, endpointID string, allowForward bool) (Upstream, bool)
 
 	// AddConn adds a local upstream connection.
 	AddConn(u Upstream)
@@ -57,6 +59,15 @@ func (lb *loadBalancer) Remove(u Upstream) bool {
 	return len(lb.upstreams) == 0
 }
 
+func (lb *loadBalancer) Contains(u Upstream) bool {
+	for _, upstream := range lb.upstreams {
+		if upstream == u {
+			return true
+		}
+	}
+	return false
+}
+
 func (lb *loadBalancer) Next() Upstream {
 	if len(lb.upstreams) == 0 {
 		return nil
@@ -69,6 +80,8 @@ func (lb *loadBalancer) Next() Upstream {
 }
 
 type LoadBalancedManager struct {
+	// localUpstreams contains the upstreams connected to the local node,
+	// keyed by the namespaced endpoint key (see cluster.EndpointKey).
 	localUpstreams map[string]*loadBalancer
 
 	mu sync.Mutex
@@ -89,11 +102,17 @@ func NewLoadBalancedManager(cluster *cluster.State, proxyClientTLSConfig *tls.Co
 	}
 }
 
-func (m *LoadBalancedManager) Select(endpointID string, allowRemote bool) (Upstream, bool) {
+func (m *LoadBalancedManager) Select(
+	tenantID string,
+	endpointID string,
+	allowRemote bool,
+) (Upstream, bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	lb, ok := m.localUpstreams[endpointID]
+	key := cluster.EndpointKey(tenantID, endpointID)
+
+	lb, ok := m.localUpstreams[key]
 	if ok {
 		m.metrics.UpstreamRequestsTotal.Inc()
 		return lb.Next(), true
@@ -102,7 +121,7 @@ func (m *LoadBalancedManager) Select(endpointID string, allowRemote bool) (Upstr
 		return nil, false
 	}
 
-	node, ok := m.cluster.LookupEndpoint(endpointID)
+	node, ok := m.cluster.LookupEndpoint(key)
 	if !ok {
 		return nil, false
 	}
@@ -116,7 +135,9 @@ func (m *LoadBalancedManager) AddConn(u Upstream) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	lb, ok := m.localUpstreams[u.EndpointID()]
+	key := cluster.EndpointKey(u.TenantID(), u.EndpointID())
+
+	lb, ok := m.localUpstreams[key]
 	if !ok {
 		lb = &loadBalancer{}
 
@@ -124,9 +145,9 @@ func (m *LoadBalancedManager) AddConn(u Upstream) {
 	}
 
 	lb.Add(u)
-	m.localUpstreams[u.EndpointID()] = lb
+	m.localUpstreams[key] = lb
 
-	m.cluster.AddLocalEndpoint(u.EndpointID())
+	m.cluster.AddLocalEndpoint(key)
 
 	m.metrics.ConnectedUpstreams.Inc()
 }
@@ -135,32 +156,122 @@ func (m *LoadBalancedManager) RemoveConn(u Upstream) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	lb, ok := m.localUpstreams[u.EndpointID()]
+	key := cluster.EndpointKey(u.TenantID(), u.EndpointID())
+
+	lb, ok := m.localUpstreams[key]
 	if !ok {
 		return
 	}
+	if !lb.Contains(u) {
+		// The upstream may be removed multiple times, such as when the
+		// upstream is gone and when its connection closes.
+		return
+	}
 	if lb.Remove(u) {
-		delete(m.localUpstreams, u.EndpointID())
+		delete(m.localUpstreams, key)
 
 		m.metrics.RegisteredEndpoints.Dec()
 	}
 
-	m.cluster.RemoveLocalEndpoint(u.EndpointID())
+	m.cluster.RemoveLocalEndpoint(key)
 
 	m.metrics.ConnectedUpstreams.Dec()
 }
 
-func (m *LoadBalancedManager) Endpoints() map[string]int {
+// Endpoints returns the number of local upstreams connected to each endpoint.
+//
+// If tenantID is empty, returns all endpoints keyed by the namespaced
+// endpoint key. Otherwise only returns the endpoints belonging to the tenant,
+// keyed by endpoint ID.
+func (m *LoadBalancedManager) Endpoints(tenantID string) map[string]int {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	endpoints := make(map[string]int)
-	for endpointID, lb := range m.localUpstreams {
-		endpoints[endpointID] = len(lb.upstreams)
+	for key, lb := range m.localUpstreams {
+		if tenantID == "" {
+			endpoints[key] = len(lb.upstreams)
+			continue
+		}
+		endpointTenantID, endpointID := cluster.ParseEndpointKey(key)
+		if endpointTenantID == tenantID {
+			endpoints[endpointID] = len(lb.upstreams)
+		}
 	}
 	return endpoints
 }
 
+// Connections returns the upstreams connected to the local node matching the
+// filter, sorted by the time they connected.
+func (m *LoadBalancedManager) Connections(filter ConnectionFilter) []Connection {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var conns []Connection
+	for _, lb := range m.localUpstreams {
+		for _, u := range lb.upstreams {
+			connUpstream, ok := u.(*ConnUpstream)
+			if !ok {
+				continue
+			}
+			conn := connUpstream.Connection()
+			if filter.Match(conn) {
+				conns = append(conns, conn)
+			}
+		}
+	}
+	sortConnections(conns)
+	return conns
+}
+
+// Disconnect disconnects the upstreams connected to the local node matching
+// the filter, and returns the disconnected connections.
+//
+// If force is false, the upstreams are removed so no new requests are routed
+// to them, then disconnected once their open streams complete.
+func (m *LoadBalancedManager) Disconnect(
+	filter ConnectionFilter,
+	force bool,
+) []Connection {
+	m.mu.Lock()
+	var disconnecting []*ConnUpstream
+	for _, lb := range m.localUpstreams {
+		for _, u := range lb.upstreams {
+			connUpstream, ok := u.(*ConnUpstream)
+			if !ok {
+				continue
+			}
+			if filter.Match(connUpstream.Connection()) {
+				disconnecting = append(disconnecting, connUpstream)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	// Don't hold the mutex when disconnecting as closing the session could
+	// block.
+	conns := make([]Connection, 0, len(disconnecting))
+	for _, u := range disconnecting {
+		conns = append(conns, u.Connection())
+		if !force {
+			m.RemoveConn(u)
+		}
+		u.Disconnect(force)
+	}
+	sortConnections(conns)
+	return conns
+}
+
 func (m *LoadBalancedManager) Metrics() *Metrics {
 	return m.metrics
 }
+
+// sortConnections sorts the connections by the time they connected.
+func sortConnections(conns []Connection) {
+	sort.Slice(conns, func(i, j int) bool {
+		if conns[i].ConnectedAt.Equal(conns[j].ConnectedAt) {
+			return conns[i].ID < conns[j].ID
+		}
+		return conns[i].ConnectedAt.Before(conns[j].ConnectedAt)
+	})
+}
@@ -5,10 +5,15 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/andydunstall/piko/pkg/limit"
+	"github.com/andydunstall/piko/pkg/log"
+	"github.com/andydunstall/piko/server/cluster"
 )
 
 type fakeUpstream struct {
 	endpointID string
+	tenantID   string
 }
 
 func (u *fakeUpstream) EndpointID() string {
@@ -23,6 +28,14 @@ func (u *fakeUpstream) Forward() bool {
 	return false
 }
 
+func (u *fakeUpstream) TenantID() string {
+	return u.tenantID
+}
+
+func (u *fakeUpstream) Limits() limit.Config {
+	return limit.Config{}
+}
+
 func TestLocalLoadBalancer(t *testing.T) {
 	lb := &loadBalancer{}
 
@@ -58,3 +71,81 @@ func TestLocalLoadBalancer(t *testing.T) {
 
 	assert.Nil(t, lb.Next())
 }
+
+func TestLoadBalancedManager_TenantNamespace(t *testing.T) {
+	state := cluster.NewState(&cluster.Node{
+		ID:     "local",
+		Status: cluster.NodeStatusActive,
+	}, log.NewNopLogger())
+	m := NewLoadBalancedManager(state, nil)
+
+	// Both tenants register the same endpoint ID.
+	u1 := &fakeUpstream{endpointID: "my-endpoint", tenantID: "tenant-1"}
+	u2 := &fakeUpstream{endpointID: "my-endpoint", tenantID: "tenant-2"}
+	u3 := &fakeUpstream{endpointID: "my-endpoint"}
+	m.AddConn(u1)
+	m.AddConn(u2)
+	m.AddConn(u3)
+
+	for i := 0; i != 2; i++ {
+		u, ok := m.Select("tenant-1", "my-endpoint", false)
+		assert.True(t, ok)
+		assert.Equal(t, u1, u)
+
+		u, ok = m.Select("tenant-2", "my-endpoint", false)
+		assert.True(t, ok)
+		assert.Equal(t, u2, u)
+
+		u, ok = m.Select("", "my-endpoint", false)
+		assert.True(t, ok)
+		assert.Equal(t, u3, u)
+	}
+
+	_, ok := m.Select("tenant-3", "my-endpoint", false)
+	assert.False(t, ok)
+
+	assert.Equal(t, map[string]int{
+		"tenant-1/my-endpoint": 1,
+		"tenant-2/my-endpoint": 1,
+		"my-endpoint":          1,
+	}, m.Endpoints(""))
+	assert.Equal(t, map[string]int{
+		"my-endpoint": 1,
+	}, m.Endpoints("tenant-1"))
+	assert.Equal(t, map[string]int{
+		"tenant-1/my-endpoint": 1,
+		"tenant-2/my-endpoint": 1,
+		"my-endpoint":          1,
+	}, state.LocalNode().Endpoints)
+
+	m.RemoveConn(u1)
+	_, ok = m.Select("tenant-1", "my-endpoint", false)
+	assert.False(t, ok)
+	_, ok = m.Select("tenant-2", "my-endpoint", false)
+	assert.True(t, ok)
+}
+
+// TestLoadBalancedManager_RemoveTwice tests removing the same upstream
+// multiple times only removes it once.
+func TestLoadBalancedManager_RemoveTwice(t *testing.T) {
+	state := cluster.NewState(&cluster.Node{
+		ID:     "local",
+		Status: cluster.NodeStatusActive,
+	}, log.NewNopLogger())
+	m := NewLoadBalancedManager(state, nil)
+
+	u1 := &fakeUpstream{endpointID: "my-endpoint"}
+	u2 := &fakeUpstream{endpointID: "my-endpoint"}
+	m.AddConn(u1)
+	m.AddConn(u2)
+
+	m.RemoveConn(u1)
+	m.RemoveConn(u1)
+
+	u, ok := m.Select("", "my-endpoint", false)
+	assert.True(t, ok)
+	assert.Equal(t, u2, u)
+	assert.Equal(t, map[string]int{
+		"my-endpoint": 1,
+	}, state.LocalNode().Endpoints)
+}
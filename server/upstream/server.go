@@ -9,6 +9,8 @@ import (
 	"net"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/andydunstall/yamux"
 	"github.com/gin-gonic/gin"
@@ -16,12 +18,17 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
+	"github.com/andydunstall/piko/pkg/audit"
 	"github.com/andydunstall/piko/pkg/auth"
+	"github.com/andydunstall/piko/pkg/limit"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/pkg/middleware"
 	pikowebsocket "github.com/andydunstall/piko/pkg/websocket"
+	"github.com/andydunstall/piko/server/authz"
 	"github.com/andydunstall/piko/server/cluster"
 	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/metering"
+	"github.com/andydunstall/piko/server/quota"
 )
 
 // Server accepts connections from upstream services.
@@ -43,6 +50,26 @@ type Server struct {
 	config       config.UpstreamConfig
 	streamConfig config.StreamConfig
 
+	// rebalanceConfig contains the current rebalance configuration, which
+	// may be updated when the configuration is reloaded.
+	rebalanceConfig atomic.Pointer[config.RebalanceConfig]
+
+	// metering records upstream connection time, or is nil if usage isn't
+	// recorded.
+	metering *metering.Meter
+
+	// quotas enforces tenant upstream quotas, or is nil if there are no
+	// quotas.
+	quotas *quota.Quotas
+
+	// authorizer authorizes upstreams using an external webhook, or nil if
+	// not configured.
+	authorizer *authz.Authorizer
+
+	// auditLogger records upstreams registering and disconnecting, or is
+	// nil if audit logging is disabled.
+	auditLogger *audit.Logger
+
 	logger log.Logger
 }
 
@@ -53,10 +80,17 @@ func NewServer(
 	cluster *cluster.State,
 	config config.UpstreamConfig,
 	streamConfig config.StreamConfig,
+	metering *metering.Meter,
+	quotas *quota.Quotas,
+	auditLogger *audit.Logger,
 	logger log.Logger,
 ) *Server {
 	logger = logger.WithSubsystem("upstream")
 
+	if auditLogger != nil {
+		auditLogger = auditLogger.WithListener("upstream")
+	}
+
 	router := gin.New()
 	ctx, cancel := context.WithCancel(context.Background())
 	server := &Server{
@@ -73,14 +107,21 @@ func NewServer(
 		cluster:           cluster,
 		config:            config,
 		streamConfig:      streamConfig,
+		metering:          metering,
+		quotas:            quotas,
+		auditLogger:       auditLogger,
 		logger:            logger,
 	}
+	server.rebalanceConfig.Store(&config.Rebalance)
+	if config.Authz.Enabled() {
+		server.authorizer = authz.NewAuthorizer(config.Authz, logger)
+	}
 
 	// Recover from panics.
 	router.Use(gin.CustomRecoveryWithWriter(nil, server.panicRoute))
 
 	if verifier != nil {
-		authMiddleware := middleware.NewAuth(verifier, logger)
+		authMiddleware := middleware.NewAuth(verifier, auditLogger, logger)
 		router.Use(authMiddleware.Verify)
 	}
 
@@ -108,14 +149,25 @@ func (s *Server) Serve(ln net.Listener) error {
 	return nil
 }
 
+// UpdateRebalance replaces the rebalance configuration.
+func (s *Server) UpdateRebalance(conf config.RebalanceConfig) {
+	s.rebalanceConfig.Store(&conf)
+}
+
 func (s *Server) Rebalance() {
+	rebalanceConfig := s.rebalanceConfig.Load()
+	if rebalanceConfig.Threshold == 0 {
+		// Rebalancing is disabled.
+		return
+	}
+
 	if len(s.cluster.Nodes()) <= 1 {
 		s.logger.Debug("rebalance; skip; no other nodes")
 		return
 	}
 
 	localConns := s.openSessions()
-	if localConns == 0 || localConns < int(s.config.Rebalance.MinConns) {
+	if localConns == 0 || localConns < int(rebalanceConfig.MinConns) {
 		s.logger.Debug(
 			"rebalance; skip; too few conns",
 			zap.Int("local_conns", localConns),
@@ -125,11 +177,11 @@ func (s *Server) Rebalance() {
 
 	avgConns := s.cluster.AvgConns()
 	balance := float64(localConns-avgConns) / float64(avgConns)
-	if balance < s.config.Rebalance.Threshold {
+	if balance < rebalanceConfig.Threshold {
 		s.logger.Debug(
 			"rebalance; skip; below threshold",
 			zap.String("balance", fmt.Sprintf("%.2f", balance)),
-			zap.Float64("threshold", s.config.Rebalance.Threshold),
+			zap.Float64("threshold", rebalanceConfig.Threshold),
 			zap.Int("local_conns", localConns),
 			zap.Int("avg_conns", avgConns),
 		)
@@ -138,15 +190,15 @@ func (s *Server) Rebalance() {
 
 	// Shed up to the shed rate (as Rebalance is called every second).
 	shedding := float64(localConns) * balance
-	if shedding > float64(avgConns)*s.config.Rebalance.ShedRate {
-		shedding = math.Ceil(float64(avgConns) * s.config.Rebalance.ShedRate)
+	if shedding > float64(avgConns)*rebalanceConfig.ShedRate {
+		shedding = math.Ceil(float64(avgConns) * rebalanceConfig.ShedRate)
 	}
 
 	s.logger.Info(
 		"rebalance; shedding connections",
 		zap.Int("shedding", int(shedding)),
 		zap.String("balance", fmt.Sprintf("%.2f", balance)),
-		zap.Float64("threshold", s.config.Rebalance.Threshold),
+		zap.Float64("threshold", rebalanceConfig.Threshold),
 		zap.Int("local_conns", localConns),
 		zap.Int("avg_conns", avgConns),
 	)
@@ -167,6 +219,7 @@ func (s *Server) upstreamRoute(c *gin.Context) {
 	endpointID := c.Param("endpointID")
 
 	var tenantID string
+	var limits limit.Config
 	token, ok := c.Get(middleware.TokenContextKey)
 	if ok {
 		// If the token contains a set of permitted endpoints, verify the
@@ -174,12 +227,14 @@ func (s *Server) upstreamRoute(c *gin.Context) {
 		// token doesn't contain any endpoints the client can access any
 		// endpoint.
 		endpointToken := token.(*auth.Token)
-		if !endpointToken.EndpointPermitted(endpointID) {
+		if !endpointToken.ListenPermitted(endpointID) {
 			s.logger.Warn(
 				"endpoint not permitted",
 				zap.Strings("token-endpoints", endpointToken.Endpoints),
+				zap.Strings("token-listen-endpoints", endpointToken.ListenEndpoints),
 				zap.String("endpoint-id", endpointID),
 			)
+			s.recordUpstream(c, audit.TypeUpstreamRejected, endpointID, "endpoint not permitted")
 			c.JSON(
 				http.StatusUnauthorized,
 				gin.H{"error": "endpoint not permitted"},
@@ -187,15 +242,79 @@ func (s *Server) upstreamRoute(c *gin.Context) {
 			return
 		}
 		tenantID = endpointToken.TenantID
+		limits = endpointToken.Limits
+	}
+
+	if s.authorizer != nil {
+		req := authz.Request{
+			Action:     authz.ActionListen,
+			EndpointID: endpointID,
+			TenantID:   tenantID,
+			ClientIP:   c.ClientIP(),
+		}
+		if ok {
+			req.Claims = token.(*auth.Token).Claims
+		}
+		decision, err := s.authorizer.Authorize(c.Request.Context(), req)
+		if err != nil {
+			// Use a retryable status code so the client reconnects once the
+			// authorization service is available.
+			c.JSON(
+				http.StatusServiceUnavailable,
+				gin.H{"error": "authorization unavailable"},
+			)
+			return
+		}
+		if !decision.Allow {
+			s.logger.Warn(
+				"upstream denied by authorization webhook",
+				zap.String("endpoint-id", endpointID),
+				zap.String("tenant-id", tenantID),
+				zap.String("reason", decision.Reason),
+			)
+			reason := decision.Reason
+			if reason == "" {
+				reason = "not authorized"
+			}
+			s.recordUpstream(c, audit.TypeUpstreamRejected, endpointID, reason)
+			c.JSON(http.StatusForbidden, gin.H{"error": reason})
+			return
+		}
 	}
 
+	// releaseReservation releases the upstreams quota reservation once the
+	// upstream is added, or if the upstream fails to connect.
+	releaseReservation := func() {}
+	if s.quotas != nil {
+		release, err := s.quotas.ReserveUpstream(tenantID, endpointID)
+		if err != nil {
+			s.logger.Warn(
+				"upstream rejected",
+				zap.String("endpoint-id", endpointID),
+				zap.String("tenant-id", tenantID),
+				zap.Error(err),
+			)
+			s.recordUpstream(c, audit.TypeUpstreamRejected, endpointID, err.Error())
+			// Use a non-retryable status code so the client doesn't keep
+			// reconnecting.
+			c.JSON(
+				http.StatusForbidden,
+				gin.H{"error": err.Error()},
+			)
+			return
+		}
+		releaseReservation = release
+	}
+	defer releaseReservation()
+
 	wsConn, err := s.websocketUpgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		// Upgrade replies to the client so nothing else to do.
 		s.logger.Warn("failed to upgrade websocket", zap.Error(err))
 		return
 	}
-	conn := pikowebsocket.New(wsConn)
+	stats := NewConnStats()
+	conn := stats.Conn(pikowebsocket.New(wsConn))
 	defer conn.Close()
 
 	s.logger.Info(
@@ -211,6 +330,9 @@ func (s *Server) upstreamRoute(c *gin.Context) {
 		zap.String("tenant-id", tenantID),
 	)
 
+	s.recordUpstream(c, audit.TypeUpstreamRegistered, endpointID, "")
+	defer s.recordUpstream(c, audit.TypeUpstreamDisconnected, endpointID, "")
+
 	ctx := s.ctx
 	if ok {
 		// If the token has an expiry, then we ensure we close the connection
@@ -221,6 +343,16 @@ func (s *Server) upstreamRoute(c *gin.Context) {
 			ctx, cancel = context.WithDeadline(ctx, endpointToken.Expiry)
 			defer cancel()
 		}
+
+		// The auth middleware cancels the request context if the token is
+		// revoked, in which case close the connection.
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		stop := context.AfterFunc(c.Request.Context(), func() {
+			cancel(context.Cause(c.Request.Context()))
+		})
+		defer stop()
 	}
 
 	muxConfig := yamux.DefaultConfig()
@@ -237,16 +369,50 @@ func (s *Server) upstreamRoute(c *gin.Context) {
 	s.addSession(sess)
 	defer s.removeSession(sess)
 
-	upstream := NewConnUpstream(endpointID, sess)
+	info := Connection{
+		ID:           generateConnectionID(),
+		EndpointID:   endpointID,
+		TenantID:     tenantID,
+		ClientIP:     c.ClientIP(),
+		AgentVersion: agentVersion(c.Request.UserAgent()),
+		ConnectedAt:  time.Now(),
+	}
+	if ok {
+		info.Subject = token.(*auth.Token).Subject
+	}
+	upstream := NewConnUpstream(info, sess, stats, limits)
 
 	s.upstreams.AddConn(upstream)
 	defer s.upstreams.RemoveConn(upstream)
 
+	// The upstream is now counted as an endpoint listener.
+	releaseReservation()
+
+	if s.metering != nil {
+		defer s.metering.UpstreamConnected(tenantID, endpointID)()
+	}
+
 	for {
 		// The client will never open streams but block on accept to wait for
 		// close or an error.
 		if _, err := sess.AcceptStreamWithContext(ctx); err != nil {
 			if errors.Is(err, net.ErrClosed) {
+				// The session sent a GoAway as the upstream is being
+				// disconnected, so wait for the open streams to complete
+				// and the session to close.
+				s.logger.Info(
+					"upstream draining",
+					zap.String("endpoint-id", endpointID),
+					zap.String("connection-id", info.ID),
+				)
+				select {
+				case <-sess.CloseChan():
+				case <-ctx.Done():
+				}
+				return
+			}
+			if errors.Is(context.Cause(ctx), auth.ErrRevokedToken) {
+				s.logger.Info("upstream token revoked")
 				return
 			}
 			if errors.Is(err, context.Canceled) {
@@ -266,6 +432,32 @@ func (s *Server) upstreamRoute(c *gin.Context) {
 	}
 }
 
+// recordUpstream records an audit event for the upstream, including the
+// client IP and token.
+func (s *Server) recordUpstream(
+	c *gin.Context,
+	eventType string,
+	endpointID string,
+	reason string,
+) {
+	if s.auditLogger == nil {
+		return
+	}
+	event := audit.Event{
+		Type:       eventType,
+		ClientIP:   c.ClientIP(),
+		EndpointID: endpointID,
+		Reason:     reason,
+	}
+	if token, ok := c.Get(middleware.TokenContextKey); ok {
+		endpointToken := token.(*auth.Token)
+		event.TenantID = endpointToken.TenantID
+		event.Subject = endpointToken.Subject
+		event.TokenID = endpointToken.ID
+	}
+	s.auditLogger.Record(event)
+}
+
 func (s *Server) addSession(sess *yamux.Session) {
 	s.sessionsMu.Lock()
 	defer s.sessionsMu.Unlock()
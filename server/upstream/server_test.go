@@ -3,19 +3,31 @@ package upstream
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/andydunstall/yamux"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/andydunstall/piko/pkg/audit"
 	"github.com/andydunstall/piko/pkg/auth"
+	"github.com/andydunstall/piko/pkg/build"
 	"github.com/andydunstall/piko/pkg/log"
 	"github.com/andydunstall/piko/pkg/testutil"
 	"github.com/andydunstall/piko/pkg/websocket"
+	"github.com/andydunstall/piko/server/authz"
+	"github.com/andydunstall/piko/server/cluster"
 	"github.com/andydunstall/piko/server/config"
+	"github.com/andydunstall/piko/server/quota"
 )
 
 type fakeManager struct {
@@ -30,7 +42,7 @@ func newFakeManager() *fakeManager {
 	}
 }
 
-func (m *fakeManager) Select(_ string, _ bool) (Upstream, bool) {
+func (m *fakeManager) Select(_ string, _ string, _ bool) (Upstream, bool) {
 	return nil, false
 }
 
@@ -52,6 +64,19 @@ func (v *fakeVerifier) Verify(token string) (*auth.Token, error) {
 
 var _ auth.Verifier = &fakeVerifier{}
 
+type fakeAuditSink struct {
+	events []audit.Event
+}
+
+func (s *fakeAuditSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeAuditSink) Write(_ context.Context, events []audit.Event) error {
+	s.events = append(s.events, events...)
+	return nil
+}
+
 func TestServer_Register(t *testing.T) {
 	t.Run("ok", func(t *testing.T) {
 		ln, err := net.Listen("tcp", "127.0.0.1:0")
@@ -59,7 +84,7 @@ func TestServer_Register(t *testing.T) {
 
 		manager := newFakeManager()
 
-		s := NewServer(manager, nil, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, log.NewNopLogger())
+		s := NewServer(manager, nil, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -88,7 +113,7 @@ func TestServer_Register(t *testing.T) {
 
 		manager := newFakeManager()
 
-		s := NewServer(manager, nil, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, log.NewNopLogger())
+		s := NewServer(manager, nil, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -129,7 +154,7 @@ func TestServer_Authentication(t *testing.T) {
 			},
 		}, nil)
 
-		s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, log.NewNopLogger())
+		s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -168,7 +193,7 @@ func TestServer_Authentication(t *testing.T) {
 			},
 		}, nil)
 
-		s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, log.NewNopLogger())
+		s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -191,6 +216,51 @@ func TestServer_Authentication(t *testing.T) {
 		assert.Equal(t, "my-endpoint", removedUpstream.EndpointID())
 	})
 
+	t.Run("token revoked", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		revocations := auth.NewRevocationList()
+		verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+			handler: func(token string) (*auth.Token, error) {
+				assert.Equal(t, "123", token)
+				return &auth.Token{
+					Expiry:    time.Now().Add(time.Hour),
+					ID:        "my-id",
+					Endpoints: []string{"my-endpoint"},
+				}, nil
+			},
+		}, nil, auth.WithRevocations(revocations))
+
+		s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		conn, err := websocket.Dial(context.TODO(), url, websocket.WithToken("123"))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		addedUpstream := <-manager.addConnCh
+		assert.Equal(t, "my-endpoint", addedUpstream.EndpointID())
+
+		revocations.Revoke(auth.Revocation{
+			Type:   auth.RevocationTypeID,
+			Value:  "my-id",
+			Expiry: time.Now().Add(time.Hour),
+		})
+
+		removedUpstream := <-manager.removeConnCh
+		assert.Equal(t, "my-endpoint", removedUpstream.EndpointID())
+	})
+
 	t.Run("endpoint not permitted", func(t *testing.T) {
 		ln, err := net.Listen("tcp", "127.0.0.1:0")
 		require.NoError(t, err)
@@ -207,7 +277,39 @@ func TestServer_Authentication(t *testing.T) {
 			},
 		}, nil)
 
-		s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, log.NewNopLogger())
+		s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
+		go func() {
+			require.NoError(t, s.Serve(ln))
+		}()
+		defer s.Shutdown(context.TODO())
+
+		url := fmt.Sprintf(
+			"ws://%s/piko/v1/upstream/my-endpoint",
+			ln.Addr().String(),
+		)
+		_, err = websocket.Dial(context.TODO(), url, websocket.WithToken("123"))
+		require.ErrorContains(t, err, "401: endpoint not permitted")
+	})
+
+	// Tests a token that can only connect to the endpoint can't listen on
+	// the endpoint.
+	t.Run("listen not permitted", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		manager := newFakeManager()
+
+		verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+			handler: func(token string) (*auth.Token, error) {
+				assert.Equal(t, "123", token)
+				return &auth.Token{
+					Expiry:           time.Now().Add(time.Hour),
+					ConnectEndpoints: []string{"my-endpoint"},
+				}, nil
+			},
+		}, nil)
+
+		s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -238,7 +340,7 @@ func TestServer_Authentication(t *testing.T) {
 			},
 		}, nil)
 
-		s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, log.NewNopLogger())
+		s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -273,7 +375,7 @@ func TestServer_Authentication(t *testing.T) {
 			},
 		}, nil)
 
-		s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, log.NewNopLogger())
+		s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
 		go func() {
 			require.NoError(t, s.Serve(ln))
 		}()
@@ -288,6 +390,387 @@ func TestServer_Authentication(t *testing.T) {
 	})
 }
 
+func TestServer_Quota(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	manager := newFakeManager()
+
+	verifier := auth.NewMultiTenantVerifier(nil, map[string]auth.Verifier{
+		"my-tenant": &fakeVerifier{
+			handler: func(_ string) (*auth.Token, error) {
+				return &auth.Token{
+					Expiry: time.Now().Add(time.Hour),
+				}, nil
+			},
+		},
+	})
+
+	state := cluster.NewState(&cluster.Node{ID: "local"}, log.NewNopLogger())
+	// The tenant already has an active endpoint.
+	state.AddLocalEndpoint(cluster.EndpointKey("my-tenant", "endpoint-1"))
+
+	quotas := quota.NewQuotas([]config.TenantConfig{
+		{
+			ID:    "my-tenant",
+			Quota: config.QuotaConfig{MaxEndpoints: 1},
+		},
+	}, state)
+
+	s := NewServer(manager, verifier, nil, state, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, quotas, nil, log.NewNopLogger())
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	url := fmt.Sprintf(
+		"ws://%s/piko/v1/upstream/endpoint-2",
+		ln.Addr().String(),
+	)
+	_, err = websocket.Dial(
+		context.TODO(),
+		url,
+		websocket.WithToken("123"),
+		websocket.WithTenantID("my-tenant"),
+	)
+	require.Error(t, err)
+	assert.Equal(t, "403: tenant quota exceeded: max endpoints (1)", err.Error())
+
+	// The error must not be retried.
+	var retryableError *websocket.RetryableError
+	assert.False(t, errors.As(err, &retryableError))
+
+	// Upstreams for the active endpoint are accepted, and the quota
+	// reservation is released once the upstream is added.
+	conn, err := websocket.Dial(
+		context.TODO(),
+		fmt.Sprintf("ws://%s/piko/v1/upstream/endpoint-1", ln.Addr().String()),
+		websocket.WithToken("123"),
+		websocket.WithTenantID("my-tenant"),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+	<-manager.addConnCh
+	assert.Eventually(t, func() bool {
+		return state.TenantUsage("my-tenant").Endpoints["endpoint-1"] == 1
+	}, time.Second, time.Millisecond*10)
+}
+
+func TestServer_Audit(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	manager := newFakeManager()
+
+	verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+		handler: func(_ string) (*auth.Token, error) {
+			return &auth.Token{
+				Expiry:    time.Now().Add(time.Hour),
+				ID:        "token-1",
+				Subject:   "my-service",
+				Endpoints: []string{"my-endpoint"},
+			}, nil
+		},
+	}, nil)
+
+	sink := &fakeAuditSink{}
+	auditLogger := audit.NewLogger(
+		"node-1", audit.Config{}, []audit.Sink{sink}, log.NewNopLogger(),
+	)
+
+	s := NewServer(manager, verifier, nil, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, auditLogger, log.NewNopLogger())
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	// Endpoint not permitted.
+	_, err = websocket.Dial(
+		context.TODO(),
+		fmt.Sprintf("ws://%s/piko/v1/upstream/other-endpoint", ln.Addr().String()),
+		websocket.WithToken("123"),
+	)
+	require.Error(t, err)
+
+	conn, err := websocket.Dial(
+		context.TODO(),
+		fmt.Sprintf("ws://%s/piko/v1/upstream/my-endpoint", ln.Addr().String()),
+		websocket.WithToken("123"),
+	)
+	require.NoError(t, err)
+	<-manager.addConnCh
+	conn.Close()
+	<-manager.removeConnCh
+
+	var types []string
+	assert.Eventually(t, func() bool {
+		auditLogger.Flush(context.Background())
+		types = nil
+		for _, event := range sink.events {
+			types = append(types, event.Type)
+		}
+		return len(types) == 3
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, []string{
+		audit.TypeUpstreamRejected,
+		audit.TypeUpstreamRegistered,
+		audit.TypeUpstreamDisconnected,
+	}, types)
+
+	for _, event := range sink.events {
+		assert.Equal(t, "upstream", event.Listener)
+		assert.Equal(t, "127.0.0.1", event.ClientIP)
+		assert.Equal(t, "my-service", event.Subject)
+		assert.Equal(t, "token-1", event.TokenID)
+	}
+	assert.Equal(t, "other-endpoint", sink.events[0].EndpointID)
+	assert.Equal(t, "endpoint not permitted", sink.events[0].Reason)
+	assert.Equal(t, "my-endpoint", sink.events[1].EndpointID)
+}
+
+func TestServer_Connections(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	state := cluster.NewState(&cluster.Node{ID: "local"}, log.NewNopLogger())
+	manager := NewLoadBalancedManager(state, nil)
+
+	verifier := auth.NewMultiTenantVerifier(&fakeVerifier{
+		handler: func(token string) (*auth.Token, error) {
+			return &auth.Token{
+				Expiry:  time.Now().Add(time.Hour),
+				Subject: "service-" + token,
+			}, nil
+		},
+	}, nil)
+
+	s := NewServer(manager, verifier, nil, state, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	for i, endpointID := range []string{"endpoint-1", "endpoint-2"} {
+		conn, err := websocket.Dial(
+			context.TODO(),
+			fmt.Sprintf("ws://%s/piko/v1/upstream/%s", ln.Addr().String(), endpointID),
+			websocket.WithToken(endpointID),
+		)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// Wait for the upstream to register so the connections are
+		// ordered.
+		require.Eventually(t, func() bool {
+			return len(manager.Connections(ConnectionFilter{})) == i+1
+		}, time.Second, time.Millisecond*10)
+	}
+
+	conns := manager.Connections(ConnectionFilter{})
+	assert.Equal(t, "endpoint-1", conns[0].EndpointID)
+	assert.Equal(t, "service-endpoint-1", conns[0].Subject)
+	assert.Equal(t, "127.0.0.1", conns[0].ClientIP)
+	assert.Equal(t, build.Version, conns[0].AgentVersion)
+	assert.NotEmpty(t, conns[0].ID)
+	assert.False(t, conns[0].ConnectedAt.IsZero())
+	assert.Equal(t, "endpoint-2", conns[1].EndpointID)
+
+	router := gin.New()
+	NewStatus(manager).Register(router.Group("/status/upstream"))
+
+	listConnections := func(query string) (int, ConnectionList) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/status/upstream/connections?"+query, nil)
+		router.ServeHTTP(w, r)
+
+		var list ConnectionList
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&list))
+		}
+		return w.Code, list
+	}
+
+	t.Run("filter", func(t *testing.T) {
+		code, list := listConnections("subject=service-endpoint-2")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 1, list.Total)
+		require.Len(t, list.Connections, 1)
+		assert.Equal(t, "endpoint-2", list.Connections[0].EndpointID)
+
+		code, list = listConnections("endpoint=unknown")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 0, list.Total)
+		assert.Empty(t, list.Connections)
+	})
+
+	t.Run("paginate", func(t *testing.T) {
+		code, list := listConnections("offset=1&limit=1")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 2, list.Total)
+		require.Len(t, list.Connections, 1)
+		assert.Equal(t, "endpoint-2", list.Connections[0].EndpointID)
+
+		code, list = listConnections("offset=5")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, 2, list.Total)
+		assert.Empty(t, list.Connections)
+
+		code, _ = listConnections("limit=-1")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+}
+
+func TestServer_Disconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	state := cluster.NewState(&cluster.Node{ID: "local"}, log.NewNopLogger())
+	manager := NewLoadBalancedManager(state, nil)
+
+	s := NewServer(manager, nil, nil, state, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	connect := func(endpointID string) *yamux.Session {
+		conn, err := websocket.Dial(
+			context.TODO(),
+			fmt.Sprintf("ws://%s/piko/v1/upstream/%s", ln.Addr().String(), endpointID),
+		)
+		require.NoError(t, err)
+
+		sess, err := yamux.Client(conn, yamux.DefaultConfig())
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			return len(manager.Connections(ConnectionFilter{EndpointID: endpointID})) == 1
+		}, time.Second, time.Millisecond*10)
+
+		return sess
+	}
+
+	t.Run("graceful", func(t *testing.T) {
+		sess := connect("endpoint-1")
+		defer sess.Close()
+
+		u, ok := manager.Select("", "endpoint-1", false)
+		require.True(t, ok)
+		serverStream, err := u.Dial()
+		require.NoError(t, err)
+		clientStream, err := sess.AcceptStream()
+		require.NoError(t, err)
+
+		conns := manager.Disconnect(ConnectionFilter{EndpointID: "endpoint-1"}, false)
+		require.Len(t, conns, 1)
+		assert.Equal(t, "endpoint-1", conns[0].EndpointID)
+
+		// The upstream is removed but the session stays open until the
+		// open stream completes.
+		_, ok = manager.Select("", "endpoint-1", false)
+		assert.False(t, ok)
+		_, err = u.Dial()
+		assert.ErrorIs(t, err, ErrGone)
+
+		// Verify the stream still works.
+		_, err = serverStream.Write([]byte("foo"))
+		require.NoError(t, err)
+		buf := make([]byte, 3)
+		_, err = io.ReadFull(clientStream, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "foo", string(buf))
+
+		serverStream.Close()
+		clientStream.Close()
+
+		select {
+		case <-sess.CloseChan():
+		case <-time.After(time.Second * 5):
+			t.Fatal("session not closed")
+		}
+	})
+
+	t.Run("force", func(t *testing.T) {
+		sess := connect("endpoint-2")
+		defer sess.Close()
+
+		id := manager.Connections(ConnectionFilter{EndpointID: "endpoint-2"})[0].ID
+		conns := manager.Disconnect(ConnectionFilter{ID: id}, true)
+		require.Len(t, conns, 1)
+		assert.Equal(t, id, conns[0].ID)
+
+		select {
+		case <-sess.CloseChan():
+		case <-time.After(time.Second * 5):
+			t.Fatal("session not closed")
+		}
+
+		assert.Eventually(t, func() bool {
+			return len(manager.Connections(ConnectionFilter{})) == 0
+		}, time.Second, time.Millisecond*10)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		conns := manager.Disconnect(ConnectionFilter{ID: "unknown"}, true)
+		assert.Empty(t, conns)
+	})
+}
+
+func TestServer_Authz(t *testing.T) {
+	authzServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req authz.Request
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, authz.ActionListen, req.Action)
+		assert.Equal(t, "127.0.0.1", req.ClientIP)
+
+		_ = json.NewEncoder(w).Encode(authz.Decision{
+			Allow:  req.EndpointID == "allowed",
+			Reason: "endpoint denied",
+		})
+	}))
+	defer authzServer.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	manager := newFakeManager()
+
+	conf := config.UpstreamConfig{
+		Authz: config.AuthzConfig{
+			URL:     authzServer.URL,
+			Timeout: time.Second,
+		},
+	}
+	s := NewServer(manager, nil, nil, nil, conf, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
+	go func() {
+		require.NoError(t, s.Serve(ln))
+	}()
+	defer s.Shutdown(context.TODO())
+
+	t.Run("allowed", func(t *testing.T) {
+		url := fmt.Sprintf("ws://%s/piko/v1/upstream/allowed", ln.Addr().String())
+		conn, err := websocket.Dial(context.TODO(), url)
+		require.NoError(t, err)
+
+		addedUpstream := <-manager.addConnCh
+		assert.Equal(t, "allowed", addedUpstream.EndpointID())
+
+		conn.Close()
+
+		<-manager.removeConnCh
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		url := fmt.Sprintf("ws://%s/piko/v1/upstream/denied", ln.Addr().String())
+		_, err := websocket.Dial(context.TODO(), url)
+		require.Error(t, err)
+		assert.Equal(t, "403: endpoint denied", err.Error())
+
+		var retryableError *websocket.RetryableError
+		assert.False(t, errors.As(err, &retryableError))
+	})
+}
+
 func TestServer_TLS(t *testing.T) {
 	rootCAPool, cert, err := testutil.LocalTLSServerCert()
 	require.NoError(t, err)
@@ -300,7 +783,7 @@ func TestServer_TLS(t *testing.T) {
 
 	manager := newFakeManager()
 
-	s := NewServer(manager, nil, tlsConfig, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, log.NewNopLogger())
+	s := NewServer(manager, nil, tlsConfig, nil, config.UpstreamConfig{}, config.StreamConfig{MaxWindowSize: 256 * 1024}, nil, nil, nil, log.NewNopLogger())
 	go func() {
 		require.NoError(t, s.Serve(ln))
 	}()
@@ -2,12 +2,18 @@ package upstream
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/andydunstall/piko/server/status"
 )
 
+const (
+	defaultConnectionsLimit = 100
+	maxConnectionsLimit     = 1000
+)
+
 type Status struct {
 	manager *LoadBalancedManager
 }
@@ -20,11 +26,69 @@ func NewStatus(manager *LoadBalancedManager) *Status {
 
 func (s *Status) Register(group *gin.RouterGroup) {
 	group.GET("/endpoints", s.listEndpointsRoute)
+	group.GET("/connections", s.listConnectionsRoute)
 }
 
+// listEndpointsRoute returns the number of upstreams connected to each
+// endpoint. Tenants can only view their own upstreams.
 func (s *Status) listEndpointsRoute(c *gin.Context) {
-	endpoints := s.manager.Endpoints()
+	endpoints := s.manager.Endpoints(status.TenantID(c))
 	c.JSON(http.StatusOK, endpoints)
 }
 
+// listConnectionsRoute returns the upstreams connected to the local node.
+//
+// Supports filtering by the 'id', 'endpoint', 'tenant', 'client_ip' and
+// 'subject' queries, and pagination using the 'offset' and 'limit' queries.
+// Tenants can only view their own upstreams.
+func (s *Status) listConnectionsRoute(c *gin.Context) {
+	offset, ok := intQuery(c, "offset", 0)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+	limit, ok := intQuery(c, "limit", defaultConnectionsLimit)
+	if !ok || limit > maxConnectionsLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+
+	filter := ConnectionFilter{
+		ID:         c.Query("id"),
+		EndpointID: c.Query("endpoint"),
+		TenantID:   c.Query("tenant"),
+		ClientIP:   c.Query("client_ip"),
+		Subject:    c.Query("subject"),
+	}
+	if tenantID := status.TenantID(c); tenantID != "" {
+		filter.TenantID = tenantID
+	}
+
+	conns := s.manager.Connections(filter)
+	list := ConnectionList{
+		Connections: []Connection{},
+		Total:       len(conns),
+	}
+	if offset < len(conns) {
+		conns = conns[offset:]
+		if len(conns) > limit {
+			conns = conns[:limit]
+		}
+		list.Connections = conns
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func intQuery(c *gin.Context, name string, defaultValue int) (int, bool) {
+	query := c.Query(name)
+	if query == "" {
+		return defaultValue, true
+	}
+	n, err := strconv.Atoi(query)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
 var _ status.Handler = &Status{}
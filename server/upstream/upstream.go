@@ -4,9 +4,12 @@ import (
 	"crypto/tls"
 	"errors"
 	"net"
+	"sync/atomic"
+	"time"
 
 	"github.com/andydunstall/yamux"
 
+	"github.com/andydunstall/piko/pkg/limit"
 	"github.com/andydunstall/piko/server/cluster"
 )
 
@@ -15,6 +18,14 @@ var (
 	ErrGone = errors.New("gone")
 )
 
+const (
+	// drainTimeout is the maximum time to wait for open streams to complete
+	// when gracefully disconnecting an upstream.
+	drainTimeout = time.Second * 30
+
+	drainPollInterval = time.Millisecond * 100
+)
+
 // Upstream represents an upstream for a given endpoint.
 //
 // An upstream may be an upstream service connected to the local node, or
@@ -29,27 +40,52 @@ type Upstream interface {
 	// Forward indicates whether the upstream is forwarding traffic to a remote
 	// node rather than a client listener.
 	Forward() bool
+	// TenantID returns the ID of the tenant the upstream authenticated
+	// with, or an empty string if the upstream has no tenant.
+	TenantID() string
+	// Limits returns the request limits from the upstream token, which
+	// may lower (but never raise) the configured limits for the endpoint.
+	Limits() limit.Config
 }
 
 // ConnUpstream represents a connection to an upstream service thats connected
 // to the local node.
 type ConnUpstream struct {
-	endpointID string
-	sess       *yamux.Session
+	info   Connection
+	sess   *yamux.Session
+	stats  *ConnStats
+	limits limit.Config
+
+	// draining is set when the upstream is being gracefully disconnected,
+	// so no new streams are opened.
+	draining atomic.Bool
 }
 
-func NewConnUpstream(endpointID string, sess *yamux.Session) *ConnUpstream {
+// NewConnUpstream creates an upstream for the given session, where info
+// describes the connection and stats records the traffic on the underlying
+// connection.
+func NewConnUpstream(
+	info Connection,
+	sess *yamux.Session,
+	stats *ConnStats,
+	limits limit.Config,
+) *ConnUpstream {
 	return &ConnUpstream{
-		endpointID: endpointID,
-		sess:       sess,
+		info:   info,
+		sess:   sess,
+		stats:  stats,
+		limits: limits,
 	}
 }
 
 func (u *ConnUpstream) EndpointID() string {
-	return u.endpointID
+	return u.info.EndpointID
 }
 
 func (u *ConnUpstream) Dial() (net.Conn, error) {
+	if u.draining.Load() {
+		return nil, ErrGone
+	}
 	c, err := u.sess.OpenStream()
 	if err != nil && errors.Is(err, yamux.ErrRemoteGoAway) {
 		err = ErrGone
@@ -61,6 +97,62 @@ func (u *ConnUpstream) Forward() bool {
 	return false
 }
 
+func (u *ConnUpstream) TenantID() string {
+	return u.info.TenantID
+}
+
+func (u *ConnUpstream) Limits() limit.Config {
+	return u.limits
+}
+
+// Connection returns the current state of the connection.
+func (u *ConnUpstream) Connection() Connection {
+	info := u.info
+	info.Streams = u.sess.NumStreams()
+	info.BytesIn = u.stats.BytesIn()
+	info.BytesOut = u.stats.BytesOut()
+	info.LastActive = u.stats.LastActive()
+	return info
+}
+
+// Disconnect disconnects the upstream.
+//
+// If force is true, the session is closed immediately. Otherwise a GoAway is
+// sent and no new streams are opened, then the session is closed once the
+// open streams complete (or drainTimeout expires).
+func (u *ConnUpstream) Disconnect(force bool) {
+	if force {
+		u.sess.Close()
+		return
+	}
+	if u.draining.Swap(true) {
+		// Already draining.
+		return
+	}
+	go u.drain()
+}
+
+func (u *ConnUpstream) drain() {
+	_ = u.sess.GoAway()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+
+	for u.sess.NumStreams() > 0 {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			u.sess.Close()
+			return
+		case <-u.sess.CloseChan():
+			return
+		}
+	}
+	u.sess.Close()
+}
+
 // NodeUpstream represents a remote Piko server node.
 type NodeUpstream struct {
 	endpointID string
@@ -91,3 +183,15 @@ func (u *NodeUpstream) Dial() (net.Conn, error) {
 func (u *NodeUpstream) Forward() bool {
 	return true
 }
+
+// TenantID returns an empty string, as the tenant is only known by the node
+// the upstream is connected to.
+func (u *NodeUpstream) TenantID() string {
+	return ""
+}
+
+// Limits returns no limits, as the limits are applied by the node the
+// upstream is connected to.
+func (u *NodeUpstream) Limits() limit.Config {
+	return limit.Config{}
+}
@@ -0,0 +1,107 @@
+//go:build system
+
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/andydunstall/piko/client"
+	"github.com/andydunstall/piko/pikotest/cluster"
+	"github.com/andydunstall/piko/server/config"
+)
+
+// Tests obtaining proxy certificates from a Pebble ACME server.
+//
+// Requires a Pebble server that skips challenge validation, such as:
+//
+//	docker run -p 14000:14000 -e PEBBLE_VA_ALWAYS_VALID=1 ghcr.io/letsencrypt/pebble
+//
+// Configured with 'PIKO_PEBBLE_DIRECTORY_URL' containing the Pebble
+// directory URL and 'PIKO_PEBBLE_ROOT_CAS' containing a path to the Pebble
+// API root CA.
+func TestACME_Pebble(t *testing.T) {
+	directoryURL := os.Getenv("PIKO_PEBBLE_DIRECTORY_URL")
+	rootCAs := os.Getenv("PIKO_PEBBLE_ROOT_CAS")
+	if directoryURL == "" || rootCAs == "" {
+		t.Skip("missing pebble configuration")
+	}
+
+	node := cluster.NewNode(cluster.WithACMEConfig(config.ACMEConfig{
+		Domains:      []string{"*.piko.test"},
+		DirectoryURL: directoryURL,
+		AcceptTOS:    true,
+		RootCAs:      rootCAs,
+		IssueRate:    10,
+	}))
+	node.Start()
+	defer node.Stop()
+
+	// Pebble issues certificates from a root generated on startup, so
+	// verify the certificate name rather than the chain.
+	tlsConfig := func(serverName string) *tls.Config {
+		return &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+		}
+	}
+
+	t.Run("inactive endpoint", func(t *testing.T) {
+		// No certificate is obtained for endpoints without a listener.
+		_, err := tls.Dial(
+			"tcp", node.ProxyAddr(), tlsConfig("inactive-endpoint.piko.test"),
+		)
+		assert.Error(t, err)
+	})
+
+	t.Run("active endpoint", func(t *testing.T) {
+		upstream := client.Upstream{
+			URL: &url.URL{
+				Scheme: "http",
+				Host:   node.UpstreamAddr(),
+			},
+		}
+		ln, err := upstream.Listen(context.TODO(), "my-endpoint")
+		require.NoError(t, err)
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(
+			func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte("hello"))
+			},
+		))
+		server.Listener = ln
+		go server.Start()
+		defer server.Close()
+
+		httpClient := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: tlsConfig("my-endpoint.piko.test"),
+			},
+		}
+		req, _ := http.NewRequest(
+			http.MethodGet, "https://"+node.ProxyAddr(), nil,
+		)
+		req.Host = "my-endpoint.piko.test"
+		resp, err := httpClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(body))
+
+		require.NotNil(t, resp.TLS)
+		cert := resp.TLS.PeerCertificates[0]
+		assert.Equal(t, []string{"my-endpoint.piko.test"}, cert.DNSNames)
+	})
+}